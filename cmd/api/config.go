@@ -0,0 +1,321 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/OsagieDG/jwt-based-auth-system/handlers"
+	"github.com/OsagieDG/jwt-based-auth-system/internal/breach"
+	"github.com/OsagieDG/jwt-based-auth-system/internal/credentials"
+	"github.com/OsagieDG/jwt-based-auth-system/internal/jsonschema"
+	"github.com/OsagieDG/jwt-based-auth-system/internal/mailer"
+	"github.com/OsagieDG/jwt-based-auth-system/internal/models"
+	"github.com/OsagieDG/jwt-based-auth-system/internal/oidc"
+	"github.com/OsagieDG/jwt-based-auth-system/internal/ratelimit"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+)
+
+type config struct {
+	baseURL string
+
+	mfaIssuer    string
+	mfaSecretKey string
+	// signingKeysSecret encrypts the stored token signing keys. authctl
+	// needs the same value to generate and rotate them.
+	signingKeysSecret string
+
+	purgeGracePeriod time.Duration
+	purgeInterval    time.Duration
+
+	// authBackends lists the credential backends Login tries in order,
+	// "local" and "ldap".
+	authBackends []string
+	ldap         *credentials.LDAPConfig
+
+	// metadataSchema validates user metadata. It is nil when
+	// USER_METADATA_SCHEMA is not set.
+	metadataSchema *jsonschema.Schema
+
+	passwordPolicy models.PasswordPolicy
+
+	session   *handlers.SessionConfig
+	magicLink *handlers.MagicLinkConfig
+	emailOTP  *handlers.EmailOTPConfig
+	lockout   models.LockoutPolicy
+	mailer    *mailer.Config
+
+	webAuthn      *webauthn.Config
+	oidcProviders []oidc.ProviderConfig
+
+	rateLimits *rateLimitConfig
+}
+
+// rateLimitConfig holds one limit per route and key. Limits are written as
+// "<requests>/<period>" with an optional "token_bucket:" or
+// "sliding_window:" prefix.
+type rateLimitConfig struct {
+	store          string
+	loginIP        ratelimit.Limit
+	loginEmail     ratelimit.Limit
+	signupIP       ratelimit.Limit
+	refreshIP      ratelimit.Limit
+	refreshUser    ratelimit.Limit
+	mfaChallenge   ratelimit.Limit
+	magicLinkIP    ratelimit.Limit
+	magicLinkEmail ratelimit.Limit
+	emailOTPIP     ratelimit.Limit
+	emailOTPEmail  ratelimit.Limit
+	// Limits of the routes that mail a link to an address given in the
+	// request, and of the unlock route that consumes such a link.
+	passwordResetIP    ratelimit.Limit
+	passwordResetEmail ratelimit.Limit
+	verificationIP     ratelimit.Limit
+	verificationEmail  ratelimit.Limit
+	unlockIP           ratelimit.Limit
+}
+
+func loadConfig() *config {
+	baseURL := getEnv("APP_BASE_URL", "http://localhost:3000")
+	passwordPolicy := loadPasswordPolicy()
+
+	return &config{
+		baseURL:           baseURL,
+		mfaIssuer:         getEnv("MFA_ISSUER", "jwt-based-auth-system"),
+		mfaSecretKey:      getEnv("MFA_SECRET_KEY", "MY_MFA_SECRET_KEY"),
+		signingKeysSecret: getEnv("SIGNING_KEYS_SECRET", "MY_SIGNING_KEYS_SECRET"),
+		purgeGracePeriod:  getEnvDuration("USER_PURGE_GRACE_PERIOD", 30*24*time.Hour),
+		purgeInterval:     getEnvDuration("USER_PURGE_INTERVAL", time.Hour),
+		authBackends:      getEnvList("AUTH_BACKENDS", "local"),
+		metadataSchema:    getEnvSchema("USER_METADATA_SCHEMA"),
+		passwordPolicy:    passwordPolicy,
+		ldap: &credentials.LDAPConfig{
+			URL:               getEnv("LDAP_URL", "ldap://localhost:389"),
+			StartTLS:          getEnvBool("LDAP_START_TLS", false),
+			BindDN:            os.Getenv("LDAP_BIND_DN"),
+			BindPassword:      os.Getenv("LDAP_BIND_PASSWORD"),
+			BaseDN:            os.Getenv("LDAP_BASE_DN"),
+			UserFilter:        getEnv("LDAP_USER_FILTER", "(&(objectClass=person)(mail=%s))"),
+			UserNameAttribute: getEnv("LDAP_USERNAME_ATTRIBUTE", "uid"),
+			GroupAttribute:    getEnv("LDAP_GROUP_ATTRIBUTE", "memberOf"),
+			Organization:      getEnvUUID("LDAP_ORGANIZATION_ID"),
+			// Group DNs contain commas, so this list is separated by
+			// semicolons.
+			AdminGroups: splitList(os.Getenv("LDAP_ADMIN_GROUPS"), ";"),
+		},
+		session: &handlers.SessionConfig{
+			RequireEmailVerification: getEnvBool("REQUIRE_EMAIL_VERIFICATION", false),
+			PasswordPolicy:           passwordPolicy,
+			MetadataClaims:           getEnvMetadataPaths("METADATA_CLAIMS"),
+		},
+		magicLink: &handlers.MagicLinkConfig{
+			TTL:         getEnvDuration("MAGIC_LINK_TTL", 15*time.Minute),
+			BindBrowser: getEnvBool("MAGIC_LINK_BIND_BROWSER", false),
+		},
+		emailOTP: &handlers.EmailOTPConfig{
+			TTL:         getEnvDuration("EMAIL_OTP_TTL", 10*time.Minute),
+			MaxAttempts: getEnvInt("EMAIL_OTP_MAX_ATTEMPTS", 5),
+		},
+		lockout: models.LockoutPolicy{
+			Threshold:    getEnvInt("LOCKOUT_THRESHOLD", 5),
+			BaseDuration: getEnvDuration("LOCKOUT_BASE_DURATION", time.Minute),
+			MaxDuration:  getEnvDuration("LOCKOUT_MAX_DURATION", 24*time.Hour),
+			ResetWindow:  getEnvDuration("LOCKOUT_RESET_WINDOW", 24*time.Hour),
+		},
+		rateLimits: &rateLimitConfig{
+			store:       getEnv("RATE_LIMIT_STORE", "memory"),
+			loginIP:     getEnvLimit("RATE_LIMIT_LOGIN_IP", "token_bucket:20/1m"),
+			loginEmail:  getEnvLimit("RATE_LIMIT_LOGIN_EMAIL", "sliding_window:10/15m"),
+			signupIP:    getEnvLimit("RATE_LIMIT_SIGNUP_IP", "sliding_window:5/1h"),
+			refreshIP:   getEnvLimit("RATE_LIMIT_REFRESH_IP", "token_bucket:60/1m"),
+			refreshUser: getEnvLimit("RATE_LIMIT_REFRESH_USER", "token_bucket:10/1m"),
+			// A challenge token lives five minutes, so a handful of tries
+			// leaves no room for guessing six digit codes.
+			mfaChallenge:       getEnvLimit("RATE_LIMIT_MFA_CHALLENGE", "sliding_window:5/5m"),
+			magicLinkIP:        getEnvLimit("RATE_LIMIT_MAGIC_LINK_IP", "sliding_window:20/1h"),
+			magicLinkEmail:     getEnvLimit("RATE_LIMIT_MAGIC_LINK_EMAIL", "sliding_window:3/15m"),
+			emailOTPIP:         getEnvLimit("RATE_LIMIT_EMAIL_OTP_IP", "sliding_window:20/1h"),
+			emailOTPEmail:      getEnvLimit("RATE_LIMIT_EMAIL_OTP_EMAIL", "sliding_window:3/15m"),
+			passwordResetIP:    getEnvLimit("RATE_LIMIT_PASSWORD_RESET_IP", "sliding_window:20/1h"),
+			passwordResetEmail: getEnvLimit("RATE_LIMIT_PASSWORD_RESET_EMAIL", "sliding_window:3/15m"),
+			verificationIP:     getEnvLimit("RATE_LIMIT_VERIFICATION_IP", "sliding_window:20/1h"),
+			verificationEmail:  getEnvLimit("RATE_LIMIT_VERIFICATION_EMAIL", "sliding_window:3/15m"),
+			unlockIP:           getEnvLimit("RATE_LIMIT_UNLOCK_IP", "sliding_window:20/1h"),
+		},
+		webAuthn: &webauthn.Config{
+			RPID:          getEnv("WEBAUTHN_RP_ID", "localhost"),
+			RPDisplayName: getEnv("WEBAUTHN_RP_NAME", "jwt-based-auth-system"),
+			RPOrigins:     getEnvList("WEBAUTHN_RP_ORIGINS", "http://localhost:3000"),
+		},
+		oidcProviders: loadOIDCProviders(baseURL),
+		mailer: &mailer.Config{
+			Driver:   os.Getenv("MAILER_DRIVER"),
+			From:     getEnv("MAIL_FROM", "no-reply@localhost"),
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     getEnv("SMTP_PORT", "587"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			Dir:      os.Getenv("MAIL_DIR"),
+		},
+	}
+}
+
+// loadOIDCProviders reads the providers named in OIDC_PROVIDERS. Each one is
+// configured with OIDC_<NAME>_* variables, e.g. OIDC_GOOGLE_ISSUER.
+func loadOIDCProviders(baseURL string) []oidc.ProviderConfig {
+	var providers []oidc.ProviderConfig
+	for _, name := range getEnvList("OIDC_PROVIDERS", "") {
+		name = strings.ToLower(name)
+		prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+
+		provider := oidc.ProviderConfig{
+			Name:          name,
+			Issuer:        os.Getenv(prefix + "ISSUER"),
+			ClientID:      os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret:  os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:   strings.TrimSuffix(baseURL, "/") + "/login/oidc/" + name + "/callback",
+			Scopes:        getEnvList(prefix+"SCOPES", "openid,email,profile"),
+			AutoProvision: getEnvBool(prefix+"AUTO_PROVISION", true),
+		}
+		if provider.Issuer == "" || provider.ClientID == "" {
+			log.Fatalf("OIDC provider %s needs %sISSUER and %sCLIENT_ID", name, prefix, prefix)
+		}
+		providers = append(providers, provider)
+	}
+	return providers
+}
+
+// loadPasswordPolicy reads the PASSWORD_* variables on top of
+// models.DefaultPasswordPolicy, and the breach corpus at BREACHED_PASSWORDS
+// if one is configured.
+func loadPasswordPolicy() models.PasswordPolicy {
+	defaults := models.DefaultPasswordPolicy
+	policy := models.PasswordPolicy{
+		MinLength:       getEnvInt("PASSWORD_MIN_LENGTH", defaults.MinLength),
+		MaxLength:       getEnvInt("PASSWORD_MAX_LENGTH", defaults.MaxLength),
+		RequiredClasses: getEnvList("PASSWORD_REQUIRED_CLASSES", ""),
+		MinStrength:     getEnvInt("PASSWORD_MIN_STRENGTH", defaults.MinStrength),
+	}
+	if err := policy.Validate(); err != nil {
+		log.Fatalf("invalid password policy: %v", err)
+	}
+	if path := os.Getenv("BREACHED_PASSWORDS"); path != "" {
+		breached, err := breach.Open(path)
+		if err != nil {
+			log.Fatalf("failed to load breached passwords from %s: %v", path, err)
+		}
+		policy.Breached = breached
+	}
+	return policy
+}
+
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func getEnvBool(key string, fallback bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Fatalf("invalid boolean value %q for %s", value, key)
+	}
+	return b
+}
+
+func getEnvInt(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatalf("invalid integer value %q for %s", value, key)
+	}
+	return i
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("invalid duration value %q for %s", value, key)
+	}
+	return d
+}
+
+// getEnvUUID reads an optional UUID and exits if it is not valid.
+func getEnvUUID(key string) uuid.UUID {
+	value := os.Getenv(key)
+	if value == "" {
+		return uuid.Nil
+	}
+
+	id, err := uuid.Parse(value)
+	if err != nil {
+		log.Fatalf("invalid UUID value %q for %s", value, key)
+	}
+	return id
+}
+
+// getEnvList reads a comma separated list, ignoring empty entries.
+func getEnvList(key, fallback string) []string {
+	return splitList(getEnv(key, fallback), ",")
+}
+
+func splitList(value, sep string) []string {
+	var list []string
+	for _, item := range strings.Split(value, sep) {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// getEnvSchema loads the JSON Schema in the file the variable names.
+func getEnvSchema(key string) *jsonschema.Schema {
+	path := os.Getenv(key)
+	if path == "" {
+		return nil
+	}
+
+	schema, err := jsonschema.Load(path)
+	if err != nil {
+		log.Fatalf("invalid schema for %s: %v", key, err)
+	}
+	return schema
+}
+
+// getEnvMetadataPaths reads a list of metadata fields such as "user.locale".
+func getEnvMetadataPaths(key string) []string {
+	paths := getEnvList(key, "")
+	for _, path := range paths {
+		if !models.IsMetadataPath(path) {
+			log.Fatalf("invalid metadata field %q for %s", path, key)
+		}
+	}
+	return paths
+}
+
+func getEnvLimit(key, fallback string) ratelimit.Limit {
+	limit, err := ratelimit.ParseLimit(getEnv(key, fallback))
+	if err != nil {
+		log.Fatalf("invalid rate limit for %s: %v", key, err)
+	}
+	return limit
+}
@@ -1,12 +1,18 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/OsagieDG/jwt-based-auth-system/internal/db/migrations"
 	"github.com/OsagieDG/jwt-based-auth-system/internal/db/postgres"
+	"github.com/OsagieDG/jwt-based-auth-system/internal/jobs"
+	"github.com/OsagieDG/jwt-based-auth-system/internal/mailer"
+	"github.com/OsagieDG/jwt-based-auth-system/internal/query"
+	"github.com/OsagieDG/jwt-based-auth-system/internal/ratelimit"
 	"github.com/OsagieDG/mlog/service/middleware"
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/joho/godotenv"
@@ -36,7 +42,27 @@ func main() {
 		log.Fatal("could not migrate the database:", migrationsErr)
 	}
 
-	router := initializeRouter(dbConn)
+	appConfig := loadConfig()
+
+	mail, err := mailer.New(appConfig.mailer)
+	if err != nil {
+		log.Fatal("could not configure the mailer:", err)
+	}
+
+	go jobs.RunUserPurge(context.Background(), query.NewUserSQLRepository(dbConn), appConfig.purgeGracePeriod, appConfig.purgeInterval)
+
+	var rateLimitStore ratelimit.Store
+	switch appConfig.rateLimits.store {
+	case "postgres":
+		rateLimitStore = ratelimit.NewPostgresStore(dbConn)
+	case "memory":
+		rateLimitStore = ratelimit.NewMemoryStore()
+	default:
+		log.Fatalf("unknown rate limit store %q", appConfig.rateLimits.store)
+	}
+	go jobs.RunRateLimitPrune(context.Background(), rateLimitStore, 24*time.Hour, time.Hour)
+
+	router := initializeRouter(dbConn, appConfig, mail, rateLimitStore)
 
 	listenAddr := os.Getenv("HTTP_LISTEN_ADDRESS")
 
@@ -2,35 +2,251 @@ package main
 
 import (
 	"database/sql"
+	"log"
 	"net/http"
 
 	"github.com/OsagieDG/jwt-based-auth-system/handlers"
+	"github.com/OsagieDG/jwt-based-auth-system/internal/credentials"
+	"github.com/OsagieDG/jwt-based-auth-system/internal/keys"
+	"github.com/OsagieDG/jwt-based-auth-system/internal/mailer"
+	"github.com/OsagieDG/jwt-based-auth-system/internal/oidc"
 	"github.com/OsagieDG/jwt-based-auth-system/internal/query"
+	"github.com/OsagieDG/jwt-based-auth-system/internal/ratelimit"
+	"github.com/OsagieDG/jwt-based-auth-system/internal/secretbox"
 	"github.com/go-chi/chi/v5"
 )
 
-func initializeRouter(dbConn *sql.DB) http.Handler {
+func initializeRouter(dbConn *sql.DB, appConfig *config, mail mailer.Mailer, rateLimitStore ratelimit.Store) http.Handler {
 	router := chi.NewRouter()
 
+	mfaBox, err := secretbox.New([]byte(appConfig.mfaSecretKey))
+	if err != nil {
+		log.Fatal("could not set up MFA secret encryption:", err)
+	}
+	keysBox, err := secretbox.New([]byte(appConfig.signingKeysSecret))
+	if err != nil {
+		log.Fatal("could not set up signing key encryption:", err)
+	}
+
 	// Initializing the repositories and handlers
 	userRepository := query.NewUserSQLRepository(dbConn)
 	tokenRepository := query.NewTokenSQLRepository(dbConn)
-	session := handlers.NewSessionHandler(dbConn, userRepository, tokenRepository)
-	userHandler := handlers.NewUserHandler(userRepository)
+	verificationRepository := query.NewVerificationSQLRepository(dbConn)
+	passwordResetRepository := query.NewPasswordResetSQLRepository(dbConn)
+	emailChangeRepository := query.NewEmailChangeSQLRepository(dbConn)
+	lockoutRepository := query.NewLockoutSQLRepository(dbConn)
+	mfaRepository := query.NewMFASQLRepository(dbConn)
+	webauthnRepository := query.NewWebAuthnSQLRepository(dbConn)
+	auditRepository := query.NewAuditSQLRepository(dbConn)
+	magicLinkRepository := query.NewMagicLinkSQLRepository(dbConn)
+	emailOTPRepository := query.NewEmailOTPSQLRepository(dbConn)
+	identityRepository := query.NewIdentitySQLRepository(dbConn)
+	signingKeyRepository := query.NewSigningKeySQLRepository(dbConn)
+	scimClientRepository := query.NewSCIMClientSQLRepository(dbConn)
+	organizationRepository := query.NewOrganizationSQLRepository(dbConn)
+	invitationRepository := query.NewInvitationSQLRepository(dbConn)
+
+	// Login checks passwords against each configured backend in order
+	var verifiers credentials.Chain
+	for _, backend := range appConfig.authBackends {
+		switch backend {
+		case "local":
+			verifiers = append(verifiers, credentials.NewLocal(userRepository))
+		case "ldap":
+			verifiers = append(verifiers, credentials.NewLDAP(appConfig.ldap, userRepository, identityRepository, organizationRepository))
+		default:
+			log.Fatalf("unknown auth backend %q", backend)
+		}
+	}
+
+	lockout := handlers.NewLockoutHandler(appConfig.lockout, lockoutRepository, mail, appConfig.baseURL)
+	mfa := handlers.NewMFAHandler(userRepository, mfaRepository, webauthnRepository, mfaBox, appConfig.mfaIssuer)
+	session := handlers.NewSessionHandler(dbConn, appConfig.session, keys.NewRing(signingKeyRepository, keysBox), userRepository, tokenRepository, organizationRepository, verifiers, lockout, mfa)
+	emailVerification := handlers.NewEmailVerificationHandler(userRepository, verificationRepository, mail, appConfig.baseURL)
+	passwordReset := handlers.NewPasswordResetHandler(userRepository, passwordResetRepository, appConfig.passwordPolicy, mail, appConfig.baseURL)
+	emailChange := handlers.NewEmailChangeHandler(userRepository, emailChangeRepository, mail, appConfig.baseURL)
+	userHandler := handlers.NewUserHandler(userRepository, emailVerification, mfa, appConfig.passwordPolicy, appConfig.metadataSchema)
+	magicLink := handlers.NewMagicLinkHandler(appConfig.magicLink, userRepository, magicLinkRepository, session, mail, appConfig.baseURL)
+	emailOTP := handlers.NewEmailOTPHandler(appConfig.emailOTP, userRepository, emailOTPRepository, session, mail)
+	var oidcProviders []*oidc.Provider
+	for _, providerConfig := range appConfig.oidcProviders {
+		oidcProviders = append(oidcProviders, oidc.NewProvider(providerConfig))
+	}
+	oidcHandler := handlers.NewOIDCHandler(oidcProviders, userRepository, identityRepository, session)
+	bulkUsers := handlers.NewBulkUserHandler(userRepository, passwordReset)
+	organizations := handlers.NewOrganizationHandler(dbConn, organizationRepository, userHandler)
+	invitations := handlers.NewInvitationHandler(userRepository, organizationRepository, invitationRepository, appConfig.passwordPolicy, mail, appConfig.baseURL)
+	scimHandler := handlers.NewSCIMHandler(dbConn, userRepository, scimClientRepository, appConfig.passwordPolicy, appConfig.baseURL)
+	webAuthn, err := handlers.NewWebAuthnHandler(appConfig.webAuthn, userRepository, webauthnRepository, auditRepository, session)
+	if err != nil {
+		log.Fatal("could not set up WebAuthn:", err)
+	}
+
+	// Rate limits for the routes that are attractive for credential stuffing
+	// and signup spam
+	limiter := ratelimit.NewLimiter(rateLimitStore)
+	limits := appConfig.rateLimits
+	loginLimit := limiter.Middleware(
+		ratelimit.Rule{Name: "login_ip", Limit: limits.loginIP, Key: ratelimit.ByIP},
+		ratelimit.Rule{Name: "login_email", Limit: limits.loginEmail, Key: ratelimit.ByJSONField("email")},
+	)
+	signupLimit := limiter.Middleware(
+		ratelimit.Rule{Name: "signup_ip", Limit: limits.signupIP, Key: ratelimit.ByIP},
+	)
+	mfaLimit := limiter.Middleware(
+		ratelimit.Rule{Name: "mfa_ip", Limit: limits.loginIP, Key: ratelimit.ByIP},
+		ratelimit.Rule{Name: "mfa_challenge", Limit: limits.mfaChallenge, Key: ratelimit.ByJSONField("mfa_token")},
+	)
+	magicLinkLimit := limiter.Middleware(
+		ratelimit.Rule{Name: "magic_link_ip", Limit: limits.magicLinkIP, Key: ratelimit.ByIP},
+		ratelimit.Rule{Name: "magic_link_email", Limit: limits.magicLinkEmail, Key: ratelimit.ByJSONField("email")},
+	)
+	emailOTPLimit := limiter.Middleware(
+		ratelimit.Rule{Name: "email_otp_ip", Limit: limits.emailOTPIP, Key: ratelimit.ByIP},
+		ratelimit.Rule{Name: "email_otp_email", Limit: limits.emailOTPEmail, Key: ratelimit.ByJSONField("email")},
+	)
+	passwordResetLimit := limiter.Middleware(
+		ratelimit.Rule{Name: "password_reset_ip", Limit: limits.passwordResetIP, Key: ratelimit.ByIP},
+		ratelimit.Rule{Name: "password_reset_email", Limit: limits.passwordResetEmail, Key: ratelimit.ByJSONField("email")},
+	)
+	verificationLimit := limiter.Middleware(
+		ratelimit.Rule{Name: "verification_ip", Limit: limits.verificationIP, Key: ratelimit.ByIP},
+		ratelimit.Rule{Name: "verification_email", Limit: limits.verificationEmail, Key: ratelimit.ByJSONField("email")},
+	)
+	unlockLimit := limiter.Middleware(
+		ratelimit.Rule{Name: "unlock_ip", Limit: limits.unlockIP, Key: ratelimit.ByIP},
+	)
+	refreshLimit := limiter.Middleware(
+		ratelimit.Rule{Name: "refresh_ip", Limit: limits.refreshIP, Key: ratelimit.ByIP},
+		ratelimit.Rule{Name: "refresh_user", Limit: limits.refreshUser, Key: session.RefreshTokenUser},
+	)
 
 	// Defining Routes and Handlers
-	// Create user and get users does not need session validation
-	router.Post("/user", userHandler.HandleCreateUser)
-	router.Get("/users", userHandler.HandleFetchUsers)
-	router.Get("/user/{userID}", userHandler.HandleFetchUserByID)
+	// Create user does not need session validation
+	router.With(signupLimit).Post("/user", userHandler.HandleCreateUser)
+
+	// Email verification links are sent on signup and can be re-requested
+	router.Post("/verify-email", emailVerification.HandleVerifyEmail)
+	router.With(verificationLimit).Post("/verify-email/resend", emailVerification.HandleResendVerification)
+
+	// Password reset for users who forgot their password
+	router.With(passwordResetLimit).Post("/password/forgot", passwordReset.HandleForgotPassword)
+	router.Post("/password/reset", passwordReset.HandleResetPassword)
+
+	// Links sent to the new and old address when a user changes their email
+	router.Post("/email/change/confirm", emailChange.HandleConfirmEmailChange)
+	router.Post("/email/change/cancel", emailChange.HandleCancelEmailChange)
+
+	// Links emailed to people invited to an organization
+	router.Get("/invitations", invitations.HandleFetchInvitation)
+	router.With(signupLimit).Post("/invitations/accept", invitations.HandleAcceptInvitation)
+
+	// Link emailed to users whose account got locked by failed logins
+	router.With(unlockLimit).Post("/unlock", lockout.HandleUnlock)
 
 	// Login is used to generate session
-	router.Post("/login", session.Login)
+	router.With(loginLimit).Post("/login", session.Login)
+	router.With(mfaLimit).Post("/login/mfa", session.LoginMFA)
+	router.With(refreshLimit).Post("/refresh", session.Refresh)
+
+	// Passwordless login with a link sent to the user's email
+	router.With(magicLinkLimit).Post("/login/magic-link", magicLink.HandleRequestMagicLink)
+	router.With(loginLimit).Get("/login/magic-link/callback", magicLink.HandleMagicLinkCallback)
+
+	// Passwordless login with a numeric code sent to the user's email
+	router.With(emailOTPLimit).Post("/login/otp/request", emailOTP.HandleRequestOTP)
+	router.With(loginLimit).Post("/login/otp", emailOTP.HandleLoginOTP)
+
+	// Login through external OpenID Connect providers
+	router.Get("/login/oidc", oidcHandler.HandleListProviders)
+	router.With(loginLimit).Get("/login/oidc/{provider}", oidcHandler.HandleBeginLogin)
+	router.With(loginLimit).Get("/login/oidc/{provider}/callback", oidcHandler.HandleCallback)
+
+	// Security keys as a second factor after the password, or as a
+	// passwordless login with a passkey
+	router.With(mfaLimit).Post("/login/webauthn/begin", webAuthn.HandleBeginSecondFactor)
+	router.With(mfaLimit).Post("/login/webauthn/finish", webAuthn.HandleFinishSecondFactor)
+	router.With(loginLimit).Post("/login/passkey/begin", webAuthn.HandleBeginPasswordless)
+	router.With(loginLimit).Post("/login/passkey/finish", webAuthn.HandleFinishPasswordless)
 
 	// Applying the ValidateSession middleware to routes that need session validation
 	router.With(session.ValidateSession).Post("/logout", session.Logout)
-	router.With(session.ValidateSession).Put("/user/{userID}", userHandler.HandleUserUpdate)
-	router.With(session.ValidateSession).Delete("/user/{userID}", userHandler.HandleDeleteUser)
+	router.With(session.ValidateSession).Get("/me", userHandler.HandleFetchProfile)
+	router.With(session.ValidateSession).Post("/me/password", session.ChangePassword)
+	router.With(session.ValidateSession).Post("/me/email", emailChange.HandleRequestEmailChange)
+	router.With(session.ValidateSession, session.RequireSelf).Put("/user/{userID}", userHandler.HandleUserUpdate)
+	router.With(session.ValidateSession, session.RequireSelf).Patch("/user/{userID}", userHandler.HandleUserUpdate)
+	router.With(session.ValidateSession, session.RequireSelf).Delete("/user/{userID}", userHandler.HandleDeleteUser)
+	router.With(session.ValidateSession).Post("/me/deactivate", userHandler.HandleDeactivateSelf)
+	router.With(session.ValidateSession).Post("/me/mfa/totp", mfa.HandleEnrollTOTP)
+	router.With(session.ValidateSession).Post("/me/mfa/totp/confirm", mfa.HandleConfirmTOTP)
+	router.With(session.ValidateSession).Post("/me/mfa/totp/disable", mfa.HandleDisableTOTP)
+	router.With(session.ValidateSession).Post("/me/mfa/recovery-codes", mfa.HandleRegenerateRecoveryCodes)
+	router.With(session.ValidateSession).Get("/me/webauthn/credentials", webAuthn.HandleListCredentials)
+	router.With(session.ValidateSession).Post("/me/webauthn/register/begin", webAuthn.HandleBeginRegistration)
+	router.With(session.ValidateSession).Post("/me/webauthn/register/finish", webAuthn.HandleFinishRegistration)
+	router.With(session.ValidateSession).Delete("/me/webauthn/credentials/{credentialID}", webAuthn.HandleDeleteCredential)
+	router.With(session.ValidateSession).Get("/me/organizations", organizations.HandleListMyOrganizations)
+	router.With(session.ValidateSession).Post("/me/organization", session.HandleSwitchOrganization)
+	router.With(session.ValidateSession).Post("/me/invitations/accept", invitations.HandleAcceptInvitationForSession)
+
+	// Admin only routes
+	router.With(session.ValidateSession, session.RequireAdmin).Get("/users", userHandler.HandleFetchUsers)
+	router.With(session.ValidateSession, session.RequireAdmin).Get("/user/{userID}", userHandler.HandleFetchUserByID)
+	router.With(session.ValidateSession, session.RequireAdmin).Patch("/admin/users/{userID}", userHandler.HandleAdminUserUpdate)
+	router.With(session.ValidateSession, session.RequireAdmin).Delete("/admin/users/{userID}", userHandler.HandleDeleteUser)
+	router.With(session.ValidateSession, session.RequireAdmin).Post("/admin/users/{userID}/suspend", userHandler.HandleSuspendUser)
+	router.With(session.ValidateSession, session.RequireAdmin).Post("/admin/users/{userID}/restore", userHandler.HandleRestoreUser)
+	router.With(session.ValidateSession, session.RequireAdmin).Post("/admin/users/{userID}/unlock", lockout.HandleAdminUnlock)
+	router.With(session.ValidateSession, session.RequireAdmin).Post("/admin/users/import", bulkUsers.HandleImportUsers)
+	router.With(session.ValidateSession, session.RequireAdmin).Get("/admin/users/export", bulkUsers.HandleExportUsers)
+	router.With(session.ValidateSession, session.RequireAdmin).Get("/admin/scim/clients", scimHandler.HandleListClients)
+	router.With(session.ValidateSession, session.RequireAdmin).Post("/admin/scim/clients", scimHandler.HandleCreateClient)
+	router.With(session.ValidateSession, session.RequireAdmin).Delete("/admin/scim/clients/{clientID}", scimHandler.HandleDeleteClient)
+	router.With(session.ValidateSession, session.RequireAdmin).Get("/admin/organizations", organizations.HandleListOrganizations)
+	router.With(session.ValidateSession, session.RequireAdmin).Post("/admin/organizations", organizations.HandleCreateOrganization)
+	router.With(session.ValidateSession, session.RequireAdmin).Delete("/admin/organizations/{orgID}", organizations.HandleDeleteOrganization)
+	router.With(session.ValidateSession, session.RequireAdmin).Get("/admin/organizations/{orgID}/members", organizations.HandleAdminListMembers)
+	router.With(session.ValidateSession, session.RequireAdmin).Post("/admin/organizations/{orgID}/members", organizations.HandleAdminAddMember)
+
+	// Organization admin routes, scoped to the organization of the session
+	router.Group(func(r chi.Router) {
+		r.Use(session.ValidateSession, organizations.RequireOrganizationAdmin)
+		r.Get("/org", organizations.HandleFetchOrganization)
+		r.Get("/org/members", organizations.HandleListMembers)
+		r.Put("/org/members/{userID}/role", organizations.HandleSetMemberRole)
+		r.Delete("/org/members/{userID}", organizations.HandleRemoveMember)
+		r.Get("/org/users", organizations.HandleFetchUsers)
+		r.Post("/org/users", organizations.HandleCreateUser)
+		r.Get("/org/users/{userID}", organizations.HandleFetchUserByID)
+		r.Patch("/org/users/{userID}", organizations.HandleUserUpdate)
+		r.Delete("/org/users/{userID}", organizations.HandleDeleteUser)
+		r.Post("/org/users/{userID}/suspend", organizations.HandleSuspendUser)
+		r.Post("/org/users/{userID}/restore", organizations.HandleRestoreUser)
+		r.Get("/org/invitations", invitations.HandleListInvitations)
+		r.Post("/org/invitations", invitations.HandleCreateInvitation)
+		r.Post("/org/invitations/{invitationID}/resend", invitations.HandleResendInvitation)
+		r.Delete("/org/invitations/{invitationID}", invitations.HandleRevokeInvitation)
+	})
+
+	// SCIM 2.0 provisioning API for identity providers and HR systems,
+	// authenticated with a bearer token issued through the admin routes
+	router.Group(func(r chi.Router) {
+		r.Use(scimHandler.RequireClient)
+		r.Get("/scim/v2/ServiceProviderConfig", scimHandler.HandleServiceProviderConfig)
+		r.Get("/scim/v2/Users", scimHandler.HandleListUsers)
+		r.Post("/scim/v2/Users", scimHandler.HandleCreateUser)
+		r.Get("/scim/v2/Users/{id}", scimHandler.HandleFetchUser)
+		r.Put("/scim/v2/Users/{id}", scimHandler.HandleReplaceUser)
+		r.Patch("/scim/v2/Users/{id}", scimHandler.HandlePatchUser)
+		r.Delete("/scim/v2/Users/{id}", scimHandler.HandleDeleteUser)
+		r.Get("/scim/v2/Groups", scimHandler.HandleListGroups)
+		r.Post("/scim/v2/Groups", scimHandler.HandleCreateGroup)
+		r.Get("/scim/v2/Groups/{id}", scimHandler.HandleFetchGroup)
+		r.Put("/scim/v2/Groups/{id}", scimHandler.HandleReplaceGroup)
+		r.Patch("/scim/v2/Groups/{id}", scimHandler.HandlePatchGroup)
+		r.Delete("/scim/v2/Groups/{id}", scimHandler.HandleDeleteGroup)
+	})
 
 	return router
 }
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/OsagieDG/jwt-based-auth-system/internal/keys"
+	"github.com/OsagieDG/jwt-based-auth-system/internal/models"
+	"github.com/OsagieDG/jwt-based-auth-system/internal/query"
+	"github.com/OsagieDG/jwt-based-auth-system/internal/secretbox"
+)
+
+// The refresh token lifetime, so a rotation by default ends no session.
+const defaultRotationGrace = 30 * 24 * time.Hour
+
+func keysList(ctx context.Context, db *sql.DB, args []string) error {
+	flags, format := newFlags("keys list")
+	_ = flags.Parse(args)
+
+	signingKeys, err := query.NewSigningKeySQLRepository(db).GetSigningKeys(ctx)
+	if err != nil {
+		return err
+	}
+	if signingKeys == nil {
+		signingKeys = []models.SigningKey{}
+	}
+	return printOutput(*format, keysTable(signingKeys))
+}
+
+// keysGenerate adds a signing key. The first key of a purpose replaces the
+// built-in legacy key, which invalidates the tokens it signed.
+func keysGenerate(ctx context.Context, db *sql.DB, args []string) error {
+	flags, format := newFlags("keys generate")
+	purpose := flags.String("purpose", "all", "access, refresh, mfa_challenge or all")
+	_ = flags.Parse(args)
+
+	box, err := keysBox()
+	if err != nil {
+		return err
+	}
+
+	repository := query.NewSigningKeySQLRepository(db)
+	var generated []models.SigningKey
+	for _, p := range purposes(*purpose) {
+		key, err := keys.Generate(ctx, repository, box, p)
+		if err != nil {
+			return err
+		}
+		generated = append(generated, *key)
+	}
+	return printOutput(*format, keysTable(generated))
+}
+
+// keysRotate adds a signing key and retires the previous ones of the same
+// purpose once the grace period is over.
+func keysRotate(ctx context.Context, db *sql.DB, args []string) error {
+	flags, format := newFlags("keys rotate")
+	purpose := flags.String("purpose", "all", "access, refresh, mfa_challenge or all")
+	grace := flags.Duration("grace", defaultRotationGrace, "how long the previous keys keep verifying tokens")
+	_ = flags.Parse(args)
+
+	box, err := keysBox()
+	if err != nil {
+		return err
+	}
+
+	repository := query.NewSigningKeySQLRepository(db)
+	var rotated []models.SigningKey
+	for _, p := range purposes(*purpose) {
+		key, err := keys.Rotate(ctx, repository, box, p, *grace)
+		if err != nil {
+			return err
+		}
+		rotated = append(rotated, *key)
+	}
+	return printOutput(*format, keysTable(rotated))
+}
+
+// keysBox encrypts keys with the same secret the API decrypts them with.
+func keysBox() (*secretbox.Box, error) {
+	return secretbox.New([]byte(getEnv("SIGNING_KEYS_SECRET", "MY_SIGNING_KEYS_SECRET")))
+}
+
+func purposes(purpose string) []string {
+	if purpose == "all" {
+		return models.KeyPurposes
+	}
+	return []string{purpose}
+}
+
+func keysTable(signingKeys []models.SigningKey) table {
+	t := table{
+		value:   signingKeys,
+		headers: []string{"ID", "PURPOSE", "CREATED", "RETIRES", "STATE"},
+	}
+
+	now := time.Now()
+	signing := map[string]bool{}
+	for i := range signingKeys {
+		key := &signingKeys[i]
+
+		// Keys are listed newest first, so the first active key of a
+		// purpose is the one that signs.
+		state := "retired"
+		switch {
+		case key.RetiredAt == nil && !signing[key.Purpose]:
+			state = "signing"
+			signing[key.Purpose] = true
+		case key.ValidAt(now):
+			state = "verifying"
+		}
+
+		t.rows = append(t.rows, []string{key.ID, key.Purpose, formatTime(&key.CreatedAt), formatTime(key.RetiredAt), state})
+	}
+	return t
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/OsagieDG/jwt-based-auth-system/internal/db/migrations"
+)
+
+// migrateUp applies the migration scripts like the API does on startup.
+// The scripts are read relative to the working directory, so run it from
+// the repository root.
+func migrateUp(ctx context.Context, db *sql.DB, args []string) error {
+	flags, format := newFlags("migrate up")
+	_ = flags.Parse(args)
+
+	if err := migrations.ApplyMigrations(db); err != nil {
+		return err
+	}
+	return printResult(*format, "migrations applied")
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// newFlags returns the flag set of a subcommand with the shared -o flag.
+func newFlags(name string) (*flag.FlagSet, *string) {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	format := flags.String("o", "table", "output format, table or json")
+	return flags, format
+}
+
+// table is what a command prints: a JSON value, or the same data as rows
+// under a header.
+type table struct {
+	value   interface{}
+	headers []string
+	rows    [][]string
+}
+
+func printOutput(format string, t table) error {
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(t.value)
+	case "table":
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, strings.Join(t.headers, "\t"))
+		for _, row := range t.rows {
+			fmt.Fprintln(w, strings.Join(row, "\t"))
+		}
+		return w.Flush()
+	}
+	return fmt.Errorf("unknown output format %q", format)
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Local().Format(time.DateTime)
+}
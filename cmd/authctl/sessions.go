@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/OsagieDG/jwt-based-auth-system/internal/models"
+	"github.com/OsagieDG/jwt-based-auth-system/internal/query"
+	"github.com/google/uuid"
+)
+
+// sessionsList lists the refresh tokens that are still usable, of one user
+// or of everyone.
+func sessionsList(ctx context.Context, db *sql.DB, args []string) error {
+	flags, format := newFlags("sessions list")
+	_ = flags.Parse(args)
+
+	var userID *uuid.UUID
+	if flags.NArg() > 0 {
+		user, err := resolveUser(ctx, query.NewUserSQLRepository(db), flags.Args())
+		if err != nil {
+			return err
+		}
+		userID = &user.ID
+	}
+
+	tokens, err := query.NewTokenSQLRepository(db).GetActiveRefreshTokens(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if tokens == nil {
+		tokens = []models.RefreshToken{}
+	}
+
+	t := table{
+		value:   tokens,
+		headers: []string{"ID", "USER", "JTI", "EXPIRES"},
+	}
+	for i := range tokens {
+		token := &tokens[i]
+		t.rows = append(t.rows, []string{token.ID.String(), token.UserID.String(), token.JTI, formatTime(&token.ExpiresAt)})
+	}
+	return printOutput(*format, t)
+}
+
+// sessionsRevoke force-logs-out a user by revoking all their refresh
+// tokens, or revokes the single token given with -jti. Access tokens stay
+// valid until they expire a few minutes later.
+func sessionsRevoke(ctx context.Context, db *sql.DB, args []string) error {
+	flags, format := newFlags("sessions revoke")
+	jti := flags.String("jti", "", "revoke only the refresh token with this id")
+	_ = flags.Parse(args)
+
+	tokenRepository := query.NewTokenSQLRepository(db)
+
+	if *jti != "" {
+		if flags.NArg() > 0 {
+			return errors.New("use either -jti or a user, not both")
+		}
+		if err := tokenRepository.RevokeRefreshToken(ctx, *jti); err != nil {
+			return err
+		}
+		return printResult(*format, fmt.Sprintf("revoked refresh token %s", *jti))
+	}
+
+	user, err := resolveUser(ctx, query.NewUserSQLRepository(db), flags.Args())
+	if err != nil {
+		return err
+	}
+	if err := tokenRepository.RevokeRefreshTokensExcept(ctx, user.ID, ""); err != nil {
+		return err
+	}
+	return printResult(*format, fmt.Sprintf("revoked all sessions of %s", user.Email))
+}
+
+func sessionsPrune(ctx context.Context, db *sql.DB, args []string) error {
+	flags, format := newFlags("sessions prune")
+	_ = flags.Parse(args)
+
+	deleted, err := query.NewTokenSQLRepository(db).PruneRefreshTokens(ctx)
+	if err != nil {
+		return err
+	}
+	return printResult(*format, fmt.Sprintf("deleted %d expired or revoked refresh tokens", deleted))
+}
+
+func printResult(format, message string) error {
+	return printOutput(format, table{
+		value:   map[string]string{"message": message},
+		headers: []string{"RESULT"},
+		rows:    [][]string{{message}},
+	})
+}
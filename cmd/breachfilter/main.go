@@ -0,0 +1,83 @@
+// Command breachfilter builds the Bloom filter the API screens new passwords
+// with from the Pwned Passwords SHA-1 file of Have I Been Pwned, ordered by
+// hash or by count, with one "HASH:COUNT" line per password.
+//
+//	breachfilter [-fp rate] [-min-count n] [-n entries] -o FILE INPUT
+//
+// The input is read twice, once to count the entries the filter is sized
+// for and once to fill it, unless -n gives the count. Point
+// BREACHED_PASSWORDS at the output file to use it.
+package main
+
+import (
+	"crypto/sha1"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/OsagieDG/jwt-based-auth-system/internal/breach"
+)
+
+func main() {
+	log.SetFlags(0)
+
+	falsePositiveRate := flag.Float64("fp", 0.001, "false positive rate of the filter")
+	minCount := flag.Int("min-count", 1, "skip passwords seen fewer times than this in breaches")
+	entries := flag.Uint64("n", 0, "number of entries to size the filter for (default counted from the input)")
+	output := flag.String("o", "", "file to write the filter to")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() != 1 || *output == "" {
+		usage()
+	}
+	input := flag.Arg(0)
+
+	if *entries == 0 {
+		if err := scan(input, *minCount, func([sha1.Size]byte) { *entries++ }); err != nil {
+			log.Fatalf("breachfilter: %v", err)
+		}
+	}
+
+	filter, err := breach.NewFilter(*entries, *falsePositiveRate)
+	if err != nil {
+		log.Fatalf("breachfilter: %v", err)
+	}
+	if err := scan(input, *minCount, filter.Add); err != nil {
+		log.Fatalf("breachfilter: %v", err)
+	}
+
+	file, err := os.Create(*output)
+	if err != nil {
+		log.Fatalf("breachfilter: %v", err)
+	}
+	size, err := filter.WriteTo(file)
+	if err == nil {
+		err = file.Close()
+	}
+	if err != nil {
+		log.Fatalf("breachfilter: failed to write %s: %v", *output, err)
+	}
+
+	fmt.Printf("wrote %d passwords to %s (%d MiB)\n", filter.Entries(), *output, size>>20)
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: breachfilter [-fp rate] [-min-count n] [-n entries] -o FILE INPUT")
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func scan(path string, minCount int, fn func(sum [sha1.Size]byte)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := breach.ScanFile(file, minCount, fn); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+// Command bulkusers imports users from and exports users to CSV or JSON Lines
+// files, talking to the database configured in .env directly.
+//
+//	bulkusers import [-format csv|jsonl] [-dry-run] [-reset-passwords] [-batch-size n] FILE
+//	bulkusers export [-format csv|jsonl] [-include-password-hashes] [-o FILE]
+//
+// Imported users without a password hash cannot log in with a password
+// until they reset it through the forgot password flow.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/OsagieDG/jwt-based-auth-system/internal/bulk"
+	"github.com/OsagieDG/jwt-based-auth-system/internal/db/postgres"
+	"github.com/OsagieDG/jwt-based-auth-system/internal/query"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	// Settings can also come from the environment, so .env is optional here.
+	_ = godotenv.Load()
+
+	switch os.Args[1] {
+	case "import":
+		os.Exit(runImport(os.Args[2:]))
+	case "export":
+		os.Exit(runExport(os.Args[2:]))
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: bulkusers import [-format csv|jsonl] [-dry-run] [-reset-passwords] [-batch-size n] FILE")
+	fmt.Fprintln(os.Stderr, "       bulkusers export [-format csv|jsonl] [-include-password-hashes] [-o FILE]")
+	os.Exit(2)
+}
+
+func runImport(args []string) int {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	format := flags.String("format", "", "file format, csv or jsonl (default from the file extension)")
+	dryRun := flags.Bool("dry-run", false, "validate the file without storing anything")
+	resetPasswords := flags.Bool("reset-passwords", false, "ignore password hashes so every user has to reset their password")
+	batchSize := flags.Int("batch-size", bulk.DefaultBatchSize, "users stored per COPY")
+	_ = flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		usage()
+	}
+	path := flags.Arg(0)
+
+	if *format == "" {
+		*format = strings.TrimPrefix(filepath.Ext(path), ".")
+	}
+	fileFormat, err := bulk.ParseFormat(*format)
+	if err != nil {
+		log.Print(err)
+		return 2
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	defer file.Close()
+
+	userRepository := query.NewUserSQLRepository(connect())
+	importer := bulk.NewImporter(userRepository, bulk.ImportOptions{
+		Format:         fileFormat,
+		DryRun:         *dryRun,
+		ResetPasswords: *resetPasswords,
+		BatchSize:      *batchSize,
+	})
+
+	report, importErr := importer.Import(context.Background(), file)
+	if report != nil {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		_ = encoder.Encode(report)
+	}
+	if importErr != nil {
+		log.Print("import stopped: ", importErr)
+		return 1
+	}
+	if report.Failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+func runExport(args []string) int {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	format := flags.String("format", bulk.FormatJSONL, "file format, csv or jsonl")
+	includeHashes := flags.Bool("include-password-hashes", false, "include the bcrypt password hashes")
+	output := flags.String("o", "", "output file (default stdout)")
+	_ = flags.Parse(args)
+
+	fileFormat, err := bulk.ParseFormat(*format)
+	if err != nil {
+		log.Print(err)
+		return 2
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			log.Print(err)
+			return 1
+		}
+		defer file.Close()
+		w = file
+	}
+
+	userRepository := query.NewUserSQLRepository(connect())
+	count, err := bulk.Export(context.Background(), userRepository, w, bulk.ExportOptions{
+		Format:                fileFormat,
+		IncludePasswordHashes: *includeHashes,
+	})
+	if err != nil {
+		log.Print("export failed: ", err)
+		return 1
+	}
+
+	log.Printf("exported %d users", count)
+	return 0
+}
+
+func connect() *sql.DB {
+	dbConn, err := postgres.NewConnection(&postgres.Config{
+		Host:     os.Getenv("DB_HOST"),
+		Port:     os.Getenv("DB_PORT"),
+		Password: os.Getenv("DB_PASSWORD"),
+		User:     os.Getenv("DB_USER"),
+		SSLMode:  os.Getenv("DB_SSLMODE"),
+		DBName:   os.Getenv("DB_NAME"),
+	})
+	if err != nil {
+		log.Fatal("could not connect to the database: ", err)
+	}
+	return dbConn
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/OsagieDG/jwt-based-auth-system/internal/models"
+)
+
+// ChangePassword lets the logged in user rotate their password. Every other
+// session is revoked while the refresh token of the current one stays valid.
+func (s *SessionHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	id, ok := sessionUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var params models.ChangePasswordParams
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	user, err := s.userRepository.GetUserByID(context.Background(), id)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if errors := params.Validate(s.config.PasswordPolicy, user.PasswordInputs()...); len(errors) > 0 {
+		writeJSONResponse(w, http.StatusBadRequest, map[string]interface{}{
+			"error":  "invalid parameters",
+			"fields": errors,
+		})
+		return
+	}
+
+	if !models.IsValidPassword(user.EncryptedPassword, params.CurrentPassword) {
+		http.Error(w, "Current password is incorrect", http.StatusForbidden)
+		return
+	}
+
+	encpw, err := models.EncryptPassword(params.NewPassword)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.userRepository.UpdatePassword(context.Background(), user.ID, encpw); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.tokenRepository.RevokeRefreshTokensExcept(context.Background(), user.ID, sessionRefreshJTI(r)); err != nil {
+		http.Error(w, "Failed to revoke other sessions", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, map[string]string{"message": "Password changed successfully"})
+}
@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/OsagieDG/jwt-based-auth-system/internal/mailer"
+	"github.com/OsagieDG/jwt-based-auth-system/internal/models"
+	"github.com/OsagieDG/jwt-based-auth-system/internal/query"
+)
+
+const emailChangeTTL = 24 * time.Hour
+
+type EmailChangeHandler struct {
+	userRepository        query.UserRespository
+	emailChangeRepository query.EmailChangeRepository
+	mailer                mailer.Mailer
+	baseURL               string
+}
+
+func NewEmailChangeHandler(userRepository query.UserRespository, emailChangeRepository query.EmailChangeRepository, m mailer.Mailer, baseURL string) *EmailChangeHandler {
+	return &EmailChangeHandler{
+		userRepository:        userRepository,
+		emailChangeRepository: emailChangeRepository,
+		mailer:                m,
+		baseURL:               baseURL,
+	}
+}
+
+// HandleRequestEmailChange starts an email change for the logged in user. The
+// new address receives a confirmation link and the old one a cancel link; the
+// email is only swapped once the new address has been confirmed.
+func (h *EmailChangeHandler) HandleRequestEmailChange(w http.ResponseWriter, r *http.Request) {
+	id, ok := sessionUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var params models.ChangeEmailParams
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	params.NewEmail = models.NormalizeEmail(params.NewEmail)
+	if errors := params.Validate(); len(errors) > 0 {
+		writeJSONResponse(w, http.StatusBadRequest, map[string]interface{}{
+			"error":  "invalid parameters",
+			"fields": errors,
+		})
+		return
+	}
+
+	user, err := h.userRepository.GetUserByID(context.Background(), id)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !models.IsValidPassword(user.EncryptedPassword, params.CurrentPassword) {
+		http.Error(w, "Current password is incorrect", http.StatusForbidden)
+		return
+	}
+
+	if params.NewEmail == user.Email {
+		writeJSONResponse(w, http.StatusBadRequest, map[string]string{
+			"error": "new email must differ from the current email",
+		})
+		return
+	}
+
+	if _, err := h.userRepository.GetUserByEmail(context.Background(), params.NewEmail); err == nil {
+		writeJSONResponse(w, http.StatusConflict, map[string]string{
+			"error": query.ErrEmailTaken.Error(),
+		})
+		return
+	}
+
+	confirmToken, confirmHash, err := models.NewOpaqueToken()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	cancelToken, cancelHash, err := models.NewOpaqueToken()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	request := &models.EmailChangeRequest{
+		ID:               models.NewUUID(),
+		UserID:           user.ID,
+		OldEmail:         user.Email,
+		NewEmail:         params.NewEmail,
+		ConfirmTokenHash: confirmHash,
+		CancelTokenHash:  cancelHash,
+		ExpiresAt:        time.Now().Add(emailChangeTTL),
+	}
+	if err := h.emailChangeRepository.CreateEmailChangeRequest(context.Background(), request); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = h.mailer.Send(context.Background(), &mailer.Message{
+		To:      request.NewEmail,
+		Subject: "Confirm your new email address",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nPlease confirm that you want to use this address for your account by opening the link below:\n\n%s/email/change/confirm?token=%s\n\nThe link expires in %s.\n",
+			user.UserName, h.baseURL, confirmToken, emailChangeTTL,
+		),
+	})
+	if err != nil {
+		http.Error(w, "Failed to send confirmation email", http.StatusInternalServerError)
+		return
+	}
+
+	err = h.mailer.Send(context.Background(), &mailer.Message{
+		To:      request.OldEmail,
+		Subject: "Your email address is being changed",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nA request was made to change the email address of your account to %s.\nIf this was not you, cancel the change by opening the link below:\n\n%s/email/change/cancel?token=%s\n",
+			user.UserName, request.NewEmail, h.baseURL, cancelToken,
+		),
+	})
+	if err != nil {
+		http.Error(w, "Failed to send notification email", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusAccepted, map[string]string{"message": "A confirmation link has been sent to the new email address"})
+}
+
+func (h *EmailChangeHandler) HandleConfirmEmailChange(w http.ResponseWriter, r *http.Request) {
+	var params models.EmailChangeTokenParams
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	if errors := params.Validate(); len(errors) > 0 {
+		writeJSONResponse(w, http.StatusBadRequest, map[string]string{
+			"error": "invalid parameters",
+		})
+		return
+	}
+
+	_, err := h.emailChangeRepository.ConfirmEmailChange(context.Background(), models.HashOpaqueToken(params.Token))
+	if err != nil {
+		h.writeEmailChangeError(w, err)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, map[string]string{"message": "Email address changed successfully"})
+}
+
+func (h *EmailChangeHandler) HandleCancelEmailChange(w http.ResponseWriter, r *http.Request) {
+	var params models.EmailChangeTokenParams
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	if errors := params.Validate(); len(errors) > 0 {
+		writeJSONResponse(w, http.StatusBadRequest, map[string]string{
+			"error": "invalid parameters",
+		})
+		return
+	}
+
+	_, err := h.emailChangeRepository.CancelEmailChange(context.Background(), models.HashOpaqueToken(params.Token))
+	if err != nil {
+		h.writeEmailChangeError(w, err)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, map[string]string{"message": "Email change cancelled"})
+}
+
+func (h *EmailChangeHandler) writeEmailChangeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, query.ErrInvalidToken):
+		writeJSONResponse(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+	case errors.Is(err, query.ErrEmailTaken):
+		writeJSONResponse(w, http.StatusConflict, map[string]string{"error": err.Error()})
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
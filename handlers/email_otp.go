@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/OsagieDG/jwt-based-auth-system/internal/mailer"
+	"github.com/OsagieDG/jwt-based-auth-system/internal/models"
+	"github.com/OsagieDG/jwt-based-auth-system/internal/query"
+)
+
+type EmailOTPConfig struct {
+	TTL time.Duration
+	// MaxAttempts is how many codes may be tried before the code that was
+	// sent stops working and a new one has to be requested.
+	MaxAttempts int
+}
+
+type EmailOTPHandler struct {
+	config             *EmailOTPConfig
+	userRepository     query.UserRespository
+	emailOTPRepository query.EmailOTPRepository
+	session            *SessionHandler
+	mailer             mailer.Mailer
+}
+
+func NewEmailOTPHandler(config *EmailOTPConfig, userRepository query.UserRespository, emailOTPRepository query.EmailOTPRepository, session *SessionHandler, m mailer.Mailer) *EmailOTPHandler {
+	return &EmailOTPHandler{
+		config:             config,
+		userRepository:     userRepository,
+		emailOTPRepository: emailOTPRepository,
+		session:            session,
+		mailer:             m,
+	}
+}
+
+// HandleRequestOTP answers with the same message whether or not the email
+// belongs to an account, so it cannot be used to enumerate users. The account
+// is looked up and mailed after answering, so the response time does not give
+// it away either.
+func (h *EmailOTPHandler) HandleRequestOTP(w http.ResponseWriter, r *http.Request) {
+	var params models.RequestEmailOTPParams
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	go h.sendOTPTo(params.Email)
+
+	writeJSONResponse(w, http.StatusAccepted, map[string]string{
+		"message": "If an account with that email exists, a login code has been sent",
+	})
+}
+
+func (h *EmailOTPHandler) sendOTPTo(email string) {
+	user, err := h.userRepository.GetUserByEmail(context.Background(), email)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("failed to look up %s for a login code: %v", email, err)
+		}
+		return
+	}
+	if !user.IsActive() {
+		return
+	}
+
+	if err := h.sendOTP(context.Background(), user); err != nil {
+		log.Printf("failed to send login code to %s: %v", user.Email, err)
+	}
+}
+
+// HandleLoginOTP checks the emailed code and logs the user in exactly like a
+// correct password would.
+func (h *EmailOTPHandler) HandleLoginOTP(w http.ResponseWriter, r *http.Request) {
+	var params models.EmailOTPLoginParams
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.userRepository.GetUserByEmail(context.Background(), params.Email)
+	if err != nil {
+		http.Error(w, "Invalid or expired code", http.StatusUnauthorized)
+		return
+	}
+
+	otp, err := h.emailOTPRepository.ClaimEmailOTPAttempt(context.Background(), user.ID, h.config.MaxAttempts)
+	if err != nil {
+		if errors.Is(err, query.ErrInvalidToken) {
+			http.Error(w, "Invalid or expired code", http.StatusUnauthorized)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !otp.Matches(params.Code) {
+		http.Error(w, "Invalid or expired code", http.StatusUnauthorized)
+		return
+	}
+
+	if err := h.emailOTPRepository.UseEmailOTP(context.Background(), otp.ID); err != nil {
+		if errors.Is(err, query.ErrInvalidToken) {
+			http.Error(w, "Invalid or expired code", http.StatusUnauthorized)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.session.completeFirstFactor(w, user)
+}
+
+func (h *EmailOTPHandler) sendOTP(ctx context.Context, user *models.User) error {
+	code, hash, err := models.NewEmailOTPCode()
+	if err != nil {
+		return err
+	}
+
+	otp := &models.EmailOTP{
+		ID:        models.NewUUID(),
+		UserID:    user.ID,
+		CodeHash:  hash,
+		ExpiresAt: time.Now().Add(h.config.TTL),
+	}
+	if err := h.emailOTPRepository.SaveEmailOTP(ctx, otp); err != nil {
+		return err
+	}
+
+	return h.mailer.Send(ctx, &mailer.Message{
+		To:      user.Email,
+		Subject: "Your login code",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nYour login code is:\n\n%s\n\nThe code expires in %s and can only be used once. If you did not request this, you can ignore this email.\n",
+			user.UserName, code, h.config.TTL,
+		),
+	})
+}
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/OsagieDG/jwt-based-auth-system/internal/mailer"
+	"github.com/OsagieDG/jwt-based-auth-system/internal/models"
+	"github.com/OsagieDG/jwt-based-auth-system/internal/query"
+)
+
+const (
+	verificationTokenTTL       = 24 * time.Hour
+	verificationResendInterval = time.Minute
+)
+
+type VerifyEmailParams struct {
+	Token string `json:"token"`
+}
+
+type ResendVerificationParams struct {
+	Email string `json:"email"`
+}
+
+type EmailVerificationHandler struct {
+	userRepository         query.UserRespository
+	verificationRepository query.VerificationRepository
+	mailer                 mailer.Mailer
+	baseURL                string
+}
+
+func NewEmailVerificationHandler(userRepository query.UserRespository, verificationRepository query.VerificationRepository, m mailer.Mailer, baseURL string) *EmailVerificationHandler {
+	return &EmailVerificationHandler{
+		userRepository:         userRepository,
+		verificationRepository: verificationRepository,
+		mailer:                 m,
+		baseURL:                baseURL,
+	}
+}
+
+// SendVerification issues a fresh verification token for the user and mails
+// it. Only the hash of the token is stored.
+func (h *EmailVerificationHandler) SendVerification(ctx context.Context, user *models.User) error {
+	token, hash, err := models.NewOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	verificationToken := &models.VerificationToken{
+		ID:        models.NewUUID(),
+		UserID:    user.ID,
+		TokenHash: hash,
+		ExpiresAt: time.Now().Add(verificationTokenTTL),
+	}
+	if err := h.verificationRepository.SaveVerificationToken(ctx, verificationToken); err != nil {
+		return err
+	}
+
+	return h.mailer.Send(ctx, &mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nPlease confirm your email address by opening the link below:\n\n%s/verify-email?token=%s\n\nThe link expires in %s.\n",
+			user.UserName, h.baseURL, token, verificationTokenTTL,
+		),
+	})
+}
+
+func (h *EmailVerificationHandler) HandleVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var params VerifyEmailParams
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil || params.Token == "" {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	_, err := h.verificationRepository.ConsumeVerificationToken(context.Background(), models.HashOpaqueToken(params.Token))
+	if err != nil {
+		if errors.Is(err, query.ErrInvalidToken) {
+			writeJSONResponse(w, http.StatusBadRequest, map[string]string{
+				"error": err.Error(),
+			})
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, map[string]string{"message": "Email verified successfully"})
+}
+
+// HandleResendVerification always answers with the same message so it cannot
+// be used to find out which addresses are registered or already verified. The
+// account is looked up and mailed after answering, so the response time does
+// not give it away either.
+func (h *EmailVerificationHandler) HandleResendVerification(w http.ResponseWriter, r *http.Request) {
+	var params ResendVerificationParams
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	go h.resendVerificationTo(params.Email)
+
+	writeJSONResponse(w, http.StatusAccepted, map[string]string{
+		"message": "If the account exists and is not yet verified, a verification email has been sent",
+	})
+}
+
+func (h *EmailVerificationHandler) resendVerificationTo(email string) {
+	user, err := h.userRepository.GetUserByEmail(context.Background(), email)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("failed to look up %s for a verification email: %v", email, err)
+		}
+		return
+	}
+	if user.EmailVerified {
+		return
+	}
+
+	latest, err := h.verificationRepository.GetLatestVerificationToken(context.Background(), user.ID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("failed to load the verification token of %s: %v", user.ID, err)
+		return
+	}
+	if latest != nil && time.Since(latest.CreatedAt) < verificationResendInterval {
+		return
+	}
+
+	if err := h.SendVerification(context.Background(), user); err != nil {
+		log.Printf("failed to send verification email to %s: %v", user.Email, err)
+	}
+}
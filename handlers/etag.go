@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/OsagieDG/jwt-based-auth-system/internal/models"
+	"github.com/google/uuid"
+)
+
+func userETag(user *models.User) string {
+	return fmt.Sprintf(`"%d"`, user.Version)
+}
+
+// etagListContains reports whether an If-Match or If-None-Match header value
+// lists etag or is "*". With weak set, W/ prefixes are ignored as required
+// for If-None-Match; If-Match uses the strong comparison.
+func etagListContains(header, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// ifMatchVersion resolves the If-Match header of a write request to the user
+// version the write must be conditional on. A zero version means the request
+// carried no precondition. When ok is false a 412 has already been written.
+func (h *UserHandler) ifMatchVersion(w http.ResponseWriter, r *http.Request, id uuid.UUID) (version int64, ok bool) {
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		return 0, true
+	}
+
+	user, err := h.userRepository.GetUserByID(context.Background(), id)
+	if err != nil || !etagListContains(ifMatch, userETag(user), false) {
+		http.Error(w, http.StatusText(http.StatusPreconditionFailed), http.StatusPreconditionFailed)
+		return 0, false
+	}
+
+	return user.Version, true
+}
@@ -0,0 +1,317 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/OsagieDG/jwt-based-auth-system/internal/mailer"
+	"github.com/OsagieDG/jwt-based-auth-system/internal/models"
+	"github.com/OsagieDG/jwt-based-auth-system/internal/query"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+const invitationTTL = 7 * 24 * time.Hour
+
+// InvitationHandler lets organization admins invite people by email. The
+// invite link either creates an account for the address or, once the
+// invitee has logged in, adds the existing account to the organization.
+type InvitationHandler struct {
+	userRepository         query.UserRespository
+	organizationRepository query.OrganizationRepository
+	invitationRepository   query.InvitationRepository
+	passwordPolicy         models.PasswordPolicy
+	mailer                 mailer.Mailer
+	baseURL                string
+}
+
+func NewInvitationHandler(userRepository query.UserRespository, organizationRepository query.OrganizationRepository, invitationRepository query.InvitationRepository, passwordPolicy models.PasswordPolicy, m mailer.Mailer, baseURL string) *InvitationHandler {
+	return &InvitationHandler{
+		userRepository:         userRepository,
+		organizationRepository: organizationRepository,
+		invitationRepository:   invitationRepository,
+		passwordPolicy:         passwordPolicy,
+		mailer:                 m,
+		baseURL:                baseURL,
+	}
+}
+
+// HandleCreateInvitation must be chained after RequireOrganizationAdmin, as
+// the invitation is for the session's organization.
+func (h *InvitationHandler) HandleCreateInvitation(w http.ResponseWriter, r *http.Request) {
+	var params models.CreateInvitationParams
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	params.Email = models.NormalizeEmail(params.Email)
+	if errors := params.Validate(); len(errors) > 0 {
+		writeJSONResponse(w, http.StatusBadRequest, map[string]interface{}{
+			"error":  "invalid parameters",
+			"fields": errors,
+		})
+		return
+	}
+	if params.Role == models.OrgRoleOwner && !isOrganizationOwner(r) {
+		http.Error(w, "Only owners can appoint owners", http.StatusForbidden)
+		return
+	}
+
+	tenant, _ := sessionOrganizationID(r)
+	token, hash, err := models.NewOpaqueToken()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	invitation := &models.Invitation{
+		ID:             models.NewUUID(),
+		OrganizationID: tenant,
+		Email:          params.Email,
+		Role:           params.Role,
+		TokenHash:      hash,
+		InvitedBy:      sessionActorID(r),
+		ExpiresAt:      time.Now().Add(invitationTTL),
+	}
+	if err := h.invitationRepository.CreateInvitation(context.Background(), invitation); err != nil {
+		if errors.Is(err, query.ErrAlreadyMember) || errors.Is(err, query.ErrInvitationPending) {
+			writeJSONResponse(w, http.StatusConflict, map[string]string{"error": err.Error()})
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// The invitation exists at this point, so a mail failure is only logged;
+	// the admin can resend it.
+	if err := h.sendInvitation(context.Background(), invitation, token); err != nil {
+		log.Printf("failed to send invitation email to %s: %v", invitation.Email, err)
+	}
+
+	writeJSONResponse(w, http.StatusCreated, map[string]interface{}{"data": invitation})
+}
+
+func (h *InvitationHandler) HandleListInvitations(w http.ResponseWriter, r *http.Request) {
+	tenant, _ := sessionOrganizationID(r)
+
+	invitations, err := h.invitationRepository.GetPendingInvitations(context.Background(), tenant)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if invitations == nil {
+		invitations = []models.Invitation{}
+	}
+
+	writeJSONResponse(w, http.StatusOK, map[string]interface{}{"data": invitations})
+}
+
+// HandleResendInvitation mails a new link with a fresh expiry. The link sent
+// before stops working.
+func (h *InvitationHandler) HandleResendInvitation(w http.ResponseWriter, r *http.Request) {
+	invitationID, err := uuid.Parse(chi.URLParam(r, "invitationID"))
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	tenant, _ := sessionOrganizationID(r)
+
+	token, hash, err := models.NewOpaqueToken()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	invitation, err := h.invitationRepository.RenewInvitation(context.Background(), tenant, invitationID, hash, time.Now().Add(invitationTTL))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeJSONResponse(w, http.StatusNotFound, map[string]string{"error": "invitation not found"})
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.sendInvitation(context.Background(), invitation, token); err != nil {
+		http.Error(w, "Failed to send invitation email", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, map[string]interface{}{"data": invitation})
+}
+
+func (h *InvitationHandler) HandleRevokeInvitation(w http.ResponseWriter, r *http.Request) {
+	invitationID, err := uuid.Parse(chi.URLParam(r, "invitationID"))
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	tenant, _ := sessionOrganizationID(r)
+
+	if err := h.invitationRepository.RevokeInvitation(context.Background(), tenant, invitationID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeJSONResponse(w, http.StatusNotFound, map[string]string{"error": "invitation not found"})
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleFetchInvitation shows the invitation behind a link before it is
+// accepted, including whether the invitee has to create an account or log in
+// to accept it.
+func (h *InvitationHandler) HandleFetchInvitation(w http.ResponseWriter, r *http.Request) {
+	invitation, err := h.invitationRepository.GetInvitationByToken(context.Background(), models.HashOpaqueToken(r.URL.Query().Get("token")))
+	if err != nil {
+		if errors.Is(err, query.ErrInvalidToken) {
+			writeJSONResponse(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	organization, err := h.organizationRepository.GetOrganizationByID(context.Background(), invitation.OrganizationID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	newAccount, err := h.needsAccount(invitation)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"email":        invitation.Email,
+		"role":         invitation.Role,
+		"organization": organization.Name,
+		"expires_at":   invitation.ExpiresAt,
+		"new_account":  newAccount,
+	})
+}
+
+// HandleAcceptInvitation joins the organization with a new account, created
+// from the username and password sent along. An invitation for an existing
+// account is refused here, as whoever holds the link may not own it; the
+// invitee has to log in and use HandleAcceptInvitationForSession.
+func (h *InvitationHandler) HandleAcceptInvitation(w http.ResponseWriter, r *http.Request) {
+	h.acceptInvitation(w, r, nil)
+}
+
+// HandleAcceptInvitationForSession must be chained after ValidateSession. It
+// joins the organization with the logged in account, which has to be the one
+// the invitation was sent to.
+func (h *InvitationHandler) HandleAcceptInvitationForSession(w http.ResponseWriter, r *http.Request) {
+	id, ok := sessionUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	h.acceptInvitation(w, r, &id)
+}
+
+func (h *InvitationHandler) acceptInvitation(w http.ResponseWriter, r *http.Request, accountID *uuid.UUID) {
+	var params models.AcceptInvitationParams
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	tokenHash := models.HashOpaqueToken(params.Token)
+
+	invitation, err := h.invitationRepository.GetInvitationByToken(context.Background(), tokenHash)
+	if err != nil {
+		if errors.Is(err, query.ErrInvalidToken) {
+			writeJSONResponse(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	newAccount := false
+	if accountID == nil {
+		if newAccount, err = h.needsAccount(invitation); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if errors := params.Validate(newAccount, h.passwordPolicy, invitation.Email); len(errors) > 0 {
+		writeJSONResponse(w, http.StatusBadRequest, map[string]interface{}{
+			"error":  "invalid parameters",
+			"fields": errors,
+		})
+		return
+	}
+
+	var newUser *models.User
+	if newAccount {
+		newUser, err = models.NewUserFromParams(models.CreateUserParams{
+			UserName: models.NormalizeUserName(params.UserName),
+			Email:    invitation.Email,
+			Password: params.Password,
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	_, userID, err := h.invitationRepository.AcceptInvitation(context.Background(), tokenHash, newUser, accountID)
+	if err != nil {
+		if writeDuplicateUser(w, err) {
+			return
+		}
+		switch {
+		case errors.Is(err, query.ErrInvalidToken), errors.Is(err, query.ErrAccountRequired):
+			writeJSONResponse(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		case errors.Is(err, query.ErrNotInvitee) && accountID == nil:
+			writeJSONResponse(w, http.StatusUnauthorized, map[string]string{"error": err.Error()})
+		case errors.Is(err, query.ErrNotInvitee):
+			writeJSONResponse(w, http.StatusForbidden, map[string]string{"error": err.Error()})
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if newUser != nil && newUser.ID == userID {
+		writeJSONResponse(w, http.StatusCreated, map[string]string{"message": "Account created and invitation accepted, you can now log in"})
+		return
+	}
+	writeJSONResponse(w, http.StatusOK, map[string]string{"message": "Invitation accepted"})
+}
+
+func (h *InvitationHandler) needsAccount(invitation *models.Invitation) (bool, error) {
+	_, err := h.userRepository.GetUserByEmail(context.Background(), invitation.Email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return true, nil
+	}
+	return false, err
+}
+
+func (h *InvitationHandler) sendInvitation(ctx context.Context, invitation *models.Invitation, token string) error {
+	organization, err := h.organizationRepository.GetOrganizationByID(ctx, invitation.OrganizationID)
+	if err != nil {
+		return err
+	}
+
+	return h.mailer.Send(ctx, &mailer.Message{
+		To:      invitation.Email,
+		Subject: fmt.Sprintf("You have been invited to join %s", organization.Name),
+		Body: fmt.Sprintf(
+			"Hi,\n\nYou have been invited to join %s as %s. Open the link below to accept the invitation:\n\n%s/invitations?token=%s\n\nThe link expires in %s. If you were not expecting this, you can ignore this email.\n",
+			organization.Name, invitation.Role, h.baseURL, token, invitationTTL,
+		),
+	})
+}
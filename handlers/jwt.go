@@ -4,27 +4,37 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
 	"time"
 
+	"github.com/OsagieDG/jwt-based-auth-system/internal/credentials"
+	"github.com/OsagieDG/jwt-based-auth-system/internal/keys"
 	"github.com/OsagieDG/jwt-based-auth-system/internal/models"
 	"github.com/OsagieDG/jwt-based-auth-system/internal/query"
+	"github.com/go-chi/chi/v5"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 )
 
 type ContextKey string
 
-const userID ContextKey = "userID"
-
-var (
-	jwtKey          = []byte("MY_SECRET_KEY")
-	refreshTokenKey = []byte("MY_REFRESH_SECRET_KEY")
+const (
+	userID         ContextKey = "userID"
+	refreshJTI     ContextKey = "refreshJTI"
+	organizationID ContextKey = "organizationID"
 )
 
 type Claims struct {
 	UserID uuid.UUID `json:"user_id"`
 	JTI    string    `json:"jti"`
+	// OrganizationID is the tenant the session acts in. It is only set for
+	// users who belong to an organization.
+	OrganizationID *uuid.UUID `json:"org_id,omitempty"`
+	// Metadata holds the user metadata fields listed in
+	// SessionConfig.MetadataClaims. Only access tokens carry it.
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
 	jwt.RegisteredClaims
 }
 
@@ -33,17 +43,40 @@ type LoginParams struct {
 	Password string `json:"password"`
 }
 
+type SessionConfig struct {
+	// RequireEmailVerification blocks Login until the user has confirmed
+	// their email address.
+	RequireEmailVerification bool
+	// PasswordPolicy applies when users change their password.
+	PasswordPolicy models.PasswordPolicy
+	// MetadataClaims lists the metadata fields, such as "user.locale", that
+	// are copied into access tokens.
+	MetadataClaims []string
+}
+
 type SessionHandler struct {
-	DB              *sql.DB
-	userRepository  query.UserRespository
-	tokenRepository query.TokenRepository
+	DB                     *sql.DB
+	config                 *SessionConfig
+	keys                   *keys.Ring
+	userRepository         query.UserRespository
+	tokenRepository        query.TokenRepository
+	organizationRepository query.OrganizationRepository
+	credentials            credentials.Verifier
+	lockout                *LockoutHandler
+	mfa                    *MFAHandler
 }
 
-func NewSessionHandler(db *sql.DB, userRepository query.UserRespository, tokenRepository query.TokenRepository) *SessionHandler {
+func NewSessionHandler(db *sql.DB, config *SessionConfig, keyRing *keys.Ring, userRepository query.UserRespository, tokenRepository query.TokenRepository, organizationRepository query.OrganizationRepository, verifier credentials.Verifier, lockout *LockoutHandler, mfa *MFAHandler) *SessionHandler {
 	return &SessionHandler{
-		DB:              db,
-		userRepository:  userRepository,
-		tokenRepository: tokenRepository,
+		DB:                     db,
+		config:                 config,
+		keys:                   keyRing,
+		userRepository:         userRepository,
+		tokenRepository:        tokenRepository,
+		organizationRepository: organizationRepository,
+		credentials:            verifier,
+		lockout:                lockout,
+		mfa:                    mfa,
 	}
 }
 
@@ -54,33 +87,131 @@ func (s *SessionHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := s.userRepository.GetUserByEmail(context.Background(), params.Email)
-	if err != nil || !models.IsValidPassword(user.EncryptedPassword, params.Password) {
+	// Directory users may not have a local record before their first login,
+	// so only known users can be checked for and counted towards a lockout.
+	known, err := s.userRepository.GetUserByEmail(context.Background(), params.Email)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// A locked account gets the same answer as a wrong password so the
+	// response does not tell an attacker which of the two it was.
+	if known != nil && s.lockout.IsLocked(context.Background(), known) {
 		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 		return
 	}
 
+	user, err := s.credentials.Verify(context.Background(), params.Email, params.Password)
+	if err != nil {
+		if !errors.Is(err, credentials.ErrInvalidCredentials) {
+			log.Printf("failed to verify credentials for %s: %v", params.Email, err)
+			http.Error(w, "Authentication backend is unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		if known != nil {
+			s.lockout.RegisterFailure(context.Background(), known)
+		}
+		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		return
+	}
+	s.lockout.RegisterSuccess(context.Background(), user)
+
+	s.completeFirstFactor(w, user)
+}
+
+// completeFirstFactor finishes a login once the user has proven the first
+// factor, by password or by access to their inbox. Users with a second
+// factor get an MFA challenge instead of a session.
+func (s *SessionHandler) completeFirstFactor(w http.ResponseWriter, user *models.User) {
+	if !s.canLogin(w, user) {
+		return
+	}
+
+	methods, err := s.mfa.Methods(context.Background(), user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(methods) > 0 {
+		s.startMFAChallenge(w, user, methods)
+		return
+	}
+
+	if err := s.issueSession(w, user); err != nil {
+		http.Error(w, "Failed to save refresh token", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, map[string]string{"message": "Login successful"})
+}
+
+// canLogin applies the account checks every login method has to pass once
+// the user has been identified. When it returns false the response has
+// already been written.
+func (s *SessionHandler) canLogin(w http.ResponseWriter, user *models.User) bool {
+	if !user.IsActive() {
+		http.Error(w, "Account is not active", http.StatusForbidden)
+		return false
+	}
+
+	if s.config.RequireEmailVerification && !user.EmailVerified {
+		http.Error(w, "Email address has not been verified", http.StatusForbidden)
+		return false
+	}
+
+	return true
+}
+
+// issueSession signs a new access and refresh token pair for the user,
+// stores the refresh token and sets both cookies. The session acts in the
+// first organization the user joined.
+func (s *SessionHandler) issueSession(w http.ResponseWriter, user *models.User) error {
+	memberships, err := s.organizationRepository.GetUserMemberships(context.Background(), user.ID)
+	if err != nil {
+		return err
+	}
+
+	var tenant *uuid.UUID
+	if len(memberships) > 0 {
+		tenant = &memberships[0].Organization.ID
+	}
+	return s.issueTenantSession(w, user, tenant)
+}
+
+// issueTenantSession is issueSession for a given organization, which the
+// caller has checked the user belongs to.
+func (s *SessionHandler) issueTenantSession(w http.ResponseWriter, user *models.User, tenant *uuid.UUID) error {
 	expirationTime := time.Now().Add(5 * time.Minute)
 	refreshExpirationTime := time.Now().Add(29 * 24 * time.Hour)
 	jti := uuid.New().String()
 	refreshJTI := uuid.New().String()
 
 	claims := &Claims{
-		UserID: user.ID,
-		JTI:    jti,
+		UserID:         user.ID,
+		JTI:            jti,
+		OrganizationID: tenant,
+		Metadata:       s.metadataClaims(user),
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 		},
 	}
 	refreshClaims := &Claims{
-		UserID: user.ID,
-		JTI:    refreshJTI,
+		UserID:         user.ID,
+		JTI:            refreshJTI,
+		OrganizationID: tenant,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(refreshExpirationTime),
 		},
 	}
-	token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(jwtKey)
-	refreshToken, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, refreshClaims).SignedString(refreshTokenKey)
+	token, err := s.keys.Sign(models.KeyPurposeAccess, claims)
+	if err != nil {
+		return err
+	}
+	refreshToken, err := s.keys.Sign(models.KeyPurposeRefresh, refreshClaims)
+	if err != nil {
+		return err
+	}
 
 	refreshTokenModel := &models.RefreshToken{
 		ID:        uuid.New(),
@@ -90,10 +221,8 @@ func (s *SessionHandler) Login(w http.ResponseWriter, r *http.Request) {
 		Revoked:   false,
 	}
 
-	err = s.tokenRepository.SaveRefreshToken(context.Background(), refreshTokenModel)
-	if err != nil {
-		http.Error(w, "Failed to save refresh token", http.StatusInternalServerError)
-		return
+	if err := s.tokenRepository.SaveRefreshToken(context.Background(), refreshTokenModel); err != nil {
+		return err
 	}
 
 	http.SetCookie(w, &http.Cookie{
@@ -112,7 +241,20 @@ func (s *SessionHandler) Login(w http.ResponseWriter, r *http.Request) {
 		SameSite: http.SameSiteStrictMode,
 	})
 
-	writeJSONResponse(w, http.StatusOK, map[string]string{"message": "Login successful"})
+	return nil
+}
+
+// metadataClaims returns the configured metadata fields of the user, or nil
+// when there are none to include.
+func (s *SessionHandler) metadataClaims(user *models.User) map[string]interface{} {
+	if len(s.config.MetadataClaims) == 0 {
+		return nil
+	}
+	metadata := user.Metadata.Project(s.config.MetadataClaims)
+	if len(metadata) == 0 {
+		return nil
+	}
+	return metadata
 }
 
 func (s *SessionHandler) Logout(w http.ResponseWriter, r *http.Request) {
@@ -141,9 +283,8 @@ func (s *SessionHandler) Logout(w http.ResponseWriter, r *http.Request) {
 
 func (s *SessionHandler) ValidateRefreshToken(refreshToken string) (*Claims, error) {
 	claims := &Claims{}
-	token, err := jwt.ParseWithClaims(refreshToken, claims, func(token *jwt.Token) (interface{}, error) {
-		return refreshTokenKey, nil
-	})
+	token, err := jwt.ParseWithClaims(refreshToken, claims, s.keys.Keyfunc(models.KeyPurposeRefresh),
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
 
 	if err != nil || !token.Valid {
 		return nil, err
@@ -160,8 +301,8 @@ func (s *SessionHandler) ValidateSession(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		c, err := r.Cookie("token")
 		if err != nil {
-			if s.refreshJWTToken(w, r) {
-				next.ServeHTTP(w, r)
+			if refreshClaims, ok := s.refreshJWTToken(w, r); ok {
+				s.serveActive(w, r.WithContext(withSession(r.Context(), refreshClaims)), next)
 			} else {
 				http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			}
@@ -169,13 +310,12 @@ func (s *SessionHandler) ValidateSession(next http.Handler) http.Handler {
 		}
 
 		claims := &Claims{}
-		tkn, err := jwt.ParseWithClaims(c.Value, claims, func(token *jwt.Token) (interface{}, error) {
-			return jwtKey, nil
-		})
+		tkn, err := jwt.ParseWithClaims(c.Value, claims, s.keys.Keyfunc(models.KeyPurposeAccess),
+			jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
 
 		if err != nil || !tkn.Valid {
-			if s.refreshJWTToken(w, r) {
-				next.ServeHTTP(w, r)
+			if refreshClaims, ok := s.refreshJWTToken(w, r); ok {
+				s.serveActive(w, r.WithContext(withSession(r.Context(), refreshClaims)), next)
 			} else {
 				http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			}
@@ -183,32 +323,135 @@ func (s *SessionHandler) ValidateSession(next http.Handler) http.Handler {
 		}
 
 		ctx := context.WithValue(r.Context(), userID, claims.UserID)
-		next.ServeHTTP(w, r.WithContext(ctx))
+		if claims.OrganizationID != nil {
+			ctx = context.WithValue(ctx, organizationID, *claims.OrganizationID)
+		}
+		if rc, err := r.Cookie("refresh_token"); err == nil {
+			if refreshClaims, err := s.ValidateRefreshToken(rc.Value); err == nil {
+				ctx = context.WithValue(ctx, refreshJTI, refreshClaims.JTI)
+			}
+		}
+		s.serveActive(w, r.WithContext(ctx), next)
 	})
 }
 
-func (s *SessionHandler) refreshJWTToken(w http.ResponseWriter, r *http.Request) bool {
+// serveActive only lets the request through if the session's user still
+// exists and is active, so suspended or deleted users lose access right away
+// instead of when their access token expires.
+func (s *SessionHandler) serveActive(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	id, _ := sessionUserID(r)
+	user, err := s.userRepository.GetUserByID(context.Background(), id)
+	if err != nil || !user.IsActive() {
+		http.Error(w, "Account is not active", http.StatusForbidden)
+		return
+	}
+	next.ServeHTTP(w, r)
+}
+
+// RequireAdmin must be chained after ValidateSession.
+func (s *SessionHandler) RequireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, ok := sessionUserID(r)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		user, err := s.userRepository.GetUserByID(context.Background(), id)
+		if err != nil || !user.IsAdmin {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireSelf must be chained after ValidateSession. It only lets a user
+// act on their own account, named by the userID path parameter; admins go
+// through the /admin routes instead.
+func (s *SessionHandler) RequireSelf(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, ok := sessionUserID(r)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if target, err := uuid.Parse(chi.URLParam(r, "userID")); err != nil || target != id {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// withSession stores the user, organization and refresh token JTI of the
+// current session in the request context.
+func withSession(ctx context.Context, claims *Claims) context.Context {
+	ctx = context.WithValue(ctx, userID, claims.UserID)
+	if claims.OrganizationID != nil {
+		ctx = context.WithValue(ctx, organizationID, *claims.OrganizationID)
+	}
+	return context.WithValue(ctx, refreshJTI, claims.JTI)
+}
+
+func sessionUserID(r *http.Request) (uuid.UUID, bool) {
+	id, ok := r.Context().Value(userID).(uuid.UUID)
+	return id, ok
+}
+
+// sessionActorID returns the session's user for audit events, or nil.
+func sessionActorID(r *http.Request) *uuid.UUID {
+	if id, ok := sessionUserID(r); ok {
+		return &id
+	}
+	return nil
+}
+
+// sessionOrganizationID returns the tenant of the session, if it has one.
+func sessionOrganizationID(r *http.Request) (uuid.UUID, bool) {
+	id, ok := r.Context().Value(organizationID).(uuid.UUID)
+	return id, ok
+}
+
+func sessionRefreshJTI(r *http.Request) string {
+	jti, _ := r.Context().Value(refreshJTI).(string)
+	return jti
+}
+
+func (s *SessionHandler) refreshJWTToken(w http.ResponseWriter, r *http.Request) (*Claims, bool) {
 	c, err := r.Cookie("refresh_token")
 	if err != nil {
 		http.Error(w, "Missing refresh token", http.StatusUnauthorized)
-		return false
+		return nil, false
 	}
 
 	claims, err := s.ValidateRefreshToken(c.Value)
 	if err != nil {
 		http.Error(w, "Invalid or expired refresh token", http.StatusUnauthorized)
-		return false
+		return nil, false
 	}
 
 	storedToken, err := s.tokenRepository.GetValidRefreshToken(context.Background(), claims.JTI)
 	if err != nil {
 		http.Error(w, "Refresh token revoked or not found", http.StatusUnauthorized)
-		return false
+		return nil, false
 	}
 
 	if storedToken.Revoked {
 		http.Error(w, "Refresh token revoked", http.StatusUnauthorized)
-		return false
+		return nil, false
+	}
+
+	// The metadata is read again so changes reach the next access token.
+	var metadata map[string]interface{}
+	if len(s.config.MetadataClaims) > 0 {
+		user, err := s.userRepository.GetUserByID(context.Background(), claims.UserID)
+		if err != nil {
+			http.Error(w, "Failed to load user", http.StatusInternalServerError)
+			return nil, false
+		}
+		metadata = s.metadataClaims(user)
 	}
 
 	expirationTime := time.Now().Add(1 * time.Minute)
@@ -218,36 +461,39 @@ func (s *SessionHandler) refreshJWTToken(w http.ResponseWriter, r *http.Request)
 	newRefreshJTI := uuid.New().String()
 
 	newClaims := &Claims{
-		UserID: claims.UserID,
-		JTI:    newJTI,
+		UserID:         claims.UserID,
+		JTI:            newJTI,
+		OrganizationID: claims.OrganizationID,
+		Metadata:       metadata,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 		},
 	}
 
 	newRefreshClaims := &Claims{
-		UserID: claims.UserID,
-		JTI:    newRefreshJTI,
+		UserID:         claims.UserID,
+		JTI:            newRefreshJTI,
+		OrganizationID: claims.OrganizationID,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(refreshExpirationTime),
 		},
 	}
 
-	_, err = jwt.NewWithClaims(jwt.SigningMethodHS256, newClaims).SignedString(jwtKey)
+	newToken, err := s.keys.Sign(models.KeyPurposeAccess, newClaims)
 	if err != nil {
 		http.Error(w, "Failed to generate new access token", http.StatusInternalServerError)
-		return false
+		return nil, false
 	}
 
-	newRefreshToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, newRefreshClaims).SignedString(refreshTokenKey)
+	newRefreshToken, err := s.keys.Sign(models.KeyPurposeRefresh, newRefreshClaims)
 	if err != nil {
 		http.Error(w, "Failed to generate new refresh token", http.StatusInternalServerError)
-		return false
+		return nil, false
 	}
 
 	if err := s.tokenRepository.RevokeRefreshToken(context.Background(), claims.JTI); err != nil {
 		http.Error(w, "Failed to revoke old refresh token", http.StatusInternalServerError)
-		return false
+		return nil, false
 	}
 
 	newRefreshTokenModel := &models.RefreshToken{
@@ -260,7 +506,7 @@ func (s *SessionHandler) refreshJWTToken(w http.ResponseWriter, r *http.Request)
 
 	if err := s.tokenRepository.SaveRefreshToken(context.Background(), newRefreshTokenModel); err != nil {
 		http.Error(w, "Failed to save new refresh token", http.StatusInternalServerError)
-		return false
+		return nil, false
 	}
 
 	http.SetCookie(w, &http.Cookie{
@@ -271,6 +517,14 @@ func (s *SessionHandler) refreshJWTToken(w http.ResponseWriter, r *http.Request)
 		SameSite: http.SameSiteStrictMode,
 	})
 
+	http.SetCookie(w, &http.Cookie{
+		Name:     "token",
+		Value:    newToken,
+		Expires:  expirationTime,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+
 	// Sets the new refresh token
 	http.SetCookie(w, &http.Cookie{
 		Name:     "refresh_token",
@@ -280,5 +534,30 @@ func (s *SessionHandler) refreshJWTToken(w http.ResponseWriter, r *http.Request)
 		SameSite: http.SameSiteStrictMode,
 	})
 
-	return true
+	return newRefreshClaims, true
+}
+
+// Refresh rotates the refresh token and issues a new access token without
+// going through a protected route first.
+func (s *SessionHandler) Refresh(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.refreshJWTToken(w, r); !ok {
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, map[string]string{"message": "Session refreshed"})
+}
+
+// RefreshTokenUser is a rate limit key function that identifies the user
+// from the refresh token cookie.
+func (s *SessionHandler) RefreshTokenUser(r *http.Request) (string, bool) {
+	c, err := r.Cookie("refresh_token")
+	if err != nil {
+		return "", false
+	}
+
+	claims, err := s.ValidateRefreshToken(c.Value)
+	if err != nil {
+		return "", false
+	}
+	return claims.UserID.String(), true
 }
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/OsagieDG/jwt-based-auth-system/internal/mailer"
+	"github.com/OsagieDG/jwt-based-auth-system/internal/models"
+	"github.com/OsagieDG/jwt-based-auth-system/internal/query"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+const unlockTokenTTL = 24 * time.Hour
+
+type UnlockParams struct {
+	Token string `json:"token"`
+}
+
+type LockoutHandler struct {
+	policy            models.LockoutPolicy
+	lockoutRepository query.LockoutRepository
+	mailer            mailer.Mailer
+	baseURL           string
+}
+
+func NewLockoutHandler(policy models.LockoutPolicy, lockoutRepository query.LockoutRepository, m mailer.Mailer, baseURL string) *LockoutHandler {
+	return &LockoutHandler{
+		policy:            policy,
+		lockoutRepository: lockoutRepository,
+		mailer:            m,
+		baseURL:           baseURL,
+	}
+}
+
+// IsLocked reports whether the user is currently locked out. Lookup errors
+// are treated as locked so a database hiccup never opens the door.
+func (h *LockoutHandler) IsLocked(ctx context.Context, user *models.User) bool {
+	attempts, err := h.lockoutRepository.GetLoginAttempts(ctx, user.ID)
+	if err != nil {
+		log.Printf("failed to load login attempts for %s: %v", user.ID, err)
+		return true
+	}
+	return attempts.IsLocked(time.Now())
+}
+
+// RegisterFailure counts a failed login and emails an unlock link when the
+// failure locks the account.
+func (h *LockoutHandler) RegisterFailure(ctx context.Context, user *models.User) {
+	_, locked, err := h.lockoutRepository.RecordFailedLogin(ctx, user.ID, h.policy)
+	if err != nil {
+		log.Printf("failed to record failed login for %s: %v", user.ID, err)
+		return
+	}
+	if !locked {
+		return
+	}
+
+	if err := h.sendUnlock(ctx, user); err != nil {
+		log.Printf("failed to send unlock email to %s: %v", user.Email, err)
+	}
+}
+
+func (h *LockoutHandler) RegisterSuccess(ctx context.Context, user *models.User) {
+	if err := h.lockoutRepository.ResetFailedLogins(ctx, user.ID); err != nil {
+		log.Printf("failed to reset failed logins for %s: %v", user.ID, err)
+	}
+}
+
+func (h *LockoutHandler) HandleUnlock(w http.ResponseWriter, r *http.Request) {
+	var params UnlockParams
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil || params.Token == "" {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	_, err := h.lockoutRepository.ConsumeUnlockToken(context.Background(), models.HashOpaqueToken(params.Token))
+	if err != nil {
+		if errors.Is(err, query.ErrInvalidToken) {
+			writeJSONResponse(w, http.StatusBadRequest, map[string]string{
+				"error": err.Error(),
+			})
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, map[string]string{"message": "Account unlocked"})
+}
+
+func (h *LockoutHandler) HandleAdminUnlock(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(chi.URLParam(r, "userID"))
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	var actorID *uuid.UUID
+	if id, ok := sessionUserID(r); ok {
+		actorID = &id
+	}
+
+	if err := h.lockoutRepository.Unlock(context.Background(), userID, actorID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, map[string]string{"message": "Account unlocked"})
+}
+
+func (h *LockoutHandler) sendUnlock(ctx context.Context, user *models.User) error {
+	token, hash, err := models.NewOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	unlockToken := &models.UnlockToken{
+		ID:        models.NewUUID(),
+		UserID:    user.ID,
+		TokenHash: hash,
+		ExpiresAt: time.Now().Add(unlockTokenTTL),
+	}
+	if err := h.lockoutRepository.SaveUnlockToken(ctx, unlockToken); err != nil {
+		return err
+	}
+
+	return h.mailer.Send(ctx, &mailer.Message{
+		To:      user.Email,
+		Subject: "Your account has been locked",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nYour account was temporarily locked after several failed login attempts.\nIf this was you, you can unlock it right away by opening the link below:\n\n%s/unlock?token=%s\n\nIf it was not you, consider resetting your password.\n",
+			user.UserName, h.baseURL, token,
+		),
+	})
+}
@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/OsagieDG/jwt-based-auth-system/internal/mailer"
+	"github.com/OsagieDG/jwt-based-auth-system/internal/models"
+	"github.com/OsagieDG/jwt-based-auth-system/internal/query"
+)
+
+const magicLinkBrowserCookie = "magic_link_browser"
+
+type MagicLinkConfig struct {
+	TTL time.Duration
+	// BindBrowser only accepts a link in the browser that requested it, so
+	// a forwarded or intercepted email cannot be used to log in.
+	BindBrowser bool
+}
+
+type MagicLinkHandler struct {
+	config              *MagicLinkConfig
+	userRepository      query.UserRespository
+	magicLinkRepository query.MagicLinkRepository
+	session             *SessionHandler
+	mailer              mailer.Mailer
+	baseURL             string
+}
+
+func NewMagicLinkHandler(config *MagicLinkConfig, userRepository query.UserRespository, magicLinkRepository query.MagicLinkRepository, session *SessionHandler, m mailer.Mailer, baseURL string) *MagicLinkHandler {
+	return &MagicLinkHandler{
+		config:              config,
+		userRepository:      userRepository,
+		magicLinkRepository: magicLinkRepository,
+		session:             session,
+		mailer:              m,
+		baseURL:             baseURL,
+	}
+}
+
+// HandleRequestMagicLink answers with the same message whether or not the
+// email belongs to an account, so it cannot be used to enumerate users. The
+// account is looked up and mailed after answering, so the response time does
+// not give it away either.
+func (h *MagicLinkHandler) HandleRequestMagicLink(w http.ResponseWriter, r *http.Request) {
+	var params models.MagicLinkParams
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	var browserHash *string
+	if h.config.BindBrowser {
+		browser, hash, err := models.NewOpaqueToken()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		browserHash = &hash
+
+		// Lax, because the callback is opened from a link in an email
+		// client, which is a cross-site navigation.
+		http.SetCookie(w, &http.Cookie{
+			Name:     magicLinkBrowserCookie,
+			Value:    browser,
+			Expires:  time.Now().Add(h.config.TTL),
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+
+	go h.sendMagicLinkTo(params.Email, browserHash)
+
+	writeJSONResponse(w, http.StatusAccepted, map[string]string{
+		"message": "If an account with that email exists, a login link has been sent",
+	})
+}
+
+func (h *MagicLinkHandler) sendMagicLinkTo(email string, browserHash *string) {
+	user, err := h.userRepository.GetUserByEmail(context.Background(), email)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("failed to look up %s for a magic link: %v", email, err)
+		}
+		return
+	}
+	if !user.IsActive() {
+		return
+	}
+
+	if err := h.sendMagicLink(context.Background(), user, browserHash); err != nil {
+		log.Printf("failed to send magic link to %s: %v", user.Email, err)
+	}
+}
+
+// HandleMagicLinkCallback consumes the token from the link and logs the user
+// in exactly like a correct password would.
+func (h *MagicLinkHandler) HandleMagicLinkCallback(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		writeJSONResponse(w, http.StatusBadRequest, map[string]string{"error": query.ErrInvalidToken.Error()})
+		return
+	}
+
+	var browserHash string
+	if c, err := r.Cookie(magicLinkBrowserCookie); err == nil {
+		browserHash = models.HashOpaqueToken(c.Value)
+	}
+
+	userID, err := h.magicLinkRepository.ConsumeMagicLinkToken(context.Background(), models.HashOpaqueToken(token), browserHash)
+	if err != nil {
+		if errors.Is(err, query.ErrInvalidToken) {
+			writeJSONResponse(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	user, err := h.userRepository.GetUserByID(context.Background(), userID)
+	if err != nil {
+		writeJSONResponse(w, http.StatusBadRequest, map[string]string{"error": query.ErrInvalidToken.Error()})
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:    magicLinkBrowserCookie,
+		Value:   "",
+		Expires: time.Now().Add(-time.Hour),
+	})
+
+	h.session.completeFirstFactor(w, user)
+}
+
+func (h *MagicLinkHandler) sendMagicLink(ctx context.Context, user *models.User, browserHash *string) error {
+	token, hash, err := models.NewOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	magicLink := &models.MagicLinkToken{
+		ID:          models.NewUUID(),
+		UserID:      user.ID,
+		TokenHash:   hash,
+		BrowserHash: browserHash,
+		ExpiresAt:   time.Now().Add(h.config.TTL),
+	}
+	if err := h.magicLinkRepository.SaveMagicLinkToken(ctx, magicLink); err != nil {
+		return err
+	}
+
+	return h.mailer.Send(ctx, &mailer.Message{
+		To:      user.Email,
+		Subject: "Your login link",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nOpen the link below to log in:\n\n%s/login/magic-link/callback?token=%s\n\nThe link expires in %s and can only be used once. If you did not request this, you can ignore this email.\n",
+			user.UserName, h.baseURL, token, h.config.TTL,
+		),
+	})
+}
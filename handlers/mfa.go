@@ -0,0 +1,379 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/OsagieDG/jwt-based-auth-system/internal/models"
+	"github.com/OsagieDG/jwt-based-auth-system/internal/query"
+	"github.com/OsagieDG/jwt-based-auth-system/internal/secretbox"
+	"github.com/OsagieDG/jwt-based-auth-system/internal/totp"
+	"github.com/google/uuid"
+)
+
+// totpSkew accepts codes from one time step before and after the current one
+// to make up for clock drift between the server and the authenticator.
+const totpSkew = 1
+
+var (
+	errInvalidMFACode    = errors.New("invalid two-factor code")
+	errIncorrectPassword = errors.New("current password is incorrect")
+)
+
+// Second factors a user can be challenged with after their password.
+const (
+	mfaMethodTOTP     = "totp"
+	mfaMethodWebAuthn = "webauthn"
+)
+
+type MFAHandler struct {
+	userRepository     query.UserRespository
+	mfaRepository      query.MFARepository
+	webauthnRepository query.WebAuthnRepository
+	box                *secretbox.Box
+	issuer             string
+}
+
+func NewMFAHandler(userRepository query.UserRespository, mfaRepository query.MFARepository, webauthnRepository query.WebAuthnRepository, box *secretbox.Box, issuer string) *MFAHandler {
+	return &MFAHandler{
+		userRepository:     userRepository,
+		mfaRepository:      mfaRepository,
+		webauthnRepository: webauthnRepository,
+		box:                box,
+		issuer:             issuer,
+	}
+}
+
+// Methods lists the confirmed second factors of the user. An empty list
+// means the user logs in with their password alone.
+func (h *MFAHandler) Methods(ctx context.Context, id uuid.UUID) ([]string, error) {
+	var methods []string
+
+	enrollment, err := h.mfaRepository.GetTOTPEnrollment(ctx, id)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	if err == nil && enrollment.IsConfirmed() {
+		methods = append(methods, mfaMethodTOTP)
+	}
+
+	credentials, err := h.webauthnRepository.GetCredentialsByUserID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(credentials) > 0 {
+		methods = append(methods, mfaMethodWebAuthn)
+	}
+
+	return methods, nil
+}
+
+func (h *MFAHandler) Status(ctx context.Context, id uuid.UUID) (*models.MFAStatus, error) {
+	methods, err := h.Methods(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	remaining, err := h.mfaRepository.CountRecoveryCodes(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if methods == nil {
+		methods = []string{}
+	}
+	return &models.MFAStatus{Methods: methods, RecoveryCodesRemaining: remaining}, nil
+}
+
+// VerifyCode checks a TOTP code of a confirmed enrollment and burns it so it
+// cannot be used a second time.
+func (h *MFAHandler) VerifyCode(ctx context.Context, id uuid.UUID, code string) error {
+	enrollment, err := h.mfaRepository.GetTOTPEnrollment(ctx, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return errInvalidMFACode
+		}
+		return err
+	}
+	if !enrollment.IsConfirmed() {
+		return errInvalidMFACode
+	}
+
+	counter, err := h.validate(enrollment, code)
+	if err != nil {
+		return err
+	}
+
+	if err := h.mfaRepository.UseTOTPCounter(ctx, id, counter); err != nil {
+		if errors.Is(err, query.ErrCodeAlreadyUsed) {
+			return errInvalidMFACode
+		}
+		return err
+	}
+	return nil
+}
+
+// HandleEnrollTOTP generates a new secret for the logged in user and returns
+// it together with the provisioning URI to render as a QR code. The
+// enrollment only takes effect once a code has been confirmed.
+func (h *MFAHandler) HandleEnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	id, ok := sessionUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := h.userRepository.GetUserByID(context.Background(), id)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	sealed, err := h.box.Seal(secret)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = h.mfaRepository.SaveTOTPEnrollment(context.Background(), &models.TOTPEnrollment{
+		UserID: user.ID,
+		Secret: sealed,
+	})
+	if err != nil {
+		if errors.Is(err, query.ErrMFAAlreadyEnabled) {
+			writeJSONResponse(w, http.StatusConflict, map[string]string{"error": err.Error()})
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, map[string]string{
+		"secret":           secret,
+		"provisioning_uri": totp.ProvisioningURI(h.issuer, user.Email, secret),
+	})
+}
+
+func (h *MFAHandler) HandleConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	id, ok := sessionUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var params models.TOTPCodeParams
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	enrollment, err := h.mfaRepository.GetTOTPEnrollment(context.Background(), id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeJSONResponse(w, http.StatusNotFound, map[string]string{"error": "no pending enrollment"})
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if enrollment.IsConfirmed() {
+		writeJSONResponse(w, http.StatusConflict, map[string]string{"error": query.ErrMFAAlreadyEnabled.Error()})
+		return
+	}
+
+	counter, err := h.validate(enrollment, params.Code)
+	if err != nil {
+		h.writeCodeError(w, err)
+		return
+	}
+
+	if err := h.mfaRepository.ConfirmTOTPEnrollment(context.Background(), id, counter); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{"message": "Two-factor authentication enabled"}
+
+	// Hand out recovery codes with the first factor so the user can write
+	// them down before they ever need them.
+	remaining, err := h.mfaRepository.CountRecoveryCodes(context.Background(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if remaining == 0 {
+		codes, err := h.generateRecoveryCodes(context.Background(), id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		response["recovery_codes"] = codes
+	}
+
+	writeJSONResponse(w, http.StatusOK, response)
+}
+
+// HandleDisableTOTP requires both the password and a current code so a
+// hijacked session alone cannot strip the second factor. A recovery code
+// stands in for the code when the authenticator is lost, after which the
+// user can enroll a new one.
+func (h *MFAHandler) HandleDisableTOTP(w http.ResponseWriter, r *http.Request) {
+	id, ok := sessionUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var params models.DisableTOTPParams
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.userRepository.GetUserByID(context.Background(), id)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !models.IsValidPassword(user.EncryptedPassword, params.Password) {
+		http.Error(w, "Current password is incorrect", http.StatusForbidden)
+		return
+	}
+
+	if params.RecoveryCode != "" {
+		err = h.VerifyRecoveryCode(context.Background(), id, params.RecoveryCode)
+	} else {
+		err = h.VerifyCode(context.Background(), id, params.Code)
+	}
+	if err != nil {
+		h.writeCodeError(w, err)
+		return
+	}
+
+	if err := h.mfaRepository.DeleteTOTPEnrollment(context.Background(), id); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, map[string]string{"message": "Two-factor authentication disabled"})
+}
+
+// ConfirmIdentity checks the current password and, when the user has a
+// second factor, a TOTP code or one of their recovery codes, so a hijacked
+// session alone cannot add or strip a second factor. It returns
+// errIncorrectPassword or errInvalidMFACode when the check fails.
+func (h *MFAHandler) ConfirmIdentity(ctx context.Context, user *models.User, params models.ConfirmIdentityParams) error {
+	if !models.IsValidPassword(user.EncryptedPassword, params.Password) {
+		return errIncorrectPassword
+	}
+
+	methods, err := h.Methods(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	if len(methods) == 0 {
+		return nil
+	}
+
+	if params.RecoveryCode != "" {
+		return h.VerifyRecoveryCode(ctx, user.ID, params.RecoveryCode)
+	}
+	return h.VerifyCode(ctx, user.ID, params.Code)
+}
+
+// VerifyRecoveryCode burns one of the user's recovery codes.
+func (h *MFAHandler) VerifyRecoveryCode(ctx context.Context, id uuid.UUID, code string) error {
+	if err := h.mfaRepository.UseRecoveryCode(ctx, id, models.HashRecoveryCode(code)); err != nil {
+		if errors.Is(err, query.ErrInvalidToken) {
+			return errInvalidMFACode
+		}
+		return err
+	}
+	return nil
+}
+
+// HandleRegenerateRecoveryCodes replaces all recovery codes of the logged in
+// user with a new set. The codes are only shown in this response.
+func (h *MFAHandler) HandleRegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	id, ok := sessionUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var params models.RegenerateRecoveryCodesParams
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.userRepository.GetUserByID(context.Background(), id)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !models.IsValidPassword(user.EncryptedPassword, params.Password) {
+		http.Error(w, "Current password is incorrect", http.StatusForbidden)
+		return
+	}
+
+	methods, err := h.Methods(context.Background(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(methods) == 0 {
+		writeJSONResponse(w, http.StatusConflict, map[string]string{"error": "two-factor authentication is not enabled"})
+		return
+	}
+
+	codes, err := h.generateRecoveryCodes(context.Background(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, map[string]interface{}{"recovery_codes": codes})
+}
+
+func (h *MFAHandler) generateRecoveryCodes(ctx context.Context, id uuid.UUID) ([]string, error) {
+	codes, hashes, err := models.NewRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := h.mfaRepository.ReplaceRecoveryCodes(ctx, id, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func (h *MFAHandler) validate(enrollment *models.TOTPEnrollment, code string) (int64, error) {
+	secret, err := h.box.Open(enrollment.Secret)
+	if err != nil {
+		return 0, err
+	}
+
+	counter, ok := totp.Validate(secret, code, time.Now(), totpSkew)
+	if !ok {
+		return 0, errInvalidMFACode
+	}
+	return counter, nil
+}
+
+func (h *MFAHandler) writeCodeError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errInvalidMFACode) {
+		writeJSONResponse(w, http.StatusUnauthorized, map[string]string{"error": err.Error()})
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/OsagieDG/jwt-based-auth-system/internal/models"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const mfaChallengeTTL = 5 * time.Minute
+
+// startMFAChallenge answers a correct password for a user with a second
+// factor by handing out a short-lived challenge token instead of cookies.
+// The token can be redeemed with any of the listed methods.
+func (s *SessionHandler) startMFAChallenge(w http.ResponseWriter, user *models.User, methods []string) {
+	claims := &Claims{
+		UserID: user.ID,
+		JTI:    uuid.New().String(),
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(mfaChallengeTTL)),
+		},
+	}
+
+	// The challenge token is signed with its own keys so it can never be
+	// mistaken for an access or refresh token.
+	token, err := s.keys.Sign(models.KeyPurposeMFAChallenge, claims)
+	if err != nil {
+		http.Error(w, "Failed to generate MFA challenge", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"message":      "Two-factor authentication required",
+		"mfa_required": true,
+		"mfa_token":    token,
+		"mfa_methods":  methods,
+	})
+}
+
+func (s *SessionHandler) validateMFAChallenge(token string) (*Claims, error) {
+	claims := &Claims{}
+	tkn, err := jwt.ParseWithClaims(token, claims, s.keys.Keyfunc(models.KeyPurposeMFAChallenge), jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil || !tkn.Valid {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// LoginMFA completes a login that was answered with an MFA challenge, either
+// with a TOTP code or with a recovery code for users who lost their device.
+func (s *SessionHandler) LoginMFA(w http.ResponseWriter, r *http.Request) {
+	var params models.MFALoginParams
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	claims, err := s.validateMFAChallenge(params.MFAToken)
+	if err != nil {
+		http.Error(w, "Invalid or expired MFA challenge", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := s.userRepository.GetUserByID(context.Background(), claims.UserID)
+	if err != nil {
+		http.Error(w, "Invalid or expired MFA challenge", http.StatusUnauthorized)
+		return
+	}
+	if !s.canLogin(w, user) {
+		return
+	}
+
+	if params.RecoveryCode != "" {
+		err = s.mfa.VerifyRecoveryCode(context.Background(), user.ID, params.RecoveryCode)
+	} else {
+		err = s.mfa.VerifyCode(context.Background(), user.ID, params.Code)
+	}
+	if err != nil {
+		s.mfa.writeCodeError(w, err)
+		return
+	}
+
+	if err := s.issueSession(w, user); err != nil {
+		http.Error(w, "Failed to save refresh token", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, map[string]string{"message": "Login successful"})
+}
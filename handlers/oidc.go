@@ -0,0 +1,243 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/OsagieDG/jwt-based-auth-system/internal/models"
+	"github.com/OsagieDG/jwt-based-auth-system/internal/oidc"
+	"github.com/OsagieDG/jwt-based-auth-system/internal/query"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	oidcStateCookie = "oidc_state"
+	oidcStateTTL    = 10 * time.Minute
+)
+
+type OIDCHandler struct {
+	providers          map[string]*oidc.Provider
+	userRepository     query.UserRespository
+	identityRepository query.IdentityRepository
+	session            *SessionHandler
+}
+
+func NewOIDCHandler(providers []*oidc.Provider, userRepository query.UserRespository, identityRepository query.IdentityRepository, session *SessionHandler) *OIDCHandler {
+	byName := make(map[string]*oidc.Provider, len(providers))
+	for _, provider := range providers {
+		byName[provider.Name()] = provider
+	}
+
+	return &OIDCHandler{
+		providers:          byName,
+		userRepository:     userRepository,
+		identityRepository: identityRepository,
+		session:            session,
+	}
+}
+
+func (h *OIDCHandler) HandleListProviders(w http.ResponseWriter, r *http.Request) {
+	names := make([]string, 0, len(h.providers))
+	for name := range h.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	writeJSONResponse(w, http.StatusOK, map[string]interface{}{"data": names})
+}
+
+// HandleBeginLogin redirects the browser to the provider. The state is also
+// put in a cookie so the callback only succeeds in the browser that started
+// the login.
+func (h *OIDCHandler) HandleBeginLogin(w http.ResponseWriter, r *http.Request) {
+	provider, ok := h.providers[chi.URLParam(r, "provider")]
+	if !ok {
+		writeJSONResponse(w, http.StatusNotFound, map[string]string{"error": "not found"})
+		return
+	}
+
+	state, stateHash, err := models.NewOpaqueToken()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	nonce, _, err := models.NewOpaqueToken()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	codeVerifier, _, err := models.NewOpaqueToken()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	authURL, err := provider.AuthCodeURL(context.Background(), state, nonce, codeVerifier)
+	if err != nil {
+		log.Printf("failed to start login with %s: %v", provider.Name(), err)
+		http.Error(w, "Identity provider is unavailable", http.StatusBadGateway)
+		return
+	}
+
+	err = h.identityRepository.SaveLoginState(context.Background(), &models.OIDCLoginState{
+		StateHash:    stateHash,
+		Provider:     provider.Name(),
+		Nonce:        nonce,
+		CodeVerifier: codeVerifier,
+		ExpiresAt:    time.Now().Add(oidcStateTTL),
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Lax, because the provider redirects back with a cross-site navigation.
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Expires:  time.Now().Add(oidcStateTTL),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// HandleCallback finishes the authorization code flow, finds or provisions
+// the local user and logs them in like a correct password would.
+func (h *OIDCHandler) HandleCallback(w http.ResponseWriter, r *http.Request) {
+	provider, ok := h.providers[chi.URLParam(r, "provider")]
+	if !ok {
+		writeJSONResponse(w, http.StatusNotFound, map[string]string{"error": "not found"})
+		return
+	}
+
+	if providerError := r.URL.Query().Get("error"); providerError != "" {
+		http.Error(w, "Login was not completed: "+providerError, http.StatusUnauthorized)
+		return
+	}
+
+	state := r.URL.Query().Get("state")
+	c, err := r.Cookie(oidcStateCookie)
+	if err != nil || state == "" || c.Value != state {
+		writeJSONResponse(w, http.StatusBadRequest, map[string]string{"error": query.ErrInvalidToken.Error()})
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:    oidcStateCookie,
+		Value:   "",
+		Expires: time.Now().Add(-time.Hour),
+	})
+
+	loginState, err := h.identityRepository.ConsumeLoginState(context.Background(), models.HashOpaqueToken(state), provider.Name())
+	if err != nil {
+		if errors.Is(err, query.ErrInvalidToken) {
+			writeJSONResponse(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	identity, err := provider.Exchange(context.Background(), r.URL.Query().Get("code"), loginState.CodeVerifier, loginState.Nonce)
+	if err != nil {
+		log.Printf("failed to complete login with %s: %v", provider.Name(), err)
+		http.Error(w, "Login with identity provider failed", http.StatusUnauthorized)
+		return
+	}
+
+	user, ok := h.resolveUser(w, provider, identity)
+	if !ok {
+		return
+	}
+
+	h.session.completeFirstFactor(w, user)
+}
+
+// resolveUser returns the user linked to the identity. An unknown identity is
+// linked to the account with the same email if the provider verified that
+// email, or gets a new account if the provider allows provisioning. When it
+// returns false the response has already been written.
+func (h *OIDCHandler) resolveUser(w http.ResponseWriter, provider *oidc.Provider, identity *oidc.Identity) (*models.User, bool) {
+	ctx := context.Background()
+
+	userID, err := h.identityRepository.RecordIdentityLogin(ctx, provider.Name(), identity.Subject)
+	if err == nil {
+		user, err := h.userRepository.GetUserByID(ctx, userID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return nil, false
+		}
+		return user, true
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+
+	email := models.NormalizeEmail(identity.Email)
+	if !models.IsEmailValid(email) {
+		http.Error(w, "Identity provider did not share a usable email address", http.StatusForbidden)
+		return nil, false
+	}
+
+	link := &models.UserIdentity{
+		ID:       models.NewUUID(),
+		Provider: provider.Name(),
+		Subject:  identity.Subject,
+		Email:    email,
+	}
+
+	user, err := h.userRepository.GetUserByEmail(ctx, email)
+	if err == nil {
+		// Without a verified email anyone could claim an existing account
+		// by registering its address at the provider.
+		if !identity.EmailVerified {
+			writeJSONResponse(w, http.StatusConflict, map[string]string{
+				"error": "an account with this email already exists",
+			})
+			return nil, false
+		}
+
+		link.UserID = user.ID
+		if err := h.identityRepository.LinkIdentity(ctx, link); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return nil, false
+		}
+		return user, true
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+
+	if !provider.AutoProvision() {
+		http.Error(w, "No account is linked to this identity", http.StatusForbidden)
+		return nil, false
+	}
+
+	userName := identity.PreferredUsername
+	if userName == "" {
+		userName, _, _ = strings.Cut(email, "@")
+	}
+
+	user, err = models.NewProvisionedUser(email, userName, identity.EmailVerified)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+
+	link.UserID = user.ID
+	if err := h.identityRepository.CreateUserWithIdentity(ctx, user, link); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	return user, true
+}
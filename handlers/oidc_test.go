@@ -0,0 +1,285 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/OsagieDG/jwt-based-auth-system/internal/models"
+	"github.com/OsagieDG/jwt-based-auth-system/internal/oidc"
+	"github.com/OsagieDG/jwt-based-auth-system/internal/query"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// fakeOIDCUsers keeps users in memory. Methods the OIDC handler should not
+// call panic through the nil embedded interface.
+type fakeOIDCUsers struct {
+	query.UserRespository
+	users map[uuid.UUID]*models.User
+}
+
+func (f *fakeOIDCUsers) GetUserByID(ctx context.Context, userID uuid.UUID) (*models.User, error) {
+	if user, ok := f.users[userID]; ok {
+		return user, nil
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (f *fakeOIDCUsers) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	for _, user := range f.users {
+		if user.Email == email {
+			return user, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+type fakeOIDCIdentities struct {
+	query.IdentityRepository
+	users      *fakeOIDCUsers
+	states     map[string]*models.OIDCLoginState
+	identities map[string]uuid.UUID
+}
+
+func (f *fakeOIDCIdentities) SaveLoginState(ctx context.Context, state *models.OIDCLoginState) error {
+	f.states[state.StateHash] = state
+	return nil
+}
+
+func (f *fakeOIDCIdentities) ConsumeLoginState(ctx context.Context, stateHash, provider string) (*models.OIDCLoginState, error) {
+	state, ok := f.states[stateHash]
+	if !ok || state.Provider != provider {
+		return nil, query.ErrInvalidToken
+	}
+	delete(f.states, stateHash)
+	return state, nil
+}
+
+func (f *fakeOIDCIdentities) RecordIdentityLogin(ctx context.Context, provider, subject string) (uuid.UUID, error) {
+	if userID, ok := f.identities[provider+" "+subject]; ok {
+		return userID, nil
+	}
+	return uuid.Nil, sql.ErrNoRows
+}
+
+func (f *fakeOIDCIdentities) LinkIdentity(ctx context.Context, identity *models.UserIdentity) error {
+	f.identities[identity.Provider+" "+identity.Subject] = identity.UserID
+	return nil
+}
+
+func (f *fakeOIDCIdentities) CreateUserWithIdentity(ctx context.Context, user *models.User, identity *models.UserIdentity) error {
+	f.users.users[user.ID] = user
+	return f.LinkIdentity(ctx, identity)
+}
+
+type oidcFixture struct {
+	handler    *OIDCHandler
+	router     chi.Router
+	provider   *oidc.Provider
+	users      *fakeOIDCUsers
+	identities *fakeOIDCIdentities
+}
+
+// newOIDCFixture serves the discovery document of a provider, which is all
+// the handler needs before the browser comes back with a code.
+func newOIDCFixture(t *testing.T, autoProvision bool) *oidcFixture {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                 server.URL,
+			"authorization_endpoint": server.URL + "/authorize",
+			"token_endpoint":         server.URL + "/token",
+			"jwks_uri":               server.URL + "/keys",
+		})
+	}))
+	t.Cleanup(server.Close)
+
+	provider := oidc.NewProvider(oidc.ProviderConfig{
+		Name:          "stub",
+		Issuer:        server.URL,
+		ClientID:      "api",
+		RedirectURL:   "http://localhost/login/oidc/stub/callback",
+		Scopes:        []string{"openid", "email"},
+		AutoProvision: autoProvision,
+	})
+	users := &fakeOIDCUsers{users: map[uuid.UUID]*models.User{}}
+	identities := &fakeOIDCIdentities{
+		users:      users,
+		states:     map[string]*models.OIDCLoginState{},
+		identities: map[string]uuid.UUID{},
+	}
+	handler := NewOIDCHandler([]*oidc.Provider{provider}, users, identities, nil)
+
+	router := chi.NewRouter()
+	router.Get("/login/oidc/{provider}", handler.HandleBeginLogin)
+	router.Get("/login/oidc/{provider}/callback", handler.HandleCallback)
+
+	return &oidcFixture{handler: handler, router: router, provider: provider, users: users, identities: identities}
+}
+
+func TestOIDCBeginLoginBindsStateNonceAndVerifier(t *testing.T) {
+	f := newOIDCFixture(t, false)
+
+	w := httptest.NewRecorder()
+	f.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/login/oidc/stub", nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusFound)
+	}
+
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := location.Query()
+
+	var cookie *http.Cookie
+	for _, c := range w.Result().Cookies() {
+		if c.Name == oidcStateCookie {
+			cookie = c
+		}
+	}
+	if cookie == nil || cookie.Value == "" || cookie.Value != q.Get("state") {
+		t.Fatalf("state cookie %v does not match state %q of the redirect", cookie, q.Get("state"))
+	}
+
+	state, ok := f.identities.states[models.HashOpaqueToken(q.Get("state"))]
+	if !ok {
+		t.Fatal("no login state saved for the state of the redirect")
+	}
+	if state.Nonce == "" || q.Get("nonce") != state.Nonce {
+		t.Errorf("nonce = %q, want the saved %q", q.Get("nonce"), state.Nonce)
+	}
+	challenge := sha256.Sum256([]byte(state.CodeVerifier))
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") != base64.RawURLEncoding.EncodeToString(challenge[:]) {
+		t.Errorf("code challenge %q (%s) does not match the saved verifier", q.Get("code_challenge"), q.Get("code_challenge_method"))
+	}
+}
+
+func TestOIDCCallbackRejectsForeignState(t *testing.T) {
+	f := newOIDCFixture(t, false)
+	f.identities.states[models.HashOpaqueToken("state-1")] = &models.OIDCLoginState{Provider: "stub"}
+
+	tests := []struct {
+		name   string
+		target string
+		cookie string
+	}{
+		{"no cookie", "/login/oidc/stub/callback?state=state-1&code=code", ""},
+		{"other browser", "/login/oidc/stub/callback?state=state-1&code=code", "state-2"},
+		{"no state", "/login/oidc/stub/callback?code=code", "state-1"},
+		{"unknown state", "/login/oidc/stub/callback?state=state-3&code=code", "state-3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: oidcStateCookie, Value: tt.cookie})
+			}
+			w := httptest.NewRecorder()
+			f.router.ServeHTTP(w, r)
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("status = %d, want %d", w.Code, http.StatusBadRequest)
+			}
+		})
+	}
+
+	if _, ok := f.identities.states[models.HashOpaqueToken("state-1")]; !ok {
+		t.Error("login state was consumed by a callback from another browser")
+	}
+}
+
+func TestOIDCResolveUser(t *testing.T) {
+	existing := &models.User{ID: models.NewUUID(), Email: "alice@example.com", UserName: "alice"}
+	linked := &models.User{ID: models.NewUUID(), Email: "bob@example.com", UserName: "bob"}
+
+	tests := []struct {
+		name          string
+		autoProvision bool
+		identity      oidc.Identity
+		wantStatus    int
+		wantUser      *models.User
+		wantLinked    bool
+	}{
+		{
+			name:       "linked identity",
+			identity:   oidc.Identity{Subject: "subject-bob", Email: "someone-else@example.com"},
+			wantUser:   linked,
+			wantLinked: true,
+		},
+		{
+			name:       "verified email links the existing account",
+			identity:   oidc.Identity{Subject: "subject-alice", Email: "Alice@Example.com", EmailVerified: true},
+			wantUser:   existing,
+			wantLinked: true,
+		},
+		{
+			name:       "unverified email does not claim the existing account",
+			identity:   oidc.Identity{Subject: "subject-alice", Email: "alice@example.com"},
+			wantStatus: http.StatusConflict,
+		},
+		{
+			name:          "unverified email does not claim the account even with provisioning",
+			autoProvision: true,
+			identity:      oidc.Identity{Subject: "subject-alice", Email: "alice@example.com"},
+			wantStatus:    http.StatusConflict,
+		},
+		{
+			name:       "unknown email without provisioning",
+			identity:   oidc.Identity{Subject: "subject-carol", Email: "carol@example.com", EmailVerified: true},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "no usable email",
+			identity:   oidc.Identity{Subject: "subject-carol"},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:          "unknown email with provisioning",
+			autoProvision: true,
+			identity:      oidc.Identity{Subject: "subject-carol", Email: "carol@example.com", EmailVerified: true, PreferredUsername: "carol"},
+			wantLinked:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newOIDCFixture(t, tt.autoProvision)
+			for _, user := range []*models.User{existing, linked} {
+				copied := *user
+				f.users.users[user.ID] = &copied
+			}
+			f.identities.identities["stub subject-bob"] = linked.ID
+
+			w := httptest.NewRecorder()
+			user, ok := f.handler.resolveUser(w, f.provider, &tt.identity)
+
+			if tt.wantStatus != 0 {
+				if ok || w.Code != tt.wantStatus {
+					t.Fatalf("resolveUser = %v with status %d, want status %d", ok, w.Code, tt.wantStatus)
+				}
+				if _, linked := f.identities.identities["stub "+tt.identity.Subject]; linked {
+					t.Error("identity was linked although the login was refused")
+				}
+				return
+			}
+			if !ok {
+				t.Fatalf("resolveUser failed with status %d: %s", w.Code, w.Body.String())
+			}
+			if tt.wantUser != nil && user.ID != tt.wantUser.ID {
+				t.Errorf("user = %s, want %s", user.ID, tt.wantUser.ID)
+			}
+			if tt.wantUser == nil && (user.ID == existing.ID || user.ID == linked.ID || user.Email != tt.identity.Email || !user.EmailVerified) {
+				t.Errorf("provisioned user = %+v, want a new verified account for %s", user, tt.identity.Email)
+			}
+			if tt.wantLinked && f.identities.identities["stub "+tt.identity.Subject] != user.ID {
+				t.Errorf("identity %s is not linked to %s", tt.identity.Subject, user.ID)
+			}
+		})
+	}
+}
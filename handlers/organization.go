@@ -0,0 +1,466 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/OsagieDG/jwt-based-auth-system/internal/models"
+	"github.com/OsagieDG/jwt-based-auth-system/internal/query"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+const organizationRole ContextKey = "organizationRole"
+
+// OrganizationHandler serves the organization APIs. Global admins create
+// organizations and appoint their first owners; owners and admins of an
+// organization then manage its users through the /org routes, which only
+// ever see the members of the session's organization.
+type OrganizationHandler struct {
+	DB                     *sql.DB
+	organizationRepository query.OrganizationRepository
+	userHandler            *UserHandler
+}
+
+func NewOrganizationHandler(db *sql.DB, organizationRepository query.OrganizationRepository, userHandler *UserHandler) *OrganizationHandler {
+	return &OrganizationHandler{
+		DB:                     db,
+		organizationRepository: organizationRepository,
+		userHandler:            userHandler,
+	}
+}
+
+func (h *OrganizationHandler) HandleCreateOrganization(w http.ResponseWriter, r *http.Request) {
+	var params models.CreateOrganizationParams
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	if errors := params.Validate(); len(errors) > 0 {
+		writeJSONResponse(w, http.StatusBadRequest, map[string]interface{}{
+			"error":  "invalid parameters",
+			"fields": errors,
+		})
+		return
+	}
+
+	organization := models.NewOrganizationFromParams(params)
+	if err := h.organizationRepository.CreateOrganization(context.Background(), organization); err != nil {
+		if errors.Is(err, query.ErrSlugTaken) {
+			writeJSONResponse(w, http.StatusConflict, map[string]string{"error": err.Error()})
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusCreated, map[string]interface{}{"data": organization})
+}
+
+func (h *OrganizationHandler) HandleListOrganizations(w http.ResponseWriter, r *http.Request) {
+	organizations, err := h.organizationRepository.GetOrganizations(context.Background())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if organizations == nil {
+		organizations = []models.Organization{}
+	}
+
+	writeJSONResponse(w, http.StatusOK, map[string]interface{}{"data": organizations})
+}
+
+// HandleDeleteOrganization removes the organization and its memberships but
+// keeps the accounts of its members.
+func (h *OrganizationHandler) HandleDeleteOrganization(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathOrganizationID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.organizationRepository.DeleteOrganization(context.Background(), id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeJSONResponse(w, http.StatusNotFound, map[string]string{"error": "organization not found"})
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleAdminListMembers lists the members of any organization for a global
+// admin.
+func (h *OrganizationHandler) HandleAdminListMembers(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathOrganizationID(w, r)
+	if !ok {
+		return
+	}
+	h.listMembers(w, id)
+}
+
+// HandleAdminAddMember adds an existing user to an organization with any
+// role, which is how an organization gets its first owner.
+func (h *OrganizationHandler) HandleAdminAddMember(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathOrganizationID(w, r)
+	if !ok {
+		return
+	}
+
+	var params models.AddMemberParams
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	if errors := params.Validate(); len(errors) > 0 {
+		writeJSONResponse(w, http.StatusBadRequest, map[string]interface{}{
+			"error":  "invalid parameters",
+			"fields": errors,
+		})
+		return
+	}
+
+	err := h.organizationRepository.AddMember(context.Background(), id, params.UserID, params.Role, sessionActorID(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			writeJSONResponse(w, http.StatusNotFound, map[string]string{"error": "organization not found"})
+		case errors.Is(err, query.ErrUnknownMember):
+			writeJSONResponse(w, http.StatusBadRequest, map[string]string{"error": "user not found"})
+		case errors.Is(err, query.ErrAlreadyMember):
+			writeJSONResponse(w, http.StatusConflict, map[string]string{"error": err.Error()})
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	writeJSONResponse(w, http.StatusCreated, map[string]string{"message": "Member added"})
+}
+
+// HandleListMyOrganizations returns the organizations of the logged in user
+// and which of them the session acts in.
+func (h *OrganizationHandler) HandleListMyOrganizations(w http.ResponseWriter, r *http.Request) {
+	id, ok := sessionUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	memberships, err := h.organizationRepository.GetUserMemberships(context.Background(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if memberships == nil {
+		memberships = []models.Membership{}
+	}
+
+	var current *uuid.UUID
+	if tenant, ok := sessionOrganizationID(r); ok {
+		current = &tenant
+	}
+
+	writeJSONResponse(w, http.StatusOK, map[string]interface{}{"data": memberships, "current": current})
+}
+
+// RequireOrganizationAdmin must be chained after ValidateSession. It lets
+// owners and admins of the session's organization through. Membership is
+// checked on every request, so a removed admin loses access right away.
+func (h *OrganizationHandler) RequireOrganizationAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, ok := sessionUserID(r)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		tenant, ok := sessionOrganizationID(r)
+		if !ok {
+			http.Error(w, "Session does not belong to an organization", http.StatusForbidden)
+			return
+		}
+
+		member, err := h.organizationRepository.GetMember(context.Background(), tenant, id)
+		if err != nil || !models.CanManageUsers(member.Role) {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), organizationRole, member.Role)))
+	})
+}
+
+func (h *OrganizationHandler) HandleFetchOrganization(w http.ResponseWriter, r *http.Request) {
+	tenant, _ := sessionOrganizationID(r)
+
+	organization, err := h.organizationRepository.GetOrganizationByID(context.Background(), tenant)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeJSONResponse(w, http.StatusNotFound, map[string]string{"error": "organization not found"})
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	role, _ := r.Context().Value(organizationRole).(string)
+	writeJSONResponse(w, http.StatusOK, map[string]interface{}{"data": organization, "role": role})
+}
+
+func (h *OrganizationHandler) HandleListMembers(w http.ResponseWriter, r *http.Request) {
+	tenant, _ := sessionOrganizationID(r)
+	h.listMembers(w, tenant)
+}
+
+func (h *OrganizationHandler) listMembers(w http.ResponseWriter, organizationID uuid.UUID) {
+	members, err := h.organizationRepository.GetMembers(context.Background(), organizationID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if members == nil {
+		members = []models.OrganizationMember{}
+	}
+
+	writeJSONResponse(w, http.StatusOK, map[string]interface{}{"data": members})
+}
+
+func (h *OrganizationHandler) HandleSetMemberRole(w http.ResponseWriter, r *http.Request) {
+	var params models.SetMemberRoleParams
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	if errors := params.Validate(); len(errors) > 0 {
+		writeJSONResponse(w, http.StatusBadRequest, map[string]interface{}{
+			"error":  "invalid parameters",
+			"fields": errors,
+		})
+		return
+	}
+
+	target, ok := h.manageableMember(w, r)
+	if !ok {
+		return
+	}
+	if params.Role == models.OrgRoleOwner && !isOrganizationOwner(r) {
+		http.Error(w, "Only owners can appoint owners", http.StatusForbidden)
+		return
+	}
+
+	err := h.organizationRepository.SetMemberRole(context.Background(), target.OrganizationID, target.UserID, params.Role, sessionActorID(r))
+	if err != nil {
+		h.writeMemberError(w, err)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, map[string]string{"message": "Member role updated"})
+}
+
+// HandleRemoveMember takes the user out of the organization. Their account
+// stays, unlike with DELETE /org/users/{userID}.
+func (h *OrganizationHandler) HandleRemoveMember(w http.ResponseWriter, r *http.Request) {
+	target, ok := h.manageableMember(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.organizationRepository.RemoveMember(context.Background(), target.OrganizationID, target.UserID, sessionActorID(r))
+	if err != nil {
+		h.writeMemberError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *OrganizationHandler) writeMemberError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		writeJSONResponse(w, http.StatusNotFound, map[string]string{"error": "not found"})
+	case errors.Is(err, query.ErrLastOwner):
+		writeJSONResponse(w, http.StatusConflict, map[string]string{"error": err.Error()})
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// The user routes of an organization reuse the UserHandler with a repository
+// scoped to the session's organization, so users of other organizations
+// answer with 404 exactly like users that do not exist. Organization admins
+// are not global admins, so the admin metadata is hidden from them.
+
+func (h *OrganizationHandler) HandleFetchUsers(w http.ResponseWriter, r *http.Request) {
+	h.scopedUsers(r).HandleFetchUsers(w, r)
+}
+
+func (h *OrganizationHandler) HandleFetchUserByID(w http.ResponseWriter, r *http.Request) {
+	h.scopedUsers(r).HandleFetchUserByID(w, r)
+}
+
+// HandleCreateUser creates a new account that becomes a member of the
+// organization.
+func (h *OrganizationHandler) HandleCreateUser(w http.ResponseWriter, r *http.Request) {
+	h.scopedUsers(r).HandleCreateUser(w, r)
+}
+
+// Usernames, statuses and deletion apply to the whole account, so the
+// following routes refuse users who also belong to another organization;
+// those can only be removed from this one.
+
+func (h *OrganizationHandler) HandleUserUpdate(w http.ResponseWriter, r *http.Request) {
+	if h.ownedAccount(w, r) {
+		h.scopedUsers(r).HandleUserUpdate(w, r)
+	}
+}
+
+func (h *OrganizationHandler) HandleDeleteUser(w http.ResponseWriter, r *http.Request) {
+	if h.ownedAccount(w, r) {
+		h.scopedUsers(r).HandleDeleteUser(w, r)
+	}
+}
+
+func (h *OrganizationHandler) HandleSuspendUser(w http.ResponseWriter, r *http.Request) {
+	if h.ownedAccount(w, r) {
+		h.scopedUsers(r).HandleSuspendUser(w, r)
+	}
+}
+
+func (h *OrganizationHandler) HandleRestoreUser(w http.ResponseWriter, r *http.Request) {
+	if h.ownedAccount(w, r) {
+		h.scopedUsers(r).HandleRestoreUser(w, r)
+	}
+}
+
+func (h *OrganizationHandler) scopedUsers(r *http.Request) *UserHandler {
+	tenant, _ := sessionOrganizationID(r)
+
+	scoped := *h.userHandler
+	scoped.userRepository = query.NewOrganizationUserSQLRepository(h.DB, tenant)
+	scoped.hideAdminMetadata = true
+	return &scoped
+}
+
+// manageableMember loads the member named by the userID path parameter and
+// checks the caller may change them: owners can only be managed by owners,
+// and global admins not through an organization at all. When it returns
+// false the response has already been written.
+func (h *OrganizationHandler) manageableMember(w http.ResponseWriter, r *http.Request) (*models.OrganizationMember, bool) {
+	userID, err := uuid.Parse(chi.URLParam(r, "userID"))
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return nil, false
+	}
+	tenant, _ := sessionOrganizationID(r)
+
+	member, err := h.organizationRepository.GetMember(context.Background(), tenant, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeJSONResponse(w, http.StatusNotFound, map[string]string{"error": "not found"})
+			return nil, false
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+
+	if member.Role == models.OrgRoleOwner && !isOrganizationOwner(r) {
+		http.Error(w, "Only owners can manage owners", http.StatusForbidden)
+		return nil, false
+	}
+
+	user, err := query.NewOrganizationUserSQLRepository(h.DB, tenant).GetUserByID(context.Background(), userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	if user.IsAdmin {
+		http.Error(w, "Global admins cannot be managed by an organization", http.StatusForbidden)
+		return nil, false
+	}
+
+	return member, true
+}
+
+// ownedAccount is manageableMember for changes to the account itself, which
+// are only allowed while the session's organization is the only one the
+// user belongs to. When it returns false the response has already been
+// written.
+func (h *OrganizationHandler) ownedAccount(w http.ResponseWriter, r *http.Request) bool {
+	member, ok := h.manageableMember(w, r)
+	if !ok {
+		return false
+	}
+
+	memberships, err := h.organizationRepository.GetUserMemberships(context.Background(), member.UserID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	for _, membership := range memberships {
+		if membership.Organization.ID != member.OrganizationID {
+			writeJSONResponse(w, http.StatusConflict, map[string]string{
+				"error": "user also belongs to another organization and can only be removed from this one",
+			})
+			return false
+		}
+	}
+	return true
+}
+
+// HandleSwitchOrganization moves the session to another organization of the
+// user by issuing a new token pair with that tenant.
+func (s *SessionHandler) HandleSwitchOrganization(w http.ResponseWriter, r *http.Request) {
+	id, ok := sessionUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var params struct {
+		OrganizationID uuid.UUID `json:"organization_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	if _, err := s.organizationRepository.GetMember(context.Background(), params.OrganizationID, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeJSONResponse(w, http.StatusNotFound, map[string]string{"error": "organization not found"})
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	user, err := s.userRepository.GetUserByID(context.Background(), id)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := s.issueTenantSession(w, user, &params.OrganizationID); err != nil {
+		http.Error(w, "Failed to save refresh token", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, map[string]string{"message": "Organization switched"})
+}
+
+func pathOrganizationID(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	id, err := uuid.Parse(chi.URLParam(r, "orgID"))
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return uuid.Nil, false
+	}
+	return id, true
+}
+
+func isOrganizationOwner(r *http.Request) bool {
+	role, _ := r.Context().Value(organizationRole).(string)
+	return role == models.OrgRoleOwner
+}
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/OsagieDG/jwt-based-auth-system/internal/mailer"
+	"github.com/OsagieDG/jwt-based-auth-system/internal/models"
+	"github.com/OsagieDG/jwt-based-auth-system/internal/query"
+)
+
+const passwordResetTokenTTL = time.Hour
+
+type PasswordResetHandler struct {
+	userRepository          query.UserRespository
+	passwordResetRepository query.PasswordResetRepository
+	passwordPolicy          models.PasswordPolicy
+	mailer                  mailer.Mailer
+	baseURL                 string
+}
+
+func NewPasswordResetHandler(userRepository query.UserRespository, passwordResetRepository query.PasswordResetRepository, passwordPolicy models.PasswordPolicy, m mailer.Mailer, baseURL string) *PasswordResetHandler {
+	return &PasswordResetHandler{
+		userRepository:          userRepository,
+		passwordResetRepository: passwordResetRepository,
+		passwordPolicy:          passwordPolicy,
+		mailer:                  m,
+		baseURL:                 baseURL,
+	}
+}
+
+// HandleForgotPassword answers with the same message whether or not the email
+// belongs to an account, so it cannot be used to enumerate users. The account
+// is looked up and mailed after answering, so the response time does not give
+// it away either.
+func (h *PasswordResetHandler) HandleForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var params models.ForgotPasswordParams
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	go h.sendPasswordResetTo(params.Email)
+
+	writeJSONResponse(w, http.StatusAccepted, map[string]string{
+		"message": "If an account with that email exists, a password reset link has been sent",
+	})
+}
+
+func (h *PasswordResetHandler) sendPasswordResetTo(email string) {
+	user, err := h.userRepository.GetUserByEmail(context.Background(), email)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("failed to look up %s for a password reset: %v", email, err)
+		}
+		return
+	}
+
+	if err := h.SendPasswordReset(context.Background(), user); err != nil {
+		log.Printf("failed to send password reset email to %s: %v", user.Email, err)
+	}
+}
+
+func (h *PasswordResetHandler) HandleResetPassword(w http.ResponseWriter, r *http.Request) {
+	var params models.ResetPasswordParams
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	tokenHash := models.HashOpaqueToken(params.Token)
+
+	// The user is looked up first so the new password can be checked
+	// against their username and email address.
+	userID, err := h.passwordResetRepository.GetPasswordResetUserID(context.Background(), tokenHash)
+	if err != nil {
+		if errors.Is(err, query.ErrInvalidToken) {
+			writeJSONResponse(w, http.StatusBadRequest, map[string]string{
+				"error": err.Error(),
+			})
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	user, err := h.userRepository.GetUserByID(context.Background(), userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if errors := params.Validate(h.passwordPolicy, user.PasswordInputs()...); len(errors) > 0 {
+		writeJSONResponse(w, http.StatusBadRequest, map[string]interface{}{
+			"error":  "invalid parameters",
+			"fields": errors,
+		})
+		return
+	}
+
+	encpw, err := models.EncryptPassword(params.Password)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	_, err = h.passwordResetRepository.ResetPassword(context.Background(), tokenHash, encpw)
+	if err != nil {
+		if errors.Is(err, query.ErrInvalidToken) {
+			writeJSONResponse(w, http.StatusBadRequest, map[string]string{
+				"error": err.Error(),
+			})
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, map[string]string{"message": "Password has been reset"})
+}
+
+// SendPasswordReset issues a reset token for the user and mails the link.
+func (h *PasswordResetHandler) SendPasswordReset(ctx context.Context, user *models.User) error {
+	token, hash, err := models.NewOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	resetToken := &models.PasswordResetToken{
+		ID:        models.NewUUID(),
+		UserID:    user.ID,
+		TokenHash: hash,
+		ExpiresAt: time.Now().Add(passwordResetTokenTTL),
+	}
+	if err := h.passwordResetRepository.SavePasswordResetToken(ctx, resetToken); err != nil {
+		return err
+	}
+
+	return h.mailer.Send(ctx, &mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nA password reset was requested for your account. Open the link below to choose a new password:\n\n%s/password/reset?token=%s\n\nThe link expires in %s. If you did not request this, you can ignore this email.\n",
+			user.UserName, h.baseURL, token, passwordResetTokenTTL,
+		),
+	})
+}
@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/OsagieDG/jwt-based-auth-system/internal/models"
+	"github.com/OsagieDG/jwt-based-auth-system/internal/query"
+	"github.com/OsagieDG/jwt-based-auth-system/internal/scim"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+const scimClient ContextKey = "scimClient"
+
+// SCIMHandler serves the SCIM 2.0 API that identity providers and HR systems
+// use to provision users and groups, and the admin endpoints that issue the
+// bearer tokens those clients authenticate with. Every client belongs to an
+// organization and only sees its members and groups.
+type SCIMHandler struct {
+	DB *sql.DB
+	// allUsers is unscoped and only used to check that usernames and email
+	// addresses are unique across organizations.
+	allUsers             query.UserRespository
+	userRepository       query.UserRespository
+	groupRepository      query.GroupRepository
+	scimClientRepository query.SCIMClientRepository
+	passwordPolicy       models.PasswordPolicy
+	baseURL              string
+}
+
+func NewSCIMHandler(db *sql.DB, userRepository query.UserRespository, scimClientRepository query.SCIMClientRepository, passwordPolicy models.PasswordPolicy, baseURL string) *SCIMHandler {
+	return &SCIMHandler{
+		DB:                   db,
+		allUsers:             userRepository,
+		scimClientRepository: scimClientRepository,
+		passwordPolicy:       passwordPolicy,
+		baseURL:              strings.TrimRight(baseURL, "/"),
+	}
+}
+
+// RequireClient authenticates the bearer token of a provisioning client and
+// stores the client in the request context.
+func (h *SCIMHandler) RequireClient(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeSCIMError(w, scim.NewError(http.StatusUnauthorized, "", "missing bearer token"))
+			return
+		}
+
+		client, err := h.scimClientRepository.AuthenticateSCIMClient(context.Background(), models.HashOpaqueToken(token))
+		if err != nil {
+			if errors.Is(err, query.ErrInvalidToken) {
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				writeSCIMError(w, scim.NewError(http.StatusUnauthorized, "", "invalid bearer token"))
+				return
+			}
+			writeSCIMError(w, scim.NewError(http.StatusInternalServerError, "", err.Error()))
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), scimClient, client)))
+	})
+}
+
+// forClient returns a copy of the handler whose repositories are scoped to
+// the organization of the client authenticated by RequireClient.
+func (h *SCIMHandler) forClient(r *http.Request) *SCIMHandler {
+	client := r.Context().Value(scimClient).(*models.SCIMClient)
+
+	scoped := *h
+	scoped.userRepository = query.NewOrganizationUserSQLRepository(h.DB, client.OrganizationID)
+	scoped.groupRepository = query.NewGroupSQLRepository(h.DB, client.OrganizationID)
+	return &scoped
+}
+
+func (h *SCIMHandler) HandleServiceProviderConfig(w http.ResponseWriter, r *http.Request) {
+	writeSCIMResponse(w, http.StatusOK, scim.NewServiceProviderConfig())
+}
+
+// HandleCreateClient registers a provisioning client. The token is only
+// shown in this response; the database keeps its hash.
+func (h *SCIMHandler) HandleCreateClient(w http.ResponseWriter, r *http.Request) {
+	var params models.CreateSCIMClientParams
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	if errors := params.Validate(); len(errors) > 0 {
+		writeJSONResponse(w, http.StatusBadRequest, map[string]interface{}{
+			"error":  "invalid parameters",
+			"fields": errors,
+		})
+		return
+	}
+
+	token, tokenHash, err := models.NewOpaqueToken()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	client := &models.SCIMClient{
+		ID:             models.NewUUID(),
+		OrganizationID: params.OrganizationID,
+		Name:           params.Name,
+		TokenHash:      tokenHash,
+	}
+	if err := h.scimClientRepository.CreateSCIMClient(context.Background(), client); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeJSONResponse(w, http.StatusNotFound, map[string]string{"error": "organization not found"})
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusCreated, map[string]interface{}{
+		"client": client,
+		"token":  token,
+	})
+}
+
+func (h *SCIMHandler) HandleListClients(w http.ResponseWriter, r *http.Request) {
+	clients, err := h.scimClientRepository.GetSCIMClients(context.Background())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if clients == nil {
+		clients = []models.SCIMClient{}
+	}
+
+	writeJSONResponse(w, http.StatusOK, clients)
+}
+
+func (h *SCIMHandler) HandleDeleteClient(w http.ResponseWriter, r *http.Request) {
+	clientID, err := uuid.Parse(chi.URLParam(r, "clientID"))
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.scimClientRepository.DeleteSCIMClient(context.Background(), clientID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeJSONResponse(w, http.StatusNotFound, map[string]string{"error": "SCIM client not found"})
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *SCIMHandler) location(resourceType string, id uuid.UUID) string {
+	return fmt.Sprintf("%s/scim/v2/%ss/%s", h.baseURL, resourceType, id)
+}
+
+// resourceID parses the id path parameter. Ids that are not UUIDs cannot
+// name any resource, so they are answered with 404.
+func resourceID(r *http.Request, resourceType string) (uuid.UUID, *scim.Error) {
+	id, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		return uuid.Nil, notFound(resourceType, chi.URLParam(r, "id"))
+	}
+	return id, nil
+}
+
+func notFound(resourceType, id string) *scim.Error {
+	return scim.NewError(http.StatusNotFound, "", fmt.Sprintf("%s %s not found", resourceType, id))
+}
+
+func decodeSCIM(r *http.Request, v interface{}) *scim.Error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return scim.NewError(http.StatusBadRequest, scim.ErrInvalidSyntax, "request body is not valid JSON")
+	}
+	return nil
+}
+
+// filterConditions translates a filter into repository conditions using the
+// mapping of SCIM attributes to columns.
+func filterConditions(filter string, columns map[string]string) ([]query.Condition, *scim.Error) {
+	if filter == "" {
+		return nil, nil
+	}
+
+	comparisons, scimErr := scim.ParseFilter(filter)
+	if scimErr != nil {
+		return nil, scimErr
+	}
+
+	conditions := make([]query.Condition, 0, len(comparisons))
+	for _, c := range comparisons {
+		column, ok := columns[c.Attribute]
+		if !ok {
+			return nil, scim.NewError(http.StatusBadRequest, scim.ErrInvalidFilter, fmt.Sprintf("filtering on %q is not supported", c.Attribute))
+		}
+		condition, scimErr := comparisonCondition(column, c)
+		if scimErr != nil {
+			return nil, scimErr
+		}
+		conditions = append(conditions, condition)
+	}
+	return conditions, nil
+}
+
+func comparisonCondition(column string, c scim.Comparison) (query.Condition, *scim.Error) {
+	switch c.Operator {
+	case query.OpEqual, query.OpNotEqual, query.OpContains, query.OpStartsWith, query.OpEndsWith:
+		value, ok := c.Value.(string)
+		if !ok {
+			return query.Condition{}, scim.NewError(http.StatusBadRequest, scim.ErrInvalidFilter, fmt.Sprintf("%s needs a string value", c.Attribute))
+		}
+		return query.Condition{Column: column, Operator: c.Operator, Value: value}, nil
+	case query.OpPresent:
+		return query.Condition{Column: column, Operator: c.Operator}, nil
+	}
+	return query.Condition{}, scim.NewError(http.StatusBadRequest, scim.ErrInvalidFilter, fmt.Sprintf("operator %q is not supported", c.Operator))
+}
+
+func writeSCIMResponse(w http.ResponseWriter, statusCode int, data interface{}) {
+	w.Header().Set("Content-Type", scim.ContentType)
+	w.WriteHeader(statusCode)
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		http.Error(w, "Failed to encode JSON response", http.StatusInternalServerError)
+	}
+}
+
+func writeSCIMError(w http.ResponseWriter, err *scim.Error) {
+	writeSCIMResponse(w, err.Status, err)
+}
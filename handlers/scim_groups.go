@@ -0,0 +1,398 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/OsagieDG/jwt-based-auth-system/internal/models"
+	"github.com/OsagieDG/jwt-based-auth-system/internal/query"
+	"github.com/OsagieDG/jwt-based-auth-system/internal/scim"
+	"github.com/google/uuid"
+)
+
+const maxGroupNameLen = 100
+
+// scimGroupColumns maps the filterable Group attributes to group columns.
+var scimGroupColumns = map[string]string{
+	"id":          "id",
+	"displayname": "display_name",
+}
+
+func (h *SCIMHandler) HandleListGroups(w http.ResponseWriter, r *http.Request) {
+	h = h.forClient(r)
+	conditions, scimErr := filterConditions(r.URL.Query().Get("filter"), scimGroupColumns)
+	if scimErr != nil {
+		writeSCIMError(w, scimErr)
+		return
+	}
+
+	startIndex, count := scim.Pagination(r.URL.Query())
+	groups, total, err := h.groupRepository.FindGroups(context.Background(), conditions, startIndex-1, count)
+	if err != nil {
+		writeSCIMError(w, scim.NewError(http.StatusInternalServerError, "", err.Error()))
+		return
+	}
+
+	withMembers := !excludesMembers(r)
+	resources := make([]*scim.Group, 0, len(groups))
+	for i := range groups {
+		resources = append(resources, h.toSCIMGroup(&groups[i], withMembers))
+	}
+
+	writeSCIMResponse(w, http.StatusOK, scim.NewListResponse(resources, len(resources), total, startIndex))
+}
+
+func (h *SCIMHandler) HandleFetchGroup(w http.ResponseWriter, r *http.Request) {
+	h = h.forClient(r)
+	group, scimErr := h.loadGroup(context.Background(), r)
+	if scimErr != nil {
+		writeSCIMError(w, scimErr)
+		return
+	}
+
+	writeSCIMResponse(w, http.StatusOK, h.toSCIMGroup(group, !excludesMembers(r)))
+}
+
+func (h *SCIMHandler) HandleCreateGroup(w http.ResponseWriter, r *http.Request) {
+	h = h.forClient(r)
+	var resource scim.Group
+	if scimErr := decodeSCIM(r, &resource); scimErr != nil {
+		writeSCIMError(w, scimErr)
+		return
+	}
+
+	group := &models.Group{ID: models.NewUUID()}
+	if scimErr := applySCIMGroup(group, &resource); scimErr != nil {
+		writeSCIMError(w, scimErr)
+		return
+	}
+
+	if err := h.groupRepository.CreateGroup(context.Background(), group); err != nil {
+		writeSCIMError(w, groupError(err))
+		return
+	}
+
+	h.writeGroup(w, http.StatusCreated, group.ID)
+}
+
+func (h *SCIMHandler) HandleReplaceGroup(w http.ResponseWriter, r *http.Request) {
+	h = h.forClient(r)
+	ctx := context.Background()
+
+	group, scimErr := h.loadGroup(ctx, r)
+	if scimErr != nil {
+		writeSCIMError(w, scimErr)
+		return
+	}
+
+	var resource scim.Group
+	if scimErr := decodeSCIM(r, &resource); scimErr != nil {
+		writeSCIMError(w, scimErr)
+		return
+	}
+	if scimErr := applySCIMGroup(group, &resource); scimErr != nil {
+		writeSCIMError(w, scimErr)
+		return
+	}
+
+	if err := h.groupRepository.ReplaceGroup(ctx, group); err != nil {
+		writeSCIMError(w, groupError(err))
+		return
+	}
+
+	h.writeGroup(w, http.StatusOK, group.ID)
+}
+
+// HandlePatchGroup applies the operations to the stored group and saves the
+// result. Clients mostly use it to add and remove single members without
+// sending the whole member list.
+func (h *SCIMHandler) HandlePatchGroup(w http.ResponseWriter, r *http.Request) {
+	h = h.forClient(r)
+	ctx := context.Background()
+
+	group, scimErr := h.loadGroup(ctx, r)
+	if scimErr != nil {
+		writeSCIMError(w, scimErr)
+		return
+	}
+
+	var patch scim.PatchRequest
+	if scimErr := decodeSCIM(r, &patch); scimErr != nil {
+		writeSCIMError(w, scimErr)
+		return
+	}
+	if scimErr := patch.Validate(); scimErr != nil {
+		writeSCIMError(w, scimErr)
+		return
+	}
+
+	for i := range patch.Operations {
+		if scimErr := patchGroup(group, &patch.Operations[i]); scimErr != nil {
+			writeSCIMError(w, scimErr)
+			return
+		}
+	}
+	if scimErr := validateGroupName(group.DisplayName); scimErr != nil {
+		writeSCIMError(w, scimErr)
+		return
+	}
+
+	if err := h.groupRepository.ReplaceGroup(ctx, group); err != nil {
+		writeSCIMError(w, groupError(err))
+		return
+	}
+
+	h.writeGroup(w, http.StatusOK, group.ID)
+}
+
+func (h *SCIMHandler) HandleDeleteGroup(w http.ResponseWriter, r *http.Request) {
+	h = h.forClient(r)
+	id, scimErr := resourceID(r, "Group")
+	if scimErr != nil {
+		writeSCIMError(w, scimErr)
+		return
+	}
+
+	if err := h.groupRepository.DeleteGroup(context.Background(), id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeSCIMError(w, notFound("Group", id.String()))
+			return
+		}
+		writeSCIMError(w, scim.NewError(http.StatusInternalServerError, "", err.Error()))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *SCIMHandler) loadGroup(ctx context.Context, r *http.Request) (*models.Group, *scim.Error) {
+	id, scimErr := resourceID(r, "Group")
+	if scimErr != nil {
+		return nil, scimErr
+	}
+
+	group, err := h.groupRepository.GetGroupByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, notFound("Group", id.String())
+		}
+		return nil, scim.NewError(http.StatusInternalServerError, "", err.Error())
+	}
+	return group, nil
+}
+
+// applySCIMGroup copies the name and the complete member list of a POST or
+// PUT body onto the group.
+func applySCIMGroup(group *models.Group, resource *scim.Group) *scim.Error {
+	if scimErr := validateGroupName(resource.DisplayName); scimErr != nil {
+		return scimErr
+	}
+
+	members, scimErr := groupMembers(resource.Members)
+	if scimErr != nil {
+		return scimErr
+	}
+
+	group.DisplayName = resource.DisplayName
+	group.Members = members
+	return nil
+}
+
+func validateGroupName(name string) *scim.Error {
+	if strings.TrimSpace(name) == "" || len(name) > maxGroupNameLen {
+		return scim.NewError(http.StatusBadRequest, scim.ErrInvalidValue, fmt.Sprintf("displayName should be between 1 and %d characters", maxGroupNameLen))
+	}
+	return nil
+}
+
+func groupMembers(members []scim.Member) ([]models.GroupMember, *scim.Error) {
+	result := make([]models.GroupMember, 0, len(members))
+	for _, member := range members {
+		userID, err := uuid.Parse(member.Value)
+		if err != nil {
+			return nil, scim.NewError(http.StatusBadRequest, scim.ErrInvalidValue, fmt.Sprintf("member %q is not a user id", member.Value))
+		}
+		result = append(result, models.GroupMember{UserID: userID})
+	}
+	return result, nil
+}
+
+func patchGroup(group *models.Group, op *scim.PatchOperation) *scim.Error {
+	ops, scimErr := op.Attributes()
+	if scimErr != nil {
+		return scimErr
+	}
+
+	for _, op := range ops {
+		path, scimErr := scim.ParsePath(op.Path)
+		if scimErr != nil {
+			return scimErr
+		}
+
+		switch path.Attribute {
+		case "displayname":
+			if op.Op == scim.OpRemove {
+				return scim.NewError(http.StatusBadRequest, scim.ErrMutability, "displayName is required")
+			}
+			if scimErr := unmarshalValue(op, &group.DisplayName); scimErr != nil {
+				return scimErr
+			}
+		case "members":
+			if scimErr := patchMembers(group, op, path); scimErr != nil {
+				return scimErr
+			}
+		default:
+			return scim.NewError(http.StatusBadRequest, scim.ErrInvalidPath, fmt.Sprintf("unsupported path %q", op.Path))
+		}
+	}
+	return nil
+}
+
+// patchMembers supports the forms clients send: add a list of members,
+// replace the whole list, remove members[value eq "id"], and remove with the
+// members to drop listed in the value.
+func patchMembers(group *models.Group, op scim.PatchOperation, path *scim.Path) *scim.Error {
+	if path.Filter != nil {
+		if op.Op != scim.OpRemove || path.SubAttribute != "" {
+			return scim.NewError(http.StatusBadRequest, scim.ErrInvalidPath, "member filters can only be used to remove members")
+		}
+		ids, scimErr := memberFilterIDs(path.Filter)
+		if scimErr != nil {
+			return scimErr
+		}
+		group.Members = withoutMembers(group.Members, ids)
+		return nil
+	}
+
+	var members []models.GroupMember
+	if len(op.Value) > 0 {
+		var values []scim.Member
+		if scimErr := unmarshalValue(op, &values); scimErr != nil {
+			return scimErr
+		}
+		var scimErr *scim.Error
+		if members, scimErr = groupMembers(values); scimErr != nil {
+			return scimErr
+		}
+	}
+
+	switch op.Op {
+	case scim.OpAdd:
+		ids := make(map[uuid.UUID]bool, len(members))
+		for _, member := range members {
+			ids[member.UserID] = true
+		}
+		group.Members = append(withoutMembers(group.Members, ids), members...)
+	case scim.OpReplace:
+		group.Members = members
+	case scim.OpRemove:
+		if len(op.Value) == 0 {
+			group.Members = nil
+			return nil
+		}
+		ids := make(map[uuid.UUID]bool, len(members))
+		for _, member := range members {
+			ids[member.UserID] = true
+		}
+		group.Members = withoutMembers(group.Members, ids)
+	}
+	return nil
+}
+
+// memberFilterIDs reads the user ids out of a filter like
+// value eq "2819c223-7f76-453a-919d-413861904646".
+func memberFilterIDs(filter []scim.Comparison) (map[uuid.UUID]bool, *scim.Error) {
+	ids := map[uuid.UUID]bool{}
+	for _, c := range filter {
+		value, ok := c.Value.(string)
+		if c.Attribute != "value" || c.Operator != query.OpEqual || !ok {
+			return nil, scim.NewError(http.StatusBadRequest, scim.ErrInvalidFilter, `member filters must have the form value eq "id"`)
+		}
+		id, err := uuid.Parse(value)
+		if err != nil {
+			return nil, scim.NewError(http.StatusBadRequest, scim.ErrInvalidValue, fmt.Sprintf("member %q is not a user id", value))
+		}
+		ids[id] = true
+	}
+	if len(ids) > 1 {
+		// Comparisons are joined by and, so two different ids match nothing.
+		return map[uuid.UUID]bool{}, nil
+	}
+	return ids, nil
+}
+
+func withoutMembers(members []models.GroupMember, ids map[uuid.UUID]bool) []models.GroupMember {
+	kept := make([]models.GroupMember, 0, len(members))
+	for _, member := range members {
+		if !ids[member.UserID] {
+			kept = append(kept, member)
+		}
+	}
+	return kept
+}
+
+func groupError(err error) *scim.Error {
+	switch {
+	case errors.Is(err, query.ErrGroupNameTaken):
+		return scim.NewError(http.StatusConflict, scim.ErrUniqueness, err.Error())
+	case errors.Is(err, query.ErrUnknownMember):
+		return scim.NewError(http.StatusBadRequest, scim.ErrInvalidValue, err.Error())
+	case errors.Is(err, sql.ErrNoRows):
+		return scim.NewError(http.StatusNotFound, "", err.Error())
+	}
+	return scim.NewError(http.StatusInternalServerError, "", err.Error())
+}
+
+// excludesMembers reports whether the client asked to leave out members,
+// which identity providers do to avoid loading large groups.
+func excludesMembers(r *http.Request) bool {
+	for _, attribute := range strings.Split(r.URL.Query().Get("excludedAttributes"), ",") {
+		if strings.EqualFold(strings.TrimSpace(attribute), "members") {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *SCIMHandler) toSCIMGroup(group *models.Group, withMembers bool) *scim.Group {
+	resource := &scim.Group{
+		Schemas:     []string{scim.SchemaGroup},
+		ID:          group.ID.String(),
+		DisplayName: group.DisplayName,
+		Meta: &scim.Meta{
+			ResourceType: "Group",
+			Created:      group.CreatedAt,
+			LastModified: group.UpdatedAt,
+			Location:     h.location("Group", group.ID),
+		},
+	}
+
+	if withMembers {
+		for _, member := range group.Members {
+			resource.Members = append(resource.Members, scim.Member{
+				Value:   member.UserID.String(),
+				Display: member.UserName,
+				Ref:     h.location("User", member.UserID),
+			})
+		}
+	}
+	return resource
+}
+
+// writeGroup reloads the group so the response carries the member names.
+func (h *SCIMHandler) writeGroup(w http.ResponseWriter, statusCode int, id uuid.UUID) {
+	group, err := h.groupRepository.GetGroupByID(context.Background(), id)
+	if err != nil {
+		writeSCIMError(w, scim.NewError(http.StatusInternalServerError, "", err.Error()))
+		return
+	}
+
+	if statusCode == http.StatusCreated {
+		w.Header().Set("Location", h.location("Group", id))
+	}
+	writeSCIMResponse(w, statusCode, h.toSCIMGroup(group, true))
+}
@@ -0,0 +1,633 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/OsagieDG/jwt-based-auth-system/internal/models"
+	"github.com/OsagieDG/jwt-based-auth-system/internal/query"
+	"github.com/OsagieDG/jwt-based-auth-system/internal/scim"
+	"github.com/google/uuid"
+)
+
+// scimUserColumns maps the filterable User attributes to user columns.
+// active is handled separately since it maps onto the status column.
+var scimUserColumns = map[string]string{
+	"id":              "id",
+	"username":        "username",
+	"emails":          "email",
+	"emails.value":    "email",
+	"name.givenname":  "first_name",
+	"name.familyname": "last_name",
+}
+
+func (h *SCIMHandler) HandleListUsers(w http.ResponseWriter, r *http.Request) {
+	h = h.forClient(r)
+	ctx := context.Background()
+
+	conditions, scimErr := userConditions(r.URL.Query().Get("filter"))
+	if scimErr != nil {
+		writeSCIMError(w, scimErr)
+		return
+	}
+	// Users deleted through SCIM stay in the table until they are purged.
+	conditions = append(conditions, query.Condition{
+		Column:   "status",
+		Operator: query.OpNotEqual,
+		Value:    models.UserStatusPendingDeletion,
+	})
+
+	startIndex, count := scim.Pagination(r.URL.Query())
+	users, total, err := h.userRepository.FindUsers(ctx, conditions, startIndex-1, count)
+	if err != nil {
+		writeSCIMError(w, scim.NewError(http.StatusInternalServerError, "", err.Error()))
+		return
+	}
+
+	resources := make([]*scim.User, 0, len(users))
+	for i := range users {
+		resource, err := h.userResource(ctx, &users[i])
+		if err != nil {
+			writeSCIMError(w, scim.NewError(http.StatusInternalServerError, "", err.Error()))
+			return
+		}
+		resources = append(resources, resource)
+	}
+
+	writeSCIMResponse(w, http.StatusOK, scim.NewListResponse(resources, len(resources), total, startIndex))
+}
+
+// userConditions is filterConditions plus the active attribute, which is
+// true for active users and false for every other status.
+func userConditions(filter string) ([]query.Condition, *scim.Error) {
+	comparisons, scimErr := scim.ParseFilter(filter)
+	if scimErr != nil || filter == "" {
+		return nil, scimErr
+	}
+
+	conditions := make([]query.Condition, 0, len(comparisons))
+	for _, c := range comparisons {
+		if c.Attribute == "active" {
+			active, ok := c.Value.(bool)
+			if !ok || (c.Operator != query.OpEqual && c.Operator != query.OpNotEqual) {
+				return nil, scim.NewError(http.StatusBadRequest, scim.ErrInvalidFilter, "active can only be compared to true or false with eq or ne")
+			}
+			operator := query.OpEqual
+			if active != (c.Operator == query.OpEqual) {
+				operator = query.OpNotEqual
+			}
+			conditions = append(conditions, query.Condition{Column: "status", Operator: operator, Value: models.UserStatusActive})
+			continue
+		}
+
+		column, ok := scimUserColumns[c.Attribute]
+		if !ok {
+			return nil, scim.NewError(http.StatusBadRequest, scim.ErrInvalidFilter, fmt.Sprintf("filtering on %q is not supported", c.Attribute))
+		}
+		condition, scimErr := comparisonCondition(column, c)
+		if scimErr != nil {
+			return nil, scimErr
+		}
+		conditions = append(conditions, condition)
+	}
+	return conditions, nil
+}
+
+func (h *SCIMHandler) HandleFetchUser(w http.ResponseWriter, r *http.Request) {
+	h = h.forClient(r)
+	ctx := context.Background()
+
+	user, scimErr := h.loadUser(ctx, r)
+	if scimErr != nil {
+		writeSCIMError(w, scimErr)
+		return
+	}
+
+	h.writeUser(ctx, w, http.StatusOK, user)
+}
+
+// HandleCreateUser provisions a user. Without a password the account gets a
+// random one and can only be used through other login methods until the
+// password is reset. The address is trusted as verified, since it is managed
+// by the organization.
+func (h *SCIMHandler) HandleCreateUser(w http.ResponseWriter, r *http.Request) {
+	h = h.forClient(r)
+	ctx := context.Background()
+
+	var resource scim.User
+	if scimErr := decodeSCIM(r, &resource); scimErr != nil {
+		writeSCIMError(w, scimErr)
+		return
+	}
+
+	params := userParams(&resource)
+	if scimErr := validateUserParams(params); scimErr != nil {
+		writeSCIMError(w, scimErr)
+		return
+	}
+	email, scimErr := resourceEmail(&resource)
+	if scimErr != nil {
+		writeSCIMError(w, scimErr)
+		return
+	}
+	if scimErr := h.checkUniqueness(ctx, uuid.Nil, resource.UserName, email); scimErr != nil {
+		writeSCIMError(w, scimErr)
+		return
+	}
+
+	user, err := models.NewProvisionedUser(email, resource.UserName, true)
+	if err != nil {
+		writeSCIMError(w, scim.NewError(http.StatusInternalServerError, "", err.Error()))
+		return
+	}
+	if resource.Password != "" {
+		if user.EncryptedPassword, scimErr = h.encryptPassword(resource.Password, user.PasswordInputs()...); scimErr != nil {
+			writeSCIMError(w, scimErr)
+			return
+		}
+	}
+	if params.FirstName.Valid {
+		user.FirstName = &params.FirstName.Value
+	}
+	if params.LastName.Valid {
+		user.LastName = &params.LastName.Value
+	}
+
+	if _, err := h.userRepository.InsertUser(ctx, user); err != nil {
+		writeSCIMError(w, scimUserError(err))
+		return
+	}
+
+	if resource.Active != nil && !*resource.Active {
+		if _, err := h.userRepository.SetUserStatus(ctx, user.ID, models.UserStatusSuspended, nil, 0); err != nil {
+			writeSCIMError(w, scim.NewError(http.StatusInternalServerError, "", err.Error()))
+			return
+		}
+	}
+
+	user, err = h.userRepository.GetUserByID(ctx, user.ID)
+	if err != nil {
+		writeSCIMError(w, scim.NewError(http.StatusInternalServerError, "", err.Error()))
+		return
+	}
+
+	w.Header().Set("Location", h.location("User", user.ID))
+	h.writeUser(ctx, w, http.StatusCreated, user)
+}
+
+// HandleReplaceUser stores the complete representation sent with PUT.
+// Attributes left out, like name, are cleared.
+func (h *SCIMHandler) HandleReplaceUser(w http.ResponseWriter, r *http.Request) {
+	h = h.forClient(r)
+	ctx := context.Background()
+
+	user, scimErr := h.loadUser(ctx, r)
+	if scimErr != nil {
+		writeSCIMError(w, scimErr)
+		return
+	}
+
+	var resource scim.User
+	if scimErr := decodeSCIM(r, &resource); scimErr != nil {
+		writeSCIMError(w, scimErr)
+		return
+	}
+
+	user, scimErr = h.saveUser(ctx, user, &resource)
+	if scimErr != nil {
+		writeSCIMError(w, scimErr)
+		return
+	}
+
+	h.writeUser(ctx, w, http.StatusOK, user)
+}
+
+// HandlePatchUser applies the operations to the current representation of
+// the user and then saves it like a PUT would.
+func (h *SCIMHandler) HandlePatchUser(w http.ResponseWriter, r *http.Request) {
+	h = h.forClient(r)
+	ctx := context.Background()
+
+	user, scimErr := h.loadUser(ctx, r)
+	if scimErr != nil {
+		writeSCIMError(w, scimErr)
+		return
+	}
+
+	var patch scim.PatchRequest
+	if scimErr := decodeSCIM(r, &patch); scimErr != nil {
+		writeSCIMError(w, scimErr)
+		return
+	}
+	if scimErr := patch.Validate(); scimErr != nil {
+		writeSCIMError(w, scimErr)
+		return
+	}
+
+	resource := h.toSCIMUser(user, nil)
+	for i := range patch.Operations {
+		if scimErr := patchUser(resource, &patch.Operations[i]); scimErr != nil {
+			writeSCIMError(w, scimErr)
+			return
+		}
+	}
+
+	user, scimErr = h.saveUser(ctx, user, resource)
+	if scimErr != nil {
+		writeSCIMError(w, scimErr)
+		return
+	}
+
+	h.writeUser(ctx, w, http.StatusOK, user)
+}
+
+// HandleDeleteUser schedules the user for deletion, which revokes their
+// sessions right away. The purge job removes the row after the grace period.
+func (h *SCIMHandler) HandleDeleteUser(w http.ResponseWriter, r *http.Request) {
+	h = h.forClient(r)
+	ctx := context.Background()
+
+	user, scimErr := h.loadUser(ctx, r)
+	if scimErr != nil {
+		writeSCIMError(w, scimErr)
+		return
+	}
+
+	provision := models.ProvisionUserParams{Status: models.UserStatusPendingDeletion}
+	if _, err := h.userRepository.ProvisionUser(ctx, user.ID, provision); err != nil {
+		writeSCIMError(w, provisionError(user.ID, err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// loadUser finds the user named in the URL. Users pending deletion are
+// already gone as far as the client is concerned.
+func (h *SCIMHandler) loadUser(ctx context.Context, r *http.Request) (*models.User, *scim.Error) {
+	id, scimErr := resourceID(r, "User")
+	if scimErr != nil {
+		return nil, scimErr
+	}
+
+	user, err := h.userRepository.GetUserByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, notFound("User", id.String())
+		}
+		return nil, scim.NewError(http.StatusInternalServerError, "", err.Error())
+	}
+	if user.Status == models.UserStatusPendingDeletion {
+		return nil, notFound("User", id.String())
+	}
+	return user, nil
+}
+
+// saveUser makes the stored user match the resource in one transaction.
+func (h *SCIMHandler) saveUser(ctx context.Context, user *models.User, resource *scim.User) (*models.User, *scim.Error) {
+	params := userParams(resource)
+	if scimErr := validateUserParams(params); scimErr != nil {
+		return nil, scimErr
+	}
+	email, scimErr := resourceEmail(resource)
+	if scimErr != nil {
+		return nil, scimErr
+	}
+
+	userName := ""
+	if !strings.EqualFold(resource.UserName, user.UserName) {
+		userName = resource.UserName
+	}
+	if email == user.Email {
+		email = ""
+	}
+	if scimErr := h.checkUniqueness(ctx, user.ID, userName, email); scimErr != nil {
+		return nil, scimErr
+	}
+
+	provision := models.ProvisionUserParams{Profile: params, Email: email}
+	if resource.Password != "" {
+		if provision.EncryptedPassword, scimErr = h.encryptPassword(resource.Password, resource.UserName, resource.PrimaryEmail()); scimErr != nil {
+			return nil, scimErr
+		}
+	}
+
+	active := resource.Active == nil || *resource.Active
+	switch {
+	case active && user.Status != models.UserStatusActive:
+		provision.Status = models.UserStatusActive
+	case !active && user.Status == models.UserStatusActive:
+		provision.Status = models.UserStatusSuspended
+	}
+
+	updated, err := h.userRepository.ProvisionUser(ctx, user.ID, provision)
+	if err != nil {
+		return nil, provisionError(user.ID, err)
+	}
+	return updated, nil
+}
+
+// provisionError maps the errors of ProvisionUser. Accounts that are not
+// the organization's alone, like those of global admins, are refused.
+func provisionError(userID uuid.UUID, err error) *scim.Error {
+	switch {
+	case errors.Is(err, query.ErrAdminUser):
+		return scim.NewError(http.StatusForbidden, "", "global admins cannot be managed through SCIM")
+	case errors.Is(err, query.ErrSharedUser):
+		return scim.NewError(http.StatusForbidden, "", "users who also belong to another organization cannot be managed through SCIM")
+	case errors.Is(err, sql.ErrNoRows):
+		return notFound("User", userID.String())
+	}
+	return scimUserError(err)
+}
+
+// checkUniqueness reports a conflict when another user, in any
+// organization, already has the username or email. Empty values are not
+// checked.
+func (h *SCIMHandler) checkUniqueness(ctx context.Context, userID uuid.UUID, userName, email string) *scim.Error {
+	if userName != "" {
+		users, _, err := h.allUsers.FindUsers(ctx, []query.Condition{
+			{Column: "username", Operator: query.OpEqual, Value: userName},
+			{Column: "id", Operator: query.OpNotEqual, Value: userID.String()},
+		}, 0, 1)
+		if err != nil {
+			return scim.NewError(http.StatusInternalServerError, "", err.Error())
+		}
+		if len(users) > 0 {
+			return scim.NewError(http.StatusConflict, scim.ErrUniqueness, fmt.Sprintf("userName %s is already in use", userName))
+		}
+	}
+
+	if email != "" {
+		user, err := h.allUsers.GetUserByEmail(ctx, email)
+		if err == nil && user.ID != userID {
+			return scim.NewError(http.StatusConflict, scim.ErrUniqueness, query.ErrEmailTaken.Error())
+		}
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return scim.NewError(http.StatusInternalServerError, "", err.Error())
+		}
+	}
+	return nil
+}
+
+// scimUserError reports a username or email address another request took
+// since checkUniqueness as a uniqueness error.
+func scimUserError(err error) *scim.Error {
+	var duplicate *query.DuplicateUserError
+	if errors.As(err, &duplicate) {
+		return scim.NewError(http.StatusConflict, scim.ErrUniqueness, duplicate.Error())
+	}
+	return scim.NewError(http.StatusInternalServerError, "", err.Error())
+}
+
+// userParams maps the resource onto the profile columns. Every member is
+// set, so a missing name clears the stored one.
+func userParams(resource *scim.User) models.UpdateUserParams {
+	params := models.UpdateUserParams{
+		UserName:  models.NullableString{Set: true, Valid: true, Value: resource.UserName},
+		FirstName: models.NullableString{Set: true},
+		LastName:  models.NullableString{Set: true},
+	}
+	if resource.Name != nil {
+		if resource.Name.GivenName != "" {
+			params.FirstName = models.NullableString{Set: true, Valid: true, Value: resource.Name.GivenName}
+		}
+		if resource.Name.FamilyName != "" {
+			params.LastName = models.NullableString{Set: true, Valid: true, Value: resource.Name.FamilyName}
+		}
+	}
+	return params
+}
+
+func validateUserParams(params models.UpdateUserParams) *scim.Error {
+	errors := params.Validate()
+	if len(errors) == 0 {
+		return nil
+	}
+
+	fields := make([]string, 0, len(errors))
+	for field := range errors {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	details := make([]string, 0, len(fields))
+	for _, field := range fields {
+		details = append(details, errors[field])
+	}
+	return scim.NewError(http.StatusBadRequest, scim.ErrInvalidValue, strings.Join(details, "; "))
+}
+
+func resourceEmail(resource *scim.User) (string, *scim.Error) {
+	email := models.NormalizeEmail(resource.PrimaryEmail())
+	if !models.IsEmailValid(email) {
+		return "", scim.NewError(http.StatusBadRequest, scim.ErrInvalidValue, fmt.Sprintf("email %s is invalid", email))
+	}
+	return email, nil
+}
+
+// encryptPassword hashes a password set by the client once the password
+// policy accepts it.
+func (h *SCIMHandler) encryptPassword(password string, userInputs ...string) (string, *scim.Error) {
+	if problems := h.passwordPolicy.Check(password, userInputs...); len(problems) > 0 {
+		return "", scim.NewError(http.StatusBadRequest, scim.ErrInvalidValue, strings.Join(problems, "; "))
+	}
+	encryptedPassword, err := models.EncryptPassword(password)
+	if err != nil {
+		return "", scim.NewError(http.StatusInternalServerError, "", err.Error())
+	}
+	return encryptedPassword, nil
+}
+
+// patchUser applies one PATCH operation to the resource. Attributes this
+// server does not store, such as externalId or extension schemas, are
+// ignored like unknown members of a POST or PUT body.
+func patchUser(resource *scim.User, op *scim.PatchOperation) *scim.Error {
+	ops, scimErr := op.Attributes()
+	if scimErr != nil {
+		return scimErr
+	}
+
+	for _, op := range ops {
+		path, scimErr := scim.ParsePath(op.Path)
+		if scimErr != nil {
+			return scimErr
+		}
+
+		attribute := path.Attribute
+		if path.Filter != nil || path.SubAttribute != "" {
+			// Filtered paths are only meaningful for the single email this
+			// server keeps, e.g. emails[type eq "work"].value.
+			if attribute != "emails" {
+				return scim.NewError(http.StatusBadRequest, scim.ErrInvalidPath, fmt.Sprintf("unsupported path %q", op.Path))
+			}
+			attribute = "emails.value"
+		}
+
+		switch attribute {
+		case "username":
+			if op.Op == scim.OpRemove {
+				return scim.NewError(http.StatusBadRequest, scim.ErrMutability, "userName is required")
+			}
+			if scimErr := unmarshalValue(op, &resource.UserName); scimErr != nil {
+				return scimErr
+			}
+		case "name":
+			var name scim.Name
+			if op.Op != scim.OpRemove {
+				if scimErr := unmarshalValue(op, &name); scimErr != nil {
+					return scimErr
+				}
+			}
+			if resource.Name == nil || op.Op != scim.OpAdd {
+				resource.Name = &scim.Name{}
+			}
+			if name.GivenName != "" {
+				resource.Name.GivenName = name.GivenName
+			}
+			if name.FamilyName != "" {
+				resource.Name.FamilyName = name.FamilyName
+			}
+		case "name.givenname", "name.familyname":
+			var value string
+			if op.Op != scim.OpRemove {
+				if scimErr := unmarshalValue(op, &value); scimErr != nil {
+					return scimErr
+				}
+			}
+			if resource.Name == nil {
+				resource.Name = &scim.Name{}
+			}
+			if attribute == "name.givenname" {
+				resource.Name.GivenName = value
+			} else {
+				resource.Name.FamilyName = value
+			}
+		case "emails":
+			// Only one address is stored, so add and replace both set the
+			// list and the primary address of the result is kept.
+			if op.Op == scim.OpRemove {
+				return scim.NewError(http.StatusBadRequest, scim.ErrMutability, "an email address is required")
+			}
+			if scimErr := unmarshalValue(op, &resource.Emails); scimErr != nil {
+				return scimErr
+			}
+		case "emails.value":
+			if op.Op == scim.OpRemove {
+				return scim.NewError(http.StatusBadRequest, scim.ErrMutability, "an email address is required")
+			}
+			var email string
+			if scimErr := unmarshalValue(op, &email); scimErr != nil {
+				return scimErr
+			}
+			resource.Emails = []scim.Email{{Value: email, Primary: true}}
+		case "active":
+			active := false
+			if op.Op != scim.OpRemove {
+				var scimErr *scim.Error
+				if active, scimErr = boolValue(op); scimErr != nil {
+					return scimErr
+				}
+			}
+			resource.Active = &active
+		case "password":
+			if op.Op == scim.OpRemove {
+				return scim.NewError(http.StatusBadRequest, scim.ErrMutability, "password cannot be removed")
+			}
+			if scimErr := unmarshalValue(op, &resource.Password); scimErr != nil {
+				return scimErr
+			}
+		case "groups":
+			return scim.NewError(http.StatusBadRequest, scim.ErrMutability, "group membership is changed through the Groups endpoint")
+		}
+	}
+	return nil
+}
+
+func unmarshalValue(op scim.PatchOperation, v interface{}) *scim.Error {
+	if err := json.Unmarshal(op.Value, v); err != nil {
+		return scim.NewError(http.StatusBadRequest, scim.ErrInvalidValue, fmt.Sprintf("invalid value for %s", op.Path))
+	}
+	return nil
+}
+
+// boolValue also accepts "True" and "False" as strings, which some identity
+// providers send for boolean attributes.
+func boolValue(op scim.PatchOperation) (bool, *scim.Error) {
+	var value interface{}
+	if scimErr := unmarshalValue(op, &value); scimErr != nil {
+		return false, scimErr
+	}
+	switch v := value.(type) {
+	case bool:
+		return v, nil
+	case string:
+		if b, err := strconv.ParseBool(v); err == nil {
+			return b, nil
+		}
+	}
+	return false, scim.NewError(http.StatusBadRequest, scim.ErrInvalidValue, fmt.Sprintf("%s needs a boolean value", op.Path))
+}
+
+func (h *SCIMHandler) userResource(ctx context.Context, user *models.User) (*scim.User, error) {
+	groups, err := h.groupRepository.GetUserGroups(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	return h.toSCIMUser(user, groups), nil
+}
+
+func (h *SCIMHandler) toSCIMUser(user *models.User, groups []models.Group) *scim.User {
+	active := user.IsActive()
+	resource := &scim.User{
+		Schemas:  []string{scim.SchemaUser},
+		ID:       user.ID.String(),
+		UserName: user.UserName,
+		Emails:   []scim.Email{{Value: user.Email, Type: "work", Primary: true}},
+		Active:   &active,
+		Meta: &scim.Meta{
+			ResourceType: "User",
+			Created:      user.CreatedAt,
+			LastModified: user.UpdatedAt,
+			Location:     h.location("User", user.ID),
+			Version:      "W/" + userETag(user),
+		},
+	}
+
+	if user.FirstName != nil || user.LastName != nil {
+		resource.Name = &scim.Name{}
+		if user.FirstName != nil {
+			resource.Name.GivenName = *user.FirstName
+		}
+		if user.LastName != nil {
+			resource.Name.FamilyName = *user.LastName
+		}
+	}
+
+	for _, group := range groups {
+		resource.Groups = append(resource.Groups, scim.GroupRef{
+			Value:   group.ID.String(),
+			Display: group.DisplayName,
+			Ref:     h.location("Group", group.ID),
+		})
+	}
+	return resource
+}
+
+func (h *SCIMHandler) writeUser(ctx context.Context, w http.ResponseWriter, statusCode int, user *models.User) {
+	resource, err := h.userResource(ctx, user)
+	if err != nil {
+		writeSCIMError(w, scim.NewError(http.StatusInternalServerError, "", err.Error()))
+		return
+	}
+
+	w.Header().Set("ETag", resource.Meta.Version)
+	writeSCIMResponse(w, statusCode, resource)
+}
@@ -5,8 +5,13 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"log"
+	"mime"
 	"net/http"
+	"strings"
 
+	"github.com/OsagieDG/jwt-based-auth-system/internal/jsonschema"
 	"github.com/OsagieDG/jwt-based-auth-system/internal/models"
 	"github.com/OsagieDG/jwt-based-auth-system/internal/query"
 	"github.com/go-chi/chi/v5"
@@ -14,13 +19,27 @@ import (
 )
 
 type UserHandler struct {
-	DB             *sql.DB
-	userRepository query.UserRespository
+	DB                *sql.DB
+	userRepository    query.UserRespository
+	emailVerification *EmailVerificationHandler
+	mfa               *MFAHandler
+	passwordPolicy    models.PasswordPolicy
+	// metadataSchema validates user metadata on create and update. Without
+	// one any object is accepted in either section.
+	metadataSchema *jsonschema.Schema
+	// hideAdminMetadata leaves the admin section of the metadata out of the
+	// users returned and refuses filters on it, for callers who are not
+	// global admins.
+	hideAdminMetadata bool
 }
 
-func NewUserHandler(userRepository query.UserRespository) *UserHandler {
+func NewUserHandler(userRepository query.UserRespository, emailVerification *EmailVerificationHandler, mfa *MFAHandler, passwordPolicy models.PasswordPolicy, metadataSchema *jsonschema.Schema) *UserHandler {
 	return &UserHandler{
-		userRepository: userRepository,
+		userRepository:    userRepository,
+		emailVerification: emailVerification,
+		mfa:               mfa,
+		passwordPolicy:    passwordPolicy,
+		metadataSchema:    metadataSchema,
 	}
 }
 
@@ -30,9 +49,12 @@ func (h *UserHandler) HandleCreateUser(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 		return
 	}
-	if errors := params.Validate(); len(errors) > 0 {
-		writeJSONResponse(w, http.StatusBadRequest, map[string]string{
-			"error": "invalid parameters",
+	params.Email = models.NormalizeEmail(params.Email)
+	params.UserName = models.NormalizeUserName(params.UserName)
+	if errors := params.Validate(h.passwordPolicy); len(errors) > 0 {
+		writeJSONResponse(w, http.StatusBadRequest, map[string]interface{}{
+			"error":  "invalid parameters",
+			"fields": errors,
 		})
 		return
 	}
@@ -42,17 +64,47 @@ func (h *UserHandler) HandleCreateUser(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if errors := h.metadataErrors(&user.Metadata); len(errors) > 0 {
+		writeJSONResponse(w, http.StatusBadRequest, map[string]interface{}{
+			"error":  "invalid parameters",
+			"fields": errors,
+		})
+		return
+	}
 
 	_, err = h.userRepository.InsertUser(context.Background(), user)
 	if err != nil {
+		if writeDuplicateUser(w, err) {
+			return
+		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	writeJSONResponse(w, http.StatusCreated, map[string]string{"message": "User created successfully"})
+	// The account exists at this point, so a mail failure is only logged; the
+	// user can ask for a new link through the resend endpoint.
+	if err := h.emailVerification.SendVerification(context.Background(), user); err != nil {
+		log.Printf("failed to send verification email to %s: %v", user.Email, err)
+	}
+
+	writeJSONResponse(w, http.StatusCreated, map[string]string{"message": "User created successfully, please verify your email address"})
 }
 
+// HandleUserUpdate applies a JSON Merge Patch (RFC 7396) to the user. Members
+// left out of the document are not changed and members set to null are
+// cleared; the same semantics are used for both PUT and PATCH. Only the user
+// section of the metadata can be changed this way.
 func (h *UserHandler) HandleUserUpdate(w http.ResponseWriter, r *http.Request) {
+	h.updateUser(w, r, false)
+}
+
+// HandleAdminUserUpdate is HandleUserUpdate for admins, who can also change
+// the admin section of the metadata.
+func (h *UserHandler) HandleAdminUserUpdate(w http.ResponseWriter, r *http.Request) {
+	h.updateUser(w, r, true)
+}
+
+func (h *UserHandler) updateUser(w http.ResponseWriter, r *http.Request, allowAdminMetadata bool) {
 	var (
 		param     models.UpdateUserParams
 		userIDStr = chi.URLParam(r, "userID")
@@ -64,21 +116,103 @@ func (h *UserHandler) HandleUserUpdate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&param); err != nil {
-		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "" && mediaType != "application/json" && mediaType != "application/merge-patch+json" {
+		http.Error(w, http.StatusText(http.StatusUnsupportedMediaType), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&param); err != nil {
+		writeJSONResponse(w, http.StatusBadRequest, map[string]string{
+			"error": "request body must be a JSON object with known user fields",
+		})
+		return
+	}
+	param.UserName.Value = models.NormalizeUserName(param.UserName.Value)
+	if errors := param.Validate(); len(errors) > 0 {
+		writeJSONResponse(w, http.StatusBadRequest, map[string]interface{}{
+			"error":  "invalid parameters",
+			"fields": errors,
+		})
+		return
+	}
+
+	version, ok := h.ifMatchVersion(w, r, userID)
+	if !ok {
 		return
 	}
 
-	_, err = h.userRepository.UpdateUserByID(context.Background(), userID, param)
+	if param.Metadata != nil {
+		if version, ok = h.mergeMetadata(w, userID, &param, version, allowAdminMetadata); !ok {
+			return
+		}
+	}
+
+	user, err := h.userRepository.UpdateUserByID(context.Background(), userID, param, version)
 	if err != nil {
+		if writeDuplicateUser(w, err) {
+			return
+		}
+		if errors.Is(err, query.ErrVersionMismatch) {
+			http.Error(w, http.StatusText(http.StatusPreconditionFailed), http.StatusPreconditionFailed)
+			return
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			writeJSONResponse(w, http.StatusNotFound, map[string]string{
+				"error": "not found",
+			})
+			return
+		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	writeJSONResponse(w, http.StatusOK, map[string]string{"message": "User details has been updated"})
+	h.redact(user)
+	w.Header().Set("ETag", userETag(user))
+	writeJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"message": "User details has been updated",
+		"data":    user,
+	})
 }
 
+// HandleDeleteUser soft deletes the user by marking it pending deletion. The
+// row is only removed by the purge job once the grace period has passed, and
+// can be restored by an admin until then.
 func (h *UserHandler) HandleDeleteUser(w http.ResponseWriter, r *http.Request) {
+	h.changeStatus(w, r, models.UserStatusPendingDeletion, "User scheduled for deletion")
+}
+
+// HandleSuspendUser is an admin action that blocks the user from logging in.
+func (h *UserHandler) HandleSuspendUser(w http.ResponseWriter, r *http.Request) {
+	h.changeStatus(w, r, models.UserStatusSuspended, "User suspended")
+}
+
+// HandleRestoreUser is an admin action that reactivates a suspended,
+// deactivated or not yet purged user.
+func (h *UserHandler) HandleRestoreUser(w http.ResponseWriter, r *http.Request) {
+	h.changeStatus(w, r, models.UserStatusActive, "User restored")
+}
+
+// HandleDeactivateSelf lets the logged in user deactivate their own account.
+func (h *UserHandler) HandleDeactivateSelf(w http.ResponseWriter, r *http.Request) {
+	id, ok := sessionUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	_, err := h.userRepository.SetUserStatus(context.Background(), id, models.UserStatusDeactivated, &id, 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, map[string]string{"message": "Account deactivated"})
+}
+
+func (h *UserHandler) changeStatus(w http.ResponseWriter, r *http.Request, status, message string) {
 	userIDStr := chi.URLParam(r, "userID")
 
 	userID, err := uuid.Parse(userIDStr)
@@ -87,13 +221,34 @@ func (h *UserHandler) HandleDeleteUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = h.userRepository.DeleteUserByID(context.Background(), userID)
+	version, ok := h.ifMatchVersion(w, r, userID)
+	if !ok {
+		return
+	}
+
+	var actorID *uuid.UUID
+	if id, ok := sessionUserID(r); ok {
+		actorID = &id
+	}
+
+	user, err := h.userRepository.SetUserStatus(context.Background(), userID, status, actorID, version)
 	if err != nil {
+		if errors.Is(err, query.ErrVersionMismatch) {
+			http.Error(w, http.StatusText(http.StatusPreconditionFailed), http.StatusPreconditionFailed)
+			return
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			writeJSONResponse(w, http.StatusNotFound, map[string]string{
+				"error": "not found",
+			})
+			return
+		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	writeJSONResponse(w, http.StatusOK, map[string]string{"message": "User deleted successfully"})
+	w.Header().Set("ETag", userETag(user))
+	writeJSONResponse(w, http.StatusOK, map[string]string{"message": message})
 }
 
 func (h *UserHandler) HandleFetchUserByID(w http.ResponseWriter, r *http.Request) {
@@ -116,15 +271,166 @@ func (h *UserHandler) HandleFetchUserByID(w http.ResponseWriter, r *http.Request
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	h.redact(user)
+
+	etag := userETag(user)
+	w.Header().Set("ETag", etag)
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" && etagListContains(ifNoneMatch, etag, true) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
 
 	writeJSONResponse(w, http.StatusOK, map[string]interface{}{"data": user})
 }
 
+// HandleFetchProfile returns the logged in user together with the state of
+// their second factors, which is private to them.
+func (h *UserHandler) HandleFetchProfile(w http.ResponseWriter, r *http.Request) {
+	id, ok := sessionUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := h.userRepository.GetUserByID(context.Background(), id)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	mfa, err := h.mfa.Status(context.Background(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// No ETag here, as the MFA status changes without the user's version.
+	// The version is part of the data for conditional updates.
+	writeJSONResponse(w, http.StatusOK, map[string]interface{}{"data": user, "mfa": mfa})
+}
+
+// HandleFetchUsers lists the users, filtered by exact (case-insensitive)
+// matches on the query parameters, such as ?status=active or
+// ?metadata.user.department=sales for a metadata field.
 func (h *UserHandler) HandleFetchUsers(w http.ResponseWriter, r *http.Request) {
-	users, err := h.userRepository.GetUsers(context.Background())
+	var conditions []query.Condition
+	for name, values := range r.URL.Query() {
+		path, isMetadata := strings.CutPrefix(name, "metadata.")
+		isMetadata = isMetadata && models.IsMetadataPath(path) && !(h.hideAdminMetadata && models.IsAdminMetadataPath(path))
+		if !filterableUserFields[name] && !isMetadata {
+			writeJSONResponse(w, http.StatusBadRequest, map[string]string{
+				"error": fmt.Sprintf("users cannot be filtered by %s", name),
+			})
+			return
+		}
+		for _, value := range values {
+			conditions = append(conditions, query.Condition{Column: name, Operator: query.OpEqual, Value: value})
+		}
+	}
+
+	users, err := h.userRepository.GetUsers(context.Background(), conditions)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	for i := range users {
+		h.redact(&users[i])
+	}
 	writeJSONResponse(w, http.StatusOK, map[string]interface{}{"data": users})
 }
+
+// redact removes what the caller may not see from a user about to be
+// returned.
+func (h *UserHandler) redact(user *models.User) {
+	if h.hideAdminMetadata {
+		user.Metadata.Admin = nil
+	}
+}
+
+// writeDuplicateUser answers 409 Conflict naming the taken field if err is a
+// query.DuplicateUserError, and reports whether it did.
+func writeDuplicateUser(w http.ResponseWriter, err error) bool {
+	var duplicate *query.DuplicateUserError
+	if !errors.As(err, &duplicate) {
+		return false
+	}
+
+	writeJSONResponse(w, http.StatusConflict, map[string]string{
+		"error": duplicate.Error(),
+		"field": duplicate.Field,
+	})
+	return true
+}
+
+// filterableUserFields lists the query parameters HandleFetchUsers filters
+// on besides metadata fields.
+var filterableUserFields = map[string]bool{
+	"username":   true,
+	"email":      true,
+	"first_name": true,
+	"last_name":  true,
+	"status":     true,
+}
+
+// mergeMetadata applies the metadata patch of param to the stored metadata
+// and validates the result. It returns the version the metadata was read
+// at, so the update fails instead of overwriting a concurrent change. When
+// it returns false the response has already been written.
+func (h *UserHandler) mergeMetadata(w http.ResponseWriter, userID uuid.UUID, param *models.UpdateUserParams, version int64, allowAdmin bool) (int64, bool) {
+	user, err := h.userRepository.GetUserByID(context.Background(), userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeJSONResponse(w, http.StatusNotFound, map[string]string{
+				"error": "not found",
+			})
+			return 0, false
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return 0, false
+	}
+	if version != 0 && user.Version != version {
+		http.Error(w, http.StatusText(http.StatusPreconditionFailed), http.StatusPreconditionFailed)
+		return 0, false
+	}
+
+	metadata, err := user.Metadata.Apply(param.Metadata, allowAdmin)
+	if err != nil {
+		if errors.Is(err, models.ErrAdminMetadata) {
+			writeJSONResponse(w, http.StatusForbidden, map[string]string{"error": err.Error()})
+			return 0, false
+		}
+		writeJSONResponse(w, http.StatusBadRequest, map[string]interface{}{
+			"error":  "invalid parameters",
+			"fields": map[string]string{"metadata": err.Error()},
+		})
+		return 0, false
+	}
+	if errors := h.metadataErrors(metadata); len(errors) > 0 {
+		writeJSONResponse(w, http.StatusBadRequest, map[string]interface{}{
+			"error":  "invalid parameters",
+			"fields": errors,
+		})
+		return 0, false
+	}
+
+	param.MergedMetadata = metadata
+	return user.Version, true
+}
+
+// metadataErrors validates metadata against the configured schema. The
+// problems are keyed by field, such as "metadata.user.locale".
+func (h *UserHandler) metadataErrors(metadata *models.UserMetadata) map[string]string {
+	errors := map[string]string{}
+	if h.metadataSchema == nil {
+		return errors
+	}
+
+	for path, message := range h.metadataSchema.Validate(metadata.Document()) {
+		field := "metadata"
+		if path != "" {
+			field += "." + path
+		}
+		errors[field] = message
+	}
+	return errors
+}
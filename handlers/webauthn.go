@@ -0,0 +1,472 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/OsagieDG/jwt-based-auth-system/internal/models"
+	"github.com/OsagieDG/jwt-based-auth-system/internal/query"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+)
+
+const (
+	webauthnChallengeTTL      = 5 * time.Minute
+	defaultWebAuthnCredential = "Security key"
+)
+
+var errWebAuthnFailed = errors.New("security key verification failed")
+
+// webauthnUser adapts a user and their registered credentials to the
+// interface the WebAuthn library works with. The user handle is the raw
+// bytes of the user ID.
+type webauthnUser struct {
+	user        *models.User
+	credentials []webauthn.Credential
+}
+
+func (u *webauthnUser) WebAuthnID() []byte {
+	id := u.user.ID
+	return id[:]
+}
+
+func (u *webauthnUser) WebAuthnName() string {
+	return u.user.Email
+}
+
+func (u *webauthnUser) WebAuthnDisplayName() string {
+	return u.user.UserName
+}
+
+func (u *webauthnUser) WebAuthnCredentials() []webauthn.Credential {
+	return u.credentials
+}
+
+type WebAuthnHandler struct {
+	webAuthn           *webauthn.WebAuthn
+	userRepository     query.UserRespository
+	webauthnRepository query.WebAuthnRepository
+	auditRepository    query.AuditRepository
+	session            *SessionHandler
+}
+
+func NewWebAuthnHandler(config *webauthn.Config, userRepository query.UserRespository, webauthnRepository query.WebAuthnRepository, auditRepository query.AuditRepository, session *SessionHandler) (*WebAuthnHandler, error) {
+	webAuthn, err := webauthn.New(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &WebAuthnHandler{
+		webAuthn:           webAuthn,
+		userRepository:     userRepository,
+		webauthnRepository: webauthnRepository,
+		auditRepository:    auditRepository,
+		session:            session,
+	}, nil
+}
+
+// HandleBeginRegistration starts registering a new authenticator for the
+// logged in user. Discoverable credentials are preferred so the same key can
+// later be used for passwordless login, which is why the body has to confirm
+// the user's identity like disabling TOTP does.
+func (h *WebAuthnHandler) HandleBeginRegistration(w http.ResponseWriter, r *http.Request) {
+	id, ok := sessionUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var params models.ConfirmIdentityParams
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.loadUser(context.Background(), id)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !h.confirmIdentity(w, user.user, params) {
+		return
+	}
+
+	creation, sessionData, err := h.webAuthn.BeginRegistration(user,
+		webauthn.WithExclusions(webauthn.Credentials(user.credentials).CredentialDescriptors()),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementPreferred),
+	)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.writeChallenge(w, &user.user.ID, models.WebAuthnRegistration, sessionData, creation)
+}
+
+// HandleFinishRegistration expects the attestation response from the browser
+// as the body and the challenge_id (and optionally a name) in the query.
+func (h *WebAuthnHandler) HandleFinishRegistration(w http.ResponseWriter, r *http.Request) {
+	id, ok := sessionUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	sessionData, challenge, err := h.consumeChallenge(r, models.WebAuthnRegistration)
+	if err != nil || challenge.UserID == nil || *challenge.UserID != id {
+		writeJSONResponse(w, http.StatusBadRequest, map[string]string{"error": query.ErrInvalidToken.Error()})
+		return
+	}
+
+	user, err := h.loadUser(context.Background(), id)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	credential, err := h.webAuthn.FinishRegistration(user, *sessionData, r)
+	if err != nil {
+		writeJSONResponse(w, http.StatusBadRequest, map[string]string{"error": errWebAuthnFailed.Error()})
+		return
+	}
+
+	data, err := json.Marshal(credential)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	name := r.URL.Query().Get("name")
+	if name == "" || len(name) > 50 {
+		name = defaultWebAuthnCredential
+	}
+
+	stored := &models.WebAuthnCredential{
+		ID:           models.NewUUID(),
+		UserID:       id,
+		CredentialID: credential.ID,
+		Name:         name,
+		SignCount:    credential.Authenticator.SignCount,
+		Data:         data,
+	}
+	if err := h.webauthnRepository.SaveCredential(context.Background(), stored); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusCreated, map[string]interface{}{"data": stored})
+}
+
+func (h *WebAuthnHandler) HandleListCredentials(w http.ResponseWriter, r *http.Request) {
+	id, ok := sessionUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	credentials, err := h.webauthnRepository.GetCredentialsByUserID(context.Background(), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, map[string]interface{}{"data": credentials})
+}
+
+// HandleDeleteCredential expects the same confirmation of the user's identity
+// in the body as HandleBeginRegistration.
+func (h *WebAuthnHandler) HandleDeleteCredential(w http.ResponseWriter, r *http.Request) {
+	id, ok := sessionUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	credentialID, err := uuid.Parse(chi.URLParam(r, "credentialID"))
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	var params models.ConfirmIdentityParams
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.userRepository.GetUserByID(context.Background(), id)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !h.confirmIdentity(w, user, params) {
+		return
+	}
+
+	if err := h.webauthnRepository.DeleteCredential(context.Background(), id, credentialID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeJSONResponse(w, http.StatusNotFound, map[string]string{"error": "not found"})
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, map[string]string{"message": "Security key removed"})
+}
+
+// HandleBeginSecondFactor starts an assertion for a user who passed the
+// password step of Login and received an MFA challenge token.
+func (h *WebAuthnHandler) HandleBeginSecondFactor(w http.ResponseWriter, r *http.Request) {
+	var params models.WebAuthnBeginLoginParams
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	claims, err := h.session.validateMFAChallenge(params.MFAToken)
+	if err != nil {
+		http.Error(w, "Invalid or expired MFA challenge", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := h.loadUser(context.Background(), claims.UserID)
+	if err != nil {
+		http.Error(w, "Invalid or expired MFA challenge", http.StatusUnauthorized)
+		return
+	}
+
+	assertion, sessionData, err := h.webAuthn.BeginLogin(user)
+	if err != nil {
+		writeJSONResponse(w, http.StatusBadRequest, map[string]string{"error": "no security keys registered"})
+		return
+	}
+
+	h.writeChallenge(w, &user.user.ID, models.WebAuthnSecondFactor, sessionData, assertion)
+}
+
+// HandleFinishSecondFactor expects the assertion as the body, with the
+// mfa_token next to its fields as for /login/mfa, and the challenge_id in the
+// query. The token is kept out of the URL so it does not end up in logs.
+func (h *WebAuthnHandler) HandleFinishSecondFactor(w http.ResponseWriter, r *http.Request) {
+	mfaToken, err := mfaTokenFromBody(r)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	claims, err := h.session.validateMFAChallenge(mfaToken)
+	if err != nil {
+		http.Error(w, "Invalid or expired MFA challenge", http.StatusUnauthorized)
+		return
+	}
+
+	sessionData, challenge, err := h.consumeChallenge(r, models.WebAuthnSecondFactor)
+	if err != nil || challenge.UserID == nil || *challenge.UserID != claims.UserID {
+		writeJSONResponse(w, http.StatusBadRequest, map[string]string{"error": query.ErrInvalidToken.Error()})
+		return
+	}
+
+	user, err := h.loadUser(context.Background(), claims.UserID)
+	if err != nil {
+		http.Error(w, "Invalid or expired MFA challenge", http.StatusUnauthorized)
+		return
+	}
+
+	credential, err := h.webAuthn.FinishLogin(user, *sessionData, r)
+	if err != nil {
+		writeJSONResponse(w, http.StatusUnauthorized, map[string]string{"error": errWebAuthnFailed.Error()})
+		return
+	}
+
+	h.completeLogin(w, user, credential)
+}
+
+// HandleBeginPasswordless starts a login with a discoverable credential, where
+// the authenticator tells us who the user is.
+func (h *WebAuthnHandler) HandleBeginPasswordless(w http.ResponseWriter, r *http.Request) {
+	assertion, sessionData, err := h.webAuthn.BeginDiscoverableLogin(
+		webauthn.WithUserVerification(protocol.VerificationRequired),
+	)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.writeChallenge(w, nil, models.WebAuthnPasswordless, sessionData, assertion)
+}
+
+// HandleFinishPasswordless expects the assertion as the body and the
+// challenge_id in the query.
+func (h *WebAuthnHandler) HandleFinishPasswordless(w http.ResponseWriter, r *http.Request) {
+	sessionData, _, err := h.consumeChallenge(r, models.WebAuthnPasswordless)
+	if err != nil {
+		writeJSONResponse(w, http.StatusBadRequest, map[string]string{"error": query.ErrInvalidToken.Error()})
+		return
+	}
+
+	var user *webauthnUser
+	credential, err := h.webAuthn.FinishDiscoverableLogin(func(rawID, userHandle []byte) (webauthn.User, error) {
+		id, err := uuid.FromBytes(userHandle)
+		if err != nil {
+			return nil, err
+		}
+		user, err = h.loadUser(context.Background(), id)
+		return user, err
+	}, *sessionData, r)
+	if err != nil || user == nil {
+		writeJSONResponse(w, http.StatusUnauthorized, map[string]string{"error": errWebAuthnFailed.Error()})
+		return
+	}
+
+	h.completeLogin(w, user, credential)
+}
+
+// confirmIdentity answers with 403 unless the params confirm the identity of
+// the logged in user. When it returns false the response has been written.
+func (h *WebAuthnHandler) confirmIdentity(w http.ResponseWriter, user *models.User, params models.ConfirmIdentityParams) bool {
+	err := h.session.mfa.ConfirmIdentity(context.Background(), user, params)
+	if err == nil {
+		return true
+	}
+	if errors.Is(err, errIncorrectPassword) || errors.Is(err, errInvalidMFACode) {
+		writeJSONResponse(w, http.StatusForbidden, map[string]string{"error": err.Error()})
+		return false
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+	return false
+}
+
+// mfaTokenFromBody reads the mfa_token sent along with an assertion and puts
+// the body back for the WebAuthn library, which ignores the extra field.
+func mfaTokenFromBody(r *http.Request) (string, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return "", err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	var params struct {
+		MFAToken string `json:"mfa_token"`
+	}
+	if err := json.Unmarshal(body, &params); err != nil {
+		return "", err
+	}
+	return params.MFAToken, nil
+}
+
+// completeLogin rejects assertions from authenticators that look cloned,
+// stores the new signature counter and issues the session.
+func (h *WebAuthnHandler) completeLogin(w http.ResponseWriter, user *webauthnUser, credential *webauthn.Credential) {
+	if credential.Authenticator.CloneWarning {
+		event := models.NewAuditEvent(user.user.ID, nil, models.AuditWebAuthnCloneWarning, map[string]interface{}{
+			"sign_count": credential.Authenticator.SignCount,
+		})
+		if err := h.auditRepository.RecordEvent(context.Background(), event); err != nil {
+			log.Printf("failed to record clone warning for %s: %v", user.user.ID, err)
+		}
+		writeJSONResponse(w, http.StatusUnauthorized, map[string]string{"error": errWebAuthnFailed.Error()})
+		return
+	}
+
+	data, err := json.Marshal(credential)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := h.webauthnRepository.UpdateCredentialUsage(context.Background(), user.user.ID, credential.ID, credential.Authenticator.SignCount, data); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeJSONResponse(w, http.StatusUnauthorized, map[string]string{"error": errWebAuthnFailed.Error()})
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !h.session.canLogin(w, user.user) {
+		return
+	}
+
+	if err := h.session.issueSession(w, user.user); err != nil {
+		http.Error(w, "Failed to save refresh token", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, map[string]string{"message": "Login successful"})
+}
+
+func (h *WebAuthnHandler) loadUser(ctx context.Context, id uuid.UUID) (*webauthnUser, error) {
+	user, err := h.userRepository.GetUserByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	stored, err := h.webauthnRepository.GetCredentialsByUserID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	credentials := make([]webauthn.Credential, 0, len(stored))
+	for _, s := range stored {
+		var credential webauthn.Credential
+		if err := json.Unmarshal(s.Data, &credential); err != nil {
+			return nil, fmt.Errorf("failed to decode credential %s: %w", s.ID, err)
+		}
+		credentials = append(credentials, credential)
+	}
+
+	return &webauthnUser{user: user, credentials: credentials}, nil
+}
+
+func (h *WebAuthnHandler) writeChallenge(w http.ResponseWriter, userID *uuid.UUID, purpose string, sessionData *webauthn.SessionData, options interface{}) {
+	data, err := json.Marshal(sessionData)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	challenge := &models.WebAuthnChallenge{
+		ID:        models.NewUUID(),
+		UserID:    userID,
+		Purpose:   purpose,
+		Data:      data,
+		ExpiresAt: time.Now().Add(webauthnChallengeTTL),
+	}
+	if err := h.webauthnRepository.SaveChallenge(context.Background(), challenge); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"challenge_id": challenge.ID,
+		"options":      options,
+	})
+}
+
+func (h *WebAuthnHandler) consumeChallenge(r *http.Request, purpose string) (*webauthn.SessionData, *models.WebAuthnChallenge, error) {
+	id, err := uuid.Parse(r.URL.Query().Get("challenge_id"))
+	if err != nil {
+		return nil, nil, query.ErrInvalidToken
+	}
+
+	challenge, err := h.webauthnRepository.ConsumeChallenge(context.Background(), id, purpose)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var sessionData webauthn.SessionData
+	if err := json.Unmarshal(challenge.Data, &sessionData); err != nil {
+		return nil, nil, err
+	}
+	return &sessionData, challenge, nil
+}
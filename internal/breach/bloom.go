@@ -0,0 +1,134 @@
+package breach
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// filterMagic starts every filter file, followed by the number of hash
+// functions, the number of bits and the number of entries, then the bits.
+// All numbers are little endian.
+var filterMagic = [8]byte{'P', 'W', 'B', 'L', 'O', 'O', 'M', 1}
+
+// Filter is a Bloom filter of password SHA-1 hashes. It never misses a
+// password that was added, but reports a few others as breached too, at the
+// rate it was sized for.
+type Filter struct {
+	bits    []uint64
+	m       uint64
+	k       uint32
+	entries uint64
+}
+
+// NewFilter returns an empty filter sized for n hashes at the given false
+// positive rate.
+func NewFilter(n uint64, falsePositiveRate float64) (*Filter, error) {
+	if n == 0 {
+		n = 1
+	}
+	if falsePositiveRate <= 0 || falsePositiveRate >= 1 {
+		return nil, errors.New("breach: false positive rate should be between 0 and 1")
+	}
+
+	m := uint64(math.Ceil(-float64(n) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2)))
+	m = (m + 63) / 64 * 64
+	k := uint32(math.Max(1, math.Round(float64(m)/float64(n)*math.Ln2)))
+	return &Filter{bits: make([]uint64, m/64), m: m, k: k}, nil
+}
+
+// Entries returns the number of hashes added to the filter.
+func (f *Filter) Entries() uint64 {
+	return f.entries
+}
+
+// Add adds the SHA-1 hash of a password.
+func (f *Filter) Add(sum [sha1.Size]byte) {
+	h1, h2 := filterHashes(sum)
+	for i := uint64(0); i < uint64(f.k); i++ {
+		bit := (h1 + i*h2) % f.m
+		f.bits[bit/64] |= 1 << (bit % 64)
+	}
+	f.entries++
+}
+
+// Contains reports whether the password is probably in the filter.
+func (f *Filter) Contains(password string) bool {
+	h1, h2 := filterHashes(Hash(password))
+	for i := uint64(0); i < uint64(f.k); i++ {
+		bit := (h1 + i*h2) % f.m
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// filterHashes derives the two hashes that double hashing combines into the
+// k bit positions. SHA-1 output is already uniform, so its bytes are used
+// directly. The second hash is odd so it never repeats a position early.
+func filterHashes(sum [sha1.Size]byte) (uint64, uint64) {
+	return binary.LittleEndian.Uint64(sum[0:8]), binary.LittleEndian.Uint64(sum[8:16]) | 1
+}
+
+// WriteTo writes the filter in the format ReadFilter reads.
+func (f *Filter) WriteTo(w io.Writer) (int64, error) {
+	bw := bufio.NewWriter(w)
+
+	header := make([]byte, 0, 28)
+	header = append(header, filterMagic[:]...)
+	header = binary.LittleEndian.AppendUint32(header, f.k)
+	header = binary.LittleEndian.AppendUint64(header, f.m)
+	header = binary.LittleEndian.AppendUint64(header, f.entries)
+	if _, err := bw.Write(header); err != nil {
+		return 0, err
+	}
+
+	var word [8]byte
+	for _, bits := range f.bits {
+		binary.LittleEndian.PutUint64(word[:], bits)
+		if _, err := bw.Write(word[:]); err != nil {
+			return 0, err
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return 0, err
+	}
+	return int64(len(header)) + int64(len(f.bits))*8, nil
+}
+
+// ReadFilter reads a filter written by WriteTo.
+func ReadFilter(r io.Reader) (*Filter, error) {
+	br := bufio.NewReader(r)
+
+	var header [28]byte
+	if _, err := io.ReadFull(br, header[:]); err != nil {
+		return nil, fmt.Errorf("breach: failed to read filter header: %w", err)
+	}
+	if [8]byte(header[:8]) != filterMagic {
+		return nil, errors.New("breach: not a password filter file")
+	}
+
+	f := &Filter{
+		k:       binary.LittleEndian.Uint32(header[8:12]),
+		m:       binary.LittleEndian.Uint64(header[12:20]),
+		entries: binary.LittleEndian.Uint64(header[20:28]),
+	}
+	if f.k == 0 || f.m == 0 || f.m%64 != 0 {
+		return nil, errors.New("breach: filter header is corrupt")
+	}
+
+	f.bits = make([]uint64, f.m/64)
+	var word [8]byte
+	for i := range f.bits {
+		if _, err := io.ReadFull(br, word[:]); err != nil {
+			return nil, fmt.Errorf("breach: filter is truncated: %w", err)
+		}
+		f.bits[i] = binary.LittleEndian.Uint64(word[:])
+	}
+	return f, nil
+}
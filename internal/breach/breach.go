@@ -0,0 +1,88 @@
+// Package breach screens passwords against the Pwned Passwords corpus of
+// Have I Been Pwned without any network calls. The corpus is read either
+// from a directory of hash prefix files, as the official downloader writes
+// them, or from a Bloom filter built with cmd/breachfilter, which fits the
+// whole corpus into a fraction of the space at the cost of a small rate of
+// false positives.
+package breach
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/OsagieDG/jwt-based-auth-system/internal/models"
+)
+
+// Open loads the corpus at path: a directory is read as a PrefixCorpus and
+// a file as a Filter.
+func Open(path string) (models.BreachedPasswords, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return PrefixCorpus{Dir: path}, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ReadFilter(file)
+}
+
+// Hash returns the SHA-1 of a password, the hash the corpus is keyed by.
+func Hash(password string) [sha1.Size]byte {
+	return sha1.Sum([]byte(password))
+}
+
+// ParseLine parses a "HASH:COUNT" line of the Pwned Passwords SHA-1 file,
+// where HASH is the full hash in hex and COUNT how often the password was
+// seen.
+func ParseLine(line string) ([sha1.Size]byte, int, error) {
+	var sum [sha1.Size]byte
+
+	hash, count, ok := strings.Cut(strings.TrimSpace(line), ":")
+	if !ok {
+		return sum, 0, errors.New("breach: line should be HASH:COUNT")
+	}
+	if len(hash) != 2*sha1.Size {
+		return sum, 0, fmt.Errorf("breach: hash %q is not a SHA-1", hash)
+	}
+	if _, err := hex.Decode(sum[:], []byte(hash)); err != nil {
+		return sum, 0, fmt.Errorf("breach: hash %q is not hex", hash)
+	}
+	n, err := strconv.Atoi(count)
+	if err != nil {
+		return sum, 0, fmt.Errorf("breach: count %q is not a number", count)
+	}
+	return sum, n, nil
+}
+
+// ScanFile calls fn with every hash of a Pwned Passwords SHA-1 file that was
+// seen at least minCount times. Empty lines are skipped.
+func ScanFile(r io.Reader, minCount int, fn func(sum [sha1.Size]byte)) error {
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		sum, count, err := ParseLine(scanner.Text())
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		if count >= minCount {
+			fn(sum)
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,64 @@
+package breach
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// prefixLen is the number of hex digits of the hash that name a range
+// file, as in the k-anonymity range API.
+const prefixLen = 5
+
+// PrefixCorpus is a directory holding one file per hash prefix, named like
+// "21BD1" or "21BD1.txt". Each file lists the remaining hex digits of the
+// hashes starting with that prefix as "SUFFIX:COUNT" lines, the format of
+// the range API. Only the file of the password's prefix is read, so the
+// corpus is never loaded into memory.
+type PrefixCorpus struct {
+	Dir string
+}
+
+// Contains reports whether the password is in the corpus. Padding entries
+// with a count of zero are ignored. A range file that cannot be read is
+// logged and the password let through, so a damaged corpus does not stop
+// users from choosing passwords.
+func (c PrefixCorpus) Contains(password string) bool {
+	sum := Hash(password)
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:prefixLen], hash[prefixLen:]
+
+	file, err := c.open(prefix)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("breach: failed to read range %s: %v", prefix, err)
+		}
+		return false
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lineSuffix, count, ok := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if ok && strings.EqualFold(lineSuffix, suffix) {
+			return count != "0"
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Printf("breach: failed to read range %s: %v", prefix, err)
+	}
+	return false
+}
+
+func (c PrefixCorpus) open(prefix string) (*os.File, error) {
+	file, err := os.Open(filepath.Join(c.Dir, prefix+".txt"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return os.Open(filepath.Join(c.Dir, prefix))
+	}
+	return file, err
+}
@@ -0,0 +1,52 @@
+// Package bulk imports and exports users in CSV and JSON Lines files, for
+// migrating users in from another system or taking them out.
+package bulk
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	FormatCSV   = "csv"
+	FormatJSONL = "jsonl"
+)
+
+// Record is one user in an import or export file. CSV files carry the JSON
+// member names as their header row.
+type Record struct {
+	ID            string     `json:"id,omitempty"`
+	UserName      string     `json:"username"`
+	Email         string     `json:"email"`
+	PasswordHash  string     `json:"password_hash,omitempty"`
+	FirstName     string     `json:"first_name,omitempty"`
+	LastName      string     `json:"last_name,omitempty"`
+	EmailVerified bool       `json:"email_verified"`
+	IsAdmin       bool       `json:"is_admin"`
+	Status        string     `json:"status,omitempty"`
+	CreatedAt     *time.Time `json:"created_at,omitempty"`
+}
+
+// csvColumns is the column order of exported CSV files.
+var csvColumns = []string{
+	"id", "username", "email", "password_hash", "first_name", "last_name",
+	"email_verified", "is_admin", "status", "created_at",
+}
+
+func ParseFormat(format string) (string, error) {
+	switch format {
+	case FormatCSV, FormatJSONL:
+		return format, nil
+	case "ndjson", "jsonlines":
+		return FormatJSONL, nil
+	}
+	return "", fmt.Errorf("unknown format %q, use %s or %s", format, FormatCSV, FormatJSONL)
+}
+
+// ContentType returns the media type of files in the format.
+func ContentType(format string) string {
+	if format == FormatCSV {
+		return "text/csv"
+	}
+	return "application/x-ndjson"
+}
@@ -0,0 +1,99 @@
+package bulk
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/OsagieDG/jwt-based-auth-system/internal/models"
+	"github.com/OsagieDG/jwt-based-auth-system/internal/query"
+)
+
+type ExportOptions struct {
+	Format string
+	// IncludePasswordHashes adds the bcrypt hashes, so the users can keep
+	// their passwords when they are imported elsewhere.
+	IncludePasswordHashes bool
+}
+
+// Export writes every user to w and returns how many were written.
+func Export(ctx context.Context, userRepository query.UserRespository, w io.Writer, options ExportOptions) (int, error) {
+	var (
+		write  func(*Record) error
+		finish = func() error { return nil }
+	)
+
+	switch options.Format {
+	case FormatCSV:
+		writer := csv.NewWriter(w)
+		if err := writer.Write(csvColumns); err != nil {
+			return 0, err
+		}
+		write = func(record *Record) error {
+			return writer.Write(csvRow(record))
+		}
+		finish = func() error {
+			writer.Flush()
+			return writer.Error()
+		}
+	case FormatJSONL:
+		encoder := json.NewEncoder(w)
+		write = func(record *Record) error {
+			return encoder.Encode(record)
+		}
+	default:
+		return 0, fmt.Errorf("unknown format %q", options.Format)
+	}
+
+	count := 0
+	err := userRepository.ExportUsers(ctx, func(user *models.User) error {
+		count++
+		return write(recordFromUser(user, options.IncludePasswordHashes))
+	})
+	if err != nil {
+		return count, err
+	}
+	return count, finish()
+}
+
+func recordFromUser(user *models.User, includePasswordHash bool) *Record {
+	createdAt := user.CreatedAt
+	record := &Record{
+		ID:            user.ID.String(),
+		UserName:      user.UserName,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+		IsAdmin:       user.IsAdmin,
+		Status:        user.Status,
+		CreatedAt:     &createdAt,
+	}
+	if includePasswordHash && user.EncryptedPassword != models.UnusablePassword {
+		record.PasswordHash = user.EncryptedPassword
+	}
+	if user.FirstName != nil {
+		record.FirstName = *user.FirstName
+	}
+	if user.LastName != nil {
+		record.LastName = *user.LastName
+	}
+	return record
+}
+
+func csvRow(record *Record) []string {
+	return []string{
+		record.ID,
+		record.UserName,
+		record.Email,
+		record.PasswordHash,
+		record.FirstName,
+		record.LastName,
+		strconv.FormatBool(record.EmailVerified),
+		strconv.FormatBool(record.IsAdmin),
+		record.Status,
+		record.CreatedAt.UTC().Format(time.RFC3339),
+	}
+}
@@ -0,0 +1,280 @@
+package bulk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/OsagieDG/jwt-based-auth-system/internal/models"
+	"github.com/OsagieDG/jwt-based-auth-system/internal/query"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const DefaultBatchSize = 1000
+
+type ImportOptions struct {
+	Format string
+	// DryRun validates every row, including the checks against existing
+	// users, without storing anything.
+	DryRun bool
+	// ResetPasswords ignores the password hashes in the file. Rows without a
+	// hash always get an unusable password, so the user has to reset it.
+	ResetPasswords bool
+	BatchSize      int
+}
+
+type RowError struct {
+	Row   int    `json:"row"`
+	Email string `json:"email,omitempty"`
+	Error string `json:"error"`
+}
+
+type ImportReport struct {
+	DryRun   bool `json:"dry_run"`
+	Total    int  `json:"total"`
+	Imported int  `json:"imported"`
+	Failed   int  `json:"failed"`
+	// PasswordResets counts the imported users without a usable password.
+	PasswordResets int        `json:"password_resets"`
+	Errors         []RowError `json:"errors"`
+	// ResetRequired lists the users counted in PasswordResets, for callers
+	// that want to send them a reset link.
+	ResetRequired []models.User `json:"-"`
+}
+
+type pendingUser struct {
+	row  int
+	user models.User
+}
+
+// Importer adds users from a file in batches. Invalid rows and rows that
+// clash with existing users are reported and skipped; the rest are stored.
+type Importer struct {
+	userRepository query.UserRespository
+	options        ImportOptions
+
+	report    *ImportReport
+	pending   []pendingUser
+	seenIDs   map[uuid.UUID]bool
+	seenMail  map[string]bool
+	seenNames map[string]bool
+}
+
+func NewImporter(userRepository query.UserRespository, options ImportOptions) *Importer {
+	if options.BatchSize <= 0 {
+		options.BatchSize = DefaultBatchSize
+	}
+	return &Importer{userRepository: userRepository, options: options}
+}
+
+// Import reads the whole file. The returned error is only set when the file
+// could not be read or a batch could not be checked; problems with single
+// rows end up in the report.
+func (im *Importer) Import(ctx context.Context, r io.Reader) (*ImportReport, error) {
+	im.report = &ImportReport{DryRun: im.options.DryRun, Errors: []RowError{}}
+	im.pending = nil
+	im.seenIDs = map[uuid.UUID]bool{}
+	im.seenMail = map[string]bool{}
+	im.seenNames = map[string]bool{}
+
+	records, err := newRecordReader(r, im.options.Format)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		record, row, err := records.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			var rowErr *rowError
+			if !errors.As(err, &rowErr) {
+				return im.report, err
+			}
+			im.report.Total++
+			im.fail(row, "", rowErr.err.Error())
+			continue
+		}
+
+		im.report.Total++
+		user, err := im.userFromRecord(record)
+		if err != nil {
+			im.fail(row, record.Email, err.Error())
+			continue
+		}
+
+		im.pending = append(im.pending, pendingUser{row: row, user: *user})
+		if len(im.pending) >= im.options.BatchSize {
+			if err := im.flush(ctx); err != nil {
+				return im.report, err
+			}
+		}
+	}
+
+	if err := im.flush(ctx); err != nil {
+		return im.report, err
+	}
+
+	sort.Slice(im.report.Errors, func(i, j int) bool {
+		return im.report.Errors[i].Row < im.report.Errors[j].Row
+	})
+	return im.report, nil
+}
+
+func (im *Importer) userFromRecord(record *Record) (*models.User, error) {
+	email := models.NormalizeEmail(record.Email)
+	if !models.IsEmailValid(email) {
+		return nil, fmt.Errorf("email %s is invalid", record.Email)
+	}
+	if im.seenMail[email] {
+		return nil, errors.New("email appears more than once in the file")
+	}
+
+	userName := models.NormalizeUserName(record.UserName)
+	if im.seenNames[strings.ToLower(userName)] {
+		return nil, errors.New("username appears more than once in the file")
+	}
+
+	params := models.UpdateUserParams{
+		UserName:  models.NullableString{Set: true, Valid: true, Value: userName},
+		FirstName: models.NullableString{Set: true, Valid: record.FirstName != "", Value: record.FirstName},
+		LastName:  models.NullableString{Set: true, Valid: record.LastName != "", Value: record.LastName},
+	}
+	if errs := params.Validate(); len(errs) > 0 {
+		return nil, errors.New(joinErrors(errs))
+	}
+
+	user := &models.User{
+		ID:                models.NewUUID(),
+		UserName:          userName,
+		Email:             email,
+		EncryptedPassword: models.UnusablePassword,
+		IsAdmin:           record.IsAdmin,
+		EmailVerified:     record.EmailVerified,
+	}
+	if record.ID != "" {
+		id, err := uuid.Parse(record.ID)
+		if err != nil {
+			return nil, fmt.Errorf("id %s is not a UUID", record.ID)
+		}
+		if im.seenIDs[id] {
+			return nil, errors.New("id appears more than once in the file")
+		}
+		user.ID = id
+	}
+	if params.FirstName.Valid {
+		user.FirstName = &record.FirstName
+	}
+	if params.LastName.Valid {
+		user.LastName = &record.LastName
+	}
+
+	if record.PasswordHash != "" && !im.options.ResetPasswords {
+		if _, err := bcrypt.Cost([]byte(record.PasswordHash)); err != nil {
+			return nil, errors.New("password_hash is not a bcrypt hash")
+		}
+		user.EncryptedPassword = record.PasswordHash
+	}
+
+	im.seenMail[email] = true
+	im.seenNames[strings.ToLower(userName)] = true
+	im.seenIDs[user.ID] = true
+	return user, nil
+}
+
+// flush drops the pending users that already exist and stores the rest with
+// one COPY.
+func (im *Importer) flush(ctx context.Context) error {
+	if len(im.pending) == 0 {
+		return nil
+	}
+	defer func() { im.pending = im.pending[:0] }()
+
+	emails := make([]string, 0, len(im.pending))
+	userNames := make([]string, 0, len(im.pending))
+	ids := make([]uuid.UUID, 0, len(im.pending))
+	for _, p := range im.pending {
+		emails = append(emails, p.user.Email)
+		userNames = append(userNames, p.user.UserName)
+		ids = append(ids, p.user.ID)
+	}
+
+	existingEmails, err := im.userRepository.ExistingEmails(ctx, emails)
+	if err != nil {
+		return err
+	}
+	existingNames, err := im.userRepository.ExistingUserNames(ctx, userNames)
+	if err != nil {
+		return err
+	}
+	existingIDs, err := im.userRepository.ExistingIDs(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	batch := make([]pendingUser, 0, len(im.pending))
+	for _, p := range im.pending {
+		switch {
+		case existingEmails[p.user.Email]:
+			im.fail(p.row, p.user.Email, query.ErrEmailTaken.Error())
+		case existingNames[strings.ToLower(p.user.UserName)]:
+			im.fail(p.row, p.user.Email, query.ErrUserNameTaken.Error())
+		case existingIDs[p.user.ID]:
+			im.fail(p.row, p.user.Email, "a user with this id already exists")
+		default:
+			batch = append(batch, p)
+		}
+	}
+	if len(batch) == 0 {
+		return nil
+	}
+
+	users := make([]models.User, 0, len(batch))
+	for _, p := range batch {
+		users = append(users, p.user)
+	}
+
+	if !im.options.DryRun {
+		// A batch fails as a whole, for example when another request took
+		// one of the addresses or usernames since the check above.
+		if _, err := im.userRepository.CopyUsers(ctx, users); err != nil {
+			for _, p := range batch {
+				im.fail(p.row, p.user.Email, err.Error())
+			}
+			return nil
+		}
+	}
+
+	im.report.Imported += len(users)
+	for _, user := range users {
+		if user.EncryptedPassword == models.UnusablePassword {
+			im.report.PasswordResets++
+			im.report.ResetRequired = append(im.report.ResetRequired, user)
+		}
+	}
+	return nil
+}
+
+func (im *Importer) fail(row int, email, message string) {
+	im.report.Failed++
+	im.report.Errors = append(im.report.Errors, RowError{Row: row, Email: email, Error: message})
+}
+
+func joinErrors(errs map[string]string) string {
+	fields := make([]string, 0, len(errs))
+	for field := range errs {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	messages := make([]string, 0, len(fields))
+	for _, field := range fields {
+		messages = append(messages, errs[field])
+	}
+	return strings.Join(messages, "; ")
+}
@@ -0,0 +1,142 @@
+package bulk
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const maxLineBytes = 1 << 20
+
+// rowError is a problem with a single row. Reading can go on after it,
+// unlike errors from the underlying file.
+type rowError struct {
+	row int
+	err error
+}
+
+func (e *rowError) Error() string {
+	return fmt.Sprintf("row %d: %v", e.row, e.err)
+}
+
+// recordReader returns the records of a file one at a time together with
+// their row number, and io.EOF at the end.
+type recordReader interface {
+	Next() (*Record, int, error)
+}
+
+func newRecordReader(r io.Reader, format string) (recordReader, error) {
+	switch format {
+	case FormatCSV:
+		return newCSVReader(r)
+	case FormatJSONL:
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), maxLineBytes)
+		return &jsonlReader{scanner: scanner}, nil
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+type csvReader struct {
+	reader  *csv.Reader
+	columns map[string]int
+}
+
+func newCSVReader(r io.Reader) (*csvReader, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("file is empty")
+		}
+		return nil, err
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+	for _, required := range []string{"username", "email"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("header has no %s column", required)
+		}
+	}
+
+	return &csvReader{reader: reader, columns: columns}, nil
+}
+
+func (c *csvReader) Next() (*Record, int, error) {
+	fields, err := c.reader.Read()
+	if err != nil {
+		return nil, 0, err
+	}
+	row, _ := c.reader.FieldPos(0)
+
+	get := func(column string) string {
+		i, ok := c.columns[column]
+		if !ok || i >= len(fields) {
+			return ""
+		}
+		return strings.TrimSpace(fields[i])
+	}
+
+	record := &Record{
+		ID:           get("id"),
+		UserName:     get("username"),
+		Email:        get("email"),
+		PasswordHash: get("password_hash"),
+		FirstName:    get("first_name"),
+		LastName:     get("last_name"),
+	}
+	if record.EmailVerified, err = parseBool(get("email_verified")); err != nil {
+		return nil, row, &rowError{row: row, err: fmt.Errorf("email_verified: %w", err)}
+	}
+	if record.IsAdmin, err = parseBool(get("is_admin")); err != nil {
+		return nil, row, &rowError{row: row, err: fmt.Errorf("is_admin: %w", err)}
+	}
+	return record, row, nil
+}
+
+func parseBool(value string) (bool, error) {
+	if value == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("%q is not a boolean", value)
+	}
+	return b, nil
+}
+
+type jsonlReader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func (j *jsonlReader) Next() (*Record, int, error) {
+	for j.scanner.Scan() {
+		j.line++
+		line := strings.TrimSpace(j.scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var record Record
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			return nil, j.line, &rowError{row: j.line, err: errors.New("invalid JSON")}
+		}
+		return &record, j.line, nil
+	}
+	if err := j.scanner.Err(); err != nil {
+		return nil, 0, err
+	}
+	return nil, 0, io.EOF
+}
@@ -0,0 +1,37 @@
+// Package credentials verifies email and password logins against one or more
+// backends, such as the local user table or an LDAP directory.
+package credentials
+
+import (
+	"context"
+	"errors"
+
+	"github.com/OsagieDG/jwt-based-auth-system/internal/models"
+)
+
+// ErrInvalidCredentials is returned when a backend does not accept the email
+// and password. Any other error means the backend could not decide.
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+type Verifier interface {
+	// Verify returns the local user for the credentials. Backends that keep
+	// users elsewhere create or update a shadow user in auth.users.
+	Verify(ctx context.Context, email, password string) (*models.User, error)
+}
+
+// Chain asks each verifier in turn and returns the first user one of them
+// accepts.
+type Chain []Verifier
+
+func (c Chain) Verify(ctx context.Context, email, password string) (*models.User, error) {
+	for _, verifier := range c {
+		user, err := verifier.Verify(ctx, email, password)
+		if err == nil {
+			return user, nil
+		}
+		if !errors.Is(err, ErrInvalidCredentials) {
+			return nil, err
+		}
+	}
+	return nil, ErrInvalidCredentials
+}
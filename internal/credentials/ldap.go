@@ -0,0 +1,241 @@
+package credentials
+
+import (
+	"context"
+	"crypto/tls"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/OsagieDG/jwt-based-auth-system/internal/models"
+	"github.com/OsagieDG/jwt-based-auth-system/internal/query"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/google/uuid"
+)
+
+// ldapProvider is the provider name of directory users in
+// auth.user_identities.
+const ldapProvider = "ldap"
+
+type LDAPConfig struct {
+	URL      string
+	StartTLS bool
+	// BindDN and BindPassword are the service account used to look up users
+	// before binding as them. Leave empty for anonymous search.
+	BindDN       string
+	BindPassword string
+	BaseDN       string
+	// UserFilter finds the user by email; %s is replaced with the escaped
+	// address.
+	UserFilter        string
+	UserNameAttribute string
+	GroupAttribute    string
+	// Organization is the organization directory users are made members of
+	// on every login. Without one no roles are synced.
+	Organization uuid.UUID
+	// AdminGroups are the group DNs whose members get the admin role in
+	// Organization; everyone else in the directory is a plain member. The
+	// global admin flag is never set from the directory.
+	AdminGroups []string
+}
+
+// LDAP verifies the password by binding to the directory as the user and
+// keeps a shadow user in auth.users for everyone who logged in that way.
+type LDAP struct {
+	config                 *LDAPConfig
+	userRepository         query.UserRespository
+	identityRepository     query.IdentityRepository
+	organizationRepository query.OrganizationRepository
+}
+
+func NewLDAP(config *LDAPConfig, userRepository query.UserRespository, identityRepository query.IdentityRepository, organizationRepository query.OrganizationRepository) *LDAP {
+	return &LDAP{
+		config:                 config,
+		userRepository:         userRepository,
+		identityRepository:     identityRepository,
+		organizationRepository: organizationRepository,
+	}
+}
+
+type directoryEntry struct {
+	DN       string
+	Email    string
+	UserName string
+	IsAdmin  bool
+}
+
+func (l *LDAP) Verify(ctx context.Context, email, password string) (*models.User, error) {
+	// An empty password would be an unauthenticated bind, which most
+	// directories accept without checking anything.
+	if email == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	entry, err := l.authenticate(email, password)
+	if err != nil {
+		return nil, err
+	}
+
+	return l.shadowUser(ctx, entry)
+}
+
+func (l *LDAP) authenticate(email, password string) (*directoryEntry, error) {
+	conn, err := ldap.DialURL(l.config.URL)
+	if err != nil {
+		return nil, fmt.Errorf("ldap: dial failed: %w", err)
+	}
+	defer conn.Close()
+
+	if l.config.StartTLS {
+		u, err := url.Parse(l.config.URL)
+		if err != nil {
+			return nil, fmt.Errorf("ldap: invalid url: %w", err)
+		}
+		if err := conn.StartTLS(&tls.Config{ServerName: u.Hostname()}); err != nil {
+			return nil, fmt.Errorf("ldap: starttls failed: %w", err)
+		}
+	}
+
+	if l.config.BindDN != "" {
+		if err := conn.Bind(l.config.BindDN, l.config.BindPassword); err != nil {
+			return nil, fmt.Errorf("ldap: service bind failed: %w", err)
+		}
+	}
+
+	result, err := conn.Search(ldap.NewSearchRequest(
+		l.config.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 0, false,
+		fmt.Sprintf(l.config.UserFilter, ldap.EscapeFilter(email)),
+		[]string{"mail", l.config.UserNameAttribute, l.config.GroupAttribute},
+		nil,
+	))
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("ldap: search failed: %w", err)
+	}
+	if len(result.Entries) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+	found := result.Entries[0]
+
+	if err := conn.Bind(found.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("ldap: user bind failed: %w", err)
+	}
+
+	entry := &directoryEntry{
+		DN:       found.DN,
+		Email:    models.NormalizeEmail(found.GetAttributeValue("mail")),
+		UserName: found.GetAttributeValue(l.config.UserNameAttribute),
+	}
+	if entry.Email == "" {
+		entry.Email = models.NormalizeEmail(email)
+	}
+	for _, group := range found.GetAttributeValues(l.config.GroupAttribute) {
+		for _, admin := range l.config.AdminGroups {
+			if strings.EqualFold(group, admin) {
+				entry.IsAdmin = true
+			}
+		}
+	}
+
+	return entry, nil
+}
+
+// shadowUser finds the local user of a directory entry, linking an existing
+// account with the same email or creating one on the first login, and syncs
+// the organization role from the directory groups.
+func (l *LDAP) shadowUser(ctx context.Context, entry *directoryEntry) (*models.User, error) {
+	user, err := l.findShadowUser(ctx, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := l.syncRole(ctx, user.ID, entry); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// syncRole makes the user an admin or plain member of the configured
+// organization. Owners are left alone, as ownership is granted in the
+// application and not in the directory.
+func (l *LDAP) syncRole(ctx context.Context, userID uuid.UUID, entry *directoryEntry) error {
+	if l.config.Organization == uuid.Nil {
+		return nil
+	}
+
+	role := models.OrgRoleMember
+	if entry.IsAdmin {
+		role = models.OrgRoleAdmin
+	}
+
+	member, err := l.organizationRepository.GetMember(ctx, l.config.Organization, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = l.organizationRepository.AddMember(ctx, l.config.Organization, userID, role, nil)
+		if errors.Is(err, query.ErrAlreadyMember) {
+			return nil
+		}
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if member.Role == role || member.Role == models.OrgRoleOwner {
+		return nil
+	}
+	return l.organizationRepository.SetMemberRole(ctx, l.config.Organization, userID, role, nil)
+}
+
+func (l *LDAP) findShadowUser(ctx context.Context, entry *directoryEntry) (*models.User, error) {
+	userID, err := l.identityRepository.RecordIdentityLogin(ctx, ldapProvider, entry.DN)
+	if err == nil {
+		return l.userRepository.GetUserByID(ctx, userID)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	identity := &models.UserIdentity{
+		ID:       models.NewUUID(),
+		Provider: ldapProvider,
+		Subject:  entry.DN,
+		Email:    entry.Email,
+	}
+
+	user, err := l.userRepository.GetUserByEmail(ctx, entry.Email)
+	if err == nil {
+		identity.UserID = user.ID
+		if err := l.identityRepository.LinkIdentity(ctx, identity); err != nil {
+			return nil, err
+		}
+		return user, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	userName := entry.UserName
+	if userName == "" {
+		userName, _, _ = strings.Cut(entry.Email, "@")
+	}
+
+	// Addresses in the directory are managed by the organization, so they
+	// count as verified.
+	user, err = models.NewProvisionedUser(entry.Email, userName, true)
+	if err != nil {
+		return nil, err
+	}
+
+	identity.UserID = user.ID
+	if err := l.identityRepository.CreateUserWithIdentity(ctx, user, identity); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
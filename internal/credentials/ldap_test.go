@@ -0,0 +1,365 @@
+package credentials
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/OsagieDG/jwt-based-auth-system/internal/models"
+	"github.com/OsagieDG/jwt-based-auth-system/internal/query"
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/google/uuid"
+)
+
+const (
+	testBaseDN     = "dc=example,dc=com"
+	testAdminGroup = "cn=admins,ou=groups,dc=example,dc=com"
+)
+
+type directoryUser struct {
+	dn       string
+	email    string
+	uid      string
+	password string
+	groups   []string
+}
+
+// directoryServer is an in-process LDAP server answering simple binds and
+// searches by mail, which is all the LDAP verifier uses.
+type directoryServer struct {
+	listener net.Listener
+	users    []directoryUser
+}
+
+func newDirectoryServer(t *testing.T, users ...directoryUser) *directoryServer {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &directoryServer{listener: listener, users: users}
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *directoryServer) URL() string {
+	return "ldap://" + s.listener.Addr().String()
+}
+
+func (s *directoryServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		messageID := packet.Children[0].Value
+		op := packet.Children[1]
+
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			code := ldap.LDAPResultInvalidCredentials
+			if s.bind(op.Children[1].Data.String(), op.Children[2].Data.String()) {
+				code = ldap.LDAPResultSuccess
+			}
+			s.reply(conn, messageID, ldap.ApplicationBindResponse, code)
+		case ldap.ApplicationSearchRequest:
+			filter, err := ldap.DecompileFilter(op.Children[6])
+			if err != nil {
+				return
+			}
+			for _, user := range s.users {
+				if strings.Contains(filter, "(mail="+user.email+")") {
+					s.entry(conn, messageID, user)
+				}
+			}
+			s.reply(conn, messageID, ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess)
+		default:
+			return
+		}
+	}
+}
+
+func (s *directoryServer) bind(dn, password string) bool {
+	for _, user := range s.users {
+		if user.dn == dn && user.password == password {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *directoryServer) reply(conn net.Conn, messageID interface{}, tag ber.Tag, code int) {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "")
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), ""))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	s.write(conn, messageID, op)
+}
+
+func (s *directoryServer) entry(conn net.Conn, messageID interface{}, user directoryUser) {
+	attributes := ber.NewSequence("")
+	for name, values := range map[string][]string{"mail": {user.email}, "uid": {user.uid}, "memberOf": user.groups} {
+		attribute := ber.NewSequence("")
+		attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, ""))
+		set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "")
+		for _, value := range values {
+			set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, ""))
+		}
+		attribute.AppendChild(set)
+		attributes.AppendChild(attribute)
+	}
+
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "")
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, user.dn, ""))
+	op.AppendChild(attributes)
+	s.write(conn, messageID, op)
+}
+
+func (s *directoryServer) write(conn net.Conn, messageID interface{}, op *ber.Packet) {
+	envelope := ber.NewSequence("")
+	envelope.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, ""))
+	envelope.AppendChild(op)
+	_, _ = conn.Write(envelope.Bytes())
+}
+
+// fakeUsers keeps users in memory. Methods the verifier should not call
+// panic through the nil embedded interface.
+type fakeUsers struct {
+	query.UserRespository
+	t     *testing.T
+	users map[uuid.UUID]*models.User
+}
+
+func (f *fakeUsers) GetUserByID(ctx context.Context, userID uuid.UUID) (*models.User, error) {
+	if user, ok := f.users[userID]; ok {
+		return user, nil
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (f *fakeUsers) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	for _, user := range f.users {
+		if user.Email == email {
+			return user, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (f *fakeUsers) SetAdmin(ctx context.Context, userID uuid.UUID, isAdmin bool) error {
+	f.t.Errorf("SetAdmin(%s, %t) called by the LDAP verifier", userID, isAdmin)
+	return nil
+}
+
+type fakeIdentities struct {
+	query.IdentityRepository
+	users      *fakeUsers
+	identities map[string]uuid.UUID
+}
+
+func (f *fakeIdentities) RecordIdentityLogin(ctx context.Context, provider, subject string) (uuid.UUID, error) {
+	if userID, ok := f.identities[provider+" "+subject]; ok {
+		return userID, nil
+	}
+	return uuid.Nil, sql.ErrNoRows
+}
+
+func (f *fakeIdentities) LinkIdentity(ctx context.Context, identity *models.UserIdentity) error {
+	f.identities[identity.Provider+" "+identity.Subject] = identity.UserID
+	return nil
+}
+
+func (f *fakeIdentities) CreateUserWithIdentity(ctx context.Context, user *models.User, identity *models.UserIdentity) error {
+	f.users.users[user.ID] = user
+	return f.LinkIdentity(ctx, identity)
+}
+
+type fakeOrganizations struct {
+	query.OrganizationRepository
+	roles map[uuid.UUID]string
+}
+
+func (f *fakeOrganizations) GetMember(ctx context.Context, organizationID, userID uuid.UUID) (*models.OrganizationMember, error) {
+	role, ok := f.roles[userID]
+	if !ok {
+		return nil, fmt.Errorf("not a member: %w", sql.ErrNoRows)
+	}
+	return &models.OrganizationMember{OrganizationID: organizationID, UserID: userID, Role: role}, nil
+}
+
+func (f *fakeOrganizations) AddMember(ctx context.Context, organizationID, userID uuid.UUID, role string, actorID *uuid.UUID) error {
+	f.roles[userID] = role
+	return nil
+}
+
+func (f *fakeOrganizations) SetMemberRole(ctx context.Context, organizationID, userID uuid.UUID, role string, actorID *uuid.UUID) error {
+	f.roles[userID] = role
+	return nil
+}
+
+type ldapFixture struct {
+	verifier      *LDAP
+	users         *fakeUsers
+	organizations *fakeOrganizations
+}
+
+func newLDAPFixture(t *testing.T, organization uuid.UUID, directory ...directoryUser) *ldapFixture {
+	server := newDirectoryServer(t, directory...)
+	users := &fakeUsers{t: t, users: map[uuid.UUID]*models.User{}}
+	identities := &fakeIdentities{users: users, identities: map[string]uuid.UUID{}}
+	organizations := &fakeOrganizations{roles: map[uuid.UUID]string{}}
+
+	config := &LDAPConfig{
+		URL:               server.URL(),
+		BaseDN:            testBaseDN,
+		UserFilter:        "(&(objectClass=person)(mail=%s))",
+		UserNameAttribute: "uid",
+		GroupAttribute:    "memberOf",
+		Organization:      organization,
+		AdminGroups:       []string{testAdminGroup},
+	}
+	return &ldapFixture{
+		verifier:      NewLDAP(config, users, identities, organizations),
+		users:         users,
+		organizations: organizations,
+	}
+}
+
+var (
+	alice = directoryUser{
+		dn:       "uid=alice,ou=people,dc=example,dc=com",
+		email:    "alice@example.com",
+		uid:      "alice",
+		password: "alice-secret",
+		groups:   []string{testAdminGroup},
+	}
+	bob = directoryUser{
+		dn:       "uid=bob,ou=people,dc=example,dc=com",
+		email:    "bob@example.com",
+		uid:      "bob",
+		password: "bob-secret",
+	}
+)
+
+func TestLDAPVerifyRejectsWrongPassword(t *testing.T) {
+	f := newLDAPFixture(t, models.NewUUID(), alice)
+
+	for _, password := range []string{"wrong", ""} {
+		if _, err := f.verifier.Verify(context.Background(), alice.email, password); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("Verify with password %q: got %v, want ErrInvalidCredentials", password, err)
+		}
+	}
+	if _, err := f.verifier.Verify(context.Background(), "nobody@example.com", "secret"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Verify of unknown user: got %v, want ErrInvalidCredentials", err)
+	}
+}
+
+func TestLDAPVerifyProvisionsShadowUser(t *testing.T) {
+	organization := models.NewUUID()
+	f := newLDAPFixture(t, organization, alice, bob)
+
+	user, err := f.verifier.Verify(context.Background(), alice.email, alice.password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Email != alice.email || user.UserName != alice.uid || !user.EmailVerified {
+		t.Errorf("shadow user = %+v, want %s verified with username %s", user, alice.email, alice.uid)
+	}
+	if user.IsAdmin {
+		t.Error("directory admin group granted the global admin flag")
+	}
+	if role := f.organizations.roles[user.ID]; role != models.OrgRoleAdmin {
+		t.Errorf("role of admin group member = %q, want %q", role, models.OrgRoleAdmin)
+	}
+
+	again, err := f.verifier.Verify(context.Background(), alice.email, alice.password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.ID != user.ID {
+		t.Errorf("second login created user %s, want %s", again.ID, user.ID)
+	}
+
+	user, err = f.verifier.Verify(context.Background(), bob.email, bob.password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if role := f.organizations.roles[user.ID]; role != models.OrgRoleMember {
+		t.Errorf("role of plain directory user = %q, want %q", role, models.OrgRoleMember)
+	}
+}
+
+func TestLDAPVerifyKeepsAdminFlagOfLinkedAccount(t *testing.T) {
+	f := newLDAPFixture(t, models.NewUUID(), bob)
+
+	local := &models.User{ID: models.NewUUID(), Email: bob.email, UserName: "bob", IsAdmin: true}
+	f.users.users[local.ID] = local
+
+	user, err := f.verifier.Verify(context.Background(), bob.email, bob.password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != local.ID {
+		t.Fatalf("linked user %s, want the local account %s", user.ID, local.ID)
+	}
+	if !user.IsAdmin {
+		t.Error("local admin was demoted by the directory")
+	}
+	if role := f.organizations.roles[user.ID]; role != models.OrgRoleMember {
+		t.Errorf("role = %q, want %q", role, models.OrgRoleMember)
+	}
+}
+
+func TestLDAPVerifySyncsOrganizationRole(t *testing.T) {
+	f := newLDAPFixture(t, models.NewUUID(), alice, bob)
+
+	admin := &models.User{ID: models.NewUUID(), Email: bob.email, UserName: "bob"}
+	owner := &models.User{ID: models.NewUUID(), Email: alice.email, UserName: "alice"}
+	f.users.users[admin.ID] = admin
+	f.users.users[owner.ID] = owner
+	f.organizations.roles[admin.ID] = models.OrgRoleAdmin
+	f.organizations.roles[owner.ID] = models.OrgRoleOwner
+
+	if _, err := f.verifier.Verify(context.Background(), bob.email, bob.password); err != nil {
+		t.Fatal(err)
+	}
+	if role := f.organizations.roles[admin.ID]; role != models.OrgRoleMember {
+		t.Errorf("role after leaving the admin group = %q, want %q", role, models.OrgRoleMember)
+	}
+
+	if _, err := f.verifier.Verify(context.Background(), alice.email, alice.password); err != nil {
+		t.Fatal(err)
+	}
+	if role := f.organizations.roles[owner.ID]; role != models.OrgRoleOwner {
+		t.Errorf("role of owner = %q, want %q", role, models.OrgRoleOwner)
+	}
+}
+
+func TestLDAPVerifyWithoutOrganizationSyncsNoRoles(t *testing.T) {
+	f := newLDAPFixture(t, uuid.Nil, alice)
+
+	if _, err := f.verifier.Verify(context.Background(), alice.email, alice.password); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.organizations.roles) != 0 {
+		t.Errorf("roles = %v, want none without an organization", f.organizations.roles)
+	}
+}
@@ -0,0 +1,34 @@
+package credentials
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/OsagieDG/jwt-based-auth-system/internal/models"
+	"github.com/OsagieDG/jwt-based-auth-system/internal/query"
+)
+
+// Local checks the password hash stored in auth.users.
+type Local struct {
+	userRepository query.UserRespository
+}
+
+func NewLocal(userRepository query.UserRespository) *Local {
+	return &Local{userRepository: userRepository}
+}
+
+func (l *Local) Verify(ctx context.Context, email, password string) (*models.User, error) {
+	user, err := l.userRepository.GetUserByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
+	}
+
+	if !models.IsValidPassword(user.EncryptedPassword, password) {
+		return nil, ErrInvalidCredentials
+	}
+	return user, nil
+}
@@ -9,6 +9,26 @@ func ApplyMigrations(db *sql.DB) error {
 	migrationFiles := []string{
 		"internal/db/scripts/02_create_users_table.up.sql",
 		"internal/db/scripts/04_create_token_table.up.sql",
+		"internal/db/scripts/06_create_email_verification_table.up.sql",
+		"internal/db/scripts/08_create_password_reset_table.up.sql",
+		"internal/db/scripts/10_create_email_change_table.up.sql",
+		"internal/db/scripts/12_add_user_profile_fields.up.sql",
+		"internal/db/scripts/14_add_user_versioning.up.sql",
+		"internal/db/scripts/16_add_user_status.up.sql",
+		"internal/db/scripts/18_create_login_attempts_table.up.sql",
+		"internal/db/scripts/20_create_rate_limits_table.up.sql",
+		"internal/db/scripts/22_create_mfa_totp_table.up.sql",
+		"internal/db/scripts/24_create_webauthn_tables.up.sql",
+		"internal/db/scripts/26_create_mfa_recovery_codes_table.up.sql",
+		"internal/db/scripts/28_create_magic_link_tokens_table.up.sql",
+		"internal/db/scripts/30_create_email_otp_codes_table.up.sql",
+		"internal/db/scripts/32_create_user_identities_table.up.sql",
+		"internal/db/scripts/34_create_groups_and_scim_clients_tables.up.sql",
+		"internal/db/scripts/36_create_signing_keys_table.up.sql",
+		"internal/db/scripts/38_create_organizations_tables.up.sql",
+		"internal/db/scripts/40_create_invitations_table.up.sql",
+		"internal/db/scripts/42_add_user_metadata.up.sql",
+		"internal/db/scripts/44_add_user_unique_constraints.up.sql",
 	}
 
 	for _, file := range migrationFiles {
@@ -0,0 +1,24 @@
+
+-- Accounts created before email verification existed could not have
+-- verified their address, and would be locked out once
+-- REQUIRE_EMAIL_VERIFICATION is turned on, so they count as verified. This
+-- only happens when the column is added, as migrations run on every start.
+DO $$
+BEGIN
+    IF NOT EXISTS (
+        SELECT 1 FROM information_schema.columns
+        WHERE table_schema = 'auth' AND table_name = 'users' AND column_name = 'email_verified'
+    ) THEN
+        ALTER TABLE auth.users ADD COLUMN email_verified BOOLEAN NOT NULL DEFAULT false;
+        UPDATE auth.users SET email_verified = true;
+    END IF;
+END $$;
+
+CREATE TABLE IF NOT EXISTS auth.email_verification_tokens (
+    id UUID PRIMARY KEY,
+    user_id UUID REFERENCES auth.users(id) ON DELETE CASCADE NOT NULL,
+    token_hash TEXT UNIQUE NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
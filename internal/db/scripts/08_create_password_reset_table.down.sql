@@ -0,0 +1,2 @@
+
+DROP TABLE IF EXISTS auth.password_reset_tokens;
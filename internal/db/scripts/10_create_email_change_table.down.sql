@@ -0,0 +1,4 @@
+
+DROP TABLE IF EXISTS auth.audit_events;
+
+DROP TABLE IF EXISTS auth.email_change_requests;
@@ -0,0 +1,24 @@
+
+CREATE TABLE IF NOT EXISTS auth.email_change_requests (
+    id UUID PRIMARY KEY,
+    user_id UUID REFERENCES auth.users(id) ON DELETE CASCADE NOT NULL,
+    old_email VARCHAR(50) NOT NULL,
+    new_email VARCHAR(50) NOT NULL,
+    confirm_token_hash TEXT UNIQUE NOT NULL,
+    cancel_token_hash TEXT UNIQUE NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    resolved_at TIMESTAMPTZ
+);
+
+CREATE TABLE IF NOT EXISTS auth.audit_events (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL,
+    actor_id UUID,
+    action VARCHAR(50) NOT NULL,
+    details JSONB,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS audit_events_user_id_idx ON auth.audit_events (user_id, created_at);
@@ -0,0 +1,4 @@
+
+ALTER TABLE auth.users DROP COLUMN IF EXISTS avatar_url;
+ALTER TABLE auth.users DROP COLUMN IF EXISTS last_name;
+ALTER TABLE auth.users DROP COLUMN IF EXISTS first_name;
@@ -0,0 +1,4 @@
+
+ALTER TABLE auth.users ADD COLUMN IF NOT EXISTS first_name VARCHAR(50);
+ALTER TABLE auth.users ADD COLUMN IF NOT EXISTS last_name VARCHAR(50);
+ALTER TABLE auth.users ADD COLUMN IF NOT EXISTS avatar_url TEXT;
@@ -0,0 +1,8 @@
+
+DROP TRIGGER IF EXISTS users_bump_version ON auth.users;
+
+DROP FUNCTION IF EXISTS auth.bump_user_version();
+
+ALTER TABLE auth.users DROP COLUMN IF EXISTS version;
+ALTER TABLE auth.users DROP COLUMN IF EXISTS updated_at;
+ALTER TABLE auth.users DROP COLUMN IF EXISTS created_at;
@@ -0,0 +1,21 @@
+
+ALTER TABLE auth.users ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+ALTER TABLE auth.users ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+ALTER TABLE auth.users ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 1;
+
+-- Every write to a user row bumps its version, whichever code path made it,
+-- so the ETag derived from it always changes along with the representation.
+CREATE OR REPLACE FUNCTION auth.bump_user_version() RETURNS TRIGGER AS $$
+BEGIN
+    NEW.updated_at := NOW();
+    NEW.version := OLD.version + 1;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS users_bump_version ON auth.users;
+
+CREATE TRIGGER users_bump_version
+    BEFORE UPDATE ON auth.users
+    FOR EACH ROW
+    EXECUTE FUNCTION auth.bump_user_version();
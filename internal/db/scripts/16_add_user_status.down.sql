@@ -0,0 +1,5 @@
+
+DROP INDEX IF EXISTS auth.users_pending_deletion_idx;
+
+ALTER TABLE auth.users DROP COLUMN IF EXISTS status_changed_at;
+ALTER TABLE auth.users DROP COLUMN IF EXISTS status;
@@ -0,0 +1,7 @@
+
+ALTER TABLE auth.users ADD COLUMN IF NOT EXISTS status VARCHAR(20) NOT NULL DEFAULT 'active'
+    CHECK (status IN ('active', 'suspended', 'deactivated', 'pending_deletion'));
+ALTER TABLE auth.users ADD COLUMN IF NOT EXISTS status_changed_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+
+CREATE INDEX IF NOT EXISTS users_pending_deletion_idx ON auth.users (status_changed_at)
+    WHERE status = 'pending_deletion';
@@ -0,0 +1,4 @@
+
+DROP TABLE IF EXISTS auth.unlock_tokens;
+
+DROP TABLE IF EXISTS auth.login_attempts;
@@ -0,0 +1,17 @@
+
+CREATE TABLE IF NOT EXISTS auth.login_attempts (
+    user_id UUID PRIMARY KEY REFERENCES auth.users(id) ON DELETE CASCADE,
+    failed_attempts INT NOT NULL DEFAULT 0,
+    lockout_count INT NOT NULL DEFAULT 0,
+    locked_until TIMESTAMPTZ,
+    last_failed_at TIMESTAMPTZ
+);
+
+CREATE TABLE IF NOT EXISTS auth.unlock_tokens (
+    id UUID PRIMARY KEY,
+    user_id UUID REFERENCES auth.users(id) ON DELETE CASCADE NOT NULL,
+    token_hash TEXT UNIQUE NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,2 @@
+
+DROP TABLE IF EXISTS auth.rate_limits;
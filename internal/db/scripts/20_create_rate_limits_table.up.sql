@@ -0,0 +1,9 @@
+
+CREATE TABLE IF NOT EXISTS auth.rate_limits (
+    key TEXT PRIMARY KEY,
+    tokens DOUBLE PRECISION NOT NULL DEFAULT 0,
+    window_start TIMESTAMPTZ,
+    current_count INT NOT NULL DEFAULT 0,
+    previous_count INT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMPTZ
+);
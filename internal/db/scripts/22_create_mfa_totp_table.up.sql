@@ -0,0 +1,8 @@
+
+CREATE TABLE IF NOT EXISTS auth.mfa_totp (
+    user_id UUID PRIMARY KEY REFERENCES auth.users(id) ON DELETE CASCADE,
+    secret TEXT NOT NULL,
+    confirmed_at TIMESTAMPTZ,
+    last_used_counter BIGINT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,4 @@
+
+DROP TABLE IF EXISTS auth.webauthn_challenges;
+
+DROP TABLE IF EXISTS auth.webauthn_credentials;
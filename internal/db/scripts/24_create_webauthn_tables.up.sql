@@ -0,0 +1,21 @@
+
+CREATE TABLE IF NOT EXISTS auth.webauthn_credentials (
+    id UUID PRIMARY KEY,
+    user_id UUID REFERENCES auth.users(id) ON DELETE CASCADE NOT NULL,
+    credential_id BYTEA UNIQUE NOT NULL,
+    name VARCHAR(50) NOT NULL,
+    sign_count BIGINT NOT NULL DEFAULT 0,
+    data JSONB NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_used_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS webauthn_credentials_user_id_idx ON auth.webauthn_credentials (user_id);
+
+CREATE TABLE IF NOT EXISTS auth.webauthn_challenges (
+    id UUID PRIMARY KEY,
+    user_id UUID REFERENCES auth.users(id) ON DELETE CASCADE,
+    purpose VARCHAR(20) NOT NULL,
+    data JSONB NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL
+);
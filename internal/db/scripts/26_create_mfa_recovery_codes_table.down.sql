@@ -0,0 +1,2 @@
+
+DROP TABLE IF EXISTS auth.mfa_recovery_codes;
@@ -0,0 +1,2 @@
+
+DROP TABLE IF EXISTS auth.magic_link_tokens;
@@ -0,0 +1,10 @@
+
+CREATE TABLE IF NOT EXISTS auth.magic_link_tokens (
+    id UUID PRIMARY KEY,
+    user_id UUID REFERENCES auth.users(id) ON DELETE CASCADE NOT NULL,
+    token_hash TEXT UNIQUE NOT NULL,
+    browser_hash TEXT,
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,3 @@
+
+DROP TABLE IF EXISTS auth.oidc_login_states;
+DROP TABLE IF EXISTS auth.user_identities;
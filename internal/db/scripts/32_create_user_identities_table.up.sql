@@ -0,0 +1,21 @@
+
+CREATE TABLE IF NOT EXISTS auth.user_identities (
+    id UUID PRIMARY KEY,
+    user_id UUID REFERENCES auth.users(id) ON DELETE CASCADE NOT NULL,
+    provider VARCHAR(50) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    email VARCHAR(255),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_login_at TIMESTAMPTZ,
+    UNIQUE (provider, subject)
+);
+
+CREATE INDEX IF NOT EXISTS user_identities_user_id_idx ON auth.user_identities (user_id);
+
+CREATE TABLE IF NOT EXISTS auth.oidc_login_states (
+    state_hash TEXT PRIMARY KEY,
+    provider VARCHAR(50) NOT NULL,
+    nonce TEXT NOT NULL,
+    code_verifier TEXT NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL
+);
@@ -0,0 +1,4 @@
+
+DROP TABLE IF EXISTS auth.scim_clients;
+DROP TABLE IF EXISTS auth.group_members;
+DROP TABLE IF EXISTS auth.groups;
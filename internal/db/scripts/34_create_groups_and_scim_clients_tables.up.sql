@@ -0,0 +1,27 @@
+
+CREATE TABLE IF NOT EXISTS auth.groups (
+    id UUID PRIMARY KEY,
+    organization_id UUID NOT NULL,
+    display_name VARCHAR(255) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS groups_organization_display_name_key ON auth.groups (organization_id, LOWER(display_name));
+
+CREATE TABLE IF NOT EXISTS auth.group_members (
+    group_id UUID REFERENCES auth.groups(id) ON DELETE CASCADE NOT NULL,
+    user_id UUID REFERENCES auth.users(id) ON DELETE CASCADE NOT NULL,
+    PRIMARY KEY (group_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS group_members_user_id_idx ON auth.group_members (user_id);
+
+CREATE TABLE IF NOT EXISTS auth.scim_clients (
+    id UUID PRIMARY KEY,
+    organization_id UUID NOT NULL,
+    name VARCHAR(50) NOT NULL,
+    token_hash TEXT UNIQUE NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_used_at TIMESTAMPTZ
+);
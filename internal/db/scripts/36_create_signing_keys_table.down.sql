@@ -0,0 +1,2 @@
+
+DROP TABLE IF EXISTS auth.signing_keys;
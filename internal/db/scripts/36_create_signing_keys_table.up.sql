@@ -0,0 +1,10 @@
+
+CREATE TABLE IF NOT EXISTS auth.signing_keys (
+    id TEXT PRIMARY KEY,
+    purpose TEXT NOT NULL,
+    encrypted_secret TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    retired_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS signing_keys_purpose_idx ON auth.signing_keys (purpose, created_at);
@@ -0,0 +1,5 @@
+
+ALTER TABLE IF EXISTS auth.scim_clients DROP CONSTRAINT IF EXISTS scim_clients_organization_id_fkey;
+ALTER TABLE IF EXISTS auth.groups DROP CONSTRAINT IF EXISTS groups_organization_id_fkey;
+DROP TABLE IF EXISTS auth.organization_members;
+DROP TABLE IF EXISTS auth.organizations;
@@ -0,0 +1,32 @@
+
+CREATE TABLE IF NOT EXISTS auth.organizations (
+    id UUID PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    slug VARCHAR(50) UNIQUE NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS auth.organization_members (
+    organization_id UUID REFERENCES auth.organizations(id) ON DELETE CASCADE NOT NULL,
+    user_id UUID REFERENCES auth.users(id) ON DELETE CASCADE NOT NULL,
+    role TEXT NOT NULL DEFAULT 'member',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (organization_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS organization_members_user_id_idx ON auth.organization_members (user_id);
+
+-- Groups and SCIM clients are created before organizations, so they are tied
+-- to them here.
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'groups_organization_id_fkey') THEN
+        ALTER TABLE auth.groups ADD CONSTRAINT groups_organization_id_fkey
+            FOREIGN KEY (organization_id) REFERENCES auth.organizations(id) ON DELETE CASCADE;
+    END IF;
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'scim_clients_organization_id_fkey') THEN
+        ALTER TABLE auth.scim_clients ADD CONSTRAINT scim_clients_organization_id_fkey
+            FOREIGN KEY (organization_id) REFERENCES auth.organizations(id) ON DELETE CASCADE;
+    END IF;
+END $$;
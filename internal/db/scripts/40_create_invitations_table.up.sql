@@ -0,0 +1,16 @@
+
+CREATE TABLE IF NOT EXISTS auth.invitations (
+    id UUID PRIMARY KEY,
+    organization_id UUID REFERENCES auth.organizations(id) ON DELETE CASCADE NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    role TEXT NOT NULL,
+    token_hash TEXT UNIQUE NOT NULL,
+    invited_by UUID REFERENCES auth.users(id) ON DELETE SET NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    accepted_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS invitations_pending_email_idx ON auth.invitations (organization_id, email)
+    WHERE accepted_at IS NULL AND revoked_at IS NULL;
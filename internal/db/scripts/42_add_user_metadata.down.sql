@@ -0,0 +1,3 @@
+
+DROP INDEX IF EXISTS auth.users_metadata_idx;
+ALTER TABLE auth.users DROP COLUMN IF EXISTS metadata;
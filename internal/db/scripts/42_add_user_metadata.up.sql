@@ -0,0 +1,4 @@
+
+ALTER TABLE auth.users ADD COLUMN IF NOT EXISTS metadata JSONB NOT NULL DEFAULT '{"user": {}, "admin": {}}';
+
+CREATE INDEX IF NOT EXISTS users_metadata_idx ON auth.users USING GIN (metadata jsonb_path_ops);
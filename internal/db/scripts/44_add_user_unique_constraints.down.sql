@@ -0,0 +1,5 @@
+
+DROP INDEX IF EXISTS auth.users_username_key;
+DROP INDEX IF EXISTS auth.users_email_key;
+
+ALTER TABLE auth.users ADD CONSTRAINT users_username_email_key UNIQUE (username, email);
@@ -0,0 +1,41 @@
+
+-- Accounts sharing an email address have to be merged by hand, so startup
+-- fails with the list of them until they are. Once the index exists there
+-- cannot be any left.
+DO $$
+DECLARE
+    duplicates TEXT;
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_indexes WHERE schemaname = 'auth' AND indexname = 'users_email_key') THEN
+        SELECT string_agg(format('%s (%s)', email, ids), '; ')
+        INTO duplicates
+        FROM (
+            SELECT LOWER(TRIM(email)) AS email, string_agg(id::text, ', ' ORDER BY created_at, id) AS ids
+            FROM auth.users
+            GROUP BY LOWER(TRIM(email))
+            HAVING COUNT(*) > 1
+        ) d;
+
+        IF duplicates IS NOT NULL THEN
+            RAISE EXCEPTION 'accounts share an email address and have to be merged before emails can be made unique: %', duplicates;
+        END IF;
+    END IF;
+END $$;
+
+UPDATE auth.users SET email = LOWER(TRIM(email)), username = TRIM(username)
+WHERE email <> LOWER(TRIM(email)) OR username <> TRIM(username);
+
+-- Usernames used to be unique only together with the email address. The
+-- oldest account keeps a shared username and the others get the start of
+-- their ID appended.
+UPDATE auth.users u SET username = LEFT(u.username, 11) || '-' || LEFT(u.id::text, 8)
+FROM (
+    SELECT id, ROW_NUMBER() OVER (PARTITION BY LOWER(username) ORDER BY created_at, id) AS n
+    FROM auth.users
+) d
+WHERE u.id = d.id AND d.n > 1;
+
+ALTER TABLE auth.users DROP CONSTRAINT IF EXISTS users_username_email_key;
+
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_key ON auth.users (LOWER(email));
+CREATE UNIQUE INDEX IF NOT EXISTS users_username_key ON auth.users (LOWER(username));
@@ -0,0 +1,31 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/OsagieDG/jwt-based-auth-system/internal/query"
+)
+
+// RunUserPurge hard deletes users that have been pending deletion for longer
+// than gracePeriod, checking every interval until ctx is cancelled.
+func RunUserPurge(ctx context.Context, userRepository query.UserRespository, gracePeriod, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := userRepository.PurgeDeletedUsers(ctx, time.Now().Add(-gracePeriod))
+		if err != nil {
+			log.Printf("user purge failed: %v", err)
+		} else if purged > 0 {
+			log.Printf("purged %d deleted users", purged)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/OsagieDG/jwt-based-auth-system/internal/ratelimit"
+)
+
+// RunRateLimitPrune drops limiter state that has not been touched for longer
+// than maxIdle, checking every interval until ctx is cancelled.
+func RunRateLimitPrune(ctx context.Context, store ratelimit.Store, maxIdle, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := store.Prune(ctx, time.Now().Add(-maxIdle)); err != nil {
+			log.Printf("rate limit prune failed: %v", err)
+		}
+	}
+}
@@ -0,0 +1,324 @@
+// Package jsonschema validates decoded JSON documents against a JSON Schema.
+// It implements the keywords that are useful to describe profile fields:
+// type, enum, const, properties, required, additionalProperties, items,
+// minItems, maxItems, minLength, maxLength, pattern, format, minimum and
+// maximum. Other keywords are ignored, as the specification asks of unknown
+// ones, so a schema written for a full validator still loads.
+package jsonschema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/mail"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var knownTypes = map[string]bool{
+	"null":    true,
+	"boolean": true,
+	"object":  true,
+	"array":   true,
+	"number":  true,
+	"integer": true,
+	"string":  true,
+}
+
+// Schema is a compiled schema. The boolean schemas true and false are a
+// Schema that accepts everything and one that rejects everything.
+type Schema struct {
+	Types                []string
+	Enum                 []interface{}
+	Const                interface{}
+	HasConst             bool
+	Properties           map[string]*Schema
+	Required             []string
+	AdditionalProperties *Schema
+	Items                *Schema
+	MinItems             *int
+	MaxItems             *int
+	MinLength            *int
+	MaxLength            *int
+	Pattern              *regexp.Regexp
+	Format               string
+	Minimum              *float64
+	Maximum              *float64
+
+	never bool
+}
+
+// Parse compiles a schema from its JSON text.
+func Parse(data []byte) (*Schema, error) {
+	var s Schema
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("jsonschema: %w", err)
+	}
+	return &s, nil
+}
+
+// Load compiles the schema in the file at path.
+func Load(path string) (*Schema, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+func (s *Schema) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	switch string(data) {
+	case "true":
+		*s = Schema{}
+		return nil
+	case "false":
+		*s = Schema{never: true}
+		return nil
+	}
+
+	var raw struct {
+		Type                 json.RawMessage    `json:"type"`
+		Enum                 []interface{}      `json:"enum"`
+		Const                json.RawMessage    `json:"const"`
+		Properties           map[string]*Schema `json:"properties"`
+		Required             []string           `json:"required"`
+		AdditionalProperties *Schema            `json:"additionalProperties"`
+		Items                *Schema            `json:"items"`
+		MinItems             *int               `json:"minItems"`
+		MaxItems             *int               `json:"maxItems"`
+		MinLength            *int               `json:"minLength"`
+		MaxLength            *int               `json:"maxLength"`
+		Pattern              string             `json:"pattern"`
+		Format               string             `json:"format"`
+		Minimum              *float64           `json:"minimum"`
+		Maximum              *float64           `json:"maximum"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	*s = Schema{
+		Enum:                 raw.Enum,
+		Properties:           raw.Properties,
+		Required:             raw.Required,
+		AdditionalProperties: raw.AdditionalProperties,
+		Items:                raw.Items,
+		MinItems:             raw.MinItems,
+		MaxItems:             raw.MaxItems,
+		MinLength:            raw.MinLength,
+		MaxLength:            raw.MaxLength,
+		Format:               raw.Format,
+		Minimum:              raw.Minimum,
+		Maximum:              raw.Maximum,
+	}
+
+	if len(raw.Type) > 0 {
+		if raw.Type[0] == '[' {
+			if err := json.Unmarshal(raw.Type, &s.Types); err != nil {
+				return err
+			}
+		} else {
+			var t string
+			if err := json.Unmarshal(raw.Type, &t); err != nil {
+				return err
+			}
+			s.Types = []string{t}
+		}
+		for _, t := range s.Types {
+			if !knownTypes[t] {
+				return fmt.Errorf("unknown type %q", t)
+			}
+		}
+	}
+
+	if len(raw.Const) > 0 {
+		s.HasConst = true
+		if err := json.Unmarshal(raw.Const, &s.Const); err != nil {
+			return err
+		}
+	}
+
+	if raw.Pattern != "" {
+		pattern, err := regexp.Compile(raw.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", raw.Pattern, err)
+		}
+		s.Pattern = pattern
+	}
+
+	return nil
+}
+
+// Validate checks a value as decoded by encoding/json into an interface{}.
+// It returns one message per invalid location, keyed by its path in dotted
+// form such as "address.city" or "tags[2]"; the document itself is "".
+func (s *Schema) Validate(value interface{}) map[string]string {
+	errors := map[string]string{}
+	s.validate(value, "", errors)
+	return errors
+}
+
+func (s *Schema) validate(value interface{}, path string, errors map[string]string) {
+	if s.never {
+		errors[path] = "is not allowed"
+		return
+	}
+
+	if len(s.Types) > 0 && !hasType(value, s.Types) {
+		errors[path] = fmt.Sprintf("should be of type %s", strings.Join(s.Types, " or "))
+		return
+	}
+	if s.HasConst && !reflect.DeepEqual(value, s.Const) {
+		errors[path] = fmt.Sprintf("should be %s", encode(s.Const))
+		return
+	}
+	if len(s.Enum) > 0 && !inEnum(value, s.Enum) {
+		values := make([]string, 0, len(s.Enum))
+		for _, v := range s.Enum {
+			values = append(values, encode(v))
+		}
+		errors[path] = fmt.Sprintf("should be one of %s", strings.Join(values, ", "))
+		return
+	}
+
+	switch v := value.(type) {
+	case string:
+		s.validateString(v, path, errors)
+	case float64:
+		if s.Minimum != nil && v < *s.Minimum {
+			errors[path] = fmt.Sprintf("should be at least %v", *s.Minimum)
+		} else if s.Maximum != nil && v > *s.Maximum {
+			errors[path] = fmt.Sprintf("should be at most %v", *s.Maximum)
+		}
+	case []interface{}:
+		switch {
+		case s.MinItems != nil && len(v) < *s.MinItems:
+			errors[path] = fmt.Sprintf("should have at least %d items", *s.MinItems)
+		case s.MaxItems != nil && len(v) > *s.MaxItems:
+			errors[path] = fmt.Sprintf("should have at most %d items", *s.MaxItems)
+		case s.Items != nil:
+			for i, item := range v {
+				s.Items.validate(item, fmt.Sprintf("%s[%d]", path, i), errors)
+			}
+		}
+	case map[string]interface{}:
+		s.validateObject(v, path, errors)
+	}
+}
+
+func (s *Schema) validateString(v, path string, errors map[string]string) {
+	length := utf8.RuneCountInString(v)
+	switch {
+	case s.MinLength != nil && length < *s.MinLength:
+		errors[path] = fmt.Sprintf("should be at least %d characters", *s.MinLength)
+	case s.MaxLength != nil && length > *s.MaxLength:
+		errors[path] = fmt.Sprintf("should be at most %d characters", *s.MaxLength)
+	case s.Pattern != nil && !s.Pattern.MatchString(v):
+		errors[path] = fmt.Sprintf("should match %s", s.Pattern)
+	case s.Format != "" && !hasFormat(v, s.Format):
+		errors[path] = fmt.Sprintf("should be a valid %s", s.Format)
+	}
+}
+
+func (s *Schema) validateObject(v map[string]interface{}, path string, errors map[string]string) {
+	for _, name := range s.Required {
+		if _, ok := v[name]; !ok {
+			errors[join(path, name)] = "is required"
+		}
+	}
+
+	names := make([]string, 0, len(v))
+	for name := range v {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if property, ok := s.Properties[name]; ok {
+			property.validate(v[name], join(path, name), errors)
+		} else if s.AdditionalProperties != nil {
+			s.AdditionalProperties.validate(v[name], join(path, name), errors)
+		}
+	}
+}
+
+func hasType(value interface{}, types []string) bool {
+	for _, t := range types {
+		switch v := value.(type) {
+		case nil:
+			if t == "null" {
+				return true
+			}
+		case bool:
+			if t == "boolean" {
+				return true
+			}
+		case string:
+			if t == "string" {
+				return true
+			}
+		case float64:
+			if t == "number" || (t == "integer" && v == math.Trunc(v)) {
+				return true
+			}
+		case []interface{}:
+			if t == "array" {
+				return true
+			}
+		case map[string]interface{}:
+			if t == "object" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func inEnum(value interface{}, enum []interface{}) bool {
+	for _, v := range enum {
+		if reflect.DeepEqual(value, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasFormat checks the formats profile fields commonly use. Unknown formats
+// are only annotations and always pass.
+func hasFormat(v, format string) bool {
+	switch format {
+	case "email":
+		address, err := mail.ParseAddress(v)
+		return err == nil && address.Address == v
+	case "uri":
+		u, err := url.Parse(v)
+		return err == nil && u.Scheme != ""
+	case "date":
+		_, err := time.Parse("2006-01-02", v)
+		return err == nil
+	case "date-time":
+		_, err := time.Parse(time.RFC3339, v)
+		return err == nil
+	}
+	return true
+}
+
+func join(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func encode(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
@@ -0,0 +1,193 @@
+// Package keys manages the HMAC keys that sign session tokens. Keys live in
+// the database encrypted, so they can be rotated with authctl while the API
+// is running.
+package keys
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/OsagieDG/jwt-based-auth-system/internal/models"
+	"github.com/OsagieDG/jwt-based-auth-system/internal/query"
+	"github.com/OsagieDG/jwt-based-auth-system/internal/secretbox"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// reloadInterval is how long a rotation can take to reach a running API.
+const reloadInterval = time.Minute
+
+var ErrUnknownKey = errors.New("keys: token signed with an unknown key")
+
+// legacyKeys were compiled into the API before keys could be stored. They
+// are only used for a purpose that has no stored key yet, so existing
+// installs keep working until the first key is generated.
+var legacyKeys = map[string][]byte{
+	models.KeyPurposeAccess:       []byte("MY_SECRET_KEY"),
+	models.KeyPurposeRefresh:      []byte("MY_REFRESH_SECRET_KEY"),
+	models.KeyPurposeMFAChallenge: []byte("MY_MFA_CHALLENGE_SECRET_KEY"),
+}
+
+// Ring signs and verifies tokens with the stored keys. It caches them and
+// reloads them at most once per reloadInterval.
+type Ring struct {
+	signingKeyRepository query.SigningKeyRepository
+	box                  *secretbox.Box
+
+	mu       sync.Mutex
+	keys     []models.SigningKey
+	loadedAt time.Time
+}
+
+func NewRing(signingKeyRepository query.SigningKeyRepository, box *secretbox.Box) *Ring {
+	return &Ring{signingKeyRepository: signingKeyRepository, box: box}
+}
+
+// Sign signs the claims with the newest active key of the purpose.
+func (r *Ring) Sign(purpose string, claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+
+	keys, err := r.current()
+	if err != nil {
+		return "", err
+	}
+	for i := range keys {
+		key := &keys[i]
+		if key.Purpose == purpose && key.RetiredAt == nil {
+			token.Header["kid"] = key.ID
+			return token.SignedString(key.Secret)
+		}
+	}
+
+	if hasPurpose(keys, purpose) {
+		return "", fmt.Errorf("keys: every %s key is retired", purpose)
+	}
+	return token.SignedString(legacyKeys[purpose])
+}
+
+// Keyfunc resolves the key of a token by its kid header. Tokens without a
+// kid are only accepted while the purpose still uses its legacy key.
+func (r *Ring) Keyfunc(purpose string) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		keys, err := r.current()
+		if err != nil {
+			return nil, err
+		}
+
+		kid, _ := token.Header["kid"].(string)
+		if kid == "" {
+			if hasPurpose(keys, purpose) {
+				return nil, ErrUnknownKey
+			}
+			return legacyKeys[purpose], nil
+		}
+
+		now := time.Now()
+		for i := range keys {
+			key := &keys[i]
+			if key.ID == kid && key.Purpose == purpose && key.ValidAt(now) {
+				return key.Secret, nil
+			}
+		}
+		return nil, ErrUnknownKey
+	}
+}
+
+// current returns the cached keys, reloading them when they are stale. A
+// failed reload keeps the previous keys, so a database hiccup does not log
+// everyone out; only when no keys were ever loaded is the error returned.
+func (r *Ring) current() ([]models.SigningKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.loadedAt.IsZero() && time.Since(r.loadedAt) < reloadInterval {
+		return r.keys, nil
+	}
+
+	keys, err := Load(context.Background(), r.signingKeyRepository, r.box)
+	if err != nil {
+		if r.loadedAt.IsZero() {
+			return nil, err
+		}
+		log.Printf("failed to reload signing keys: %v", err)
+		return r.keys, nil
+	}
+
+	r.keys = keys
+	r.loadedAt = time.Now()
+	return r.keys, nil
+}
+
+func hasPurpose(keys []models.SigningKey, purpose string) bool {
+	for _, key := range keys {
+		if key.Purpose == purpose {
+			return true
+		}
+	}
+	return false
+}
+
+// Load reads and decrypts every stored key, newest first.
+func Load(ctx context.Context, signingKeyRepository query.SigningKeyRepository, box *secretbox.Box) ([]models.SigningKey, error) {
+	keys, err := signingKeyRepository.GetSigningKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range keys {
+		secret, err := box.Open(keys[i].EncryptedSecret)
+		if err != nil {
+			return nil, fmt.Errorf("keys: cannot decrypt key %s: %w", keys[i].ID, err)
+		}
+		if keys[i].Secret, err = base64.StdEncoding.DecodeString(secret); err != nil {
+			return nil, fmt.Errorf("keys: key %s is corrupt: %w", keys[i].ID, err)
+		}
+	}
+	return keys, nil
+}
+
+// Generate stores a new key for the purpose. It becomes the signing key
+// while the older keys keep verifying tokens.
+func Generate(ctx context.Context, signingKeyRepository query.SigningKeyRepository, box *secretbox.Box, purpose string) (*models.SigningKey, error) {
+	key, err := newSealedKey(box, purpose)
+	if err != nil {
+		return nil, err
+	}
+	if err := signingKeyRepository.SaveSigningKey(ctx, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Rotate stores a new key for the purpose and retires the older ones after
+// the grace period. A grace period as long as the token lifetime lets
+// existing sessions run out instead of ending them.
+func Rotate(ctx context.Context, signingKeyRepository query.SigningKeyRepository, box *secretbox.Box, purpose string, grace time.Duration) (*models.SigningKey, error) {
+	key, err := newSealedKey(box, purpose)
+	if err != nil {
+		return nil, err
+	}
+	if err := signingKeyRepository.RotateSigningKey(ctx, key, time.Now().Add(grace)); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func newSealedKey(box *secretbox.Box, purpose string) (*models.SigningKey, error) {
+	if !models.IsKeyPurpose(purpose) {
+		return nil, fmt.Errorf("keys: unknown purpose %q", purpose)
+	}
+
+	key, err := models.NewSigningKey(purpose)
+	if err != nil {
+		return nil, err
+	}
+	if key.EncryptedSecret, err = box.Seal(base64.StdEncoding.EncodeToString(key.Secret)); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
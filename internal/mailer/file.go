@@ -0,0 +1,37 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// FileMailer drops every message as an .eml file into a directory, which is
+// handy for inspecting outgoing mail during local development.
+type FileMailer struct {
+	from string
+	dir  string
+}
+
+func NewFileMailer(from, dir string) (*FileMailer, error) {
+	if dir == "" {
+		dir = "mail"
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create mail directory: %w", err)
+	}
+	return &FileMailer{from: from, dir: dir}, nil
+}
+
+func (m *FileMailer) Send(ctx context.Context, msg *Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405"), uuid.New().String())
+	return os.WriteFile(filepath.Join(m.dir, name), formatMessage(m.from, msg), 0o644)
+}
@@ -0,0 +1,45 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(ctx context.Context, msg *Message) error
+}
+
+type Config struct {
+	Driver   string
+	From     string
+	Host     string
+	Port     string
+	Username string
+	Password string
+	Dir      string
+}
+
+// New returns the Mailer selected by config.Driver. The driver has to be
+// chosen explicitly, so a deployment missing its SMTP settings fails to start
+// instead of silently dropping every email.
+func New(config *Config) (Mailer, error) {
+	switch config.Driver {
+	case "smtp":
+		return NewSMTPMailer(config), nil
+	case "file":
+		return NewFileMailer(config.From, config.Dir)
+	case "memory":
+		return NewMemoryMailer(), nil
+	case "":
+		return nil, errors.New("no mailer driver configured, set MAILER_DRIVER to smtp, file or memory")
+	default:
+		return nil, fmt.Errorf("unknown mailer driver %q", config.Driver)
+	}
+}
@@ -0,0 +1,44 @@
+package mailer
+
+import (
+	"context"
+	"log"
+	"sync"
+)
+
+// memoryMailerLimit is how many messages the MemoryMailer keeps; older ones
+// are dropped.
+const memoryMailerLimit = 100
+
+// MemoryMailer keeps the latest sent messages in memory instead of delivering
+// them. Only the subject and recipient are logged, as bodies carry tokens;
+// use the file mailer to follow links during local testing.
+type MemoryMailer struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func NewMemoryMailer() *MemoryMailer {
+	return &MemoryMailer{}
+}
+
+func (m *MemoryMailer) Send(ctx context.Context, msg *Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.messages) == memoryMailerLimit {
+		m.messages = append(m.messages[:0], m.messages[1:]...)
+	}
+	m.messages = append(m.messages, *msg)
+	log.Printf("mailer: %q for %s", msg.Subject, msg.To)
+	return nil
+}
+
+func (m *MemoryMailer) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	messages := make([]Message, len(m.messages))
+	copy(messages, m.messages)
+	return messages
+}
@@ -0,0 +1,56 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+)
+
+type SMTPMailer struct {
+	from     string
+	addr     string
+	host     string
+	username string
+	password string
+}
+
+func NewSMTPMailer(config *Config) *SMTPMailer {
+	return &SMTPMailer{
+		from:     config.From,
+		addr:     net.JoinHostPort(config.Host, config.Port),
+		host:     config.Host,
+		username: config.Username,
+		password: config.Password,
+	}
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg *Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if m.username != "" {
+		auth = smtp.PlainAuth("", m.username, m.password, m.host)
+	}
+
+	if err := smtp.SendMail(m.addr, auth, m.from, []string{msg.To}, formatMessage(m.from, msg)); err != nil {
+		return fmt.Errorf("failed to send email to %s: %w", msg.To, err)
+	}
+
+	return nil
+}
+
+func formatMessage(from string, msg *Message) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=\"utf-8\"\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(msg.Body)
+	return []byte(b.String())
+}
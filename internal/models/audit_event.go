@@ -0,0 +1,45 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	AuditEmailChangeRequested = "email_change.requested"
+	AuditEmailChangeConfirmed = "email_change.confirmed"
+	AuditEmailChangeCancelled = "email_change.cancelled"
+	AuditUserStatusChanged    = "user.status_changed"
+	AuditUserPurged           = "user.purged"
+	AuditUserLocked           = "user.locked"
+	AuditUserUnlocked         = "user.unlocked"
+	AuditMFAEnabled           = "mfa.enabled"
+	AuditMFADisabled          = "mfa.disabled"
+	AuditWebAuthnCloneWarning = "webauthn.clone_warning"
+	AuditRecoveryCodesCreated = "mfa.recovery_codes_created"
+	AuditRecoveryCodeUsed     = "mfa.recovery_code_used"
+	AuditIdentityLinked       = "identity.linked"
+	AuditOrgMemberAdded       = "organization.member_added"
+	AuditOrgMemberRemoved     = "organization.member_removed"
+	AuditOrgRoleChanged       = "organization.role_changed"
+)
+
+type AuditEvent struct {
+	ID        uuid.UUID              `json:"id"`
+	UserID    uuid.UUID              `json:"user_id"`
+	ActorID   *uuid.UUID             `json:"actor_id,omitempty"`
+	Action    string                 `json:"action"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+	CreatedAt time.Time              `json:"created_at"`
+}
+
+func NewAuditEvent(userID uuid.UUID, actorID *uuid.UUID, action string, details map[string]interface{}) *AuditEvent {
+	return &AuditEvent{
+		ID:      NewUUID(),
+		UserID:  userID,
+		ActorID: actorID,
+		Action:  action,
+		Details: details,
+	}
+}
@@ -0,0 +1,60 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	EmailChangePending   = "pending"
+	EmailChangeConfirmed = "confirmed"
+	EmailChangeCancelled = "cancelled"
+)
+
+type EmailChangeRequest struct {
+	ID               uuid.UUID  `json:"id"`
+	UserID           uuid.UUID  `json:"user_id"`
+	OldEmail         string     `json:"old_email"`
+	NewEmail         string     `json:"new_email"`
+	ConfirmTokenHash string     `json:"-"`
+	CancelTokenHash  string     `json:"-"`
+	Status           string     `json:"status"`
+	ExpiresAt        time.Time  `json:"expires_at"`
+	CreatedAt        time.Time  `json:"created_at"`
+	ResolvedAt       *time.Time `json:"resolved_at"`
+}
+
+type ChangeEmailParams struct {
+	NewEmail        string `json:"new_email"`
+	CurrentPassword string `json:"current_password"`
+}
+
+func (params ChangeEmailParams) Validate() map[string]string {
+	errors := map[string]string{}
+
+	if !IsEmailValid(params.NewEmail) {
+		errors["new_email"] = fmt.Sprintf("email %s is invalid", params.NewEmail)
+	}
+	if len(params.CurrentPassword) == 0 {
+		errors["current_password"] = "current password is required"
+	}
+
+	return errors
+}
+
+type EmailChangeTokenParams struct {
+	Token string `json:"token"`
+}
+
+func (params EmailChangeTokenParams) Validate() map[string]string {
+	errors := map[string]string{}
+
+	if len(strings.TrimSpace(params.Token)) == 0 {
+		errors["token"] = "token is required"
+	}
+
+	return errors
+}
@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Group struct {
+	ID          uuid.UUID     `json:"id"`
+	DisplayName string        `json:"display_name"`
+	Members     []GroupMember `json:"members"`
+	CreatedAt   time.Time     `json:"created_at"`
+	UpdatedAt   time.Time     `json:"updated_at"`
+}
+
+type GroupMember struct {
+	UserID   uuid.UUID `json:"user_id"`
+	UserName string    `json:"username"`
+}
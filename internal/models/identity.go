@@ -0,0 +1,71 @@
+package models
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UserIdentity links a user to their account at an external OIDC provider.
+type UserIdentity struct {
+	ID          uuid.UUID  `json:"id"`
+	UserID      uuid.UUID  `json:"user_id"`
+	Provider    string     `json:"provider"`
+	Subject     string     `json:"subject"`
+	Email       string     `json:"email"`
+	CreatedAt   time.Time  `json:"created_at"`
+	LastLoginAt *time.Time `json:"last_login_at"`
+}
+
+// OIDCLoginState keeps the nonce and PKCE verifier of an authorization
+// request until the provider redirects back.
+type OIDCLoginState struct {
+	StateHash    string
+	Provider     string
+	Nonce        string
+	CodeVerifier string
+	ExpiresAt    time.Time
+}
+
+// NewProvisionedUser creates a user for someone who first logged in through
+// an external provider. The password is random and unknown to anyone, so the
+// account can only be used through the provider until a password is reset.
+func NewProvisionedUser(email, userName string, emailVerified bool) (*User, error) {
+	password, _, err := NewOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	encpw, err := EncryptPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	name := []rune(userName)
+	if len(name) > maxUserNameLen {
+		name = name[:maxUserNameLen]
+	}
+	if len(name) < minUserNameLen {
+		name = []rune("user")
+	}
+
+	return &User{
+		ID:                NewUUID(),
+		UserName:          string(name),
+		Email:             email,
+		EncryptedPassword: encpw,
+		EmailVerified:     emailVerified,
+	}, nil
+}
+
+// UserNameVariant returns the nth alternative for a username that is taken,
+// such as "jane2", shortened to fit the maximum length.
+func UserNameVariant(userName string, n int) string {
+	suffix := strconv.Itoa(n)
+	name := []rune(userName)
+	if len(name) > maxUserNameLen-len(suffix) {
+		name = name[:maxUserNameLen-len(suffix)]
+	}
+	return string(name) + suffix
+}
@@ -0,0 +1,75 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Invitation is an email asking someone to join an organization with a
+// preset role. The link in it carries a token whose hash is stored here.
+type Invitation struct {
+	ID             uuid.UUID  `json:"id"`
+	OrganizationID uuid.UUID  `json:"organization_id"`
+	Email          string     `json:"email"`
+	Role           string     `json:"role"`
+	TokenHash      string     `json:"-"`
+	InvitedBy      *uuid.UUID `json:"invited_by"`
+	ExpiresAt      time.Time  `json:"expires_at"`
+	AcceptedAt     *time.Time `json:"accepted_at"`
+	RevokedAt      *time.Time `json:"revoked_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// IsExpired reports whether the link can no longer be accepted. An expired
+// invitation stays pending and can be resent.
+func (i *Invitation) IsExpired() bool {
+	return time.Now().After(i.ExpiresAt)
+}
+
+type CreateInvitationParams struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+func (params CreateInvitationParams) Validate() map[string]string {
+	errors := map[string]string{}
+
+	if !IsEmailValid(params.Email) {
+		errors["email"] = fmt.Sprintf("email %s is invalid", params.Email)
+	}
+	if !IsOrgRole(params.Role) {
+		errors["role"] = fmt.Sprintf("role should be one of %s, %s or %s", OrgRoleOwner, OrgRoleAdmin, OrgRoleMember)
+	}
+
+	return errors
+}
+
+// AcceptInvitationParams accepts an invitation. The username and password
+// are only needed when the invited address has no account yet.
+type AcceptInvitationParams struct {
+	Token    string `json:"token"`
+	UserName string `json:"username"`
+	Password string `json:"password"`
+}
+
+// Validate checks the params. The password of a new account must satisfy
+// policy and may not be built from the username or the invited email.
+func (params AcceptInvitationParams) Validate(newAccount bool, policy PasswordPolicy, email string) map[string]string {
+	errors := map[string]string{}
+
+	if len(params.Token) == 0 {
+		errors["token"] = "token is required"
+	}
+	if newAccount {
+		if len(params.UserName) < minUserNameLen {
+			errors["username"] = fmt.Sprintf("username length should be at least %d characters", minUserNameLen)
+		}
+		if msg := policy.checkPassword(params.Password, params.UserName, email); msg != "" {
+			errors["password"] = msg
+		}
+	}
+
+	return errors
+}
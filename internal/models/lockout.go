@@ -0,0 +1,70 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type LoginAttempts struct {
+	UserID         uuid.UUID  `json:"user_id"`
+	FailedAttempts int        `json:"failed_attempts"`
+	LockoutCount   int        `json:"lockout_count"`
+	LockedUntil    *time.Time `json:"locked_until"`
+	LastFailedAt   *time.Time `json:"last_failed_at"`
+}
+
+func (a *LoginAttempts) IsLocked(now time.Time) bool {
+	return a.LockedUntil != nil && a.LockedUntil.After(now)
+}
+
+// LockoutPolicy locks an account after Threshold consecutive failed logins.
+// Every further lockout doubles the lock duration, starting at BaseDuration
+// and capped at MaxDuration. Failures and lockouts are forgotten once the
+// account has been quiet for ResetWindow.
+type LockoutPolicy struct {
+	Threshold    int
+	BaseDuration time.Duration
+	MaxDuration  time.Duration
+	ResetWindow  time.Duration
+}
+
+// Decay forgets the failed logins once the last one is older than the reset
+// window, and the lockouts once the window has also passed since the last
+// lock ended, so the backoff starts over. A zero window never forgets.
+func (p LockoutPolicy) Decay(attempts *LoginAttempts, now time.Time) {
+	if p.ResetWindow <= 0 || attempts.LastFailedAt == nil {
+		return
+	}
+	if now.Sub(*attempts.LastFailedAt) < p.ResetWindow {
+		return
+	}
+	attempts.FailedAttempts = 0
+
+	if attempts.LockedUntil == nil || now.Sub(*attempts.LockedUntil) >= p.ResetWindow {
+		attempts.LockoutCount = 0
+	}
+}
+
+func (p LockoutPolicy) LockDuration(lockoutCount int) time.Duration {
+	d := p.BaseDuration
+	for i := 1; i < lockoutCount; i++ {
+		d *= 2
+		if d >= p.MaxDuration {
+			return p.MaxDuration
+		}
+	}
+	if d > p.MaxDuration {
+		return p.MaxDuration
+	}
+	return d
+}
+
+type UnlockToken struct {
+	ID        uuid.UUID  `json:"id"`
+	UserID    uuid.UUID  `json:"user_id"`
+	TokenHash string     `json:"-"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
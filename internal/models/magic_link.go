@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MagicLinkToken is a single-use login link. When BrowserHash is set the
+// link only works in the browser that requested it.
+type MagicLinkToken struct {
+	ID          uuid.UUID  `json:"id"`
+	UserID      uuid.UUID  `json:"user_id"`
+	TokenHash   string     `json:"-"`
+	BrowserHash *string    `json:"-"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	UsedAt      *time.Time `json:"used_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+type MagicLinkParams struct {
+	Email string `json:"email"`
+}
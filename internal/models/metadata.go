@@ -0,0 +1,195 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Sections of the user metadata.
+const (
+	MetadataUser  = "user"
+	MetadataAdmin = "admin"
+)
+
+// MaxMetadataBytes limits the size of the stored metadata document.
+const MaxMetadataBytes = 16 << 10
+
+var ErrAdminMetadata = errors.New("admin metadata can only be changed by admins")
+
+// UserMetadata holds the custom profile fields of a user, such as their
+// locale or department. Users can edit the user section; the admin section is
+// for fields only admins may set. Both are validated against the configured
+// JSON Schema as the document {"user": {...}, "admin": {...}}.
+type UserMetadata struct {
+	User  map[string]interface{} `json:"user"`
+	Admin map[string]interface{} `json:"admin,omitempty"`
+}
+
+// Scan reads the JSONB column. Missing sections become empty objects.
+func (m *UserMetadata) Scan(src interface{}) error {
+	*m = UserMetadata{}
+	switch v := src.(type) {
+	case nil:
+	case []byte:
+		if err := json.Unmarshal(v, m); err != nil {
+			return err
+		}
+	case string:
+		if err := json.Unmarshal([]byte(v), m); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("cannot scan %T into UserMetadata", src)
+	}
+	m.normalize()
+	return nil
+}
+
+func (m UserMetadata) Value() (driver.Value, error) {
+	m.normalize()
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+func (m *UserMetadata) normalize() {
+	if m.User == nil {
+		m.User = map[string]interface{}{}
+	}
+	if m.Admin == nil {
+		m.Admin = map[string]interface{}{}
+	}
+}
+
+// Document returns the metadata in the form the schema validates.
+func (m UserMetadata) Document() map[string]interface{} {
+	m.normalize()
+	return map[string]interface{}{
+		MetadataUser:  m.User,
+		MetadataAdmin: m.Admin,
+	}
+}
+
+// Apply returns the metadata with a JSON Merge Patch (RFC 7396) applied to
+// it. The patch mirrors the metadata, so {"user": {"locale": null}} removes
+// the locale. Patches touching the admin section fail with ErrAdminMetadata
+// unless allowAdmin is set.
+func (m UserMetadata) Apply(patch json.RawMessage, allowAdmin bool) (*UserMetadata, error) {
+	var sections map[string]json.RawMessage
+	if err := json.Unmarshal(patch, &sections); err != nil || sections == nil {
+		return nil, errors.New("metadata should be an object")
+	}
+
+	result := UserMetadata{User: copyObject(m.User), Admin: copyObject(m.Admin)}
+	for name, sectionPatch := range sections {
+		var target *map[string]interface{}
+		switch name {
+		case MetadataUser:
+			target = &result.User
+		case MetadataAdmin:
+			if !allowAdmin {
+				return nil, ErrAdminMetadata
+			}
+			target = &result.Admin
+		default:
+			return nil, fmt.Errorf("metadata has no %s section", name)
+		}
+
+		var value interface{}
+		if err := json.Unmarshal(sectionPatch, &value); err != nil {
+			return nil, err
+		}
+		switch v := value.(type) {
+		case nil:
+			*target = map[string]interface{}{}
+		case map[string]interface{}:
+			*target = mergePatch(*target, v)
+		default:
+			return nil, fmt.Errorf("metadata %s section should be an object", name)
+		}
+	}
+
+	b, err := json.Marshal(result)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > MaxMetadataBytes {
+		return nil, fmt.Errorf("metadata should be at most %d bytes", MaxMetadataBytes)
+	}
+	return &result, nil
+}
+
+// Project returns the fields named by paths such as "user.locale", nested by
+// section, for copying into token claims. Fields the user does not have are
+// left out.
+func (m UserMetadata) Project(paths []string) map[string]interface{} {
+	projected := map[string]interface{}{}
+	for _, path := range paths {
+		section, key, _ := strings.Cut(path, ".")
+
+		var source map[string]interface{}
+		switch section {
+		case MetadataUser:
+			source = m.User
+		case MetadataAdmin:
+			source = m.Admin
+		}
+		value, ok := source[key]
+		if !ok {
+			continue
+		}
+
+		fields, _ := projected[section].(map[string]interface{})
+		if fields == nil {
+			fields = map[string]interface{}{}
+			projected[section] = fields
+		}
+		fields[key] = value
+	}
+	return projected
+}
+
+// IsAdminMetadataPath reports whether path names a field of the admin
+// section, as "admin.cost_center" does.
+func IsAdminMetadataPath(path string) bool {
+	section, _, _ := strings.Cut(path, ".")
+	return section == MetadataAdmin
+}
+
+// IsMetadataPath reports whether path names a field of a metadata section,
+// as "user.locale" does.
+func IsMetadataPath(path string) bool {
+	section, key, ok := strings.Cut(path, ".")
+	return ok && key != "" && (section == MetadataUser || section == MetadataAdmin)
+}
+
+func mergePatch(target, patch map[string]interface{}) map[string]interface{} {
+	for key, value := range patch {
+		switch v := value.(type) {
+		case nil:
+			delete(target, key)
+		case map[string]interface{}:
+			existing, _ := target[key].(map[string]interface{})
+			target[key] = mergePatch(copyObject(existing), v)
+		default:
+			target[key] = v
+		}
+	}
+	return target
+}
+
+// copyObject copies the top level of an object. mergePatch copies nested
+// objects the same way before changing them, so the original metadata is
+// never modified.
+func copyObject(object map[string]interface{}) map[string]interface{} {
+	c := make(map[string]interface{}, len(object))
+	for key, value := range object {
+		c[key] = value
+	}
+	return c
+}
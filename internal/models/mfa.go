@@ -0,0 +1,104 @@
+package models
+
+import (
+	"crypto/rand"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	RecoveryCodeCount = 10
+
+	// Lowercase letters and digits without the easily confused 0, 1, l and o.
+	recoveryCodeAlphabet = "abcdefghijkmnpqrstuvwxyz23456789"
+	recoveryCodeLength   = 10
+)
+
+type TOTPEnrollment struct {
+	UserID          uuid.UUID  `json:"user_id"`
+	Secret          string     `json:"-"`
+	ConfirmedAt     *time.Time `json:"confirmed_at"`
+	LastUsedCounter *int64     `json:"-"`
+	CreatedAt       time.Time  `json:"created_at"`
+}
+
+func (e *TOTPEnrollment) IsConfirmed() bool {
+	return e.ConfirmedAt != nil
+}
+
+type TOTPCodeParams struct {
+	Code string `json:"code"`
+}
+
+// DisableTOTPParams takes a current TOTP code or, when the authenticator is
+// lost, one of the user's recovery codes.
+type DisableTOTPParams struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+	Password     string `json:"password"`
+}
+
+// ConfirmIdentityParams proves a session is still in the hands of the user
+// before their second factors change: the current password and, once a
+// second factor is enabled, a TOTP code or one of the recovery codes.
+type ConfirmIdentityParams struct {
+	Password     string `json:"password"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// MFALoginParams completes a login with either a current TOTP code or one
+// of the user's recovery codes.
+type MFALoginParams struct {
+	MFAToken     string `json:"mfa_token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// MFAStatus summarizes the second factors of a user for their profile.
+type MFAStatus struct {
+	Methods                []string `json:"methods"`
+	RecoveryCodesRemaining int      `json:"recovery_codes_remaining"`
+}
+
+type RegenerateRecoveryCodesParams struct {
+	Password string `json:"password"`
+}
+
+// NewRecoveryCodes returns a fresh set of codes formatted as xxxxx-xxxxx
+// together with the hashes to store in their place.
+func NewRecoveryCodes() (codes []string, hashes []string, err error) {
+	b := make([]byte, recoveryCodeLength)
+	for i := 0; i < RecoveryCodeCount; i++ {
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+
+		var code strings.Builder
+		for j, c := range b {
+			if j == recoveryCodeLength/2 {
+				code.WriteByte('-')
+			}
+			// 256 is a multiple of the alphabet size, so this is unbiased.
+			code.WriteByte(recoveryCodeAlphabet[int(c)%len(recoveryCodeAlphabet)])
+		}
+
+		codes = append(codes, code.String())
+		hashes = append(hashes, HashRecoveryCode(code.String()))
+	}
+	return codes, hashes, nil
+}
+
+// HashRecoveryCode ignores case, spaces and dashes so codes can be typed the
+// way they were written down.
+func HashRecoveryCode(code string) string {
+	normalized := strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(strings.TrimSpace(code)))
+	return HashOpaqueToken(normalized)
+}
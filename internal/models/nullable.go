@@ -0,0 +1,38 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// NullableString tells apart the three states a JSON Merge Patch (RFC 7396)
+// member can be in: absent (leave unchanged), null (clear) or a value.
+type NullableString struct {
+	Set   bool
+	Valid bool
+	Value string
+}
+
+// UnmarshalJSON is only called when the member is present in the document.
+func (n *NullableString) UnmarshalJSON(data []byte) error {
+	n.Set = true
+	if bytes.Equal(data, []byte("null")) {
+		n.Valid = false
+		n.Value = ""
+		return nil
+	}
+
+	if err := json.Unmarshal(data, &n.Value); err != nil {
+		return err
+	}
+	n.Valid = true
+	return nil
+}
+
+// FieldValue returns the value to store: nil for null, the string otherwise.
+func (n NullableString) FieldValue() interface{} {
+	if !n.Valid {
+		return nil
+	}
+	return n.Value
+}
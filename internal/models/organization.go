@@ -0,0 +1,117 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Roles a user can have in an organization. Owners and admins manage the
+// organization's users; only owners can make or remove other owners.
+const (
+	OrgRoleOwner  = "owner"
+	OrgRoleAdmin  = "admin"
+	OrgRoleMember = "member"
+)
+
+const (
+	maxOrganizationNameLen = 100
+	maxOrganizationSlugLen = 50
+)
+
+var slugRegex = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// Organization is a customer company. Its users are the members listed in
+// auth.organization_members; a user can belong to several organizations.
+type Organization struct {
+	ID        uuid.UUID `json:"id"`
+	Name      string    `json:"name"`
+	Slug      string    `json:"slug"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type OrganizationMember struct {
+	OrganizationID uuid.UUID `json:"organization_id"`
+	UserID         uuid.UUID `json:"user_id"`
+	UserName       string    `json:"username"`
+	Email          string    `json:"email"`
+	Role           string    `json:"role"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// Membership is an organization seen from one of its members.
+type Membership struct {
+	Organization Organization `json:"organization"`
+	Role         string       `json:"role"`
+}
+
+func IsOrgRole(role string) bool {
+	return role == OrgRoleOwner || role == OrgRoleAdmin || role == OrgRoleMember
+}
+
+// CanManageUsers reports whether the role may administer the organization's
+// users.
+func CanManageUsers(role string) bool {
+	return role == OrgRoleOwner || role == OrgRoleAdmin
+}
+
+type CreateOrganizationParams struct {
+	Name string `json:"name"`
+	Slug string `json:"slug"`
+}
+
+func (params CreateOrganizationParams) Validate() map[string]string {
+	errors := map[string]string{}
+
+	if params.Name == "" || len(params.Name) > maxOrganizationNameLen {
+		errors["name"] = fmt.Sprintf("name should be between 1 and %d characters", maxOrganizationNameLen)
+	}
+	if len(params.Slug) > maxOrganizationSlugLen || !slugRegex.MatchString(params.Slug) {
+		errors["slug"] = fmt.Sprintf("slug should be up to %d lowercase letters, digits and single dashes", maxOrganizationSlugLen)
+	}
+
+	return errors
+}
+
+func NewOrganizationFromParams(params CreateOrganizationParams) *Organization {
+	return &Organization{
+		ID:   NewUUID(),
+		Name: params.Name,
+		Slug: params.Slug,
+	}
+}
+
+type AddMemberParams struct {
+	UserID uuid.UUID `json:"user_id"`
+	Role   string    `json:"role"`
+}
+
+func (params AddMemberParams) Validate() map[string]string {
+	errors := map[string]string{}
+
+	if params.UserID == uuid.Nil {
+		errors["user_id"] = "user_id is required"
+	}
+	if !IsOrgRole(params.Role) {
+		errors["role"] = fmt.Sprintf("role should be one of %s, %s or %s", OrgRoleOwner, OrgRoleAdmin, OrgRoleMember)
+	}
+
+	return errors
+}
+
+type SetMemberRoleParams struct {
+	Role string `json:"role"`
+}
+
+func (params SetMemberRoleParams) Validate() map[string]string {
+	errors := map[string]string{}
+
+	if !IsOrgRole(params.Role) {
+		errors["role"] = fmt.Sprintf("role should be one of %s, %s or %s", OrgRoleOwner, OrgRoleAdmin, OrgRoleMember)
+	}
+
+	return errors
+}
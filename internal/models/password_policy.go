@@ -0,0 +1,132 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MaxPasswordBytes is the longest password bcrypt can hash. Longer ones
+// would lose everything past this many bytes, so they are rejected.
+const MaxPasswordBytes = 72
+
+// Character classes a PasswordPolicy can require.
+const (
+	PasswordClassUpper  = "upper"
+	PasswordClassLower  = "lower"
+	PasswordClassDigit  = "digit"
+	PasswordClassSymbol = "symbol"
+)
+
+var passwordClasses = map[string]struct {
+	matches func(rune) bool
+	message string
+}{
+	PasswordClassUpper:  {unicode.IsUpper, "password should contain an uppercase letter"},
+	PasswordClassLower:  {unicode.IsLower, "password should contain a lowercase letter"},
+	PasswordClassDigit:  {unicode.IsDigit, "password should contain a digit"},
+	PasswordClassSymbol: {isPasswordSymbol, "password should contain a symbol"},
+}
+
+// BreachedPasswords tells whether a password is known from a data breach,
+// and so is among the first an attacker would try.
+type BreachedPasswords interface {
+	Contains(password string) bool
+}
+
+// PasswordPolicy decides which passwords users may choose. Lengths count
+// characters, not bytes, but no password may exceed MaxPasswordBytes.
+type PasswordPolicy struct {
+	MinLength int
+	MaxLength int
+	// RequiredClasses lists the character classes every password needs at
+	// least one character of, such as PasswordClassDigit.
+	RequiredClasses []string
+	// MinStrength is the lowest score from EstimateStrength that is
+	// accepted, from 0 to accept anything to 4 for very unguessable only.
+	MinStrength int
+	// Breached, when set, refuses passwords found in breach corpora.
+	Breached BreachedPasswords
+}
+
+// DefaultPasswordPolicy follows NIST SP 800-63B: no composition rules, but
+// passwords that are easy to guess are refused.
+var DefaultPasswordPolicy = PasswordPolicy{
+	MinLength:   8,
+	MaxLength:   64,
+	MinStrength: 2,
+}
+
+// Validate reports whether the policy can be satisfied at all.
+func (p PasswordPolicy) Validate() error {
+	switch {
+	case p.MinLength < 1:
+		return errors.New("minimum length should be at least 1")
+	case p.MaxLength < p.MinLength:
+		return errors.New("maximum length should not be below the minimum length")
+	case p.MaxLength > MaxPasswordBytes:
+		return fmt.Errorf("maximum length should be at most %d, the limit of bcrypt", MaxPasswordBytes)
+	case p.MinStrength < 0 || p.MinStrength > 4:
+		return errors.New("minimum strength should be between 0 and 4")
+	}
+	for _, class := range p.RequiredClasses {
+		if _, ok := passwordClasses[class]; !ok {
+			return fmt.Errorf("unknown character class %q", class)
+		}
+	}
+	return nil
+}
+
+// Check returns what is wrong with the password, with suggestions to make it
+// stronger, or nil if the policy accepts it. userInputs are things like the
+// username and email address; passwords built from them count as weak.
+func (p PasswordPolicy) Check(password string, userInputs ...string) []string {
+	length := utf8.RuneCountInString(password)
+	switch {
+	case length < p.MinLength:
+		return []string{fmt.Sprintf("password length should be at least %d characters", p.MinLength)}
+	case length > p.MaxLength:
+		return []string{fmt.Sprintf("password length should be at most %d characters", p.MaxLength)}
+	case len(password) > MaxPasswordBytes:
+		return []string{fmt.Sprintf("password should be at most %d bytes long", MaxPasswordBytes)}
+	}
+
+	var problems []string
+	for _, class := range p.RequiredClasses {
+		if !strings.ContainsFunc(password, passwordClasses[class].matches) {
+			problems = append(problems, passwordClasses[class].message)
+		}
+	}
+	if len(problems) > 0 {
+		return problems
+	}
+
+	if p.Breached != nil && p.Breached.Contains(password) {
+		return []string{
+			"password has appeared in a data breach and cannot be used",
+			"choose a password you have not used anywhere else",
+		}
+	}
+
+	if strength := EstimateStrength(password, userInputs...); strength.Score < p.MinStrength {
+		problem := "password is too easy to guess"
+		if strength.Warning != "" {
+			problem += ": " + strength.Warning
+		}
+		problems = append(problems, problem)
+		problems = append(problems, strength.Suggestions...)
+	}
+	return problems
+}
+
+// checkPassword is Check as a single message for the fields of a validation
+// error, or an empty string if the password is fine.
+func (p PasswordPolicy) checkPassword(password string, userInputs ...string) string {
+	return strings.Join(p.Check(password, userInputs...), "; ")
+}
+
+func isPasswordSymbol(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.IsSpace(r)
+}
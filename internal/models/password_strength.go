@@ -0,0 +1,480 @@
+package models
+
+import (
+	"math"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// PasswordStrength is an estimate of how hard a password is to guess, after
+// zxcvbn: the password is split into the pieces an attacker would try
+// first, such as common passwords, keyboard rows, sequences and years, and
+// the guesses for the whole are derived from those for the pieces.
+type PasswordStrength struct {
+	// Guesses is the estimated number of guesses needed to find the
+	// password.
+	Guesses float64 `json:"guesses"`
+	// Score is 0 for too guessable, 1 for very guessable, 2 for somewhat
+	// guessable, 3 for safely unguessable and 4 for very unguessable.
+	Score       int      `json:"score"`
+	Warning     string   `json:"warning,omitempty"`
+	Suggestions []string `json:"suggestions,omitempty"`
+}
+
+// Upper bounds of the guesses for scores 0 to 3, as used by zxcvbn.
+var strengthThresholds = []float64{1e3, 1e6, 1e8, 1e10}
+
+// Kinds of passwordMatch.
+const (
+	matchDictionary = "dictionary"
+	matchUserInput  = "user_input"
+	matchSequence   = "sequence"
+	matchRepeat     = "repeat"
+	matchKeyboard   = "keyboard"
+	matchYear       = "year"
+)
+
+// passwordMatch is a guessable piece of a password, covering the runes
+// [i, j).
+type passwordMatch struct {
+	i, j    int
+	kind    string
+	guesses float64
+
+	capitalized bool
+	l33t        bool
+	reversed    bool
+}
+
+// EstimateStrength estimates how hard the password is to guess. userInputs,
+// such as the username and email address, are treated like the most common
+// passwords.
+func EstimateStrength(password string, userInputs ...string) PasswordStrength {
+	runes := []rune(password)
+	if len(runes) == 0 {
+		return PasswordStrength{
+			Guesses:     1,
+			Warning:     "a password is required",
+			Suggestions: []string{"use a few words, avoiding common phrases"},
+		}
+	}
+
+	lower := make([]rune, len(runes))
+	for i, r := range runes {
+		lower[i] = unicode.ToLower(r)
+	}
+
+	var matches []passwordMatch
+	matches = append(matches, dictionaryMatches(runes, lower, userInputs)...)
+	matches = append(matches, sequenceMatches(lower)...)
+	matches = append(matches, repeatMatches(runes)...)
+	matches = append(matches, keyboardMatches(lower)...)
+	matches = append(matches, yearMatches(runes)...)
+
+	guesses, pieces := cheapestCover(len(runes), matches)
+
+	strength := PasswordStrength{Guesses: guesses, Score: len(strengthThresholds)}
+	for score, threshold := range strengthThresholds {
+		if guesses < threshold {
+			strength.Score = score
+			break
+		}
+	}
+	if strength.Score < 3 {
+		strength.Warning, strength.Suggestions = strengthFeedback(pieces, len(runes))
+	}
+	return strength
+}
+
+// cheapestCover finds the split of the password into matches and stretches
+// of brute forced characters that needs the fewest guesses. As in zxcvbn,
+// the guesses of a split are the product of those of its pieces times the
+// number of orders the pieces could be tried in.
+func cheapestCover(n int, matches []passwordMatch) (float64, []passwordMatch) {
+	type step struct {
+		guesses float64
+		from    int
+		match   int // index into matches, or -1 for brute force
+	}
+
+	// best[k][i] is the cheapest way to cover the first i runes with k
+	// pieces.
+	best := make([][]step, n+1)
+	for k := range best {
+		best[k] = make([]step, n+1)
+		for i := range best[k] {
+			best[k][i] = step{guesses: math.Inf(1)}
+		}
+	}
+	best[0][0].guesses = 1
+
+	for k := 1; k <= n; k++ {
+		for i := 1; i <= n; i++ {
+			for j := 0; j < i; j++ {
+				if g := best[k-1][j].guesses * math.Pow(10, float64(i-j)); g < best[k][i].guesses {
+					best[k][i] = step{guesses: g, from: j, match: -1}
+				}
+			}
+			for m, match := range matches {
+				if match.j != i {
+					continue
+				}
+				if g := best[k-1][match.i].guesses * match.guesses; g < best[k][i].guesses {
+					best[k][i] = step{guesses: g, from: match.i, match: m}
+				}
+			}
+		}
+	}
+
+	pieces, total := 0, math.Inf(1)
+	factorial := 1.0
+	for k := 1; k <= n; k++ {
+		factorial *= float64(k)
+		if g := best[k][n].guesses * factorial; g < total {
+			pieces, total = k, g
+		}
+	}
+
+	var cover []passwordMatch
+	for k, i := pieces, n; k > 0; k-- {
+		s := best[k][i]
+		if s.match >= 0 {
+			cover = append(cover, matches[s.match])
+		}
+		i = s.from
+	}
+	return total, cover
+}
+
+func dictionaryMatches(runes, lower []rune, userInputs []string) []passwordMatch {
+	ranks := map[string]int{}
+	for i, input := range userInputs {
+		for _, word := range userInputWords(input) {
+			if _, ok := ranks[word]; !ok {
+				// User inputs are the first thing an attacker who knows
+				// the user tries.
+				ranks[word] = -(i + 1)
+			}
+		}
+	}
+
+	var matches []passwordMatch
+	find := func(word []rune, l33t, reversed bool) {
+		n := len(word)
+		for i := 0; i < n; i++ {
+			for j := i + 3; j <= n; j++ {
+				rank, ok := ranks[string(word[i:j])]
+				if !ok {
+					rank, ok = commonPasswordRanks[string(word[i:j])]
+				}
+				if !ok {
+					continue
+				}
+
+				match := passwordMatch{i: i, j: j, kind: matchDictionary, l33t: l33t, reversed: reversed}
+				if reversed {
+					match.i, match.j = n-j, n-i
+				}
+				if rank < 0 {
+					match.kind = matchUserInput
+					rank = -rank
+				}
+				variations := uppercaseVariations(runes[match.i:match.j])
+				match.capitalized = variations > 1
+				match.guesses = float64(rank) * variations
+				if l33t {
+					match.guesses *= 2
+				}
+				if reversed {
+					match.guesses *= 2
+				}
+				matches = append(matches, match)
+			}
+		}
+	}
+
+	find(lower, false, false)
+	for _, table := range l33tTables {
+		if translated, ok := unl33t(lower, table); ok {
+			find(translated, true, false)
+		}
+	}
+	reversed := make([]rune, len(lower))
+	for i, r := range lower {
+		reversed[len(lower)-1-i] = r
+	}
+	find(reversed, false, true)
+
+	return matches
+}
+
+// userInputWords returns the lowercase input and its parts, so that both
+// "jane.doe@example.com" and "jane" are penalized.
+func userInputWords(input string) []string {
+	input = strings.ToLower(input)
+	words := []string{input}
+	for _, part := range strings.FieldsFunc(input, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if len([]rune(part)) >= 3 {
+			words = append(words, part)
+		}
+	}
+	return words
+}
+
+// uppercaseVariations is the number of ways to capitalize a word with as
+// many uppercase letters as this one has, counting only capitalizing the
+// first or last letter, or all of them, as a single extra guess.
+func uppercaseVariations(word []rune) float64 {
+	upper, lower := 0, 0
+	for _, r := range word {
+		switch {
+		case unicode.IsUpper(r):
+			upper++
+		case unicode.IsLower(r):
+			lower++
+		}
+	}
+	switch {
+	case upper == 0:
+		return 1
+	case lower == 0, upper == 1 && (unicode.IsUpper(word[0]) || unicode.IsUpper(word[len(word)-1])):
+		return 2
+	}
+
+	variations := 0.0
+	for k := 1; k <= upper && k <= lower; k++ {
+		variations += binomial(upper+lower, k)
+	}
+	return variations
+}
+
+func binomial(n, k int) float64 {
+	result := 1.0
+	for i := 1; i <= k; i++ {
+		result = result * float64(n-k+i) / float64(i)
+	}
+	return result
+}
+
+// l33tTables undo the common substitutions of letters by digits and
+// symbols. "1" stands for both "i" and "l", so there are two tables.
+var l33tTables = []map[rune]rune{
+	{'4': 'a', '@': 'a', '8': 'b', '(': 'c', '3': 'e', '6': 'g', '1': 'i', '!': 'i', '0': 'o', '$': 's', '5': 's', '7': 't', '+': 't', '2': 'z'},
+	{'4': 'a', '@': 'a', '8': 'b', '(': 'c', '3': 'e', '6': 'g', '1': 'l', '|': 'l', '0': 'o', '$': 's', '5': 's', '7': 't', '+': 't', '2': 'z'},
+}
+
+func unl33t(word []rune, table map[rune]rune) ([]rune, bool) {
+	translated := make([]rune, len(word))
+	changed := false
+	for i, r := range word {
+		if sub, ok := table[r]; ok {
+			translated[i] = sub
+			changed = true
+		} else {
+			translated[i] = r
+		}
+	}
+	return translated, changed
+}
+
+// sequenceMatches finds runs like "abcd" or "9876" of at least three
+// characters.
+func sequenceMatches(lower []rune) []passwordMatch {
+	var matches []passwordMatch
+	for i := 0; i+2 < len(lower); {
+		delta := lower[i+1] - lower[i]
+		j := i + 1
+		for (delta == 1 || delta == -1) && j+1 < len(lower) && lower[j+1]-lower[j] == delta {
+			j++
+		}
+		if j-i+1 < 3 {
+			i++
+			continue
+		}
+
+		base := 26.0
+		switch {
+		case strings.ContainsRune("az019", lower[i]):
+			base = 4
+		case unicode.IsDigit(lower[i]):
+			base = 10
+		}
+		if delta < 0 {
+			base *= 2
+		}
+		matches = append(matches, passwordMatch{i: i, j: j + 1, kind: matchSequence, guesses: base * float64(j-i+1)})
+		i = j + 1
+	}
+	return matches
+}
+
+// repeatMatches finds a character or group of characters repeated, such as
+// "aaa" or "abcabc".
+func repeatMatches(runes []rune) []passwordMatch {
+	var matches []passwordMatch
+	for i := range runes {
+		for unit := 1; i+2*unit <= len(runes); unit++ {
+			count := 1
+			for i+(count+1)*unit <= len(runes) && string(runes[i+count*unit:i+(count+1)*unit]) == string(runes[i:i+unit]) {
+				count++
+			}
+			if count < 2 || count*unit < 3 {
+				continue
+			}
+
+			unitGuesses := EstimateStrength(string(runes[i : i+unit])).Guesses
+			matches = append(matches, passwordMatch{i: i, j: i + count*unit, kind: matchRepeat, guesses: unitGuesses * float64(count)})
+		}
+	}
+	return matches
+}
+
+var keyboardRows = []string{
+	"`1234567890-=",
+	"qwertyuiop[]\\",
+	"asdfghjkl;'",
+	"zxcvbnm,./",
+	"qwertzuiopü",
+	"azertyuiop",
+	"qsdfghjklm",
+	"wxcvbn",
+}
+
+// keyboardMatches finds straight runs along a row of the keyboard, such as
+// "asdf" or "poiuy", of at least four keys.
+func keyboardMatches(lower []rune) []passwordMatch {
+	var matches []passwordMatch
+	for _, row := range keyboardRows {
+		keys := []rune(row)
+		for i := 0; i < len(lower); i++ {
+			for _, step := range []int{1, -1} {
+				pos := indexRune(keys, lower[i])
+				if pos < 0 {
+					continue
+				}
+				j := i + 1
+				for j < len(lower) && pos+step >= 0 && pos+step < len(keys) && keys[pos+step] == lower[j] {
+					pos += step
+					j++
+				}
+				if j-i >= 4 {
+					matches = append(matches, passwordMatch{i: i, j: j, kind: matchKeyboard, guesses: float64(2*len(keys)) * float64(j-i)})
+				}
+			}
+		}
+	}
+	return matches
+}
+
+func indexRune(runes []rune, r rune) int {
+	for i, c := range runes {
+		if c == r {
+			return i
+		}
+	}
+	return -1
+}
+
+// yearMatches finds years from 1900 to 2099. The closer a year is to now,
+// the more likely it is to mean something to the user.
+func yearMatches(runes []rune) []passwordMatch {
+	var matches []passwordMatch
+	now := time.Now().Year()
+	for i := 0; i+4 <= len(runes); i++ {
+		year := 0
+		for _, r := range runes[i : i+4] {
+			if r < '0' || r > '9' {
+				year = -1
+				break
+			}
+			year = year*10 + int(r-'0')
+		}
+		if year < 1900 || year > 2099 {
+			continue
+		}
+		matches = append(matches, passwordMatch{i: i, j: i + 4, kind: matchYear, guesses: math.Max(math.Abs(float64(year-now)), 20)})
+	}
+	return matches
+}
+
+// strengthFeedback explains the longest guessable piece of a weak password.
+func strengthFeedback(pieces []passwordMatch, length int) (string, []string) {
+	suggestions := []string{"add another word or two; uncommon words are better"}
+	if len(pieces) == 0 {
+		return "", append(suggestions, "use a longer password")
+	}
+
+	longest := pieces[0]
+	for _, piece := range pieces[1:] {
+		if piece.j-piece.i > longest.j-longest.i {
+			longest = piece
+		}
+	}
+	whole := longest.i == 0 && longest.j == length
+
+	var warning string
+	switch longest.kind {
+	case matchUserInput:
+		warning = "it contains your username, name or email address"
+	case matchDictionary:
+		warning = "it contains a commonly used password"
+		if whole {
+			warning = "it is a commonly used password"
+		}
+		if longest.capitalized {
+			suggestions = append(suggestions, "capitalization does not help very much")
+		}
+		if longest.l33t {
+			suggestions = append(suggestions, "predictable substitutions like '@' instead of 'a' do not help very much")
+		}
+		if longest.reversed {
+			suggestions = append(suggestions, "reversed words are not much harder to guess")
+		}
+	case matchSequence:
+		warning = "sequences like abc or 6543 are easy to guess"
+		suggestions = append(suggestions, "avoid sequences")
+	case matchRepeat:
+		warning = "repeats like aaa or abcabc are easy to guess"
+		suggestions = append(suggestions, "avoid repeated words and characters")
+	case matchKeyboard:
+		warning = "straight rows of keys are easy to guess"
+		suggestions = append(suggestions, "use a longer keyboard pattern with more turns")
+	case matchYear:
+		warning = "recent years are easy to guess"
+		suggestions = append(suggestions, "avoid years that are associated with you")
+	}
+	return warning, suggestions
+}
+
+// commonPasswordRanks maps the most common passwords, lowercased, to their
+// rank in leaked password lists.
+var commonPasswordRanks = func() map[string]int {
+	ranks := make(map[string]int, len(commonPasswords))
+	for i, password := range commonPasswords {
+		if _, ok := ranks[password]; !ok {
+			ranks[password] = i + 1
+		}
+	}
+	return ranks
+}()
+
+var commonPasswords = []string{
+	"123456", "password", "123456789", "12345678", "12345", "qwerty", "1234567", "111111", "1234567890", "123123",
+	"abc123", "1234", "password1", "iloveyou", "000000", "qwerty123", "dragon", "monkey", "letmein", "654321",
+	"666666", "123321", "baseball", "football", "sunshine", "princess", "welcome", "shadow", "superman", "michael",
+	"master", "trustno1", "qwertyuiop", "7777777", "121212", "starwars", "login", "admin", "passw0rd", "hello",
+	"freedom", "whatever", "qazwsx", "zaq1zaq1", "batman", "access", "charlie", "donald", "flower", "hottie",
+	"loveme", "solo", "ninja", "mustang", "jesus", "ashley", "bailey", "azerty", "asdfgh", "asdfghjkl",
+	"zxcvbnm", "1q2w3e4r", "1qaz2wsx", "q1w2e3r4", "aaaaaa", "abcdef", "696969", "888888", "987654321", "computer",
+	"cookie", "cheese", "chelsea", "daniel", "default", "diamond", "guest", "hunter", "internet", "jennifer",
+	"jordan", "killer", "lovely", "maggie", "matrix", "merlin", "michelle", "nicole", "pepper", "robert",
+	"secret", "silver", "soccer", "summer", "winter", "spring", "autumn", "sunday", "tigger", "thomas",
+	"trustme", "welcome1", "yankees", "liverpool", "arsenal", "samsung", "google", "apple", "orange", "banana",
+	"chocolate", "love", "money", "family", "london", "paris", "change", "changeme", "test", "testing",
+	"root", "user", "system", "server", "security", "company", "office", "monday", "friday", "pokemon",
+	"naruto", "ginger", "buster", "harley", "hannah", "jessica", "andrew", "joshua", "taylor", "matthew",
+	"pass", "qwe", "abc", "asd", "zxc", "god", "lol",
+}
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const maxSCIMClientNameLen = 50
+
+// SCIMClient is a provisioning system, such as an HR tool, that manages users
+// and groups through the SCIM API with a bearer token. It only sees the
+// members and groups of its organization.
+type SCIMClient struct {
+	ID             uuid.UUID  `json:"id"`
+	OrganizationID uuid.UUID  `json:"organization_id"`
+	Name           string     `json:"name"`
+	TokenHash      string     `json:"-"`
+	CreatedAt      time.Time  `json:"created_at"`
+	LastUsedAt     *time.Time `json:"last_used_at"`
+}
+
+type CreateSCIMClientParams struct {
+	OrganizationID uuid.UUID `json:"organization_id"`
+	Name           string    `json:"name"`
+}
+
+func (params CreateSCIMClientParams) Validate() map[string]string {
+	errors := map[string]string{}
+
+	if params.OrganizationID == uuid.Nil {
+		errors["organization_id"] = "organization_id is required"
+	}
+	if params.Name == "" || len(params.Name) > maxSCIMClientNameLen {
+		errors["name"] = fmt.Sprintf("name should be between 1 and %d characters", maxSCIMClientNameLen)
+	}
+
+	return errors
+}
@@ -0,0 +1,61 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+// Purposes of signing keys. Each kind of token is signed with its own keys,
+// so one can never be passed off as another.
+const (
+	KeyPurposeAccess       = "access"
+	KeyPurposeRefresh      = "refresh"
+	KeyPurposeMFAChallenge = "mfa_challenge"
+)
+
+var KeyPurposes = []string{KeyPurposeAccess, KeyPurposeRefresh, KeyPurposeMFAChallenge}
+
+const signingKeySecretBytes = 32
+
+// SigningKey is an HMAC key for tokens. Its ID goes into the kid header of
+// every token it signs. A retired key stops verifying tokens at RetiredAt.
+type SigningKey struct {
+	ID              string     `json:"id"`
+	Purpose         string     `json:"purpose"`
+	Secret          []byte     `json:"-"`
+	EncryptedSecret string     `json:"-"`
+	CreatedAt       time.Time  `json:"created_at"`
+	RetiredAt       *time.Time `json:"retired_at"`
+}
+
+func IsKeyPurpose(purpose string) bool {
+	for _, p := range KeyPurposes {
+		if p == purpose {
+			return true
+		}
+	}
+	return false
+}
+
+func NewSigningKey(purpose string) (*SigningKey, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	secret := make([]byte, signingKeySecretBytes)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+
+	return &SigningKey{
+		ID:      hex.EncodeToString(id),
+		Purpose: purpose,
+		Secret:  secret,
+	}, nil
+}
+
+// ValidAt reports whether tokens signed with the key are still accepted.
+func (k *SigningKey) ValidAt(t time.Time) bool {
+	return k.RetiredAt == nil || k.RetiredAt.After(t)
+}
@@ -1,15 +1,50 @@
 package models
 
 import (
+	"time"
+
 	"github.com/google/uuid"
 )
 
+const (
+	UserStatusActive          = "active"
+	UserStatusSuspended       = "suspended"
+	UserStatusDeactivated     = "deactivated"
+	UserStatusPendingDeletion = "pending_deletion"
+)
+
 type User struct {
-	ID                uuid.UUID `json:"id"`
-	UserName          string    `json:"username"`
-	Email             string    `json:"email"`
-	EncryptedPassword string    `json:"encrypted_password"`
-	IsAdmin           bool      `json:"is_admin"`
+	ID                uuid.UUID    `json:"id"`
+	UserName          string       `json:"username"`
+	Email             string       `json:"email"`
+	EncryptedPassword string       `json:"-"`
+	IsAdmin           bool         `json:"is_admin"`
+	EmailVerified     bool         `json:"email_verified"`
+	FirstName         *string      `json:"first_name"`
+	LastName          *string      `json:"last_name"`
+	AvatarURL         *string      `json:"avatar_url"`
+	CreatedAt         time.Time    `json:"created_at"`
+	UpdatedAt         time.Time    `json:"updated_at"`
+	Version           int64        `json:"version"`
+	Status            string       `json:"status"`
+	StatusChangedAt   time.Time    `json:"status_changed_at"`
+	Metadata          UserMetadata `json:"metadata"`
+}
+
+// PasswordInputs returns the details of the user that their password should
+// not be built from, for PasswordPolicy.Check.
+func (u *User) PasswordInputs() []string {
+	inputs := []string{u.UserName, u.Email}
+	for _, name := range []*string{u.FirstName, u.LastName} {
+		if name != nil {
+			inputs = append(inputs, *name)
+		}
+	}
+	return inputs
+}
+
+func (u *User) IsActive() bool {
+	return u.Status == UserStatusActive
 }
 
 func NewUUID() uuid.UUID {
@@ -1,8 +1,11 @@
 package models
 
 import (
+	"encoding/json"
 	"fmt"
+	"net/url"
 	"regexp"
+	"strings"
 
 	"golang.org/x/crypto/bcrypt"
 )
@@ -10,13 +13,28 @@ import (
 const (
 	bcryptCost     = 12
 	minUserNameLen = 2
-	minPasswordLen = 7
+	maxUserNameLen = 20
+	maxNameLen     = 50
 )
 
 type CreateUserParams struct {
 	UserName string `json:"username"`
 	Email    string `json:"email"`
 	Password string `json:"password"`
+	// Metadata is the user section of the new user's metadata.
+	Metadata map[string]interface{} `json:"metadata"`
+}
+
+// NormalizeEmail returns the form email addresses are stored and looked up
+// in. Addresses are compared without regard to case.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// NormalizeUserName trims the username. Its case is kept for display, but
+// usernames are unique without regard to case.
+func NormalizeUserName(userName string) string {
+	return strings.TrimSpace(userName)
 }
 
 func IsEmailValid(e string) bool {
@@ -24,24 +42,36 @@ func IsEmailValid(e string) bool {
 	return emailRegex.MatchString(e)
 }
 
-func (params CreateUserParams) Validate() map[string]string {
+// Validate checks the params, including the password against policy.
+func (params CreateUserParams) Validate(policy PasswordPolicy) map[string]string {
 	errors := map[string]string{}
 
 	if len(params.UserName) < minUserNameLen {
 		errors["username"] = fmt.Sprintf("username length should be at least %d characters", minUserNameLen)
 	}
-	if len(params.Password) < minPasswordLen {
-		errors["password"] = fmt.Sprintf("password length should be at least %d characters", minPasswordLen)
+	if msg := policy.checkPassword(params.Password, params.UserName, params.Email); msg != "" {
+		errors["password"] = msg
 	}
 	if !IsEmailValid(params.Email) {
 		errors["email"] = fmt.Sprintf("email %s is invalid", params.Email)
 	}
+	if b, err := json.Marshal(params.Metadata); err != nil || len(b) > MaxMetadataBytes {
+		errors["metadata"] = fmt.Sprintf("metadata should be at most %d bytes", MaxMetadataBytes)
+	}
 
 	return errors
 }
 
+func EncryptPassword(pw string) (string, error) {
+	encpw, err := bcrypt.GenerateFromPassword([]byte(pw), bcryptCost)
+	if err != nil {
+		return "", err
+	}
+	return string(encpw), nil
+}
+
 func NewUserFromParams(params CreateUserParams) (*User, error) {
-	encpw, err := bcrypt.GenerateFromPassword([]byte(params.Password), bcryptCost)
+	encpw, err := EncryptPassword(params.Password)
 	if err != nil {
 		return nil, err
 	}
@@ -52,24 +82,141 @@ func NewUserFromParams(params CreateUserParams) (*User, error) {
 		ID:                userID,
 		UserName:          params.UserName,
 		Email:             params.Email,
-		EncryptedPassword: string(encpw),
+		EncryptedPassword: encpw,
+		Metadata:          UserMetadata{User: params.Metadata, Admin: map[string]interface{}{}},
 	}, nil
 }
 
+// UnusablePassword is stored for accounts that have to reset their password
+// before they can log in with one. It is not a bcrypt hash, so no password
+// ever matches it.
+const UnusablePassword = "!"
+
 func IsValidPassword(encpw, pw string) bool {
 	return bcrypt.CompareHashAndPassword([]byte(encpw), []byte(pw)) == nil
 }
 
+// UpdateUserParams is decoded from a JSON Merge Patch document, so members
+// that are absent stay untouched and members set to null are cleared.
 type UpdateUserParams struct {
-	UserName string `json:"username"`
+	UserName  NullableString `json:"username"`
+	FirstName NullableString `json:"first_name"`
+	LastName  NullableString `json:"last_name"`
+	AvatarURL NullableString `json:"avatar_url"`
+	// Metadata is a merge patch for the metadata, see UserMetadata.Apply.
+	Metadata json.RawMessage `json:"metadata"`
+	// MergedMetadata is the metadata with Metadata applied, which is what
+	// gets stored. The caller sets it after reading the current metadata.
+	MergedMetadata *UserMetadata `json:"-"`
+}
+
+func (p UpdateUserParams) Validate() map[string]string {
+	errors := map[string]string{}
+
+	if p.UserName.Set {
+		switch {
+		case !p.UserName.Valid:
+			errors["username"] = "username cannot be removed"
+		case len(p.UserName.Value) < minUserNameLen:
+			errors["username"] = fmt.Sprintf("username length should be at least %d characters", minUserNameLen)
+		case len(p.UserName.Value) > maxUserNameLen:
+			errors["username"] = fmt.Sprintf("username length should be at most %d characters", maxUserNameLen)
+		}
+	}
+	if p.FirstName.Valid && len(p.FirstName.Value) > maxNameLen {
+		errors["first_name"] = fmt.Sprintf("first name length should be at most %d characters", maxNameLen)
+	}
+	if p.LastName.Valid && len(p.LastName.Value) > maxNameLen {
+		errors["last_name"] = fmt.Sprintf("last name length should be at most %d characters", maxNameLen)
+	}
+	if p.AvatarURL.Valid && !isHTTPURL(p.AvatarURL.Value) {
+		errors["avatar_url"] = fmt.Sprintf("avatar url %s is invalid", p.AvatarURL.Value)
+	}
+
+	return errors
 }
 
+// ToFieldsMap returns the columns to update keyed by column name. Cleared
+// members map to nil.
 func (p UpdateUserParams) ToFieldsMap() map[string]interface{} {
 	fields := map[string]interface{}{}
 
-	if len(p.UserName) > 0 {
-		fields["username"] = p.UserName
+	if p.UserName.Set {
+		fields["username"] = p.UserName.FieldValue()
+	}
+	if p.FirstName.Set {
+		fields["first_name"] = p.FirstName.FieldValue()
+	}
+	if p.LastName.Set {
+		fields["last_name"] = p.LastName.FieldValue()
+	}
+	if p.AvatarURL.Set {
+		fields["avatar_url"] = p.AvatarURL.FieldValue()
+	}
+	if p.MergedMetadata != nil {
+		fields["metadata"] = *p.MergedMetadata
 	}
 
 	return fields
 }
+
+func isHTTPURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// ProvisionUserParams is everything a provisioning client changes on a user
+// with one request. Empty Email, EncryptedPassword and Status leave the
+// stored values alone.
+type ProvisionUserParams struct {
+	Profile           UpdateUserParams
+	Email             string
+	EncryptedPassword string
+	Status            string
+}
+
+type ForgotPasswordParams struct {
+	Email string `json:"email"`
+}
+
+type ResetPasswordParams struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// Validate checks the params. userInputs describe the user whose password is
+// reset, see PasswordPolicy.Check.
+func (params ResetPasswordParams) Validate(policy PasswordPolicy, userInputs ...string) map[string]string {
+	errors := map[string]string{}
+
+	if len(params.Token) == 0 {
+		errors["token"] = "token is required"
+	}
+	if msg := policy.checkPassword(params.Password, userInputs...); msg != "" {
+		errors["password"] = msg
+	}
+
+	return errors
+}
+
+type ChangePasswordParams struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// Validate checks the params. userInputs describe the user changing their
+// password, see PasswordPolicy.Check.
+func (params ChangePasswordParams) Validate(policy PasswordPolicy, userInputs ...string) map[string]string {
+	errors := map[string]string{}
+
+	if len(params.CurrentPassword) == 0 {
+		errors["current_password"] = "current password is required"
+	}
+	if msg := policy.checkPassword(params.NewPassword, userInputs...); msg != "" {
+		errors["new_password"] = msg
+	} else if params.NewPassword == params.CurrentPassword {
+		errors["new_password"] = "new password must differ from the current password"
+	}
+
+	return errors
+}
@@ -0,0 +1,41 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// WebAuthnCredential is a registered authenticator. Data holds the full
+// credential record as JSON so the ceremony library can be handed back
+// exactly what it produced at registration.
+type WebAuthnCredential struct {
+	ID           uuid.UUID  `json:"id"`
+	UserID       uuid.UUID  `json:"user_id"`
+	CredentialID []byte     `json:"-"`
+	Name         string     `json:"name"`
+	SignCount    uint32     `json:"sign_count"`
+	Data         []byte     `json:"-"`
+	CreatedAt    time.Time  `json:"created_at"`
+	LastUsedAt   *time.Time `json:"last_used_at"`
+}
+
+const (
+	WebAuthnRegistration = "registration"
+	WebAuthnSecondFactor = "second_factor"
+	WebAuthnPasswordless = "passwordless"
+)
+
+// WebAuthnChallenge keeps the server side state of a ceremony between its
+// begin and finish requests.
+type WebAuthnChallenge struct {
+	ID        uuid.UUID  `json:"id"`
+	UserID    *uuid.UUID `json:"user_id"`
+	Purpose   string     `json:"purpose"`
+	Data      []byte     `json:"-"`
+	ExpiresAt time.Time  `json:"expires_at"`
+}
+
+type WebAuthnBeginLoginParams struct {
+	MFAToken string `json:"mfa_token"`
+}
@@ -0,0 +1,150 @@
+// Package oidc is a relying party for OpenID Connect providers using the
+// authorization code flow with PKCE.
+package oidc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	gooidc "github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+var ErrNonceMismatch = errors.New("oidc: id token nonce does not match")
+
+type ProviderConfig struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	// AutoProvision creates a local user the first time someone logs in with
+	// an identity that is not linked to any account yet.
+	AutoProvision bool
+}
+
+// Identity holds the claims of a verified ID token that are used to find or
+// provision the local user.
+type Identity struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	PreferredUsername string
+	GivenName         string
+	FamilyName        string
+}
+
+// Provider discovers its endpoints and keys on first use, so the API can start
+// while a provider is unreachable.
+type Provider struct {
+	config ProviderConfig
+
+	mu       sync.Mutex
+	provider *gooidc.Provider
+	verifier *gooidc.IDTokenVerifier
+}
+
+func NewProvider(config ProviderConfig) *Provider {
+	return &Provider{config: config}
+}
+
+func (p *Provider) Name() string {
+	return p.config.Name
+}
+
+func (p *Provider) AutoProvision() bool {
+	return p.config.AutoProvision
+}
+
+// AuthCodeURL returns the URL to send the browser to. The codeVerifier is the
+// PKCE secret that has to be passed to Exchange later.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, codeVerifier string) (string, error) {
+	oauth, _, err := p.oauth(ctx)
+	if err != nil {
+		return "", err
+	}
+	return oauth.AuthCodeURL(state, gooidc.Nonce(nonce), oauth2.S256ChallengeOption(codeVerifier)), nil
+}
+
+// Exchange redeems the authorization code and validates the returned ID token
+// against the provider's keys, the client ID and the nonce.
+func (p *Provider) Exchange(ctx context.Context, code, codeVerifier, nonce string) (*Identity, error) {
+	oauth, verifier, err := p.oauth(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := oauth.Exchange(ctx, code, oauth2.VerifierOption(codeVerifier))
+	if err != nil {
+		return nil, fmt.Errorf("oidc: code exchange failed: %w", err)
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("oidc: token response has no id_token")
+	}
+
+	idToken, err := verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: invalid id token: %w", err)
+	}
+	if idToken.Nonce != nonce {
+		return nil, ErrNonceMismatch
+	}
+
+	var claims struct {
+		Email             string `json:"email"`
+		EmailVerified     bool   `json:"email_verified"`
+		PreferredUsername string `json:"preferred_username"`
+		GivenName         string `json:"given_name"`
+		FamilyName        string `json:"family_name"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("oidc: invalid id token claims: %w", err)
+	}
+
+	return &Identity{
+		Subject:           idToken.Subject,
+		Email:             claims.Email,
+		EmailVerified:     claims.EmailVerified,
+		PreferredUsername: claims.PreferredUsername,
+		GivenName:         claims.GivenName,
+		FamilyName:        claims.FamilyName,
+	}, nil
+}
+
+func (p *Provider) oauth(ctx context.Context) (*oauth2.Config, *gooidc.IDTokenVerifier, error) {
+	if err := p.discover(ctx); err != nil {
+		return nil, nil, err
+	}
+
+	return &oauth2.Config{
+		ClientID:     p.config.ClientID,
+		ClientSecret: p.config.ClientSecret,
+		RedirectURL:  p.config.RedirectURL,
+		Endpoint:     p.provider.Endpoint(),
+		Scopes:       p.config.Scopes,
+	}, p.verifier, nil
+}
+
+// discover fetches the provider metadata once and keeps a verifier that caches
+// the provider's signing keys. A failed discovery is retried on the next call.
+func (p *Provider) discover(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.provider != nil {
+		return nil
+	}
+
+	provider, err := gooidc.NewProvider(ctx, p.config.Issuer)
+	if err != nil {
+		return fmt.Errorf("oidc: discovery for %s failed: %w", p.config.Name, err)
+	}
+	p.provider = provider
+	p.verifier = provider.Verifier(&gooidc.Config{ClientID: p.config.ClientID})
+	return nil
+}
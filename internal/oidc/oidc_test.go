@@ -0,0 +1,250 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testClientID = "api"
+	testKeyID    = "test-key"
+)
+
+type authorization struct {
+	challenge string
+	nonce     string
+}
+
+// stubProvider is an OpenID provider serving discovery, its signing keys and
+// a token endpoint that checks the PKCE verifier of each code.
+type stubProvider struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	claims map[string]interface{}
+
+	mu    sync.Mutex
+	codes map[string]authorization
+}
+
+func newStubProvider(t *testing.T, claims map[string]interface{}) *stubProvider {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &stubProvider{key: key, claims: claims, codes: map[string]authorization{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.handleDiscovery)
+	mux.HandleFunc("/keys", p.handleKeys)
+	mux.HandleFunc("/token", p.handleToken)
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+func (p *stubProvider) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"issuer":                                p.server.URL,
+		"authorization_endpoint":                p.server.URL + "/authorize",
+		"token_endpoint":                        p.server.URL + "/token",
+		"jwks_uri":                              p.server.URL + "/keys",
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+	})
+}
+
+func (p *stubProvider) handleKeys(w http.ResponseWriter, r *http.Request) {
+	encode := base64.RawURLEncoding.EncodeToString
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"alg": "RS256",
+			"use": "sig",
+			"kid": testKeyID,
+			"n":   encode(p.key.N.Bytes()),
+			"e":   encode(big.NewInt(int64(p.key.E)).Bytes()),
+		}},
+	})
+}
+
+func (p *stubProvider) handleToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	p.mu.Lock()
+	auth, ok := p.codes[r.PostForm.Get("code")]
+	delete(p.codes, r.PostForm.Get("code"))
+	p.mu.Unlock()
+
+	verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(verifier[:]) != auth.challenge {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	claims := jwt.MapClaims{
+		"iss":   p.server.URL,
+		"aud":   testClientID,
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"nonce": auth.nonce,
+	}
+	for name, value := range p.claims {
+		claims[name] = value
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = testKeyID
+	idToken, err := token.SignedString(p.key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idToken,
+	})
+}
+
+// authorize plays the user consenting at the provider: it checks the
+// authorization request and returns the code the browser is sent back with.
+func (p *stubProvider) authorize(t *testing.T, authURL, state string) string {
+	t.Helper()
+
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if u.Path != "/authorize" || q.Get("client_id") != testClientID || q.Get("response_type") != "code" {
+		t.Fatalf("unexpected authorization request %s", authURL)
+	}
+	if q.Get("state") != state {
+		t.Fatalf("state = %q, want %q", q.Get("state"), state)
+	}
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		t.Fatalf("authorization request %s has no S256 code challenge", authURL)
+	}
+
+	code := "code-" + state
+	p.mu.Lock()
+	p.codes[code] = authorization{challenge: q.Get("code_challenge"), nonce: q.Get("nonce")}
+	p.mu.Unlock()
+	return code
+}
+
+func newTestProvider(stub *stubProvider) *Provider {
+	return NewProvider(ProviderConfig{
+		Name:         "stub",
+		Issuer:       stub.server.URL,
+		ClientID:     testClientID,
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost/login/oidc/stub/callback",
+		Scopes:       []string{"openid", "email", "profile"},
+	})
+}
+
+func TestExchangeReturnsVerifiedIdentity(t *testing.T) {
+	stub := newStubProvider(t, map[string]interface{}{
+		"sub":                "subject-1",
+		"email":              "alice@example.com",
+		"email_verified":     true,
+		"preferred_username": "alice",
+		"given_name":         "Alice",
+		"family_name":        "Liddell",
+	})
+	provider := newTestProvider(stub)
+	ctx := context.Background()
+
+	authURL, err := provider.AuthCodeURL(ctx, "state-1", "nonce-1", "verifier-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := stub.authorize(t, authURL, "state-1")
+
+	identity, err := provider.Exchange(ctx, code, "verifier-1", "nonce-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Identity{
+		Subject:           "subject-1",
+		Email:             "alice@example.com",
+		EmailVerified:     true,
+		PreferredUsername: "alice",
+		GivenName:         "Alice",
+		FamilyName:        "Liddell",
+	}
+	if *identity != want {
+		t.Errorf("identity = %+v, want %+v", *identity, want)
+	}
+}
+
+func TestExchangeRejectsWrongCodeVerifier(t *testing.T) {
+	stub := newStubProvider(t, map[string]interface{}{"sub": "subject-1"})
+	provider := newTestProvider(stub)
+	ctx := context.Background()
+
+	authURL, err := provider.AuthCodeURL(ctx, "state-1", "nonce-1", "verifier-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := stub.authorize(t, authURL, "state-1")
+
+	if _, err := provider.Exchange(ctx, code, "verifier-2", "nonce-1"); err == nil {
+		t.Error("Exchange succeeded with a code verifier that does not match the challenge")
+	}
+}
+
+func TestExchangeRejectsNonceMismatch(t *testing.T) {
+	stub := newStubProvider(t, map[string]interface{}{"sub": "subject-1"})
+	provider := newTestProvider(stub)
+	ctx := context.Background()
+
+	authURL, err := provider.AuthCodeURL(ctx, "state-1", "nonce-1", "verifier-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := stub.authorize(t, authURL, "state-1")
+
+	if _, err := provider.Exchange(ctx, code, "verifier-1", "nonce-2"); !errors.Is(err, ErrNonceMismatch) {
+		t.Errorf("Exchange with another nonce: got %v, want ErrNonceMismatch", err)
+	}
+}
+
+func TestExchangeRejectsTokenForAnotherClient(t *testing.T) {
+	stub := newStubProvider(t, map[string]interface{}{"sub": "subject-1", "aud": "other-client"})
+	provider := newTestProvider(stub)
+	ctx := context.Background()
+
+	authURL, err := provider.AuthCodeURL(ctx, "state-1", "nonce-1", "verifier-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := stub.authorize(t, authURL, "state-1")
+
+	if _, err := provider.Exchange(ctx, code, "verifier-1", "nonce-1"); err == nil {
+		t.Error("Exchange accepted an ID token issued to another client")
+	}
+}
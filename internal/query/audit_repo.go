@@ -0,0 +1,74 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"github.com/OsagieDG/jwt-based-auth-system/internal/models"
+	"github.com/google/uuid"
+)
+
+type AuditRepository interface {
+	RecordEvent(ctx context.Context, event *models.AuditEvent) error
+	GetUserEvents(ctx context.Context, userID uuid.UUID) ([]models.AuditEvent, error)
+}
+
+type AuditSQLRepository struct {
+	DB *sql.DB
+}
+
+func NewAuditSQLRepository(db *sql.DB) AuditRepository {
+	return &AuditSQLRepository{DB: db}
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx so audit events can be
+// written inside the transaction of the change they describe.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+func insertAuditEvent(ctx context.Context, db execer, event *models.AuditEvent) error {
+	details, err := json.Marshal(event.Details)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO auth.audit_events (id, user_id, actor_id, action, details) VALUES ($1, $2, $3, $4, $5)`
+	_, err = db.ExecContext(ctx, query, event.ID, event.UserID, event.ActorID, event.Action, details)
+	return err
+}
+
+func (r *AuditSQLRepository) RecordEvent(ctx context.Context, event *models.AuditEvent) error {
+	return insertAuditEvent(ctx, r.DB, event)
+}
+
+func (r *AuditSQLRepository) GetUserEvents(ctx context.Context, userID uuid.UUID) ([]models.AuditEvent, error) {
+	rows, err := r.DB.QueryContext(ctx, `SELECT id, user_id, actor_id, action, details, created_at
+	          FROM auth.audit_events
+	          WHERE user_id = $1
+	          ORDER BY created_at DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []models.AuditEvent
+	for rows.Next() {
+		var (
+			event   models.AuditEvent
+			details []byte
+		)
+		if err := rows.Scan(&event.ID, &event.UserID, &event.ActorID, &event.Action, &details, &event.CreatedAt); err != nil {
+			return nil, err
+		}
+		if len(details) > 0 {
+			if err := json.Unmarshal(details, &event.Details); err != nil {
+				return nil, err
+			}
+		}
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
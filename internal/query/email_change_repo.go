@@ -0,0 +1,152 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/OsagieDG/jwt-based-auth-system/internal/models"
+)
+
+var ErrEmailTaken = errors.New("email address is already in use")
+
+type EmailChangeRepository interface {
+	CreateEmailChangeRequest(ctx context.Context, request *models.EmailChangeRequest) error
+	ConfirmEmailChange(ctx context.Context, confirmTokenHash string) (*models.EmailChangeRequest, error)
+	CancelEmailChange(ctx context.Context, cancelTokenHash string) (*models.EmailChangeRequest, error)
+}
+
+type EmailChangeSQLRepository struct {
+	DB *sql.DB
+}
+
+func NewEmailChangeSQLRepository(db *sql.DB) EmailChangeRepository {
+	return &EmailChangeSQLRepository{DB: db}
+}
+
+// CreateEmailChangeRequest stores a pending request, cancelling any request
+// that is still pending for the same user so only the latest one can be used.
+func (r *EmailChangeSQLRepository) CreateEmailChangeRequest(ctx context.Context, request *models.EmailChangeRequest) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	_, err = tx.ExecContext(ctx, `UPDATE auth.email_change_requests
+	          SET status = $1, resolved_at = NOW()
+	          WHERE user_id = $2 AND status = $3`,
+		models.EmailChangeCancelled, request.UserID, models.EmailChangePending,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO auth.email_change_requests
+	          (id, user_id, old_email, new_email, confirm_token_hash, cancel_token_hash, status, expires_at)
+	          VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		request.ID, request.UserID, request.OldEmail, request.NewEmail,
+		request.ConfirmTokenHash, request.CancelTokenHash, models.EmailChangePending, request.ExpiresAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	event := models.NewAuditEvent(request.UserID, &request.UserID, models.AuditEmailChangeRequested, map[string]interface{}{
+		"old_email": request.OldEmail,
+		"new_email": request.NewEmail,
+	})
+	if err := insertAuditEvent(ctx, tx, event); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ConfirmEmailChange swaps the user's email for the requested one. The new
+// address is checked for uniqueness again since it may have been registered
+// after the request was made.
+func (r *EmailChangeSQLRepository) ConfirmEmailChange(ctx context.Context, confirmTokenHash string) (*models.EmailChangeRequest, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	request, err := resolveEmailChange(ctx, tx, `confirm_token_hash = $2 AND expires_at > NOW()`, confirmTokenHash, models.EmailChangeConfirmed)
+	if err != nil {
+		return nil, err
+	}
+
+	var taken bool
+	err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM auth.users WHERE LOWER(email) = $1 AND id <> $2)`,
+		request.NewEmail, request.UserID,
+	).Scan(&taken)
+	if err != nil {
+		return nil, err
+	}
+	if taken {
+		return nil, ErrEmailTaken
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE auth.users SET email = $1, email_verified = true WHERE id = $2`, request.NewEmail, request.UserID)
+	if err != nil {
+		return nil, duplicateUserError(err)
+	}
+
+	event := models.NewAuditEvent(request.UserID, &request.UserID, models.AuditEmailChangeConfirmed, map[string]interface{}{
+		"old_email": request.OldEmail,
+		"new_email": request.NewEmail,
+	})
+	if err := insertAuditEvent(ctx, tx, event); err != nil {
+		return nil, err
+	}
+
+	return request, tx.Commit()
+}
+
+// CancelEmailChange is used from the link sent to the old address and works
+// for as long as the request is still pending.
+func (r *EmailChangeSQLRepository) CancelEmailChange(ctx context.Context, cancelTokenHash string) (*models.EmailChangeRequest, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	request, err := resolveEmailChange(ctx, tx, `cancel_token_hash = $2`, cancelTokenHash, models.EmailChangeCancelled)
+	if err != nil {
+		return nil, err
+	}
+
+	event := models.NewAuditEvent(request.UserID, &request.UserID, models.AuditEmailChangeCancelled, map[string]interface{}{
+		"old_email": request.OldEmail,
+		"new_email": request.NewEmail,
+	})
+	if err := insertAuditEvent(ctx, tx, event); err != nil {
+		return nil, err
+	}
+
+	return request, tx.Commit()
+}
+
+func resolveEmailChange(ctx context.Context, tx *sql.Tx, condition, tokenHash, status string) (*models.EmailChangeRequest, error) {
+	var request models.EmailChangeRequest
+	err := tx.QueryRowContext(ctx, `UPDATE auth.email_change_requests
+	          SET status = $1, resolved_at = NOW()
+	          WHERE status = $3 AND `+condition+`
+	          RETURNING id, user_id, old_email, new_email, status, expires_at, created_at, resolved_at`,
+		status, tokenHash, models.EmailChangePending,
+	).Scan(
+		&request.ID, &request.UserID, &request.OldEmail, &request.NewEmail,
+		&request.Status, &request.ExpiresAt, &request.CreatedAt, &request.ResolvedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInvalidToken
+		}
+		return nil, err
+	}
+
+	return &request, nil
+}
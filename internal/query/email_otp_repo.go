@@ -0,0 +1,68 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/OsagieDG/jwt-based-auth-system/internal/models"
+	"github.com/google/uuid"
+)
+
+type EmailOTPRepository interface {
+	SaveEmailOTP(ctx context.Context, otp *models.EmailOTP) error
+	ClaimEmailOTPAttempt(ctx context.Context, userID uuid.UUID, maxAttempts int) (*models.EmailOTP, error)
+	UseEmailOTP(ctx context.Context, id uuid.UUID) error
+}
+
+type EmailOTPSQLRepository struct {
+	DB *sql.DB
+}
+
+func NewEmailOTPSQLRepository(db *sql.DB) EmailOTPRepository {
+	return &EmailOTPSQLRepository{DB: db}
+}
+
+// SaveEmailOTP stores a new code and discards any unused code previously
+// sent to the same user, so only the latest code works.
+func (r *EmailOTPSQLRepository) SaveEmailOTP(ctx context.Context, otp *models.EmailOTP) error {
+	_, err := r.DB.ExecContext(ctx, `DELETE FROM auth.email_otp_codes WHERE user_id = $1 AND used_at IS NULL`, otp.UserID)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO auth.email_otp_codes (id, user_id, code_hash, expires_at) VALUES ($1, $2, $3, $4)`
+	_, err = r.DB.ExecContext(ctx, query, otp.ID, otp.UserID, otp.CodeHash, otp.ExpiresAt)
+	return err
+}
+
+// ClaimEmailOTPAttempt counts an attempt against the user's live code before
+// it is compared, so concurrent guesses cannot get past maxAttempts. It
+// returns ErrInvalidToken if there is no code left to try.
+func (r *EmailOTPSQLRepository) ClaimEmailOTPAttempt(ctx context.Context, userID uuid.UUID, maxAttempts int) (*models.EmailOTP, error) {
+	var otp models.EmailOTP
+	err := r.DB.QueryRowContext(ctx, `UPDATE auth.email_otp_codes
+	          SET attempts = attempts + 1
+	          WHERE user_id = $1 AND used_at IS NULL AND expires_at > NOW() AND attempts < $2
+	          RETURNING id, user_id, code_hash, attempts, expires_at, used_at, created_at`, userID, maxAttempts).Scan(
+		&otp.ID, &otp.UserID, &otp.CodeHash, &otp.Attempts, &otp.ExpiresAt, &otp.UsedAt, &otp.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInvalidToken
+		}
+		return nil, err
+	}
+	return &otp, nil
+}
+
+func (r *EmailOTPSQLRepository) UseEmailOTP(ctx context.Context, id uuid.UUID) error {
+	result, err := r.DB.ExecContext(ctx, `UPDATE auth.email_otp_codes SET used_at = NOW() WHERE id = $1 AND used_at IS NULL`, id)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrInvalidToken
+	}
+	return nil
+}
@@ -0,0 +1,248 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/OsagieDG/jwt-based-auth-system/internal/models"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrGroupNameTaken = errors.New("group name is already in use")
+	ErrUnknownMember  = errors.New("group member does not exist")
+)
+
+type GroupRepository interface {
+	CreateGroup(ctx context.Context, group *models.Group) error
+	GetGroupByID(ctx context.Context, groupID uuid.UUID) (*models.Group, error)
+	FindGroups(ctx context.Context, conditions []Condition, offset, limit int) ([]models.Group, int, error)
+	GetUserGroups(ctx context.Context, userID uuid.UUID) ([]models.Group, error)
+	ReplaceGroup(ctx context.Context, group *models.Group) error
+	DeleteGroup(ctx context.Context, groupID uuid.UUID) error
+}
+
+// searchableGroupColumns lists the columns FindGroups may filter on.
+var searchableGroupColumns = map[string]bool{
+	"id":           true,
+	"display_name": true,
+}
+
+// GroupSQLRepository works on the groups of one organization, whose members
+// are the only users that can join them.
+type GroupSQLRepository struct {
+	DB             *sql.DB
+	OrganizationID uuid.UUID
+}
+
+func NewGroupSQLRepository(db *sql.DB, organizationID uuid.UUID) GroupRepository {
+	return &GroupSQLRepository{DB: db, OrganizationID: organizationID}
+}
+
+func (r *GroupSQLRepository) CreateGroup(ctx context.Context, group *models.Group) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := r.checkGroupName(ctx, tx, group); err != nil {
+		return err
+	}
+
+	err = tx.QueryRowContext(ctx, `INSERT INTO auth.groups (id, organization_id, display_name) VALUES ($1, $2, $3) RETURNING created_at, updated_at`,
+		group.ID, r.OrganizationID, group.DisplayName,
+	).Scan(&group.CreatedAt, &group.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	if err := r.insertGroupMembers(ctx, tx, group); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *GroupSQLRepository) GetGroupByID(ctx context.Context, groupID uuid.UUID) (*models.Group, error) {
+	var group models.Group
+	err := r.DB.QueryRowContext(ctx, `SELECT id, display_name, created_at, updated_at FROM auth.groups WHERE id = $1 AND organization_id = $2`, groupID, r.OrganizationID).Scan(
+		&group.ID, &group.DisplayName, &group.CreatedAt, &group.UpdatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("group with ID %s not found: %w", groupID.String(), sql.ErrNoRows)
+		}
+		return nil, err
+	}
+
+	if group.Members, err = r.getGroupMembers(ctx, group.ID); err != nil {
+		return nil, err
+	}
+	return &group, nil
+}
+
+// FindGroups returns one page of the groups matching all conditions, ordered
+// by name, together with the total number of matches.
+func (r *GroupSQLRepository) FindGroups(ctx context.Context, conditions []Condition, offset, limit int) ([]models.Group, int, error) {
+	where, args, err := buildWhere(searchableGroupColumns, conditions)
+	if err != nil {
+		return nil, 0, err
+	}
+	args = append(args, r.OrganizationID)
+	where = fmt.Sprintf("%s AND organization_id = $%d", where, len(args))
+
+	var total int
+	if err := r.DB.QueryRowContext(ctx, `SELECT COUNT(*) FROM auth.groups WHERE `+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := fmt.Sprintf(`SELECT id, display_name, created_at, updated_at FROM auth.groups WHERE %s ORDER BY display_name LIMIT $%d OFFSET $%d`,
+		where, len(args)+1, len(args)+2)
+	groups, err := r.queryGroups(ctx, query, append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	for i := range groups {
+		if groups[i].Members, err = r.getGroupMembers(ctx, groups[i].ID); err != nil {
+			return nil, 0, err
+		}
+	}
+	return groups, total, nil
+}
+
+// GetUserGroups returns the groups the user belongs to, without their
+// members.
+func (r *GroupSQLRepository) GetUserGroups(ctx context.Context, userID uuid.UUID) ([]models.Group, error) {
+	return r.queryGroups(ctx, `SELECT g.id, g.display_name, g.created_at, g.updated_at
+	          FROM auth.groups g
+	          JOIN auth.group_members m ON m.group_id = g.id
+	          WHERE m.user_id = $1 AND g.organization_id = $2
+	          ORDER BY g.display_name`, userID, r.OrganizationID)
+}
+
+// ReplaceGroup stores the name and the complete member list of the group.
+func (r *GroupSQLRepository) ReplaceGroup(ctx context.Context, group *models.Group) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := r.checkGroupName(ctx, tx, group); err != nil {
+		return err
+	}
+
+	err = tx.QueryRowContext(ctx, `UPDATE auth.groups SET display_name = $1, updated_at = NOW() WHERE id = $2 AND organization_id = $3 RETURNING updated_at`,
+		group.DisplayName, group.ID, r.OrganizationID,
+	).Scan(&group.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("group with ID %s not found: %w", group.ID.String(), sql.ErrNoRows)
+		}
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM auth.group_members WHERE group_id = $1`, group.ID); err != nil {
+		return err
+	}
+	if err := r.insertGroupMembers(ctx, tx, group); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *GroupSQLRepository) DeleteGroup(ctx context.Context, groupID uuid.UUID) error {
+	result, err := r.DB.ExecContext(ctx, `DELETE FROM auth.groups WHERE id = $1 AND organization_id = $2`, groupID, r.OrganizationID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("group with ID %s not found: %w", groupID.String(), sql.ErrNoRows)
+	}
+	return nil
+}
+
+func (r *GroupSQLRepository) queryGroups(ctx context.Context, query string, args ...interface{}) ([]models.Group, error) {
+	rows, err := r.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var groups []models.Group
+	for rows.Next() {
+		var group models.Group
+		if err := rows.Scan(&group.ID, &group.DisplayName, &group.CreatedAt, &group.UpdatedAt); err != nil {
+			return nil, err
+		}
+		groups = append(groups, group)
+	}
+	return groups, rows.Err()
+}
+
+func (r *GroupSQLRepository) getGroupMembers(ctx context.Context, groupID uuid.UUID) ([]models.GroupMember, error) {
+	rows, err := r.DB.QueryContext(ctx, `SELECT u.id, u.username
+	          FROM auth.group_members m
+	          JOIN auth.users u ON u.id = m.user_id
+	          JOIN auth.organization_members om ON om.user_id = m.user_id AND om.organization_id = $2
+	          WHERE m.group_id = $1
+	          ORDER BY u.username`, groupID, r.OrganizationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []models.GroupMember
+	for rows.Next() {
+		var member models.GroupMember
+		if err := rows.Scan(&member.UserID, &member.UserName); err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	return members, rows.Err()
+}
+
+func (r *GroupSQLRepository) checkGroupName(ctx context.Context, tx *sql.Tx, group *models.Group) error {
+	var taken bool
+	err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM auth.groups WHERE organization_id = $1 AND LOWER(display_name) = LOWER($2) AND id <> $3)`,
+		r.OrganizationID, group.DisplayName, group.ID,
+	).Scan(&taken)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return ErrGroupNameTaken
+	}
+	return nil
+}
+
+// insertGroupMembers adds the members of the group. Users outside the
+// organization fail with ErrUnknownMember like users that do not exist.
+func (r *GroupSQLRepository) insertGroupMembers(ctx context.Context, tx *sql.Tx, group *models.Group) error {
+	for _, member := range group.Members {
+		result, err := tx.ExecContext(ctx, `INSERT INTO auth.group_members (group_id, user_id)
+		          SELECT $1, user_id FROM auth.organization_members WHERE organization_id = $2 AND user_id = $3
+		          ON CONFLICT DO NOTHING`, group.ID, r.OrganizationID, member.UserID)
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err == nil && n == 0 {
+			var exists bool
+			err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM auth.organization_members WHERE organization_id = $1 AND user_id = $2)`,
+				r.OrganizationID, member.UserID).Scan(&exists)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				return fmt.Errorf("%w: %s", ErrUnknownMember, member.UserID)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/OsagieDG/jwt-based-auth-system/internal/models"
+	"github.com/google/uuid"
+)
+
+type IdentityRepository interface {
+	SaveLoginState(ctx context.Context, state *models.OIDCLoginState) error
+	ConsumeLoginState(ctx context.Context, stateHash, provider string) (*models.OIDCLoginState, error)
+	RecordIdentityLogin(ctx context.Context, provider, subject string) (uuid.UUID, error)
+	LinkIdentity(ctx context.Context, identity *models.UserIdentity) error
+	CreateUserWithIdentity(ctx context.Context, user *models.User, identity *models.UserIdentity) error
+}
+
+type IdentitySQLRepository struct {
+	DB *sql.DB
+}
+
+func NewIdentitySQLRepository(db *sql.DB) IdentityRepository {
+	return &IdentitySQLRepository{DB: db}
+}
+
+func (r *IdentitySQLRepository) SaveLoginState(ctx context.Context, state *models.OIDCLoginState) error {
+	_, err := r.DB.ExecContext(ctx, `DELETE FROM auth.oidc_login_states WHERE expires_at < NOW()`)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO auth.oidc_login_states (state_hash, provider, nonce, code_verifier, expires_at) VALUES ($1, $2, $3, $4, $5)`
+	_, err = r.DB.ExecContext(ctx, query, state.StateHash, state.Provider, state.Nonce, state.CodeVerifier, state.ExpiresAt)
+	return err
+}
+
+// ConsumeLoginState deletes and returns the state so every authorization
+// response can only be redeemed once.
+func (r *IdentitySQLRepository) ConsumeLoginState(ctx context.Context, stateHash, provider string) (*models.OIDCLoginState, error) {
+	var state models.OIDCLoginState
+	err := r.DB.QueryRowContext(ctx, `DELETE FROM auth.oidc_login_states
+	          WHERE state_hash = $1 AND provider = $2 AND expires_at > NOW()
+	          RETURNING state_hash, provider, nonce, code_verifier, expires_at`, stateHash, provider).Scan(
+		&state.StateHash, &state.Provider, &state.Nonce, &state.CodeVerifier, &state.ExpiresAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInvalidToken
+		}
+		return nil, err
+	}
+	return &state, nil
+}
+
+// RecordIdentityLogin returns the user linked to the identity and notes the
+// login. It returns sql.ErrNoRows if the identity is not linked yet.
+func (r *IdentitySQLRepository) RecordIdentityLogin(ctx context.Context, provider, subject string) (uuid.UUID, error) {
+	var userID uuid.UUID
+	err := r.DB.QueryRowContext(ctx, `UPDATE auth.user_identities
+	          SET last_login_at = NOW()
+	          WHERE provider = $1 AND subject = $2
+	          RETURNING user_id`, provider, subject).Scan(&userID)
+	return userID, err
+}
+
+func (r *IdentitySQLRepository) LinkIdentity(ctx context.Context, identity *models.UserIdentity) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := insertIdentity(ctx, tx, identity); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// CreateUserWithIdentity provisions a user for an identity that logged in
+// for the first time. The username comes from the provider, so if it is
+// taken the user gets a variant of it instead.
+func (r *IdentitySQLRepository) CreateUserWithIdentity(ctx context.Context, user *models.User, identity *models.UserIdentity) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if user.UserName, err = availableUserName(ctx, tx, user.UserName); err != nil {
+		return err
+	}
+	if err := insertUser(ctx, tx, user); err != nil {
+		return err
+	}
+	if err := insertIdentity(ctx, tx, identity); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// availableUserName returns the username, or its first variant no user has.
+func availableUserName(ctx context.Context, tx *sql.Tx, userName string) (string, error) {
+	userName = models.NormalizeUserName(userName)
+	candidate := userName
+	for n := 2; ; n++ {
+		var taken bool
+		err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM auth.users WHERE LOWER(username) = LOWER($1))`, candidate).Scan(&taken)
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			return candidate, nil
+		}
+		candidate = models.UserNameVariant(userName, n)
+	}
+}
+
+func insertIdentity(ctx context.Context, db execer, identity *models.UserIdentity) error {
+	query := `INSERT INTO auth.user_identities (id, user_id, provider, subject, email, last_login_at)
+	          VALUES ($1, $2, $3, $4, $5, NOW())`
+	_, err := db.ExecContext(ctx, query, identity.ID, identity.UserID, identity.Provider, identity.Subject, identity.Email)
+	if err != nil {
+		return err
+	}
+
+	return insertAuditEvent(ctx, db, models.NewAuditEvent(identity.UserID, nil, models.AuditIdentityLinked, map[string]interface{}{
+		"provider": identity.Provider,
+		"subject":  identity.Subject,
+	}))
+}
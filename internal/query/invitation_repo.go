@@ -0,0 +1,221 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/OsagieDG/jwt-based-auth-system/internal/models"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrInvitationPending = errors.New("an invitation for this address is already pending")
+	// ErrAccountRequired is returned when an invitation for an address
+	// without an account is accepted without the details of a new one.
+	ErrAccountRequired = errors.New("invited address has no account yet")
+	// ErrNotInvitee is returned when an invitation for an existing account
+	// is accepted without being logged in as that account.
+	ErrNotInvitee = errors.New("invitation has to be accepted while logged in as the invited account")
+)
+
+type InvitationRepository interface {
+	CreateInvitation(ctx context.Context, invitation *models.Invitation) error
+	GetPendingInvitations(ctx context.Context, organizationID uuid.UUID) ([]models.Invitation, error)
+	GetInvitationByToken(ctx context.Context, tokenHash string) (*models.Invitation, error)
+	RenewInvitation(ctx context.Context, organizationID, invitationID uuid.UUID, tokenHash string, expiresAt time.Time) (*models.Invitation, error)
+	RevokeInvitation(ctx context.Context, organizationID, invitationID uuid.UUID) error
+	AcceptInvitation(ctx context.Context, tokenHash string, newUser *models.User, sessionUserID *uuid.UUID) (*models.Invitation, uuid.UUID, error)
+}
+
+const invitationColumns = `id, organization_id, email, role, token_hash, invited_by, expires_at, accepted_at, revoked_at, created_at`
+
+type InvitationSQLRepository struct {
+	DB *sql.DB
+}
+
+func NewInvitationSQLRepository(db *sql.DB) InvitationRepository {
+	return &InvitationSQLRepository{DB: db}
+}
+
+// CreateInvitation stores a new invitation. It fails with ErrAlreadyMember
+// if the address belongs to a member, and with ErrInvitationPending if the
+// address has already been invited; such an invitation can be resent.
+func (r *InvitationSQLRepository) CreateInvitation(ctx context.Context, invitation *models.Invitation) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var member bool
+	err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM auth.organization_members m
+	          JOIN auth.users u ON u.id = m.user_id
+	          WHERE m.organization_id = $1 AND LOWER(u.email) = $2)`, invitation.OrganizationID, invitation.Email).Scan(&member)
+	if err != nil {
+		return err
+	}
+	if member {
+		return ErrAlreadyMember
+	}
+
+	result, err := tx.ExecContext(ctx, `INSERT INTO auth.invitations (id, organization_id, email, role, token_hash, invited_by, expires_at)
+	          VALUES ($1, $2, $3, $4, $5, $6, $7)
+	          ON CONFLICT (organization_id, email) WHERE accepted_at IS NULL AND revoked_at IS NULL DO NOTHING`,
+		invitation.ID, invitation.OrganizationID, invitation.Email, invitation.Role, invitation.TokenHash, invitation.InvitedBy, invitation.ExpiresAt,
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrInvitationPending
+	}
+
+	return tx.Commit()
+}
+
+// GetPendingInvitations returns the invitations of the organization that
+// were neither accepted nor revoked, including expired ones.
+func (r *InvitationSQLRepository) GetPendingInvitations(ctx context.Context, organizationID uuid.UUID) ([]models.Invitation, error) {
+	rows, err := r.DB.QueryContext(ctx, `SELECT `+invitationColumns+` FROM auth.invitations
+	          WHERE organization_id = $1 AND accepted_at IS NULL AND revoked_at IS NULL
+	          ORDER BY created_at, id`, organizationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invitations []models.Invitation
+	for rows.Next() {
+		invitation, err := scanInvitation(rows)
+		if err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, *invitation)
+	}
+	return invitations, rows.Err()
+}
+
+// GetInvitationByToken returns the invitation the token belongs to if it can
+// still be accepted, and ErrInvalidToken otherwise.
+func (r *InvitationSQLRepository) GetInvitationByToken(ctx context.Context, tokenHash string) (*models.Invitation, error) {
+	row := r.DB.QueryRowContext(ctx, `SELECT `+invitationColumns+` FROM auth.invitations
+	          WHERE token_hash = $1 AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > NOW()`, tokenHash)
+
+	invitation, err := scanInvitation(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInvalidToken
+		}
+		return nil, err
+	}
+	return invitation, nil
+}
+
+// RenewInvitation gives a pending invitation a new token and expiry, so the
+// previously sent link stops working.
+func (r *InvitationSQLRepository) RenewInvitation(ctx context.Context, organizationID, invitationID uuid.UUID, tokenHash string, expiresAt time.Time) (*models.Invitation, error) {
+	row := r.DB.QueryRowContext(ctx, `UPDATE auth.invitations SET token_hash = $1, expires_at = $2
+	          WHERE id = $3 AND organization_id = $4 AND accepted_at IS NULL AND revoked_at IS NULL
+	          RETURNING `+invitationColumns, tokenHash, expiresAt, invitationID, organizationID)
+
+	invitation, err := scanInvitation(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("invitation with ID %s not found: %w", invitationID.String(), sql.ErrNoRows)
+		}
+		return nil, err
+	}
+	return invitation, nil
+}
+
+func (r *InvitationSQLRepository) RevokeInvitation(ctx context.Context, organizationID, invitationID uuid.UUID) error {
+	result, err := r.DB.ExecContext(ctx, `UPDATE auth.invitations SET revoked_at = NOW()
+	          WHERE id = $1 AND organization_id = $2 AND accepted_at IS NULL AND revoked_at IS NULL`, invitationID, organizationID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("invitation with ID %s not found: %w", invitationID.String(), sql.ErrNoRows)
+	}
+	return nil
+}
+
+// AcceptInvitation consumes the invitation and makes the account with the
+// invited address a member with the invited role. If there is no such
+// account, newUser is created with the invited address, already verified as
+// the link proves access to the inbox; without newUser it fails with
+// ErrAccountRequired. The link alone is not enough to add an existing
+// account, which has to be the logged in sessionUserID or the call fails
+// with ErrNotInvitee. It returns the invitation and the member's user ID.
+func (r *InvitationSQLRepository) AcceptInvitation(ctx context.Context, tokenHash string, newUser *models.User, sessionUserID *uuid.UUID) (*models.Invitation, uuid.UUID, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, uuid.Nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	row := tx.QueryRowContext(ctx, `UPDATE auth.invitations SET accepted_at = NOW()
+	          WHERE token_hash = $1 AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > NOW()
+	          RETURNING `+invitationColumns, tokenHash)
+	invitation, err := scanInvitation(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, uuid.Nil, ErrInvalidToken
+		}
+		return nil, uuid.Nil, err
+	}
+
+	var userID uuid.UUID
+	err = tx.QueryRowContext(ctx, `SELECT id FROM auth.users WHERE LOWER(email) = $1`, invitation.Email).Scan(&userID)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		if newUser == nil {
+			return nil, uuid.Nil, ErrAccountRequired
+		}
+		newUser.Email = invitation.Email
+		newUser.EmailVerified = true
+		if err := insertUser(ctx, tx, newUser); err != nil {
+			return nil, uuid.Nil, err
+		}
+		userID = newUser.ID
+	case err != nil:
+		return nil, uuid.Nil, err
+	case sessionUserID == nil || *sessionUserID != userID:
+		return nil, uuid.Nil, ErrNotInvitee
+	}
+
+	// Someone may have added the user since the invitation was sent, in
+	// which case accepting it leaves their current role alone.
+	if err := insertMember(ctx, tx, invitation.OrganizationID, userID, invitation.Role); err != nil {
+		if errors.Is(err, ErrAlreadyMember) {
+			return invitation, userID, tx.Commit()
+		}
+		return nil, uuid.Nil, err
+	}
+
+	event := models.NewAuditEvent(userID, invitation.InvitedBy, models.AuditOrgMemberAdded, map[string]interface{}{
+		"organization_id": invitation.OrganizationID,
+		"role":            invitation.Role,
+		"invitation_id":   invitation.ID,
+	})
+	if err := insertAuditEvent(ctx, tx, event); err != nil {
+		return nil, uuid.Nil, err
+	}
+
+	return invitation, userID, tx.Commit()
+}
+
+func scanInvitation(row rowScanner) (*models.Invitation, error) {
+	var invitation models.Invitation
+	err := row.Scan(
+		&invitation.ID, &invitation.OrganizationID, &invitation.Email, &invitation.Role, &invitation.TokenHash, &invitation.InvitedBy,
+		&invitation.ExpiresAt, &invitation.AcceptedAt, &invitation.RevokedAt, &invitation.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &invitation, nil
+}
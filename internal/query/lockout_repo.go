@@ -0,0 +1,177 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/OsagieDG/jwt-based-auth-system/internal/models"
+	"github.com/google/uuid"
+)
+
+type LockoutRepository interface {
+	GetLoginAttempts(ctx context.Context, userID uuid.UUID) (*models.LoginAttempts, error)
+	RecordFailedLogin(ctx context.Context, userID uuid.UUID, policy models.LockoutPolicy) (*models.LoginAttempts, bool, error)
+	ResetFailedLogins(ctx context.Context, userID uuid.UUID) error
+	Unlock(ctx context.Context, userID uuid.UUID, actorID *uuid.UUID) error
+	SaveUnlockToken(ctx context.Context, token *models.UnlockToken) error
+	ConsumeUnlockToken(ctx context.Context, tokenHash string) (uuid.UUID, error)
+}
+
+type LockoutSQLRepository struct {
+	DB *sql.DB
+}
+
+func NewLockoutSQLRepository(db *sql.DB) LockoutRepository {
+	return &LockoutSQLRepository{DB: db}
+}
+
+// GetLoginAttempts returns an empty record for users that never failed a login.
+func (r *LockoutSQLRepository) GetLoginAttempts(ctx context.Context, userID uuid.UUID) (*models.LoginAttempts, error) {
+	attempts := models.LoginAttempts{UserID: userID}
+	query := `SELECT failed_attempts, lockout_count, locked_until, last_failed_at
+	          FROM auth.login_attempts
+	          WHERE user_id = $1`
+
+	err := r.DB.QueryRowContext(ctx, query, userID).Scan(
+		&attempts.FailedAttempts, &attempts.LockoutCount, &attempts.LockedUntil, &attempts.LastFailedAt,
+	)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	return &attempts, nil
+}
+
+// RecordFailedLogin counts a failed login and locks the account once the
+// policy threshold is reached. The returned bool reports whether this very
+// failure caused the lock.
+func (r *LockoutSQLRepository) RecordFailedLogin(ctx context.Context, userID uuid.UUID, policy models.LockoutPolicy) (*models.LoginAttempts, bool, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO auth.login_attempts (user_id) VALUES ($1) ON CONFLICT (user_id) DO NOTHING`, userID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	attempts := models.LoginAttempts{UserID: userID}
+	err = tx.QueryRowContext(ctx, `SELECT failed_attempts, lockout_count, locked_until, last_failed_at
+	          FROM auth.login_attempts
+	          WHERE user_id = $1
+	          FOR UPDATE`, userID).Scan(
+		&attempts.FailedAttempts, &attempts.LockoutCount, &attempts.LockedUntil, &attempts.LastFailedAt,
+	)
+	if err != nil {
+		return nil, false, err
+	}
+
+	now := time.Now()
+	policy.Decay(&attempts, now)
+	attempts.FailedAttempts++
+	attempts.LastFailedAt = &now
+
+	locked := false
+	if attempts.FailedAttempts >= policy.Threshold {
+		attempts.LockoutCount++
+		lockedUntil := now.Add(policy.LockDuration(attempts.LockoutCount))
+		attempts.LockedUntil = &lockedUntil
+		attempts.FailedAttempts = 0
+		locked = true
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE auth.login_attempts
+	          SET failed_attempts = $1, lockout_count = $2, locked_until = $3, last_failed_at = $4
+	          WHERE user_id = $5`,
+		attempts.FailedAttempts, attempts.LockoutCount, attempts.LockedUntil, attempts.LastFailedAt, userID,
+	)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if locked {
+		event := models.NewAuditEvent(userID, nil, models.AuditUserLocked, map[string]interface{}{
+			"locked_until":  attempts.LockedUntil,
+			"lockout_count": attempts.LockoutCount,
+		})
+		if err := insertAuditEvent(ctx, tx, event); err != nil {
+			return nil, false, err
+		}
+	}
+
+	return &attempts, locked, tx.Commit()
+}
+
+// ResetFailedLogins is called after a successful login and also resets the
+// backoff, so the next lockout starts from the base duration again.
+func (r *LockoutSQLRepository) ResetFailedLogins(ctx context.Context, userID uuid.UUID) error {
+	_, err := r.DB.ExecContext(ctx, `DELETE FROM auth.login_attempts WHERE user_id = $1`, userID)
+	return err
+}
+
+func (r *LockoutSQLRepository) Unlock(ctx context.Context, userID uuid.UUID, actorID *uuid.UUID) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := unlock(ctx, tx, userID, actorID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// SaveUnlockToken stores a new token and discards any unused token previously
+// issued to the same user.
+func (r *LockoutSQLRepository) SaveUnlockToken(ctx context.Context, token *models.UnlockToken) error {
+	_, err := r.DB.ExecContext(ctx, `DELETE FROM auth.unlock_tokens WHERE user_id = $1 AND used_at IS NULL`, token.UserID)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO auth.unlock_tokens (id, user_id, token_hash, expires_at) VALUES ($1, $2, $3, $4)`
+	_, err = r.DB.ExecContext(ctx, query, token.ID, token.UserID, token.TokenHash, token.ExpiresAt)
+	return err
+}
+
+func (r *LockoutSQLRepository) ConsumeUnlockToken(ctx context.Context, tokenHash string) (uuid.UUID, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var userID uuid.UUID
+	err = tx.QueryRowContext(ctx, `UPDATE auth.unlock_tokens
+	          SET used_at = NOW()
+	          WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+	          RETURNING user_id`, tokenHash).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return uuid.Nil, ErrInvalidToken
+		}
+		return uuid.Nil, err
+	}
+
+	if err := unlock(ctx, tx, userID, &userID); err != nil {
+		return uuid.Nil, err
+	}
+
+	return userID, tx.Commit()
+}
+
+func unlock(ctx context.Context, tx *sql.Tx, userID uuid.UUID, actorID *uuid.UUID) error {
+	_, err := tx.ExecContext(ctx, `UPDATE auth.login_attempts
+	          SET failed_attempts = 0, locked_until = NULL
+	          WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	return insertAuditEvent(ctx, tx, models.NewAuditEvent(userID, actorID, models.AuditUserUnlocked, nil))
+}
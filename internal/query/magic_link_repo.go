@@ -0,0 +1,55 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/OsagieDG/jwt-based-auth-system/internal/models"
+	"github.com/google/uuid"
+)
+
+type MagicLinkRepository interface {
+	SaveMagicLinkToken(ctx context.Context, token *models.MagicLinkToken) error
+	ConsumeMagicLinkToken(ctx context.Context, tokenHash, browserHash string) (uuid.UUID, error)
+}
+
+type MagicLinkSQLRepository struct {
+	DB *sql.DB
+}
+
+func NewMagicLinkSQLRepository(db *sql.DB) MagicLinkRepository {
+	return &MagicLinkSQLRepository{DB: db}
+}
+
+// SaveMagicLinkToken stores a new token and discards any unused token
+// previously issued to the same user, so only the latest link works.
+func (r *MagicLinkSQLRepository) SaveMagicLinkToken(ctx context.Context, token *models.MagicLinkToken) error {
+	_, err := r.DB.ExecContext(ctx, `DELETE FROM auth.magic_link_tokens WHERE user_id = $1 AND used_at IS NULL`, token.UserID)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO auth.magic_link_tokens (id, user_id, token_hash, browser_hash, expires_at) VALUES ($1, $2, $3, $4, $5)`
+	_, err = r.DB.ExecContext(ctx, query, token.ID, token.UserID, token.TokenHash, token.BrowserHash, token.ExpiresAt)
+	return err
+}
+
+// ConsumeMagicLinkToken marks the token as used and returns its user. A token
+// bound to a browser is only accepted together with that browser's hash and
+// stays usable when opened anywhere else.
+func (r *MagicLinkSQLRepository) ConsumeMagicLinkToken(ctx context.Context, tokenHash, browserHash string) (uuid.UUID, error) {
+	var userID uuid.UUID
+	err := r.DB.QueryRowContext(ctx, `UPDATE auth.magic_link_tokens
+	          SET used_at = NOW()
+	          WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+	            AND (browser_hash IS NULL OR browser_hash = $2)
+	          RETURNING user_id`, tokenHash, browserHash).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return uuid.Nil, ErrInvalidToken
+		}
+		return uuid.Nil, err
+	}
+	return userID, nil
+}
@@ -0,0 +1,207 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/OsagieDG/jwt-based-auth-system/internal/models"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrMFAAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrCodeAlreadyUsed   = errors.New("code has already been used")
+)
+
+type MFARepository interface {
+	GetTOTPEnrollment(ctx context.Context, userID uuid.UUID) (*models.TOTPEnrollment, error)
+	SaveTOTPEnrollment(ctx context.Context, enrollment *models.TOTPEnrollment) error
+	ConfirmTOTPEnrollment(ctx context.Context, userID uuid.UUID, counter int64) error
+	UseTOTPCounter(ctx context.Context, userID uuid.UUID, counter int64) error
+	DeleteTOTPEnrollment(ctx context.Context, userID uuid.UUID) error
+	ReplaceRecoveryCodes(ctx context.Context, userID uuid.UUID, hashes []string) error
+	UseRecoveryCode(ctx context.Context, userID uuid.UUID, hash string) error
+	CountRecoveryCodes(ctx context.Context, userID uuid.UUID) (int, error)
+}
+
+type MFASQLRepository struct {
+	DB *sql.DB
+}
+
+func NewMFASQLRepository(db *sql.DB) MFARepository {
+	return &MFASQLRepository{DB: db}
+}
+
+func (r *MFASQLRepository) GetTOTPEnrollment(ctx context.Context, userID uuid.UUID) (*models.TOTPEnrollment, error) {
+	var enrollment models.TOTPEnrollment
+	query := `SELECT user_id, secret, confirmed_at, last_used_counter, created_at
+	          FROM auth.mfa_totp
+	          WHERE user_id = $1`
+
+	err := r.DB.QueryRowContext(ctx, query, userID).Scan(
+		&enrollment.UserID, &enrollment.Secret, &enrollment.ConfirmedAt, &enrollment.LastUsedCounter, &enrollment.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &enrollment, nil
+}
+
+// SaveTOTPEnrollment starts or restarts an enrollment. A confirmed
+// enrollment is never replaced; it has to be disabled first.
+func (r *MFASQLRepository) SaveTOTPEnrollment(ctx context.Context, enrollment *models.TOTPEnrollment) error {
+	result, err := r.DB.ExecContext(ctx, `INSERT INTO auth.mfa_totp (user_id, secret) VALUES ($1, $2)
+	          ON CONFLICT (user_id) DO UPDATE
+	          SET secret = EXCLUDED.secret, confirmed_at = NULL, last_used_counter = NULL, created_at = NOW()
+	          WHERE auth.mfa_totp.confirmed_at IS NULL`,
+		enrollment.UserID, enrollment.Secret,
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrMFAAlreadyEnabled
+	}
+	return nil
+}
+
+func (r *MFASQLRepository) ConfirmTOTPEnrollment(ctx context.Context, userID uuid.UUID, counter int64) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	result, err := tx.ExecContext(ctx, `UPDATE auth.mfa_totp
+	          SET confirmed_at = NOW(), last_used_counter = $1
+	          WHERE user_id = $2 AND confirmed_at IS NULL`, counter, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrMFAAlreadyEnabled
+	}
+
+	if err := insertAuditEvent(ctx, tx, models.NewAuditEvent(userID, &userID, models.AuditMFAEnabled, map[string]interface{}{
+		"method": "totp",
+	})); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UseTOTPCounter records that the code for counter was used. Codes for the
+// same or an earlier time step are refused afterwards, which stops a code
+// that was observed by someone else from being replayed.
+func (r *MFASQLRepository) UseTOTPCounter(ctx context.Context, userID uuid.UUID, counter int64) error {
+	result, err := r.DB.ExecContext(ctx, `UPDATE auth.mfa_totp
+	          SET last_used_counter = $1
+	          WHERE user_id = $2 AND confirmed_at IS NOT NULL
+	            AND (last_used_counter IS NULL OR last_used_counter < $1)`, counter, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrCodeAlreadyUsed
+	}
+	return nil
+}
+
+func (r *MFASQLRepository) DeleteTOTPEnrollment(ctx context.Context, userID uuid.UUID) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM auth.mfa_totp WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	if err := deleteUnusableRecoveryCodes(ctx, tx, userID); err != nil {
+		return err
+	}
+
+	if err := insertAuditEvent(ctx, tx, models.NewAuditEvent(userID, &userID, models.AuditMFADisabled, map[string]interface{}{
+		"method": "totp",
+	})); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ReplaceRecoveryCodes stores a new set of recovery codes and drops all
+// earlier ones, used or not.
+func (r *MFASQLRepository) ReplaceRecoveryCodes(ctx context.Context, userID uuid.UUID, hashes []string) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM auth.mfa_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+
+	for _, hash := range hashes {
+		_, err := tx.ExecContext(ctx, `INSERT INTO auth.mfa_recovery_codes (id, user_id, code_hash) VALUES ($1, $2, $3)`,
+			models.NewUUID(), userID, hash)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := insertAuditEvent(ctx, tx, models.NewAuditEvent(userID, &userID, models.AuditRecoveryCodesCreated, map[string]interface{}{
+		"count": len(hashes),
+	})); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UseRecoveryCode burns an unused recovery code. It returns ErrInvalidToken
+// if the code is unknown or was already used.
+func (r *MFASQLRepository) UseRecoveryCode(ctx context.Context, userID uuid.UUID, hash string) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	result, err := tx.ExecContext(ctx, `UPDATE auth.mfa_recovery_codes
+	          SET used_at = NOW()
+	          WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`, userID, hash)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrInvalidToken
+	}
+
+	if err := insertAuditEvent(ctx, tx, models.NewAuditEvent(userID, &userID, models.AuditRecoveryCodeUsed, nil)); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// deleteUnusableRecoveryCodes drops the recovery codes once the user has no
+// second factor left, so they cannot outlive the factors they stand in for.
+// A later enrollment hands out a new set.
+func deleteUnusableRecoveryCodes(ctx context.Context, db execer, userID uuid.UUID) error {
+	_, err := db.ExecContext(ctx, `DELETE FROM auth.mfa_recovery_codes
+	          WHERE user_id = $1
+	            AND NOT EXISTS (SELECT 1 FROM auth.mfa_totp WHERE user_id = $1 AND confirmed_at IS NOT NULL)
+	            AND NOT EXISTS (SELECT 1 FROM auth.webauthn_credentials WHERE user_id = $1)`, userID)
+	return err
+}
+
+func (r *MFASQLRepository) CountRecoveryCodes(ctx context.Context, userID uuid.UUID) (int, error) {
+	var count int
+	err := r.DB.QueryRowContext(ctx, `SELECT COUNT(*) FROM auth.mfa_recovery_codes
+	          WHERE user_id = $1 AND used_at IS NULL`, userID).Scan(&count)
+	return count, err
+}
@@ -0,0 +1,349 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/OsagieDG/jwt-based-auth-system/internal/models"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrSlugTaken     = errors.New("organization slug is already in use")
+	ErrAlreadyMember = errors.New("user is already a member of the organization")
+	ErrLastOwner     = errors.New("organization must keep at least one owner")
+)
+
+type OrganizationRepository interface {
+	CreateOrganization(ctx context.Context, organization *models.Organization) error
+	GetOrganizations(ctx context.Context) ([]models.Organization, error)
+	GetOrganizationByID(ctx context.Context, organizationID uuid.UUID) (*models.Organization, error)
+	DeleteOrganization(ctx context.Context, organizationID uuid.UUID) error
+	GetMembers(ctx context.Context, organizationID uuid.UUID) ([]models.OrganizationMember, error)
+	GetMember(ctx context.Context, organizationID, userID uuid.UUID) (*models.OrganizationMember, error)
+	GetUserMemberships(ctx context.Context, userID uuid.UUID) ([]models.Membership, error)
+	AddMember(ctx context.Context, organizationID, userID uuid.UUID, role string, actorID *uuid.UUID) error
+	SetMemberRole(ctx context.Context, organizationID, userID uuid.UUID, role string, actorID *uuid.UUID) error
+	RemoveMember(ctx context.Context, organizationID, userID uuid.UUID, actorID *uuid.UUID) error
+}
+
+const organizationColumns = `id, name, slug, created_at, updated_at`
+
+const memberColumns = `m.organization_id, m.user_id, u.username, u.email, m.role, m.created_at`
+
+type OrganizationSQLRepository struct {
+	DB *sql.DB
+}
+
+func NewOrganizationSQLRepository(db *sql.DB) OrganizationRepository {
+	return &OrganizationSQLRepository{DB: db}
+}
+
+func (r *OrganizationSQLRepository) CreateOrganization(ctx context.Context, organization *models.Organization) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var taken bool
+	err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM auth.organizations WHERE slug = $1)`, organization.Slug).Scan(&taken)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return ErrSlugTaken
+	}
+
+	err = tx.QueryRowContext(ctx, `INSERT INTO auth.organizations (id, name, slug) VALUES ($1, $2, $3) RETURNING created_at, updated_at`,
+		organization.ID, organization.Name, organization.Slug,
+	).Scan(&organization.CreatedAt, &organization.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *OrganizationSQLRepository) GetOrganizations(ctx context.Context) ([]models.Organization, error) {
+	rows, err := r.DB.QueryContext(ctx, `SELECT `+organizationColumns+` FROM auth.organizations ORDER BY name, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var organizations []models.Organization
+	for rows.Next() {
+		organization, err := scanOrganization(rows)
+		if err != nil {
+			return nil, err
+		}
+		organizations = append(organizations, *organization)
+	}
+	return organizations, rows.Err()
+}
+
+func (r *OrganizationSQLRepository) GetOrganizationByID(ctx context.Context, organizationID uuid.UUID) (*models.Organization, error) {
+	row := r.DB.QueryRowContext(ctx, `SELECT `+organizationColumns+` FROM auth.organizations WHERE id = $1`, organizationID)
+
+	organization, err := scanOrganization(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("organization with ID %s not found: %w", organizationID.String(), sql.ErrNoRows)
+		}
+		return nil, err
+	}
+	return organization, nil
+}
+
+// DeleteOrganization removes the organization and its memberships. The
+// users themselves are kept, as they may belong to other organizations.
+func (r *OrganizationSQLRepository) DeleteOrganization(ctx context.Context, organizationID uuid.UUID) error {
+	result, err := r.DB.ExecContext(ctx, `DELETE FROM auth.organizations WHERE id = $1`, organizationID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("organization with ID %s not found: %w", organizationID.String(), sql.ErrNoRows)
+	}
+	return nil
+}
+
+func (r *OrganizationSQLRepository) GetMembers(ctx context.Context, organizationID uuid.UUID) ([]models.OrganizationMember, error) {
+	rows, err := r.DB.QueryContext(ctx, `SELECT `+memberColumns+`
+	          FROM auth.organization_members m
+	          JOIN auth.users u ON u.id = m.user_id
+	          WHERE m.organization_id = $1
+	          ORDER BY m.created_at, m.user_id`, organizationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []models.OrganizationMember
+	for rows.Next() {
+		member, err := scanMember(rows)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, *member)
+	}
+	return members, rows.Err()
+}
+
+func (r *OrganizationSQLRepository) GetMember(ctx context.Context, organizationID, userID uuid.UUID) (*models.OrganizationMember, error) {
+	row := r.DB.QueryRowContext(ctx, `SELECT `+memberColumns+`
+	          FROM auth.organization_members m
+	          JOIN auth.users u ON u.id = m.user_id
+	          WHERE m.organization_id = $1 AND m.user_id = $2`, organizationID, userID)
+
+	member, err := scanMember(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("user with ID %s is not a member of organization %s: %w", userID.String(), organizationID.String(), sql.ErrNoRows)
+		}
+		return nil, err
+	}
+	return member, nil
+}
+
+// GetUserMemberships returns the organizations of the user in the order
+// they joined them, so the first one is their default organization.
+func (r *OrganizationSQLRepository) GetUserMemberships(ctx context.Context, userID uuid.UUID) ([]models.Membership, error) {
+	rows, err := r.DB.QueryContext(ctx, `SELECT o.id, o.name, o.slug, o.created_at, o.updated_at, m.role
+	          FROM auth.organization_members m
+	          JOIN auth.organizations o ON o.id = m.organization_id
+	          WHERE m.user_id = $1
+	          ORDER BY m.created_at, o.id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var memberships []models.Membership
+	for rows.Next() {
+		var membership models.Membership
+		organization := &membership.Organization
+		err := rows.Scan(&organization.ID, &organization.Name, &organization.Slug, &organization.CreatedAt, &organization.UpdatedAt, &membership.Role)
+		if err != nil {
+			return nil, err
+		}
+		memberships = append(memberships, membership)
+	}
+	return memberships, rows.Err()
+}
+
+func (r *OrganizationSQLRepository) AddMember(ctx context.Context, organizationID, userID uuid.UUID, role string, actorID *uuid.UUID) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var exists bool
+	if err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM auth.organizations WHERE id = $1)`, organizationID).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("organization with ID %s not found: %w", organizationID.String(), sql.ErrNoRows)
+	}
+	if err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM auth.users WHERE id = $1)`, userID).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return ErrUnknownMember
+	}
+
+	if err := insertMember(ctx, tx, organizationID, userID, role); err != nil {
+		return err
+	}
+
+	event := models.NewAuditEvent(userID, actorID, models.AuditOrgMemberAdded, map[string]interface{}{
+		"organization_id": organizationID,
+		"role":            role,
+	})
+	if err := insertAuditEvent(ctx, tx, event); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// insertMember adds a membership inside the caller's transaction. It
+// returns ErrAlreadyMember instead of changing the role of an existing one.
+func insertMember(ctx context.Context, db execer, organizationID, userID uuid.UUID, role string) error {
+	result, err := db.ExecContext(ctx, `INSERT INTO auth.organization_members (organization_id, user_id, role)
+	          VALUES ($1, $2, $3) ON CONFLICT DO NOTHING`, organizationID, userID, role)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrAlreadyMember
+	}
+	return nil
+}
+
+// SetMemberRole changes the role of a member. Demoting the last owner fails
+// with ErrLastOwner.
+func (r *OrganizationSQLRepository) SetMemberRole(ctx context.Context, organizationID, userID uuid.UUID, role string, actorID *uuid.UUID) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	previous, err := lockMember(ctx, tx, organizationID, userID)
+	if err != nil {
+		return err
+	}
+	if previous == models.OrgRoleOwner && role != models.OrgRoleOwner {
+		if err := checkOtherOwners(ctx, tx, organizationID, userID); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE auth.organization_members SET role = $1 WHERE organization_id = $2 AND user_id = $3`,
+		role, organizationID, userID)
+	if err != nil {
+		return err
+	}
+
+	event := models.NewAuditEvent(userID, actorID, models.AuditOrgRoleChanged, map[string]interface{}{
+		"organization_id": organizationID,
+		"from":            previous,
+		"to":              role,
+	})
+	if err := insertAuditEvent(ctx, tx, event); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RemoveMember takes the user out of the organization without deleting the
+// account. Removing the last owner fails with ErrLastOwner.
+func (r *OrganizationSQLRepository) RemoveMember(ctx context.Context, organizationID, userID uuid.UUID, actorID *uuid.UUID) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	previous, err := lockMember(ctx, tx, organizationID, userID)
+	if err != nil {
+		return err
+	}
+	if previous == models.OrgRoleOwner {
+		if err := checkOtherOwners(ctx, tx, organizationID, userID); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM auth.organization_members WHERE organization_id = $1 AND user_id = $2`, organizationID, userID)
+	if err != nil {
+		return err
+	}
+
+	event := models.NewAuditEvent(userID, actorID, models.AuditOrgMemberRemoved, map[string]interface{}{
+		"organization_id": organizationID,
+	})
+	if err := insertAuditEvent(ctx, tx, event); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// lockMember returns the current role of the member and keeps the row
+// locked until the transaction ends.
+func lockMember(ctx context.Context, tx *sql.Tx, organizationID, userID uuid.UUID) (string, error) {
+	var role string
+	err := tx.QueryRowContext(ctx, `SELECT role FROM auth.organization_members
+	          WHERE organization_id = $1 AND user_id = $2 FOR UPDATE`, organizationID, userID).Scan(&role)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", fmt.Errorf("user with ID %s is not a member of organization %s: %w", userID.String(), organizationID.String(), sql.ErrNoRows)
+		}
+		return "", err
+	}
+	return role, nil
+}
+
+// checkOtherOwners locks the owners of the organization, so two requests
+// cannot each demote one of the last two owners.
+func checkOtherOwners(ctx context.Context, tx *sql.Tx, organizationID, userID uuid.UUID) error {
+	rows, err := tx.QueryContext(ctx, `SELECT user_id FROM auth.organization_members
+	          WHERE organization_id = $1 AND role = $2 AND user_id <> $3 FOR UPDATE`, organizationID, models.OrgRoleOwner, userID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		return ErrLastOwner
+	}
+	return nil
+}
+
+func scanOrganization(row rowScanner) (*models.Organization, error) {
+	var organization models.Organization
+	err := row.Scan(&organization.ID, &organization.Name, &organization.Slug, &organization.CreatedAt, &organization.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &organization, nil
+}
+
+func scanMember(row rowScanner) (*models.OrganizationMember, error) {
+	var member models.OrganizationMember
+	err := row.Scan(&member.OrganizationID, &member.UserID, &member.UserName, &member.Email, &member.Role, &member.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &member, nil
+}
@@ -0,0 +1,86 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/OsagieDG/jwt-based-auth-system/internal/models"
+	"github.com/google/uuid"
+)
+
+type PasswordResetRepository interface {
+	SavePasswordResetToken(ctx context.Context, token *models.PasswordResetToken) error
+	GetPasswordResetUserID(ctx context.Context, tokenHash string) (uuid.UUID, error)
+	ResetPassword(ctx context.Context, tokenHash, encryptedPassword string) (uuid.UUID, error)
+}
+
+type PasswordResetSQLRepository struct {
+	DB *sql.DB
+}
+
+func NewPasswordResetSQLRepository(db *sql.DB) PasswordResetRepository {
+	return &PasswordResetSQLRepository{DB: db}
+}
+
+// SavePasswordResetToken stores a new token and discards any unused token
+// previously issued to the same user, so only the latest link works.
+func (r *PasswordResetSQLRepository) SavePasswordResetToken(ctx context.Context, token *models.PasswordResetToken) error {
+	_, err := r.DB.ExecContext(ctx, `DELETE FROM auth.password_reset_tokens WHERE user_id = $1 AND used_at IS NULL`, token.UserID)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO auth.password_reset_tokens (id, user_id, token_hash, expires_at) VALUES ($1, $2, $3, $4)`
+	_, err = r.DB.ExecContext(ctx, query, token.ID, token.UserID, token.TokenHash, token.ExpiresAt)
+	return err
+}
+
+// GetPasswordResetUserID returns the user a token that can still be used
+// was issued to, and ErrInvalidToken otherwise. It leaves the token unused.
+func (r *PasswordResetSQLRepository) GetPasswordResetUserID(ctx context.Context, tokenHash string) (uuid.UUID, error) {
+	var userID uuid.UUID
+	err := r.DB.QueryRowContext(ctx, `SELECT user_id FROM auth.password_reset_tokens
+	          WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()`, tokenHash).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return uuid.Nil, ErrInvalidToken
+		}
+		return uuid.Nil, err
+	}
+	return userID, nil
+}
+
+// ResetPassword consumes the token, stores the new password hash and revokes
+// every refresh token of the user in a single transaction.
+func (r *PasswordResetSQLRepository) ResetPassword(ctx context.Context, tokenHash, encryptedPassword string) (uuid.UUID, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var userID uuid.UUID
+	err = tx.QueryRowContext(ctx, `UPDATE auth.password_reset_tokens
+	          SET used_at = NOW()
+	          WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+	          RETURNING user_id`, tokenHash).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return uuid.Nil, ErrInvalidToken
+		}
+		return uuid.Nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE auth.users SET encrypted_password = $1 WHERE id = $2`, encryptedPassword, userID)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE auth.tokens SET revoked = true WHERE user_id = $1`, userID)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	return userID, tx.Commit()
+}
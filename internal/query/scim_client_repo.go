@@ -0,0 +1,98 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/OsagieDG/jwt-based-auth-system/internal/models"
+	"github.com/google/uuid"
+)
+
+type SCIMClientRepository interface {
+	CreateSCIMClient(ctx context.Context, client *models.SCIMClient) error
+	GetSCIMClients(ctx context.Context) ([]models.SCIMClient, error)
+	AuthenticateSCIMClient(ctx context.Context, tokenHash string) (*models.SCIMClient, error)
+	DeleteSCIMClient(ctx context.Context, clientID uuid.UUID) error
+}
+
+const scimClientColumns = `id, organization_id, name, token_hash, created_at, last_used_at`
+
+type SCIMClientSQLRepository struct {
+	DB *sql.DB
+}
+
+func NewSCIMClientSQLRepository(db *sql.DB) SCIMClientRepository {
+	return &SCIMClientSQLRepository{DB: db}
+}
+
+// CreateSCIMClient stores the client. It fails with sql.ErrNoRows if its
+// organization does not exist.
+func (r *SCIMClientSQLRepository) CreateSCIMClient(ctx context.Context, client *models.SCIMClient) error {
+	err := r.DB.QueryRowContext(ctx, `INSERT INTO auth.scim_clients (id, organization_id, name, token_hash)
+	          SELECT $1, id, $3, $4 FROM auth.organizations WHERE id = $2
+	          RETURNING created_at`,
+		client.ID, client.OrganizationID, client.Name, client.TokenHash,
+	).Scan(&client.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("organization with ID %s not found: %w", client.OrganizationID.String(), sql.ErrNoRows)
+	}
+	return err
+}
+
+func (r *SCIMClientSQLRepository) GetSCIMClients(ctx context.Context) ([]models.SCIMClient, error) {
+	rows, err := r.DB.QueryContext(ctx, `SELECT `+scimClientColumns+` FROM auth.scim_clients ORDER BY created_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var clients []models.SCIMClient
+	for rows.Next() {
+		client, err := scanSCIMClient(rows)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, *client)
+	}
+	return clients, rows.Err()
+}
+
+// AuthenticateSCIMClient looks up the client of a bearer token and notes
+// that it was used. It returns ErrInvalidToken for unknown tokens.
+func (r *SCIMClientSQLRepository) AuthenticateSCIMClient(ctx context.Context, tokenHash string) (*models.SCIMClient, error) {
+	row := r.DB.QueryRowContext(ctx, `UPDATE auth.scim_clients
+	          SET last_used_at = NOW()
+	          WHERE token_hash = $1
+	          RETURNING `+scimClientColumns, tokenHash)
+
+	client, err := scanSCIMClient(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInvalidToken
+		}
+		return nil, err
+	}
+	return client, nil
+}
+
+func (r *SCIMClientSQLRepository) DeleteSCIMClient(ctx context.Context, clientID uuid.UUID) error {
+	result, err := r.DB.ExecContext(ctx, `DELETE FROM auth.scim_clients WHERE id = $1`, clientID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("SCIM client with ID %s not found: %w", clientID.String(), sql.ErrNoRows)
+	}
+	return nil
+}
+
+func scanSCIMClient(row rowScanner) (*models.SCIMClient, error) {
+	var client models.SCIMClient
+	err := row.Scan(&client.ID, &client.OrganizationID, &client.Name, &client.TokenHash, &client.CreatedAt, &client.LastUsedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
@@ -0,0 +1,85 @@
+package query
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Operators a Condition can use.
+const (
+	OpEqual      = "eq"
+	OpNotEqual   = "ne"
+	OpContains   = "co"
+	OpStartsWith = "sw"
+	OpEndsWith   = "ew"
+	OpPresent    = "pr"
+)
+
+// Condition is one test of a search. Text comparisons ignore case. The
+// column of a JSONB document is followed by the dotted path of a field in
+// it, such as "metadata.user.locale".
+type Condition struct {
+	Column   string
+	Operator string
+	Value    string
+}
+
+// jsonColumns lists the JSONB columns, which are only searched by path.
+var jsonColumns = map[string]bool{
+	"metadata": true,
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// buildWhere turns conditions into a where clause joined by AND. Like
+// buildUpdateQuery it only takes column names from the allowed set and passes
+// every value as a placeholder argument, numbered from $1.
+func buildWhere(allowed map[string]bool, conditions []Condition) (string, []interface{}, error) {
+	if len(conditions) == 0 {
+		return "TRUE", nil, nil
+	}
+
+	var (
+		clauses = make([]string, 0, len(conditions))
+		args    = make([]interface{}, 0, len(conditions))
+	)
+	for _, c := range conditions {
+		column, path, isPath := strings.Cut(c.Column, ".")
+		if !allowed[column] || jsonColumns[column] != isPath || (isPath && path == "") {
+			return "", nil, fmt.Errorf("column %q cannot be searched", c.Column)
+		}
+
+		// A path is passed as a text array argument, so like values it never
+		// ends up in the SQL text.
+		expr := column
+		if isPath {
+			args = append(args, strings.Split(path, "."))
+			expr = fmt.Sprintf("(%s #>> $%d::text[])", column, len(args))
+		}
+
+		placeholder := fmt.Sprintf("$%d", len(args)+1)
+		switch c.Operator {
+		case OpEqual:
+			clauses = append(clauses, fmt.Sprintf("LOWER(%s::text) = LOWER(%s)", expr, placeholder))
+			args = append(args, c.Value)
+		case OpNotEqual:
+			clauses = append(clauses, fmt.Sprintf("(%s IS NULL OR LOWER(%s::text) <> LOWER(%s))", expr, expr, placeholder))
+			args = append(args, c.Value)
+		case OpContains:
+			clauses = append(clauses, fmt.Sprintf("%s::text ILIKE %s", expr, placeholder))
+			args = append(args, "%"+likeEscaper.Replace(c.Value)+"%")
+		case OpStartsWith:
+			clauses = append(clauses, fmt.Sprintf("%s::text ILIKE %s", expr, placeholder))
+			args = append(args, likeEscaper.Replace(c.Value)+"%")
+		case OpEndsWith:
+			clauses = append(clauses, fmt.Sprintf("%s::text ILIKE %s", expr, placeholder))
+			args = append(args, "%"+likeEscaper.Replace(c.Value))
+		case OpPresent:
+			clauses = append(clauses, fmt.Sprintf("(%s IS NOT NULL AND %s::text <> '')", expr, expr))
+		default:
+			return "", nil, fmt.Errorf("operator %q is not supported", c.Operator)
+		}
+	}
+
+	return strings.Join(clauses, " AND "), args, nil
+}
@@ -0,0 +1,76 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/OsagieDG/jwt-based-auth-system/internal/models"
+)
+
+type SigningKeyRepository interface {
+	SaveSigningKey(ctx context.Context, key *models.SigningKey) error
+	GetSigningKeys(ctx context.Context) ([]models.SigningKey, error)
+	RotateSigningKey(ctx context.Context, key *models.SigningKey, retireAt time.Time) error
+}
+
+type SigningKeySQLRepository struct {
+	DB *sql.DB
+}
+
+func NewSigningKeySQLRepository(db *sql.DB) SigningKeyRepository {
+	return &SigningKeySQLRepository{DB: db}
+}
+
+func (r *SigningKeySQLRepository) SaveSigningKey(ctx context.Context, key *models.SigningKey) error {
+	return r.DB.QueryRowContext(ctx, `INSERT INTO auth.signing_keys (id, purpose, encrypted_secret) VALUES ($1, $2, $3) RETURNING created_at`,
+		key.ID, key.Purpose, key.EncryptedSecret,
+	).Scan(&key.CreatedAt)
+}
+
+// GetSigningKeys returns every key, newest first.
+func (r *SigningKeySQLRepository) GetSigningKeys(ctx context.Context) ([]models.SigningKey, error) {
+	rows, err := r.DB.QueryContext(ctx, `SELECT id, purpose, encrypted_secret, created_at, retired_at
+	          FROM auth.signing_keys
+	          ORDER BY created_at DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []models.SigningKey
+	for rows.Next() {
+		var key models.SigningKey
+		if err := rows.Scan(&key.ID, &key.Purpose, &key.EncryptedSecret, &key.CreatedAt, &key.RetiredAt); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// RotateSigningKey stores a new key and schedules every other active key of
+// the same purpose to retire at retireAt, so tokens they signed keep working
+// until then.
+func (r *SigningKeySQLRepository) RotateSigningKey(ctx context.Context, key *models.SigningKey, retireAt time.Time) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	_, err = tx.ExecContext(ctx, `UPDATE auth.signing_keys SET retired_at = $1
+	          WHERE purpose = $2 AND (retired_at IS NULL OR retired_at > $1)`, retireAt, key.Purpose)
+	if err != nil {
+		return err
+	}
+
+	err = tx.QueryRowContext(ctx, `INSERT INTO auth.signing_keys (id, purpose, encrypted_secret) VALUES ($1, $2, $3) RETURNING created_at`,
+		key.ID, key.Purpose, key.EncryptedSecret,
+	).Scan(&key.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
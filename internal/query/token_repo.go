@@ -5,6 +5,7 @@ import (
 	"database/sql"
 
 	"github.com/OsagieDG/jwt-based-auth-system/internal/models"
+	"github.com/google/uuid"
 )
 
 type TokenRepository interface {
@@ -12,6 +13,9 @@ type TokenRepository interface {
 	GetValidRefreshToken(ctx context.Context, jti string) (*models.RefreshToken, error)
 	RevokeRefreshToken(ctx context.Context, jti string) error
 	DeleteRefreshToken(ctx context.Context, jti string) error
+	RevokeRefreshTokensExcept(ctx context.Context, userID uuid.UUID, jti string) error
+	GetActiveRefreshTokens(ctx context.Context, userID *uuid.UUID) ([]models.RefreshToken, error)
+	PruneRefreshTokens(ctx context.Context) (int64, error)
 }
 
 type TokenSQLRepository struct {
@@ -54,3 +58,45 @@ func (r *TokenSQLRepository) DeleteRefreshToken(ctx context.Context, jti string)
 	_, err := r.DB.ExecContext(ctx, query, jti)
 	return err
 }
+
+// RevokeRefreshTokensExcept revokes every refresh token of the user other than
+// the one identified by jti. An empty jti revokes them all.
+func (r *TokenSQLRepository) RevokeRefreshTokensExcept(ctx context.Context, userID uuid.UUID, jti string) error {
+	query := `UPDATE auth.tokens SET revoked = true WHERE user_id = $1 AND jti <> $2`
+	_, err := r.DB.ExecContext(ctx, query, userID, jti)
+	return err
+}
+
+// GetActiveRefreshTokens lists the refresh tokens that can still be used,
+// soonest to expire first. A nil userID lists the tokens of every user.
+func (r *TokenSQLRepository) GetActiveRefreshTokens(ctx context.Context, userID *uuid.UUID) ([]models.RefreshToken, error) {
+	query := `SELECT id, user_id, jti, expires_at, revoked
+	          FROM auth.tokens
+	          WHERE revoked = false AND expires_at > NOW() AND ($1::uuid IS NULL OR user_id = $1)
+	          ORDER BY expires_at`
+
+	rows, err := r.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []models.RefreshToken
+	for rows.Next() {
+		var token models.RefreshToken
+		if err := rows.Scan(&token.ID, &token.UserID, &token.JTI, &token.ExpiresAt, &token.Revoked); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+// PruneRefreshTokens deletes the refresh tokens that can no longer be used.
+func (r *TokenSQLRepository) PruneRefreshTokens(ctx context.Context) (int64, error) {
+	result, err := r.DB.ExecContext(ctx, `DELETE FROM auth.tokens WHERE revoked = true OR expires_at <= NOW()`)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,43 @@
+package query
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// buildUpdateQuery turns a fields map into an UPDATE statement. Column names
+// are only ever taken from the allowed set and every value is passed as a
+// placeholder argument, so user input never ends up in the SQL text. The
+// columns are sorted to keep the generated statement stable. Placeholders in
+// the where clause are written as ? and numbered after the SET ones.
+func buildUpdateQuery(table string, allowed map[string]bool, fields map[string]interface{}, where string, whereArgs ...interface{}) (string, []interface{}, error) {
+	columns := make([]string, 0, len(fields))
+	for column := range fields {
+		if !allowed[column] {
+			return "", nil, fmt.Errorf("column %q cannot be updated", column)
+		}
+		columns = append(columns, column)
+	}
+	if len(columns) == 0 {
+		return "", nil, fmt.Errorf("no columns to update")
+	}
+	sort.Strings(columns)
+
+	var (
+		set  = make([]string, 0, len(columns))
+		args = make([]interface{}, 0, len(columns)+len(whereArgs))
+	)
+	for i, column := range columns {
+		set = append(set, fmt.Sprintf("%s = $%d", column, i+1))
+		args = append(args, fields[column])
+	}
+
+	for i := range whereArgs {
+		where = strings.Replace(where, "?", fmt.Sprintf("$%d", len(columns)+i+1), 1)
+	}
+	args = append(args, whereArgs...)
+
+	query := fmt.Sprintf("UPDATE %s SET %s WHERE %s", table, strings.Join(set, ", "), where)
+	return query, args, nil
+}
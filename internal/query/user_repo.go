@@ -5,98 +5,363 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/OsagieDG/jwt-based-auth-system/internal/models"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/stdlib"
 )
 
 type UserRespository interface {
 	InsertUser(ctx context.Context, user *models.User) (*models.User, error)
 	GetUserByID(ctx context.Context, userID uuid.UUID) (*models.User, error)
 	GetUserByEmail(ctx context.Context, email string) (*models.User, error)
-	GetUsers(ctx context.Context) ([]models.User, error)
-	UpdateUserByID(ctx context.Context, userID uuid.UUID, params models.UpdateUserParams) (*models.User, error)
-	DeleteUserByID(ctx context.Context, userID uuid.UUID) error
+	GetUsers(ctx context.Context, conditions []Condition) ([]models.User, error)
+	FindUsers(ctx context.Context, conditions []Condition, offset, limit int) ([]models.User, int, error)
+	ExistingEmails(ctx context.Context, emails []string) (map[string]bool, error)
+	ExistingUserNames(ctx context.Context, userNames []string) (map[string]bool, error)
+	ExistingIDs(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]bool, error)
+	CopyUsers(ctx context.Context, users []models.User) (int64, error)
+	ExportUsers(ctx context.Context, fn func(*models.User) error) error
+	UpdateUserByID(ctx context.Context, userID uuid.UUID, params models.UpdateUserParams, version int64) (*models.User, error)
+	UpdatePassword(ctx context.Context, userID uuid.UUID, encryptedPassword string) error
+	SetAdmin(ctx context.Context, userID uuid.UUID, isAdmin bool) error
+	UpdateEmail(ctx context.Context, userID uuid.UUID, email string) error
+	DeleteUserByID(ctx context.Context, userID uuid.UUID, version int64) error
+	SetUserStatus(ctx context.Context, userID uuid.UUID, status string, actorID *uuid.UUID, version int64) (*models.User, error)
+	ProvisionUser(ctx context.Context, userID uuid.UUID, params models.ProvisionUserParams) (*models.User, error)
+	PurgeDeletedUsers(ctx context.Context, before time.Time) (int64, error)
 }
 
+const userColumns = `id, username, email, encrypted_password, is_admin, email_verified, first_name, last_name, avatar_url,
+	created_at, updated_at, version, status, status_changed_at, metadata`
+
+// ErrVersionMismatch is returned when a conditional write finds the user at a
+// different version than the caller expected.
+var ErrVersionMismatch = errors.New("user has been modified by another request")
+
+var ErrUserNameTaken = errors.New("username is already in use")
+
+// ErrAdminUser and ErrSharedUser are returned by ProvisionUser for accounts
+// a provisioning client cannot manage: global admins, and for a scoped
+// repository users who also belong to another organization.
+var (
+	ErrAdminUser  = errors.New("user is a global admin")
+	ErrSharedUser = errors.New("user also belongs to another organization")
+)
+
+// DuplicateUserError is returned when a write would give a user the email
+// address or username of another user. Field is "email" or "username". It
+// matches ErrEmailTaken or ErrUserNameTaken with errors.Is.
+type DuplicateUserError struct {
+	Field string
+}
+
+func (e *DuplicateUserError) Error() string {
+	return e.sentinel().Error()
+}
+
+func (e *DuplicateUserError) Is(target error) bool {
+	return target == e.sentinel()
+}
+
+func (e *DuplicateUserError) sentinel() error {
+	if e.Field == "email" {
+		return ErrEmailTaken
+	}
+	return ErrUserNameTaken
+}
+
+// uniqueViolation is the SQLSTATE Postgres reports for unique_violation.
+const uniqueViolation = "23505"
+
+// uniqueUserIndexes maps the unique indexes on auth.users to the field they
+// protect.
+var uniqueUserIndexes = map[string]string{
+	"users_email_key":    "email",
+	"users_username_key": "username",
+}
+
+// duplicateUserError turns a unique violation on auth.users into a
+// DuplicateUserError and returns other errors unchanged.
+func duplicateUserError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+		if field, ok := uniqueUserIndexes[pgErr.ConstraintName]; ok {
+			return &DuplicateUserError{Field: field}
+		}
+	}
+	return err
+}
+
+// updatableUserColumns lists the columns UpdateUserByID may touch.
+var updatableUserColumns = map[string]bool{
+	"username":   true,
+	"first_name": true,
+	"last_name":  true,
+	"avatar_url": true,
+	"metadata":   true,
+}
+
+// provisionableUserColumns lists the columns ProvisionUser may touch.
+var provisionableUserColumns = map[string]bool{
+	"username":           true,
+	"email":              true,
+	"encrypted_password": true,
+	"first_name":         true,
+	"last_name":          true,
+}
+
+// searchableUserColumns lists the columns FindUsers may filter on.
+var searchableUserColumns = map[string]bool{
+	"id":         true,
+	"username":   true,
+	"email":      true,
+	"first_name": true,
+	"last_name":  true,
+	"status":     true,
+	"metadata":   true,
+}
+
+var statusColumns = map[string]bool{
+	"status":            true,
+	"status_changed_at": true,
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanUser(row rowScanner) (*models.User, error) {
+	var user models.User
+	err := row.Scan(
+		&user.ID, &user.UserName, &user.Email, &user.EncryptedPassword, &user.IsAdmin, &user.EmailVerified,
+		&user.FirstName, &user.LastName, &user.AvatarURL,
+		&user.CreatedAt, &user.UpdatedAt, &user.Version, &user.Status, &user.StatusChangedAt, &user.Metadata,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// UserSQLRepository works on every user, or with OrganizationID set only on
+// the members of that organization. A scoped repository treats users of
+// other organizations as not found, so an organization admin holding one
+// cannot read or change them.
 type UserSQLRepository struct {
-	DB *sql.DB
+	DB             *sql.DB
+	OrganizationID *uuid.UUID
 }
 
 func NewUserSQLRepository(db *sql.DB) UserRespository {
 	return &UserSQLRepository{DB: db}
 }
 
+// NewOrganizationUserSQLRepository returns a repository scoped to the
+// members of the organization. Users it inserts become members.
+func NewOrganizationUserSQLRepository(db *sql.DB, organizationID uuid.UUID) UserRespository {
+	return &UserSQLRepository{DB: db, OrganizationID: &organizationID}
+}
+
+// scope restricts a condition on auth.users to the members of the
+// repository's organization. The organization is passed as the placeholder
+// after args.
+func (ur *UserSQLRepository) scope(where string, args ...interface{}) (string, []interface{}) {
+	if ur.OrganizationID == nil {
+		return where, args
+	}
+	where = fmt.Sprintf("%s AND id IN (SELECT user_id FROM auth.organization_members WHERE organization_id = $%d)", where, len(args)+1)
+	return where, append(args, *ur.OrganizationID)
+}
+
 func (ur *UserSQLRepository) InsertUser(ctx context.Context, user *models.User) (*models.User, error) {
-	userID := models.NewUUID()
+	if ur.OrganizationID == nil {
+		if err := insertUser(ctx, ur.DB, user); err != nil {
+			return nil, err
+		}
+		return user, nil
+	}
 
-	_, err := ur.DB.ExecContext(ctx, `INSERT INTO auth.users (id, username, email, encrypted_password, is_admin) VALUES ($1, $2, $3, $4, $5)`,
-		userID, user.UserName, user.Email, user.EncryptedPassword, false,
-	)
+	tx, err := ur.DB.BeginTx(ctx, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to insert user into database: %w", err)
+		return nil, err
 	}
+	defer func() { _ = tx.Rollback() }()
 
+	if err := insertUser(ctx, tx, user); err != nil {
+		return nil, err
+	}
+	if err := insertMember(ctx, tx, *ur.OrganizationID, user.ID, models.OrgRoleMember); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
 	return user, nil
 }
 
-func (ur *UserSQLRepository) UpdateUserByID(ctx context.Context, userID uuid.UUID, params models.UpdateUserParams) (*models.User, error) {
-	tx, err := ur.DB.Begin()
+// insertUser stores the user with its email address and username
+// normalized. It returns a DuplicateUserError if either is taken.
+func insertUser(ctx context.Context, db execer, user *models.User) error {
+	user.Email = models.NormalizeEmail(user.Email)
+	user.UserName = models.NormalizeUserName(user.UserName)
+
+	_, err := db.ExecContext(ctx, `INSERT INTO auth.users (id, username, email, encrypted_password, is_admin, email_verified, first_name, last_name, metadata)
+	          VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		user.ID, user.UserName, user.Email, user.EncryptedPassword, false, user.EmailVerified, user.FirstName, user.LastName, user.Metadata,
+	)
 	if err != nil {
-		return nil, err
+		var duplicate *DuplicateUserError
+		if err := duplicateUserError(err); errors.As(err, &duplicate) {
+			return err
+		}
+		return fmt.Errorf("failed to insert user into database: %w", err)
 	}
-	defer func() {
-		if p := recover(); p != nil {
-			_ = tx.Rollback()
+	return nil
+}
+
+// UpdateUserByID applies only the fields present in params. An empty patch
+// leaves the row untouched and returns the current user. A non-zero version
+// makes the write conditional on the row still being at that version. A
+// username taken by another user fails with a DuplicateUserError.
+func (ur *UserSQLRepository) UpdateUserByID(ctx context.Context, userID uuid.UUID, params models.UpdateUserParams, version int64) (*models.User, error) {
+	params.UserName.Value = models.NormalizeUserName(params.UserName.Value)
+	fields := params.ToFieldsMap()
+	if len(fields) == 0 {
+		user, err := ur.GetUserByID(ctx, userID)
+		if err == nil && version != 0 && user.Version != version {
+			return nil, ErrVersionMismatch
 		}
-	}()
+		return user, err
+	}
 
-	_, err = tx.ExecContext(ctx, `UPDATE auth.users SET username = $1 WHERE id = $2`,
-		params.UserName, userID,
-	)
+	where, whereArgs := ur.versionedWhere(userID, version)
+	query, args, err := buildUpdateQuery("auth.users", updatableUserColumns, fields, where, whereArgs...)
 	if err != nil {
-		_ = tx.Rollback()
 		return nil, err
 	}
 
-	if err := tx.Commit(); err != nil {
-		return nil, err
+	result, err := ur.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return nil, duplicateUserError(err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return nil, ur.missingOrStale(ctx, userID)
 	}
 
 	return ur.GetUserByID(ctx, userID)
 }
 
+func (ur *UserSQLRepository) versionedWhere(userID uuid.UUID, version int64) (string, []interface{}) {
+	where, args := "id = ?", []interface{}{userID}
+	if version != 0 {
+		where, args = "id = ? AND version = ?", []interface{}{userID, version}
+	}
+	if ur.OrganizationID != nil {
+		where += " AND id IN (SELECT user_id FROM auth.organization_members WHERE organization_id = ?)"
+		args = append(args, *ur.OrganizationID)
+	}
+	return where, args
+}
+
+// missingOrStale explains why a conditional write touched no rows.
+func (ur *UserSQLRepository) missingOrStale(ctx context.Context, userID uuid.UUID) error {
+	if _, err := ur.GetUserByID(ctx, userID); err != nil {
+		return err
+	}
+	return ErrVersionMismatch
+}
+
+func (ur *UserSQLRepository) UpdatePassword(ctx context.Context, userID uuid.UUID, encryptedPassword string) error {
+	where, args := ur.scope(`id = $2`, encryptedPassword, userID)
+	_, err := ur.DB.ExecContext(ctx, `UPDATE auth.users SET encrypted_password = $1 WHERE `+where, args...)
+	return err
+}
+
+func (ur *UserSQLRepository) SetAdmin(ctx context.Context, userID uuid.UUID, isAdmin bool) error {
+	where, args := ur.scope(`id = $2`, isAdmin, userID)
+	_, err := ur.DB.ExecContext(ctx, `UPDATE auth.users SET is_admin = $1 WHERE `+where, args...)
+	return err
+}
+
+// UpdateEmail sets a new email address directly, for callers such as
+// provisioning clients that manage addresses themselves. It returns
+// ErrEmailTaken if another user has the address.
+func (ur *UserSQLRepository) UpdateEmail(ctx context.Context, userID uuid.UUID, email string) error {
+	email = models.NormalizeEmail(email)
+
+	tx, err := ur.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var taken bool
+	err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM auth.users WHERE LOWER(email) = $1 AND id <> $2)`, email, userID).Scan(&taken)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return ErrEmailTaken
+	}
+
+	where, args := ur.scope(`id = $2`, email, userID)
+	result, err := tx.ExecContext(ctx, `UPDATE auth.users SET email = $1 WHERE `+where, args...)
+	if err != nil {
+		return duplicateUserError(err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("user with ID %s not found: %w", userID.String(), sql.ErrNoRows)
+	}
+
+	return tx.Commit()
+}
+
+// GetUserByEmail looks the address up without regard to case.
 func (ur *UserSQLRepository) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
-	row := ur.DB.QueryRowContext(ctx, `SELECT id, username, email, encrypted_password, is_admin FROM auth.users WHERE email = $1`, email)
+	where, args := ur.scope(`LOWER(email) = $1`, models.NormalizeEmail(email))
+	row := ur.DB.QueryRowContext(ctx, `SELECT `+userColumns+` FROM auth.users WHERE `+where, args...)
 
-	var user models.User
-	err := row.Scan(&user.ID, &user.UserName, &user.Email, &user.EncryptedPassword, &user.IsAdmin)
+	user, err := scanUser(row)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, errors.New("user not found")
+			return nil, fmt.Errorf("user with email %s not found: %w", email, sql.ErrNoRows)
 		}
 		return nil, err
 	}
 
-	return &user, nil
+	return user, nil
 }
 
 func (ur *UserSQLRepository) GetUserByID(ctx context.Context, userID uuid.UUID) (*models.User, error) {
-	row := ur.DB.QueryRowContext(ctx, `SELECT id, username, email, is_admin FROM auth.users WHERE id = $1`, userID)
+	where, args := ur.scope(`id = $1`, userID)
+	row := ur.DB.QueryRowContext(ctx, `SELECT `+userColumns+` FROM auth.users WHERE `+where, args...)
 
-	var user models.User
-	if err := row.Scan(&user.ID, &user.UserName, &user.Email, &user.IsAdmin); err != nil {
+	user, err := scanUser(row)
+	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("user with ID %s not found", userID.String())
+			return nil, fmt.Errorf("user with ID %s not found: %w", userID.String(), sql.ErrNoRows)
 		}
 		return nil, err
 	}
 
-	return &user, nil
+	return user, nil
 }
 
-func (ur *UserSQLRepository) GetUsers(ctx context.Context) ([]models.User, error) {
-	rows, err := ur.DB.QueryContext(ctx, `SELECT id, username, email, is_admin FROM auth.users`)
+// GetUsers returns every user matching all conditions, ordered by creation.
+func (ur *UserSQLRepository) GetUsers(ctx context.Context, conditions []Condition) ([]models.User, error) {
+	where, args, err := buildWhere(searchableUserColumns, conditions)
+	if err != nil {
+		return nil, err
+	}
+	where, args = ur.scope(where, args...)
+
+	rows, err := ur.DB.QueryContext(ctx, `SELECT `+userColumns+` FROM auth.users WHERE `+where+` ORDER BY created_at, id`, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -104,37 +369,411 @@ func (ur *UserSQLRepository) GetUsers(ctx context.Context) ([]models.User, error
 
 	var users []models.User
 	for rows.Next() {
-		var user models.User
-		if err := rows.Scan(&user.ID, &user.UserName, &user.Email, &user.IsAdmin); err != nil {
+		user, err := scanUser(rows)
+		if err != nil {
 			return nil, err
 		}
-		users = append(users, user)
+		users = append(users, *user)
 	}
 
-	return users, nil
+	return users, rows.Err()
 }
 
-func (ur *UserSQLRepository) DeleteUserByID(ctx context.Context, userID uuid.UUID) error {
-	tx, err := ur.DB.Begin()
+// FindUsers returns one page of the users matching all conditions, ordered by
+// creation, together with the total number of matches.
+func (ur *UserSQLRepository) FindUsers(ctx context.Context, conditions []Condition, offset, limit int) ([]models.User, int, error) {
+	where, args, err := buildWhere(searchableUserColumns, conditions)
+	if err != nil {
+		return nil, 0, err
+	}
+	where, args = ur.scope(where, args...)
+
+	var total int
+	if err := ur.DB.QueryRowContext(ctx, `SELECT COUNT(*) FROM auth.users WHERE `+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := fmt.Sprintf(`SELECT `+userColumns+` FROM auth.users WHERE %s ORDER BY created_at, id LIMIT $%d OFFSET $%d`,
+		where, len(args)+1, len(args)+2)
+	rows, err := ur.DB.QueryContext(ctx, query, append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		users = append(users, *user)
+	}
+
+	return users, total, rows.Err()
+}
+
+// ExistingEmails returns which of the addresses already belong to a user. It
+// is not scoped, as addresses are unique across organizations.
+func (ur *UserSQLRepository) ExistingEmails(ctx context.Context, emails []string) (map[string]bool, error) {
+	rows, err := ur.DB.QueryContext(ctx, `SELECT email FROM auth.users WHERE LOWER(email) = ANY($1::text[])`, emails)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	existing := map[string]bool{}
+	for rows.Next() {
+		var email string
+		if err := rows.Scan(&email); err != nil {
+			return nil, err
+		}
+		existing[email] = true
+	}
+	return existing, rows.Err()
+}
+
+// ExistingUserNames returns which of the usernames, in lower case, already
+// belong to a user in any organization.
+func (ur *UserSQLRepository) ExistingUserNames(ctx context.Context, userNames []string) (map[string]bool, error) {
+	rows, err := ur.DB.QueryContext(ctx, `SELECT LOWER(username) FROM auth.users WHERE LOWER(username) = ANY($1::text[])`, lowerAll(userNames))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	existing := map[string]bool{}
+	for rows.Next() {
+		var userName string
+		if err := rows.Scan(&userName); err != nil {
+			return nil, err
+		}
+		existing[userName] = true
+	}
+	return existing, rows.Err()
+}
+
+func lowerAll(values []string) []string {
+	lower := make([]string, 0, len(values))
+	for _, value := range values {
+		lower = append(lower, strings.ToLower(value))
+	}
+	return lower
+}
+
+// ExistingIDs returns which of the ids are already taken by a user, in any
+// organization.
+func (ur *UserSQLRepository) ExistingIDs(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]bool, error) {
+	values := make([]string, 0, len(ids))
+	for _, id := range ids {
+		values = append(values, id.String())
+	}
+
+	rows, err := ur.DB.QueryContext(ctx, `SELECT id FROM auth.users WHERE id = ANY($1::uuid[])`, values)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	existing := map[uuid.UUID]bool{}
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		existing[id] = true
+	}
+	return existing, rows.Err()
+}
+
+// CopyUsers inserts a batch of users with a single COPY, which is much faster
+// than one INSERT per user for large imports. The batch is stored completely
+// or not at all, and a scoped repository makes the users members.
+func (ur *UserSQLRepository) CopyUsers(ctx context.Context, users []models.User) (int64, error) {
+	conn, err := ur.DB.Conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	var copied int64
+	err = conn.Raw(func(driverConn interface{}) error {
+		tx, err := driverConn.(*stdlib.Conn).Conn().Begin(ctx)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = tx.Rollback(ctx) }()
+
+		copied, err = tx.CopyFrom(ctx,
+			pgx.Identifier{"auth", "users"},
+			[]string{"id", "username", "email", "encrypted_password", "is_admin", "email_verified", "first_name", "last_name"},
+			pgx.CopyFromSlice(len(users), func(i int) ([]interface{}, error) {
+				u := users[i]
+				return []interface{}{u.ID, u.UserName, u.Email, u.EncryptedPassword, u.IsAdmin, u.EmailVerified, u.FirstName, u.LastName}, nil
+			}),
+		)
+		if err != nil {
+			return err
+		}
+
+		if ur.OrganizationID != nil {
+			_, err = tx.CopyFrom(ctx,
+				pgx.Identifier{"auth", "organization_members"},
+				[]string{"organization_id", "user_id", "role"},
+				pgx.CopyFromSlice(len(users), func(i int) ([]interface{}, error) {
+					return []interface{}{*ur.OrganizationID, users[i].ID, models.OrgRoleMember}, nil
+				}),
+			)
+			if err != nil {
+				return err
+			}
+		}
+
+		return tx.Commit(ctx)
+	})
+	if err != nil {
+		var duplicate *DuplicateUserError
+		if err := duplicateUserError(err); errors.As(err, &duplicate) {
+			return 0, err
+		}
+		return 0, fmt.Errorf("failed to copy users into database: %w", err)
+	}
+	return copied, nil
+}
+
+// ExportUsers streams every user to fn in creation order without loading the
+// whole table into memory.
+func (ur *UserSQLRepository) ExportUsers(ctx context.Context, fn func(*models.User) error) error {
+	where, args := ur.scope(`TRUE`)
+	rows, err := ur.DB.QueryContext(ctx, `SELECT `+userColumns+` FROM auth.users WHERE `+where+` ORDER BY created_at, id`, args...)
 	if err != nil {
 		return err
 	}
-	defer func() {
-		if p := recover(); p != nil {
-			_ = tx.Rollback()
+	defer rows.Close()
+
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return err
 		}
-	}()
+		if err := fn(user); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
 
-	_, err = tx.ExecContext(ctx, `DELETE FROM auth.tokens WHERE user_id = $1`, userID)
+// DeleteUserByID removes the user and their tokens. A non-zero version makes
+// the delete conditional on the row still being at that version.
+func (ur *UserSQLRepository) DeleteUserByID(ctx context.Context, userID uuid.UUID, version int64) error {
+	tx, err := ur.DB.BeginTx(ctx, nil)
 	if err != nil {
-		_ = tx.Rollback()
+		return err
 	}
+	defer func() { _ = tx.Rollback() }()
 
-	_, err = tx.ExecContext(ctx, `DELETE FROM auth.users WHERE id = $1`, userID)
+	// Locking the row first also makes sure a scoped repository only ever
+	// touches the tokens of its own members.
+	var current int64
+	where, args := ur.scope(`id = $1`, userID)
+	err = tx.QueryRowContext(ctx, `SELECT version FROM auth.users WHERE `+where+` FOR UPDATE`, args...).Scan(&current)
 	if err != nil {
-		_ = tx.Rollback()
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("user with ID %s not found: %w", userID.String(), sql.ErrNoRows)
+		}
+		return err
+	}
+	if version != 0 && current != version {
+		return ErrVersionMismatch
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM auth.tokens WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM auth.users WHERE id = $1`, userID); err != nil {
 		return err
 	}
 
 	return tx.Commit()
 }
+
+// SetUserStatus moves the user to a new lifecycle status and records who did
+// it. Leaving the active status also revokes every refresh token so existing
+// sessions cannot be refreshed.
+func (ur *UserSQLRepository) SetUserStatus(ctx context.Context, userID uuid.UUID, status string, actorID *uuid.UUID, version int64) (*models.User, error) {
+	tx, err := ur.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var previous string
+	where, whereArgs := ur.scope(`id = $1`, userID)
+	err = tx.QueryRowContext(ctx, `SELECT status FROM auth.users WHERE `+where+` FOR UPDATE`, whereArgs...).Scan(&previous)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("user with ID %s not found: %w", userID.String(), sql.ErrNoRows)
+		}
+		return nil, err
+	}
+
+	where, whereArgs = ur.versionedWhere(userID, version)
+	if err := updateStatus(ctx, tx, userID, previous, status, actorID, where, whereArgs...); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return ur.GetUserByID(ctx, userID)
+}
+
+// updateStatus moves the user matched by where from the previous status to
+// the new one inside the caller's transaction, revoking their refresh tokens
+// when they leave the active status. It returns ErrVersionMismatch if where
+// matches no row.
+func updateStatus(ctx context.Context, tx *sql.Tx, userID uuid.UUID, previous, status string, actorID *uuid.UUID, where string, whereArgs ...interface{}) error {
+	fields := map[string]interface{}{
+		"status":            status,
+		"status_changed_at": time.Now(),
+	}
+	query, args, err := buildUpdateQuery("auth.users", statusColumns, fields, where, whereArgs...)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrVersionMismatch
+	}
+
+	if status != models.UserStatusActive {
+		if _, err := tx.ExecContext(ctx, `UPDATE auth.tokens SET revoked = true WHERE user_id = $1`, userID); err != nil {
+			return err
+		}
+	}
+
+	event := models.NewAuditEvent(userID, actorID, models.AuditUserStatusChanged, map[string]interface{}{
+		"from": previous,
+		"to":   status,
+	})
+	return insertAuditEvent(ctx, tx, event)
+}
+
+// ProvisionUser applies the email address, profile, password and status a
+// provisioning client sent in one transaction, so a conflict on any of them
+// leaves the user unchanged. Global admins fail with ErrAdminUser, users
+// shared with another organization with ErrSharedUser, and an address or
+// username taken by another user with a DuplicateUserError.
+func (ur *UserSQLRepository) ProvisionUser(ctx context.Context, userID uuid.UUID, params models.ProvisionUserParams) (*models.User, error) {
+	tx, err := ur.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var (
+		previous string
+		isAdmin  bool
+	)
+	where, whereArgs := ur.scope(`id = $1`, userID)
+	err = tx.QueryRowContext(ctx, `SELECT status, is_admin FROM auth.users WHERE `+where+` FOR UPDATE`, whereArgs...).Scan(&previous, &isAdmin)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("user with ID %s not found: %w", userID.String(), sql.ErrNoRows)
+		}
+		return nil, err
+	}
+	if isAdmin {
+		return nil, ErrAdminUser
+	}
+	if ur.OrganizationID != nil {
+		var shared bool
+		err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM auth.organization_members WHERE user_id = $1 AND organization_id <> $2)`,
+			userID, *ur.OrganizationID).Scan(&shared)
+		if err != nil {
+			return nil, err
+		}
+		if shared {
+			return nil, ErrSharedUser
+		}
+	}
+
+	params.Profile.UserName.Value = models.NormalizeUserName(params.Profile.UserName.Value)
+	fields := params.Profile.ToFieldsMap()
+	if params.Email != "" {
+		fields["email"] = models.NormalizeEmail(params.Email)
+	}
+	if params.EncryptedPassword != "" {
+		fields["encrypted_password"] = params.EncryptedPassword
+	}
+	if len(fields) > 0 {
+		query, args, err := buildUpdateQuery("auth.users", provisionableUserColumns, fields, "id = ?", userID)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+			return nil, duplicateUserError(err)
+		}
+	}
+
+	if params.Status != "" && params.Status != previous {
+		if err := updateStatus(ctx, tx, userID, previous, params.Status, nil, "id = ?", userID); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return ur.GetUserByID(ctx, userID)
+}
+
+// PurgeDeletedUsers hard deletes users that have been pending deletion since
+// before the given time, together with their tokens.
+func (ur *UserSQLRepository) PurgeDeletedUsers(ctx context.Context, before time.Time) (int64, error) {
+	tx, err := ur.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	where, args := ur.scope(`status = $1 AND status_changed_at < $2`, models.UserStatusPendingDeletion, before)
+	rows, err := tx.QueryContext(ctx, `SELECT id FROM auth.users WHERE `+where+` FOR UPDATE SKIP LOCKED`, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, id := range ids {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM auth.tokens WHERE user_id = $1`, id); err != nil {
+			return 0, err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM auth.users WHERE id = $1`, id); err != nil {
+			return 0, err
+		}
+		if err := insertAuditEvent(ctx, tx, models.NewAuditEvent(id, nil, models.AuditUserPurged, nil)); err != nil {
+			return 0, err
+		}
+	}
+
+	return int64(len(ids)), tx.Commit()
+}
@@ -0,0 +1,85 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/OsagieDG/jwt-based-auth-system/internal/models"
+	"github.com/google/uuid"
+)
+
+var ErrInvalidToken = errors.New("token is invalid, expired or already used")
+
+type VerificationRepository interface {
+	SaveVerificationToken(ctx context.Context, token *models.VerificationToken) error
+	GetLatestVerificationToken(ctx context.Context, userID uuid.UUID) (*models.VerificationToken, error)
+	ConsumeVerificationToken(ctx context.Context, tokenHash string) (uuid.UUID, error)
+}
+
+type VerificationSQLRepository struct {
+	DB *sql.DB
+}
+
+func NewVerificationSQLRepository(db *sql.DB) VerificationRepository {
+	return &VerificationSQLRepository{DB: db}
+}
+
+// SaveVerificationToken stores a new token and discards any unused token that
+// was previously issued to the same user, so only the latest link works.
+func (r *VerificationSQLRepository) SaveVerificationToken(ctx context.Context, token *models.VerificationToken) error {
+	_, err := r.DB.ExecContext(ctx, `DELETE FROM auth.email_verification_tokens WHERE user_id = $1 AND used_at IS NULL`, token.UserID)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO auth.email_verification_tokens (id, user_id, token_hash, expires_at) VALUES ($1, $2, $3, $4)`
+	_, err = r.DB.ExecContext(ctx, query, token.ID, token.UserID, token.TokenHash, token.ExpiresAt)
+	return err
+}
+
+func (r *VerificationSQLRepository) GetLatestVerificationToken(ctx context.Context, userID uuid.UUID) (*models.VerificationToken, error) {
+	var token models.VerificationToken
+	query := `SELECT id, user_id, token_hash, expires_at, used_at, created_at
+	          FROM auth.email_verification_tokens
+	          WHERE user_id = $1
+	          ORDER BY created_at DESC
+	          LIMIT 1`
+
+	err := r.DB.QueryRowContext(ctx, query, userID).Scan(
+		&token.ID, &token.UserID, &token.TokenHash, &token.ExpiresAt, &token.UsedAt, &token.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// ConsumeVerificationToken marks the token as used and the owner's email as
+// verified in a single transaction, returning the verified user's ID.
+func (r *VerificationSQLRepository) ConsumeVerificationToken(ctx context.Context, tokenHash string) (uuid.UUID, error) {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var userID uuid.UUID
+	err = tx.QueryRowContext(ctx, `UPDATE auth.email_verification_tokens
+	          SET used_at = NOW()
+	          WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+	          RETURNING user_id`, tokenHash).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return uuid.Nil, ErrInvalidToken
+		}
+		return uuid.Nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE auth.users SET email_verified = true WHERE id = $1`, userID)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	return userID, tx.Commit()
+}
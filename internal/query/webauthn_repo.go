@@ -0,0 +1,153 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/OsagieDG/jwt-based-auth-system/internal/models"
+	"github.com/google/uuid"
+)
+
+type WebAuthnRepository interface {
+	SaveCredential(ctx context.Context, credential *models.WebAuthnCredential) error
+	GetCredentialsByUserID(ctx context.Context, userID uuid.UUID) ([]models.WebAuthnCredential, error)
+	UpdateCredentialUsage(ctx context.Context, userID uuid.UUID, credentialID []byte, signCount uint32, data []byte) error
+	DeleteCredential(ctx context.Context, userID, id uuid.UUID) error
+	SaveChallenge(ctx context.Context, challenge *models.WebAuthnChallenge) error
+	ConsumeChallenge(ctx context.Context, id uuid.UUID, purpose string) (*models.WebAuthnChallenge, error)
+}
+
+type WebAuthnSQLRepository struct {
+	DB *sql.DB
+}
+
+func NewWebAuthnSQLRepository(db *sql.DB) WebAuthnRepository {
+	return &WebAuthnSQLRepository{DB: db}
+}
+
+func (r *WebAuthnSQLRepository) SaveCredential(ctx context.Context, credential *models.WebAuthnCredential) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	query := `INSERT INTO auth.webauthn_credentials (id, user_id, credential_id, name, sign_count, data)
+	          VALUES ($1, $2, $3, $4, $5, $6)
+	          RETURNING created_at`
+	err = tx.QueryRowContext(ctx, query,
+		credential.ID, credential.UserID, credential.CredentialID, credential.Name, credential.SignCount, credential.Data,
+	).Scan(&credential.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	if err := insertAuditEvent(ctx, tx, models.NewAuditEvent(credential.UserID, &credential.UserID, models.AuditMFAEnabled, map[string]interface{}{
+		"method":        "webauthn",
+		"credential_id": credential.ID,
+	})); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *WebAuthnSQLRepository) GetCredentialsByUserID(ctx context.Context, userID uuid.UUID) ([]models.WebAuthnCredential, error) {
+	rows, err := r.DB.QueryContext(ctx, `SELECT id, user_id, credential_id, name, sign_count, data, created_at, last_used_at
+	          FROM auth.webauthn_credentials
+	          WHERE user_id = $1
+	          ORDER BY created_at`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var credentials []models.WebAuthnCredential
+	for rows.Next() {
+		var credential models.WebAuthnCredential
+		if err := rows.Scan(
+			&credential.ID, &credential.UserID, &credential.CredentialID, &credential.Name,
+			&credential.SignCount, &credential.Data, &credential.CreatedAt, &credential.LastUsedAt,
+		); err != nil {
+			return nil, err
+		}
+		credentials = append(credentials, credential)
+	}
+
+	return credentials, rows.Err()
+}
+
+// UpdateCredentialUsage stores the new signature counter of a credential of
+// the user. A credential ID of another user is never touched.
+func (r *WebAuthnSQLRepository) UpdateCredentialUsage(ctx context.Context, userID uuid.UUID, credentialID []byte, signCount uint32, data []byte) error {
+	query := `UPDATE auth.webauthn_credentials
+	          SET sign_count = $1, data = $2, last_used_at = NOW()
+	          WHERE credential_id = $3 AND user_id = $4`
+	result, err := r.DB.ExecContext(ctx, query, signCount, data, credentialID, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("credential of user %s not found: %w", userID.String(), sql.ErrNoRows)
+	}
+	return nil
+}
+
+func (r *WebAuthnSQLRepository) DeleteCredential(ctx context.Context, userID, id uuid.UUID) error {
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM auth.webauthn_credentials WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	if err := deleteUnusableRecoveryCodes(ctx, tx, userID); err != nil {
+		return err
+	}
+
+	if err := insertAuditEvent(ctx, tx, models.NewAuditEvent(userID, &userID, models.AuditMFADisabled, map[string]interface{}{
+		"method":        "webauthn",
+		"credential_id": id,
+	})); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *WebAuthnSQLRepository) SaveChallenge(ctx context.Context, challenge *models.WebAuthnChallenge) error {
+	_, err := r.DB.ExecContext(ctx, `DELETE FROM auth.webauthn_challenges WHERE expires_at < NOW()`)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO auth.webauthn_challenges (id, user_id, purpose, data, expires_at) VALUES ($1, $2, $3, $4, $5)`
+	_, err = r.DB.ExecContext(ctx, query, challenge.ID, challenge.UserID, challenge.Purpose, challenge.Data, challenge.ExpiresAt)
+	return err
+}
+
+// ConsumeChallenge deletes and returns the challenge so every ceremony can
+// only be finished once.
+func (r *WebAuthnSQLRepository) ConsumeChallenge(ctx context.Context, id uuid.UUID, purpose string) (*models.WebAuthnChallenge, error) {
+	var challenge models.WebAuthnChallenge
+	err := r.DB.QueryRowContext(ctx, `DELETE FROM auth.webauthn_challenges
+	          WHERE id = $1 AND purpose = $2 AND expires_at > NOW()
+	          RETURNING id, user_id, purpose, data, expires_at`, id, purpose).Scan(
+		&challenge.ID, &challenge.UserID, &challenge.Purpose, &challenge.Data, &challenge.ExpiresAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInvalidToken
+		}
+		return nil, err
+	}
+	return &challenge, nil
+}
@@ -0,0 +1,39 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps limiter state in process. It is only accurate when a
+// single instance of the API is running.
+type MemoryStore struct {
+	mu     sync.Mutex
+	states map[string]state
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{states: map[string]state{}}
+}
+
+func (m *MemoryStore) Take(ctx context.Context, key string, limit Limit) (Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, result := take(m.states[key], limit, time.Now())
+	m.states[key] = s
+	return result, nil
+}
+
+func (m *MemoryStore) Prune(ctx context.Context, before time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key, s := range m.states {
+		if s.UpdatedAt.Before(before) {
+			delete(m.states, key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package ratelimit
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const maxPeekBodyBytes = 1 << 20
+
+// KeyFunc extracts the value a rule is keyed by. Returning false skips the
+// rule for that request, e.g. when the body has no email.
+type KeyFunc func(r *http.Request) (string, bool)
+
+type Rule struct {
+	Name  string
+	Limit Limit
+	Key   KeyFunc
+}
+
+type Limiter struct {
+	store Store
+}
+
+func NewLimiter(store Store) *Limiter {
+	return &Limiter{store: store}
+}
+
+// Middleware enforces every rule on the request and rejects it with 429 as
+// soon as one of them is exhausted. The RateLimit-* headers describe the
+// most restrictive rule that was checked.
+func (l *Limiter) Middleware(rules ...Rule) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var tightest *Result
+			for _, rule := range rules {
+				key, ok := rule.Key(r)
+				if !ok {
+					continue
+				}
+
+				result, err := l.store.Take(r.Context(), rule.Name+":"+key, rule.Limit)
+				if err != nil {
+					// Failing open keeps the API usable when the store is down.
+					log.Printf("rate limit store error for %s: %v", rule.Name, err)
+					continue
+				}
+
+				if tightest == nil || !result.Allowed || result.Remaining < tightest.Remaining {
+					tightest = &result
+				}
+				if !result.Allowed {
+					break
+				}
+			}
+
+			if tightest != nil {
+				writeHeaders(w, tightest)
+				if !tightest.Allowed {
+					w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(tightest.RetryAfter)))
+					http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func writeHeaders(w http.ResponseWriter, result *Result) {
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.ResetAfter)))
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// ByIP keys requests by the client address. The address is taken from the
+// connection, so deployments behind a proxy should put a real-IP middleware
+// in front of the limiter.
+func ByIP(r *http.Request) (string, bool) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr, r.RemoteAddr != ""
+	}
+	return host, true
+}
+
+// ByJSONField keys requests by a string member of the JSON body. The body is
+// restored so the handler can still decode it.
+func ByJSONField(field string) KeyFunc {
+	return func(r *http.Request) (string, bool) {
+		if r.Body == nil {
+			return "", false
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxPeekBodyBytes))
+		if err != nil {
+			return "", false
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		var fields map[string]interface{}
+		if err := json.Unmarshal(body, &fields); err != nil {
+			return "", false
+		}
+		value, ok := fields[field].(string)
+		if !ok || value == "" {
+			return "", false
+		}
+		return strings.ToLower(strings.TrimSpace(value)), true
+	}
+}
@@ -0,0 +1,64 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// PostgresStore shares limiter state between every instance of the API
+// through the auth.rate_limits table. Each Take locks the key's row for the
+// duration of a short transaction.
+type PostgresStore struct {
+	DB *sql.DB
+}
+
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{DB: db}
+}
+
+func (p *PostgresStore) Take(ctx context.Context, key string, limit Limit) (Result, error) {
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return Result{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO auth.rate_limits (key) VALUES ($1) ON CONFLICT (key) DO NOTHING`, key)
+	if err != nil {
+		return Result{}, err
+	}
+
+	var (
+		s           state
+		windowStart sql.NullTime
+		updatedAt   sql.NullTime
+	)
+	err = tx.QueryRowContext(ctx, `SELECT tokens, window_start, current_count, previous_count, updated_at
+	          FROM auth.rate_limits
+	          WHERE key = $1
+	          FOR UPDATE`, key).Scan(&s.Tokens, &windowStart, &s.CurrentCount, &s.PreviousCount, &updatedAt)
+	if err != nil {
+		return Result{}, err
+	}
+	s.WindowStart = windowStart.Time
+	s.UpdatedAt = updatedAt.Time
+
+	s, result := take(s, limit, time.Now())
+
+	_, err = tx.ExecContext(ctx, `UPDATE auth.rate_limits
+	          SET tokens = $1, window_start = $2, current_count = $3, previous_count = $4, updated_at = $5
+	          WHERE key = $6`,
+		s.Tokens, s.WindowStart, s.CurrentCount, s.PreviousCount, s.UpdatedAt, key,
+	)
+	if err != nil {
+		return Result{}, err
+	}
+
+	return result, tx.Commit()
+}
+
+func (p *PostgresStore) Prune(ctx context.Context, before time.Time) error {
+	_, err := p.DB.ExecContext(ctx, `DELETE FROM auth.rate_limits WHERE updated_at < $1`, before)
+	return err
+}
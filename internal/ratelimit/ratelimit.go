@@ -0,0 +1,172 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	TokenBucket   = "token_bucket"
+	SlidingWindow = "sliding_window"
+)
+
+// Limit allows Requests per Period. For the token bucket, Burst is the bucket
+// size and defaults to Requests.
+type Limit struct {
+	Algorithm string
+	Requests  int
+	Period    time.Duration
+	Burst     int
+}
+
+// ParseLimit reads limits written as "5/1m", optionally prefixed with the
+// algorithm, e.g. "sliding_window:100/1h" or "token_bucket:10/1s".
+func ParseLimit(s string) (Limit, error) {
+	limit := Limit{Algorithm: TokenBucket}
+
+	if algorithm, rest, ok := strings.Cut(s, ":"); ok {
+		limit.Algorithm = algorithm
+		s = rest
+	}
+	if limit.Algorithm != TokenBucket && limit.Algorithm != SlidingWindow {
+		return Limit{}, fmt.Errorf("unknown rate limit algorithm %q", limit.Algorithm)
+	}
+
+	requests, period, ok := strings.Cut(s, "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("rate limit %q must look like <requests>/<period>", s)
+	}
+
+	n, err := strconv.Atoi(requests)
+	if err != nil || n <= 0 {
+		return Limit{}, fmt.Errorf("invalid request count in rate limit %q", s)
+	}
+	d, err := time.ParseDuration(period)
+	if err != nil || d <= 0 {
+		return Limit{}, fmt.Errorf("invalid period in rate limit %q", s)
+	}
+
+	limit.Requests = n
+	limit.Period = d
+	limit.Burst = n
+	return limit, nil
+}
+
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	ResetAfter time.Duration
+	RetryAfter time.Duration
+}
+
+// Store keeps the limiter state for every key. Implementations must apply
+// Take atomically so concurrent requests cannot overdraw a key.
+type Store interface {
+	Take(ctx context.Context, key string, limit Limit) (Result, error)
+	Prune(ctx context.Context, before time.Time) error
+}
+
+// state holds what either algorithm needs to remember about a key, so both
+// stores can share the same implementation of the algorithms.
+type state struct {
+	Tokens        float64
+	WindowStart   time.Time
+	CurrentCount  int
+	PreviousCount int
+	UpdatedAt     time.Time
+}
+
+func take(s state, limit Limit, now time.Time) (state, Result) {
+	if limit.Algorithm == SlidingWindow {
+		return takeSlidingWindow(s, limit, now)
+	}
+	return takeTokenBucket(s, limit, now)
+}
+
+// takeTokenBucket refills the bucket at Requests per Period up to Burst
+// tokens and spends one token per request.
+func takeTokenBucket(s state, limit Limit, now time.Time) (state, Result) {
+	burst := limit.Burst
+	if burst <= 0 {
+		burst = limit.Requests
+	}
+	rate := float64(limit.Requests) / limit.Period.Seconds()
+
+	if s.UpdatedAt.IsZero() {
+		s.Tokens = float64(burst)
+	} else {
+		s.Tokens = math.Min(float64(burst), s.Tokens+now.Sub(s.UpdatedAt).Seconds()*rate)
+	}
+	s.UpdatedAt = now
+
+	result := Result{Limit: burst}
+	if s.Tokens >= 1 {
+		s.Tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = secondsToDuration((1 - s.Tokens) / rate)
+	}
+	result.Remaining = int(s.Tokens)
+	result.ResetAfter = secondsToDuration((float64(burst) - s.Tokens) / rate)
+
+	return s, result
+}
+
+// takeSlidingWindow approximates a sliding window by weighting the count of
+// the previous fixed window by how much of it still overlaps the sliding one.
+func takeSlidingWindow(s state, limit Limit, now time.Time) (state, Result) {
+	windowStart := now.Truncate(limit.Period)
+
+	switch {
+	case s.WindowStart.Equal(windowStart):
+	case s.WindowStart.Equal(windowStart.Add(-limit.Period)):
+		s.PreviousCount = s.CurrentCount
+		s.CurrentCount = 0
+	default:
+		s.PreviousCount = 0
+		s.CurrentCount = 0
+	}
+	s.WindowStart = windowStart
+	s.UpdatedAt = now
+
+	elapsed := now.Sub(windowStart)
+	weight := 1 - elapsed.Seconds()/limit.Period.Seconds()
+	used := float64(s.PreviousCount)*weight + float64(s.CurrentCount)
+
+	result := Result{Limit: limit.Requests, ResetAfter: limit.Period - elapsed}
+	if used+1 <= float64(limit.Requests) {
+		s.CurrentCount++
+		used++
+		result.Allowed = true
+	} else {
+		result.RetryAfter = retryAfterSlidingWindow(s, limit, elapsed)
+	}
+	result.Remaining = int(math.Max(0, float64(limit.Requests)-used))
+
+	return s, result
+}
+
+// retryAfterSlidingWindow works out how long until enough of the previous
+// window has slid out for one more request to fit.
+func retryAfterSlidingWindow(s state, limit Limit, elapsed time.Duration) time.Duration {
+	free := float64(limit.Requests-s.CurrentCount) - 1
+	if free < 0 || s.PreviousCount == 0 {
+		return limit.Period - elapsed
+	}
+
+	// Solve previous * (1 - t/period) <= free for t.
+	t := (1 - free/float64(s.PreviousCount)) * limit.Period.Seconds()
+	if wait := secondsToDuration(t) - elapsed; wait > 0 {
+		return wait
+	}
+	return time.Second
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(math.Ceil(seconds * float64(time.Second)))
+}
@@ -0,0 +1,168 @@
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode"
+)
+
+// Comparison is one attribute test of a filter. Attribute paths are
+// lowercased, since SCIM attribute names are case-insensitive.
+type Comparison struct {
+	Attribute string
+	Operator  string
+	// Value is nil for the pr operator and for a null literal.
+	Value interface{}
+}
+
+var operators = map[string]bool{
+	"eq": true, "ne": true, "co": true, "sw": true, "ew": true,
+	"gt": true, "ge": true, "lt": true, "le": true, "pr": true,
+}
+
+// ParseFilter parses the subset of the filter grammar of RFC 7644 section
+// 3.4.2.2 that provisioning clients send in practice: comparisons joined by
+// "and". Other filters are answered with an invalidFilter error.
+func ParseFilter(filter string) ([]Comparison, *Error) {
+	tokens, err := tokenize(filter)
+	if err != nil {
+		return nil, NewError(http.StatusBadRequest, ErrInvalidFilter, err.Error())
+	}
+
+	var comparisons []Comparison
+	for len(tokens) > 0 {
+		if len(comparisons) > 0 {
+			if !strings.EqualFold(tokens[0], "and") {
+				return nil, NewError(http.StatusBadRequest, ErrInvalidFilter, fmt.Sprintf("unsupported filter expression %q", tokens[0]))
+			}
+			tokens = tokens[1:]
+		}
+
+		if len(tokens) < 2 {
+			return nil, NewError(http.StatusBadRequest, ErrInvalidFilter, "incomplete filter")
+		}
+
+		comparison := Comparison{
+			Attribute: strings.ToLower(tokens[0]),
+			Operator:  strings.ToLower(tokens[1]),
+		}
+		if !operators[comparison.Operator] {
+			return nil, NewError(http.StatusBadRequest, ErrInvalidFilter, fmt.Sprintf("unsupported operator %q", tokens[1]))
+		}
+		tokens = tokens[2:]
+
+		if comparison.Operator != "pr" {
+			if len(tokens) == 0 {
+				return nil, NewError(http.StatusBadRequest, ErrInvalidFilter, "missing comparison value")
+			}
+			value, err := parseValue(tokens[0])
+			if err != nil {
+				return nil, NewError(http.StatusBadRequest, ErrInvalidFilter, err.Error())
+			}
+			comparison.Value = value
+			tokens = tokens[1:]
+		}
+
+		comparisons = append(comparisons, comparison)
+	}
+
+	return comparisons, nil
+}
+
+// tokenize splits a filter on whitespace while keeping quoted strings
+// together. Grouping with parentheses or brackets is not supported.
+func tokenize(filter string) ([]string, error) {
+	var (
+		tokens  []string
+		current strings.Builder
+		quoted  bool
+		escaped bool
+	)
+	for _, r := range filter {
+		switch {
+		case quoted:
+			current.WriteRune(r)
+			if escaped {
+				escaped = false
+			} else if r == '\\' {
+				escaped = true
+			} else if r == '"' {
+				quoted = false
+			}
+		case r == '"':
+			quoted = true
+			current.WriteRune(r)
+		case r == '(' || r == ')' || r == '[' || r == ']':
+			return nil, fmt.Errorf("grouping in filters is not supported")
+		case unicode.IsSpace(r):
+			if current.Len() > 0 {
+				tokens = append(tokens, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("unterminated string in filter")
+	}
+	if current.Len() > 0 {
+		tokens = append(tokens, current.String())
+	}
+	return tokens, nil
+}
+
+// parseValue reads a JSON literal: a string, number, boolean or null.
+func parseValue(token string) (interface{}, error) {
+	var value interface{}
+	if err := json.Unmarshal([]byte(token), &value); err != nil {
+		return nil, fmt.Errorf("invalid comparison value %s", token)
+	}
+	switch value.(type) {
+	case string, float64, bool, nil:
+		return value, nil
+	}
+	return nil, fmt.Errorf("invalid comparison value %s", token)
+}
+
+// Path is a parsed PATCH path such as name.givenName or
+// members[value eq "2819c223"].
+type Path struct {
+	Attribute string
+	Filter    []Comparison
+	// SubAttribute is set for paths like emails[type eq "work"].value.
+	SubAttribute string
+}
+
+func ParsePath(path string) (*Path, *Error) {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, SchemaUser+":"), SchemaGroup+":")
+
+	open := strings.IndexByte(path, '[')
+	if open < 0 {
+		if path == "" {
+			return nil, NewError(http.StatusBadRequest, ErrInvalidPath, "empty path")
+		}
+		return &Path{Attribute: strings.ToLower(path)}, nil
+	}
+
+	end := strings.LastIndexByte(path, ']')
+	if end < open {
+		return nil, NewError(http.StatusBadRequest, ErrInvalidPath, fmt.Sprintf("invalid path %q", path))
+	}
+
+	filter, err := ParseFilter(path[open+1 : end])
+	if err != nil {
+		return nil, NewError(http.StatusBadRequest, ErrInvalidPath, err.Detail)
+	}
+
+	parsed := &Path{Attribute: strings.ToLower(path[:open]), Filter: filter}
+	if rest := path[end+1:]; rest != "" {
+		if !strings.HasPrefix(rest, ".") {
+			return nil, NewError(http.StatusBadRequest, ErrInvalidPath, fmt.Sprintf("invalid path %q", path))
+		}
+		parsed.SubAttribute = strings.ToLower(rest[1:])
+	}
+	return parsed, nil
+}
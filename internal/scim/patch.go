@@ -0,0 +1,85 @@
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	OpAdd     = "add"
+	OpReplace = "replace"
+	OpRemove  = "remove"
+)
+
+type PatchRequest struct {
+	Schemas    []string         `json:"schemas"`
+	Operations []PatchOperation `json:"Operations"`
+}
+
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// Validate checks the message schema and normalizes the operation names,
+// which some clients send capitalized.
+func (r *PatchRequest) Validate() *Error {
+	if !containsSchema(r.Schemas, SchemaPatchOp) {
+		return NewError(http.StatusBadRequest, ErrInvalidSyntax, "request is not a PatchOp message")
+	}
+	if len(r.Operations) == 0 {
+		return NewError(http.StatusBadRequest, ErrInvalidSyntax, "no operations")
+	}
+
+	for i := range r.Operations {
+		op := &r.Operations[i]
+		op.Op = strings.ToLower(op.Op)
+		switch op.Op {
+		case OpAdd, OpReplace:
+			if len(op.Value) == 0 {
+				return NewError(http.StatusBadRequest, ErrInvalidValue, fmt.Sprintf("%s operation needs a value", op.Op))
+			}
+		case OpRemove:
+			if op.Path == "" {
+				return NewError(http.StatusBadRequest, ErrNoTarget, "remove operation needs a path")
+			}
+		default:
+			return NewError(http.StatusBadRequest, ErrInvalidSyntax, fmt.Sprintf("unknown operation %q", op.Op))
+		}
+	}
+	return nil
+}
+
+// Attributes splits an operation without a path into one operation per
+// member of its value object, so both forms can be applied the same way.
+func (op *PatchOperation) Attributes() ([]PatchOperation, *Error) {
+	if op.Path != "" {
+		return []PatchOperation{*op}, nil
+	}
+
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(op.Value, &values); err != nil {
+		return nil, NewError(http.StatusBadRequest, ErrInvalidValue, "operation without a path needs an object value")
+	}
+
+	ops := make([]PatchOperation, 0, len(values))
+	for path, value := range values {
+		if path == "schemas" || path == "id" || path == "meta" {
+			continue
+		}
+		ops = append(ops, PatchOperation{Op: op.Op, Path: path, Value: value})
+	}
+	return ops, nil
+}
+
+func containsSchema(schemas []string, schema string) bool {
+	for _, s := range schemas {
+		if s == schema {
+			return true
+		}
+	}
+	return false
+}
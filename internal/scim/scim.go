@@ -0,0 +1,213 @@
+// Package scim holds the wire format of the SCIM 2.0 protocol (RFC 7643 and
+// RFC 7644) used to provision users and groups: resources, list responses,
+// errors, filters and PATCH operations.
+package scim
+
+import (
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	SchemaUser                  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SchemaGroup                 = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	SchemaServiceProviderConfig = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	SchemaListResponse          = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	SchemaPatchOp               = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	SchemaError                 = "urn:ietf:params:scim:api:messages:2.0:Error"
+
+	ContentType = "application/scim+json"
+
+	DefaultCount = 100
+	MaxCount     = 200
+)
+
+// Error types from RFC 7644 section 3.12.
+const (
+	ErrInvalidFilter = "invalidFilter"
+	ErrInvalidSyntax = "invalidSyntax"
+	ErrInvalidPath   = "invalidPath"
+	ErrInvalidValue  = "invalidValue"
+	ErrNoTarget      = "noTarget"
+	ErrUniqueness    = "uniqueness"
+	ErrMutability    = "mutability"
+)
+
+type Error struct {
+	Status   int
+	ScimType string
+	Detail   string
+}
+
+func NewError(status int, scimType, detail string) *Error {
+	return &Error{Status: status, ScimType: scimType, Detail: detail}
+}
+
+func (e *Error) Error() string {
+	return e.Detail
+}
+
+func (e *Error) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Schemas  []string `json:"schemas"`
+		Status   string   `json:"status"`
+		ScimType string   `json:"scimType,omitempty"`
+		Detail   string   `json:"detail,omitempty"`
+	}{
+		Schemas:  []string{SchemaError},
+		Status:   strconv.Itoa(e.Status),
+		ScimType: e.ScimType,
+		Detail:   e.Detail,
+	})
+}
+
+type Meta struct {
+	ResourceType string    `json:"resourceType"`
+	Created      time.Time `json:"created"`
+	LastModified time.Time `json:"lastModified"`
+	Location     string    `json:"location"`
+	Version      string    `json:"version,omitempty"`
+}
+
+type Name struct {
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+type Email struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+type GroupRef struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Ref     string `json:"$ref,omitempty"`
+}
+
+type User struct {
+	Schemas  []string   `json:"schemas"`
+	ID       string     `json:"id,omitempty"`
+	UserName string     `json:"userName"`
+	Name     *Name      `json:"name,omitempty"`
+	Emails   []Email    `json:"emails,omitempty"`
+	Active   *bool      `json:"active,omitempty"`
+	Password string     `json:"password,omitempty"`
+	Groups   []GroupRef `json:"groups,omitempty"`
+	Meta     *Meta      `json:"meta,omitempty"`
+}
+
+// PrimaryEmail returns the address marked primary, or the first one.
+func (u *User) PrimaryEmail() string {
+	for _, email := range u.Emails {
+		if email.Primary {
+			return email.Value
+		}
+	}
+	if len(u.Emails) > 0 {
+		return u.Emails[0].Value
+	}
+	return ""
+}
+
+type Member struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Ref     string `json:"$ref,omitempty"`
+}
+
+type Group struct {
+	Schemas     []string `json:"schemas"`
+	ID          string   `json:"id,omitempty"`
+	DisplayName string   `json:"displayName"`
+	Members     []Member `json:"members,omitempty"`
+	Meta        *Meta    `json:"meta,omitempty"`
+}
+
+type ListResponse struct {
+	Schemas      []string    `json:"schemas"`
+	TotalResults int         `json:"totalResults"`
+	StartIndex   int         `json:"startIndex"`
+	ItemsPerPage int         `json:"itemsPerPage"`
+	Resources    interface{} `json:"Resources"`
+}
+
+func NewListResponse(resources interface{}, count, total, startIndex int) *ListResponse {
+	return &ListResponse{
+		Schemas:      []string{SchemaListResponse},
+		TotalResults: total,
+		StartIndex:   startIndex,
+		ItemsPerPage: count,
+		Resources:    resources,
+	}
+}
+
+// Pagination reads the 1-based startIndex and count query parameters.
+// Out of range values are clamped as RFC 7644 section 3.4.2.4 asks.
+func Pagination(query url.Values) (startIndex, count int) {
+	startIndex, count = 1, DefaultCount
+	if v, err := strconv.Atoi(query.Get("startIndex")); err == nil && v > 1 {
+		startIndex = v
+	}
+	if v, err := strconv.Atoi(query.Get("count")); err == nil {
+		count = v
+	}
+	if count < 0 {
+		count = 0
+	}
+	if count > MaxCount {
+		count = MaxCount
+	}
+	return startIndex, count
+}
+
+type supported struct {
+	Supported bool `json:"supported"`
+}
+
+type filterSupport struct {
+	Supported  bool `json:"supported"`
+	MaxResults int  `json:"maxResults"`
+}
+
+type bulkSupport struct {
+	Supported      bool `json:"supported"`
+	MaxOperations  int  `json:"maxOperations"`
+	MaxPayloadSize int  `json:"maxPayloadSize"`
+}
+
+type AuthenticationScheme struct {
+	Type        string `json:"type"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// ServiceProviderConfig tells clients which optional parts of the protocol
+// the server implements (RFC 7643 section 5).
+type ServiceProviderConfig struct {
+	Schemas               []string               `json:"schemas"`
+	Patch                 supported              `json:"patch"`
+	Bulk                  bulkSupport            `json:"bulk"`
+	Filter                filterSupport          `json:"filter"`
+	ChangePassword        supported              `json:"changePassword"`
+	Sort                  supported              `json:"sort"`
+	ETag                  supported              `json:"etag"`
+	AuthenticationSchemes []AuthenticationScheme `json:"authenticationSchemes"`
+}
+
+func NewServiceProviderConfig() *ServiceProviderConfig {
+	return &ServiceProviderConfig{
+		Schemas:        []string{SchemaServiceProviderConfig},
+		Patch:          supported{Supported: true},
+		Filter:         filterSupport{Supported: true, MaxResults: MaxCount},
+		ChangePassword: supported{Supported: true},
+		AuthenticationSchemes: []AuthenticationScheme{{
+			Type:        "oauthbearertoken",
+			Name:        "Bearer Token",
+			Description: "Token issued to the provisioning client by an administrator",
+		}},
+	}
+}